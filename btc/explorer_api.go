@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/btcsuite/btcd/wire"
 )
 
 var (
@@ -128,6 +130,16 @@ func (a *ExplorerAPI) Spends(addr string) ([]*TX, error) {
 	return spends, nil
 }
 
+func (a *ExplorerAPI) AddressInfo(addr string) (*AddressStats, error) {
+	stats := &AddressStats{}
+	err := fetchJSON(fmt.Sprintf("%s/address/%s", a.BaseURL, addr), stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
 func (a *ExplorerAPI) Unspent(addr string) ([]*Vout, error) {
 	var (
 		stats   = &AddressStats{}
@@ -211,6 +223,48 @@ func (a *ExplorerAPI) Address(outpoint string) (string, error) {
 	return tx.Vout[vout].ScriptPubkeyAddr, nil
 }
 
+// TipHeight returns the height of the current chain tip.
+func (a *ExplorerAPI) TipHeight() (uint32, error) {
+	body, err := fetchRaw(a.BaseURL + "/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+
+	height, err := strconv.ParseUint(string(body), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing block height: %w", err)
+	}
+
+	return uint32(height), nil
+}
+
+// BlockHash returns the hash of the block at the given height.
+func (a *ExplorerAPI) BlockHash(height uint32) (string, error) {
+	body, err := fetchRaw(fmt.Sprintf("%s/block-height/%d", a.BaseURL,
+		height))
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
+
+// RawBlock returns the full block with the given hash, including the witness
+// data of all transactions.
+func (a *ExplorerAPI) RawBlock(hash string) (*wire.MsgBlock, error) {
+	body, err := fetchRaw(fmt.Sprintf("%s/block/%s/raw", a.BaseURL, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	block := &wire.MsgBlock{}
+	if err := block.Deserialize(bytes.NewReader(body)); err != nil {
+		return nil, fmt.Errorf("error parsing block %s: %w", hash, err)
+	}
+
+	return block, nil
+}
+
 func (a *ExplorerAPI) PublishTx(rawTxHex string) (string, error) {
 	url := a.BaseURL + "/tx"
 	resp, err := http.Post(url, "text/plain", strings.NewReader(rawTxHex))
@@ -259,3 +313,27 @@ func fetchJSON(url string, target any) error {
 
 	return nil
 }
+
+func fetchRaw(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching data from API '%s', "+
+			"server might be experiencing temporary issues, try "+
+			"again later; error details: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	body := new(bytes.Buffer)
+	_, err = body.ReadFrom(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching data from API '%s', "+
+			"server might be experiencing temporary issues, try "+
+			"again later; error details: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching data from API '%s', "+
+			"status %d: %s", url, resp.StatusCode, body.String())
+	}
+
+	return body.Bytes(), nil
+}
@@ -0,0 +1,773 @@
+package cln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/lightningnetwork/lnd/tor"
+)
+
+const (
+	// MsgStaticChanBackupWithTlvs is the message type of the static
+	// channel backup that CLN writes to its emergency.recover file.
+	MsgStaticChanBackupWithTlvs = 6137
+
+	// StaticChanBackupVersion is the version CLN writes into the static
+	// channel backup.
+	StaticChanBackupVersion = 1
+
+	// scbTypeShachain is the TLV type of the peer's revocation secrets.
+	scbTypeShachain = 1
+
+	// scbTypeBasepoints is the TLV type of the peer's basepoints.
+	scbTypeBasepoints = 3
+
+	// scbTypeOpener is the TLV type of the side that opened the channel.
+	scbTypeOpener = 5
+
+	// scbTypeRemoteToSelfDelay is the TLV type of the CSV delay of the
+	// peer's outputs.
+	scbTypeRemoteToSelfDelay = 7
+
+	// sideLocal is CLN's encoding of our side of the channel.
+	sideLocal = 0
+
+	// addrInternalForProxy and addrInternalWireAddr are the types of CLN's
+	// internal address encoding that are used for peers.
+	addrInternalForProxy = 3
+	addrInternalWireAddr = 4
+
+	// The address types of a wire address as defined in BOLT #7.
+	addrTypeIPv4  = 1
+	addrTypeIPv6  = 2
+	addrTypeTorV3 = 4
+	addrTypeDNS   = 5
+
+	// forProxyHostLen is the fixed length of the host name of an address
+	// that is resolved by a proxy.
+	forProxyHostLen = 256
+
+	// defaultCsvDelay is the CSV delay used when the backup doesn't contain
+	// one.
+	defaultCsvDelay = 144
+)
+
+// StaticChanBackup is the content of CLN's emergency.recover file, which is
+// also what the staticbackup RPC returns, one hex encoded channel at a time.
+type StaticChanBackup struct {
+	Version   uint64
+	Timestamp uint32
+	Channels  []*ScbChan
+}
+
+// Basepoints are the peer's channel basepoints.
+type Basepoints struct {
+	Revocation     *btcec.PublicKey
+	Payment        *btcec.PublicKey
+	Htlc           *btcec.PublicKey
+	DelayedPayment *btcec.PublicKey
+}
+
+// ScbChan is CLN's static backup of a single channel.
+type ScbChan struct {
+	// ID is CLN's database ID of the channel, which is used to derive the
+	// channel's keys.
+	ID          uint64
+	ChannelID   lnwire.ChannelID
+	NodeID      *btcec.PublicKey
+	Addr        net.Addr
+	Funding     wire.OutPoint
+	FundingSats btcutil.Amount
+	ChannelType *lnwire.RawFeatureVector
+
+	// Shachain is the encoded store of revocation secrets received from
+	// the peer. It is kept as is since it can't be represented in an lnd
+	// backup.
+	Shachain          []byte
+	Basepoints        fn.Option[Basepoints]
+	IsOpener          fn.Option[bool]
+	RemoteToSelfDelay fn.Option[uint16]
+}
+
+// HostAddr is a network address with a host name that needs to be resolved
+// before it can be used.
+type HostAddr struct {
+	Host string
+	Port uint16
+}
+
+// Network returns the network of the address.
+func (a *HostAddr) Network() string {
+	return "tcp"
+}
+
+// String returns the address in the host:port format.
+func (a *HostAddr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(int(a.Port)))
+}
+
+// ScbSecret derives the key CLN uses to encrypt the emergency.recover file,
+// which is the result of the makesecret RPC with the string "scb secret".
+func ScbSecret(hsmSecret [32]byte) ([32]byte, error) {
+	derivedSecret, err := HkdfSha256(
+		hsmSecret[:], make([]byte, 4), []byte("derived secrets"),
+	)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return HkdfSha256(derivedSecret[:], nil, []byte("scb secret"))
+}
+
+// DecryptEmergencyRecover decrypts and decodes the content of CLN's
+// emergency.recover file.
+func DecryptEmergencyRecover(hsmSecret [32]byte,
+	content []byte) (*StaticChanBackup, error) {
+
+	key, err := ScbSecret(hsmSecret)
+	if err != nil {
+		return nil, err
+	}
+	plainText, err := DecryptSecretStream(key, content)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting emergency.recover: %w",
+			err)
+	}
+
+	return DecodeStaticChanBackup(plainText)
+}
+
+// EncryptEmergencyRecover encodes and encrypts the backup in the format of
+// CLN's emergency.recover file.
+func EncryptEmergencyRecover(hsmSecret [32]byte,
+	backup *StaticChanBackup) ([]byte, error) {
+
+	key, err := ScbSecret(hsmSecret)
+	if err != nil {
+		return nil, err
+	}
+	plainText, err := backup.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return EncryptSecretStream(key, plainText)
+}
+
+// DecodeStaticChanBackup decodes an unencrypted static channel backup
+// message.
+func DecodeStaticChanBackup(content []byte) (*StaticChanBackup, error) {
+	r := bytes.NewReader(content)
+
+	var (
+		msgType uint16
+		num     uint16
+		backup  StaticChanBackup
+	)
+	err := readElements(r, &msgType, &backup.Version, &backup.Timestamp,
+		&num)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup header: %w", err)
+	}
+	if msgType != MsgStaticChanBackupWithTlvs {
+		return nil, fmt.Errorf("unsupported backup message type %d",
+			msgType)
+	}
+
+	backup.Channels = make([]*ScbChan, num)
+	for idx := range backup.Channels {
+		backup.Channels[idx], err = readScbChan(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading channel %d: %w",
+				idx, err)
+		}
+	}
+
+	return &backup, nil
+}
+
+// Encode encodes the backup as an unencrypted static channel backup message.
+func (b *StaticChanBackup) Encode() ([]byte, error) {
+	if len(b.Channels) > 0xffff {
+		return nil, errors.New("too many channels")
+	}
+
+	var buf bytes.Buffer
+	err := writeElements(&buf, uint16(MsgStaticChanBackupWithTlvs),
+		b.Version, b.Timestamp, uint16(len(b.Channels)))
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range b.Channels {
+		if err := channel.Encode(&buf); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeScbChan decodes a single channel in the format the staticbackup RPC
+// returns and the recoverchannel RPC accepts.
+func DecodeScbChan(content []byte) (*ScbChan, error) {
+	r := bytes.NewReader(content)
+	channel, err := readScbChan(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after channel",
+			r.Len())
+	}
+
+	return channel, nil
+}
+
+// readScbChan reads a single channel from the reader.
+func readScbChan(r io.Reader) (*ScbChan, error) {
+	var (
+		c         ScbChan
+		nodeID    [33]byte
+		fundingID [32]byte
+		fundingN  uint32
+		sats      uint64
+	)
+	err := readElements(r, &c.ID, &c.ChannelID, &nodeID)
+	if err != nil {
+		return nil, err
+	}
+	c.NodeID, err = btcec.ParsePubKey(nodeID[:])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing node ID: %w", err)
+	}
+
+	c.Addr, err = readAddr(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading address: %w", err)
+	}
+
+	err = readElements(r, &fundingID, &fundingN, &sats)
+	if err != nil {
+		return nil, err
+	}
+	c.Funding = wire.OutPoint{Hash: fundingID, Index: fundingN}
+	c.FundingSats = btcutil.Amount(sats)
+
+	c.ChannelType = lnwire.NewRawFeatureVector()
+	if err := c.ChannelType.Decode(r); err != nil {
+		return nil, fmt.Errorf("error reading channel type: %w", err)
+	}
+
+	var tlvLen uint32
+	if err := readElements(r, &tlvLen); err != nil {
+		return nil, err
+	}
+	tlvs := make([]byte, tlvLen)
+	if _, err := io.ReadFull(r, tlvs); err != nil {
+		return nil, err
+	}
+	if err := c.decodeTlvs(tlvs); err != nil {
+		return nil, fmt.Errorf("error reading TLVs: %w", err)
+	}
+
+	return &c, nil
+}
+
+// Encode writes the channel in the format the staticbackup RPC returns.
+func (c *ScbChan) Encode(w io.Writer) error {
+	err := writeElements(
+		w, c.ID, c.ChannelID, c.NodeID.SerializeCompressed(),
+	)
+	if err != nil {
+		return err
+	}
+	if err := writeAddr(w, c.Addr); err != nil {
+		return err
+	}
+	err = writeElements(
+		w, c.Funding.Hash, c.Funding.Index, uint64(c.FundingSats),
+	)
+	if err != nil {
+		return err
+	}
+
+	channelType := c.ChannelType
+	if channelType == nil {
+		channelType = lnwire.NewRawFeatureVector()
+	}
+	if err := channelType.Encode(w); err != nil {
+		return err
+	}
+
+	tlvs, err := c.encodeTlvs()
+	if err != nil {
+		return err
+	}
+
+	return writeElements(w, uint32(len(tlvs)), tlvs)
+}
+
+// decodeTlvs decodes the TLV stream of a channel, unknown odd types are
+// ignored.
+func (c *ScbChan) decodeTlvs(tlvs []byte) error {
+	var (
+		r   = bytes.NewReader(tlvs)
+		buf [8]byte
+	)
+	for r.Len() > 0 {
+		tlvType, err := tlv.ReadVarInt(r, &buf)
+		if err != nil {
+			return err
+		}
+		length, err := tlv.ReadVarInt(r, &buf)
+		if err != nil {
+			return err
+		}
+		if length > uint64(r.Len()) {
+			return fmt.Errorf("TLV %d too long", tlvType)
+		}
+		value := make([]byte, length)
+		_, _ = r.Read(value)
+
+		switch tlvType {
+		case scbTypeShachain:
+			c.Shachain = value
+
+		case scbTypeBasepoints:
+			if length != 4*btcec.PubKeyBytesLenCompressed {
+				return errors.New("invalid basepoints length")
+			}
+			keys := make([]*btcec.PublicKey, 4)
+			for idx := range keys {
+				start := idx * btcec.PubKeyBytesLenCompressed
+				keys[idx], err = btcec.ParsePubKey(
+					value[start : start+33],
+				)
+				if err != nil {
+					return err
+				}
+			}
+			c.Basepoints = fn.Some(Basepoints{
+				Revocation:     keys[0],
+				Payment:        keys[1],
+				Htlc:           keys[2],
+				DelayedPayment: keys[3],
+			})
+
+		case scbTypeOpener:
+			if length != 1 {
+				return errors.New("invalid opener length")
+			}
+			c.IsOpener = fn.Some(value[0] == sideLocal)
+
+		case scbTypeRemoteToSelfDelay:
+			if length != 2 {
+				return errors.New("invalid delay length")
+			}
+			c.RemoteToSelfDelay = fn.Some(
+				binary.BigEndian.Uint16(value),
+			)
+
+		default:
+			if tlvType%2 == 0 {
+				return fmt.Errorf("unknown even TLV type %d",
+					tlvType)
+			}
+		}
+	}
+
+	return nil
+}
+
+// encodeTlvs encodes the optional fields of a channel as a TLV stream.
+func (c *ScbChan) encodeTlvs() ([]byte, error) {
+	var (
+		w   bytes.Buffer
+		buf [8]byte
+	)
+	writeTlv := func(tlvType uint64, value []byte) error {
+		if err := tlv.WriteVarInt(&w, tlvType, &buf); err != nil {
+			return err
+		}
+		err := tlv.WriteVarInt(&w, uint64(len(value)), &buf)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(value)
+
+		return err
+	}
+
+	if c.Shachain != nil {
+		if err := writeTlv(scbTypeShachain, c.Shachain); err != nil {
+			return nil, err
+		}
+	}
+	if c.Basepoints.IsSome() {
+		points := c.Basepoints.UnwrapOr(Basepoints{})
+		var value []byte
+		for _, key := range []*btcec.PublicKey{
+			points.Revocation, points.Payment, points.Htlc,
+			points.DelayedPayment,
+		} {
+			value = append(value, key.SerializeCompressed()...)
+		}
+		if err := writeTlv(scbTypeBasepoints, value); err != nil {
+			return nil, err
+		}
+	}
+	if c.IsOpener.IsSome() {
+		side := byte(1 - sideLocal)
+		if c.IsOpener.UnwrapOr(false) {
+			side = sideLocal
+		}
+		if err := writeTlv(scbTypeOpener, []byte{side}); err != nil {
+			return nil, err
+		}
+	}
+	if c.RemoteToSelfDelay.IsSome() {
+		value := binary.BigEndian.AppendUint16(
+			nil, c.RemoteToSelfDelay.UnwrapOr(0),
+		)
+		err := writeTlv(scbTypeRemoteToSelfDelay, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return w.Bytes(), nil
+}
+
+// readAddr reads CLN's internal encoding of a peer address.
+func readAddr(r io.Reader) (net.Addr, error) {
+	var internalType uint8
+	if err := readElements(r, &internalType); err != nil {
+		return nil, err
+	}
+
+	switch internalType {
+	case addrInternalForProxy:
+		var (
+			host [forProxyHostLen]byte
+			port uint16
+		)
+		if err := readElements(r, &host, &port); err != nil {
+			return nil, err
+		}
+		hostName, _, _ := strings.Cut(string(host[:]), "\x00")
+
+		return &HostAddr{Host: hostName, Port: port}, nil
+
+	case addrInternalWireAddr:
+		var (
+			isWebsocket bool
+			addrType    uint8
+		)
+		if err := readElements(r, &isWebsocket, &addrType); err != nil {
+			return nil, err
+		}
+
+		var addrLen uint8
+		switch addrType {
+		case addrTypeIPv4:
+			addrLen = net.IPv4len
+
+		case addrTypeIPv6:
+			addrLen = net.IPv6len
+
+		case addrTypeTorV3:
+			addrLen = tor.V3DecodedLen
+
+		case addrTypeDNS:
+			if err := readElements(r, &addrLen); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported address type %d",
+				addrType)
+		}
+
+		var port uint16
+		addr := make([]byte, addrLen)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return nil, err
+		}
+		if err := readElements(r, &port); err != nil {
+			return nil, err
+		}
+
+		switch addrType {
+		case addrTypeTorV3:
+			return &tor.OnionAddr{
+				OnionService: strings.ToLower(
+					tor.Base32Encoding.EncodeToString(addr),
+				) + tor.OnionSuffix,
+				Port: int(port),
+			}, nil
+
+		case addrTypeDNS:
+			return &HostAddr{Host: string(addr), Port: port}, nil
+
+		default:
+			return &net.TCPAddr{IP: addr, Port: int(port)}, nil
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported internal address type %d",
+			internalType)
+	}
+}
+
+// writeAddr writes a peer address in CLN's internal encoding.
+func writeAddr(w io.Writer, addr net.Addr) error {
+	var (
+		addrType  uint8
+		addrBytes []byte
+		port      int
+	)
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		addrType, addrBytes = addrTypeIPv6, a.IP.To16()
+		if ip4 := a.IP.To4(); ip4 != nil {
+			addrType, addrBytes = addrTypeIPv4, ip4
+		}
+		port = a.Port
+
+	case *tor.OnionAddr:
+		service := strings.TrimSuffix(a.OnionService, tor.OnionSuffix)
+		decoded, err := tor.Base32Encoding.DecodeString(service)
+		if err != nil || len(decoded) != tor.V3DecodedLen {
+			return fmt.Errorf("unsupported onion address %v", a)
+		}
+		addrType, addrBytes, port = addrTypeTorV3, decoded, a.Port
+
+	case *HostAddr:
+		if len(a.Host) > 255 {
+			return fmt.Errorf("host name %s too long", a.Host)
+		}
+		addrType, port = addrTypeDNS, int(a.Port)
+		addrBytes = append([]byte{byte(len(a.Host))}, a.Host...)
+
+	default:
+		return fmt.Errorf("unsupported address %v", addr)
+	}
+
+	err := writeElements(
+		w, uint8(addrInternalWireAddr), false, addrType, addrBytes,
+		uint16(port),
+	)
+	if err != nil {
+		return fmt.Errorf("error writing address: %w", err)
+	}
+
+	return nil
+}
+
+// NewScbChan converts an lnd channel backup into CLN's format. lnd's key
+// locators don't map to CLN's key derivation, so CLN will only be able to use
+// the backup to ask the peer to force close the channel.
+func NewScbChan(single *chanbackup.Single) (*ScbChan, error) {
+	if len(single.Addresses) == 0 {
+		return nil, fmt.Errorf("channel %v has no peer address",
+			single.FundingOutpoint)
+	}
+
+	channelType := lnwire.NewRawFeatureVector()
+	switch single.Version {
+	case chanbackup.TweaklessCommitVersion:
+		channelType.Set(lnwire.StaticRemoteKeyRequired)
+
+	case chanbackup.AnchorsCommitVersion:
+		channelType.Set(lnwire.StaticRemoteKeyRequired)
+		channelType.Set(lnwire.AnchorsRequired)
+
+	case chanbackup.AnchorsZeroFeeHtlcTxCommitVersion,
+		chanbackup.ScriptEnforcedLeaseVersion:
+
+		channelType.Set(lnwire.StaticRemoteKeyRequired)
+		channelType.Set(lnwire.AnchorsZeroFeeHtlcTxRequired)
+
+	case chanbackup.SimpleTaprootVersion,
+		chanbackup.TapscriptRootVersion:
+
+		channelType.Set(lnwire.StaticRemoteKeyRequired)
+		channelType.Set(lnwire.AnchorsZeroFeeHtlcTxRequired)
+		channelType.Set(lnwire.SimpleTaprootChannelsRequiredFinal)
+	}
+
+	remote := single.RemoteChanCfg
+	chanID := lnwire.NewChanIDFromOutPoint(single.FundingOutpoint)
+
+	return &ScbChan{
+		ID:          uint64(single.LocalChanCfg.MultiSigKey.Index),
+		ChannelID:   chanID,
+		NodeID:      single.RemoteNodePub,
+		Addr:        single.Addresses[0],
+		Funding:     single.FundingOutpoint,
+		FundingSats: single.Capacity,
+		ChannelType: channelType,
+		Basepoints: fn.Some(Basepoints{
+			Revocation:     remote.RevocationBasePoint.PubKey,
+			Payment:        remote.PaymentBasePoint.PubKey,
+			Htlc:           remote.HtlcBasePoint.PubKey,
+			DelayedPayment: remote.DelayBasePoint.PubKey,
+		}),
+		IsOpener:          fn.Some(single.IsInitiator),
+		RemoteToSelfDelay: fn.Some(remote.CsvDelay),
+	}, nil
+}
+
+// Single converts the channel into an lnd channel backup. CLN doesn't back up
+// the peer's multisig key, so the peer's node key is used as a placeholder,
+// the same way as in a fake channel backup. If the HSM secret is given, our
+// own keys are derived with it and added to the local channel config.
+func (c *ScbChan) Single(chainHash chainhash.Hash,
+	hsmSecret fn.Option[[32]byte]) (chanbackup.Single, error) {
+
+	var addrs []net.Addr
+	switch c.Addr.(type) {
+	case *net.TCPAddr, *tor.OnionAddr:
+		addrs = append(addrs, c.Addr)
+	}
+
+	const (
+		taprootFinal   = lnwire.SimpleTaprootChannelsRequiredFinal
+		taprootStaging = lnwire.SimpleTaprootChannelsRequiredStaging
+	)
+	version := chanbackup.SingleBackupVersion(
+		chanbackup.DefaultSingleVersion,
+	)
+	switch {
+	case c.ChannelType.IsSet(taprootFinal),
+		c.ChannelType.IsSet(taprootStaging):
+
+		version = chanbackup.SimpleTaprootVersion
+
+	case c.ChannelType.IsSet(lnwire.AnchorsZeroFeeHtlcTxRequired):
+		version = chanbackup.AnchorsZeroFeeHtlcTxCommitVersion
+
+	case c.ChannelType.IsSet(lnwire.AnchorsRequired):
+		version = chanbackup.AnchorsCommitVersion
+
+	case c.ChannelType.IsSet(lnwire.StaticRemoteKeyRequired):
+		version = chanbackup.TweaklessCommitVersion
+	}
+
+	local := channeldb.ChannelConfig{
+		CommitmentParams: channeldb.CommitmentParams{
+			CsvDelay: defaultCsvDelay,
+		},
+	}
+	localKeys := map[*keychain.KeyDescriptor]keychain.KeyFamily{
+		&local.MultiSigKey:         keychain.KeyFamilyMultiSig,
+		&local.RevocationBasePoint: keychain.KeyFamilyRevocationBase,
+		&local.PaymentBasePoint:    keychain.KeyFamilyPaymentBase,
+		&local.DelayBasePoint:      keychain.KeyFamilyDelayBase,
+		&local.HtlcBasePoint:       keychain.KeyFamilyHtlcBase,
+	}
+	for desc, family := range localKeys {
+		desc.KeyLocator = keychain.KeyLocator{
+			Family: family,
+			Index:  uint32(c.ID),
+		}
+
+		if hsmSecret.IsNone() {
+			continue
+		}
+
+		// CLN derives the keys of a channel from the peer's node key
+		// and the channel's database ID.
+		var err error
+		desc.PubKey, _, err = DeriveKeyPair(
+			hsmSecret.UnwrapOr([32]byte{}), &keychain.KeyDescriptor{
+				PubKey:     c.NodeID,
+				KeyLocator: desc.KeyLocator,
+			},
+		)
+		if err != nil {
+			return chanbackup.Single{}, err
+		}
+	}
+
+	points := c.Basepoints.UnwrapOr(Basepoints{
+		Revocation:     c.NodeID,
+		Payment:        c.NodeID,
+		Htlc:           c.NodeID,
+		DelayedPayment: c.NodeID,
+	})
+	remote := channeldb.ChannelConfig{
+		CommitmentParams: channeldb.CommitmentParams{
+			CsvDelay: c.RemoteToSelfDelay.UnwrapOr(defaultCsvDelay),
+		},
+		MultiSigKey: keychain.KeyDescriptor{PubKey: c.NodeID},
+		RevocationBasePoint: keychain.KeyDescriptor{
+			PubKey: points.Revocation,
+		},
+		PaymentBasePoint: keychain.KeyDescriptor{
+			PubKey: points.Payment,
+		},
+		DelayBasePoint: keychain.KeyDescriptor{
+			PubKey: points.DelayedPayment,
+		},
+		HtlcBasePoint: keychain.KeyDescriptor{PubKey: points.Htlc},
+	}
+
+	return chanbackup.Single{
+		Version:         version,
+		IsInitiator:     c.IsOpener.UnwrapOr(true),
+		ChainHash:       chainHash,
+		FundingOutpoint: c.Funding,
+		RemoteNodePub:   c.NodeID,
+		Addresses:       addrs,
+		Capacity:        c.FundingSats,
+		LocalChanCfg:    local,
+		RemoteChanCfg:   remote,
+		ShaChainRootDesc: keychain.KeyDescriptor{
+			KeyLocator: keychain.KeyLocator{
+				Family: keychain.KeyFamilyRevocationRoot,
+				Index:  uint32(c.ID),
+			},
+		},
+	}, nil
+}
+
+// readElements reads big endian encoded fixed size values from the reader.
+func readElements(r io.Reader, elements ...any) error {
+	for _, element := range elements {
+		err := binary.Read(r, binary.BigEndian, element)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeElements writes big endian encoded fixed size values to the writer.
+func writeElements(w io.Writer, elements ...any) error {
+	for _, element := range elements {
+		err := binary.Write(w, binary.BigEndian, element)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
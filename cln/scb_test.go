@@ -0,0 +1,164 @@
+package cln
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tor"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// secretStreamVector was created with libsodium's
+	// crypto_secretstream_xchacha20poly1305_push, using the key 0x00..0x1f.
+	secretStreamVector, _ = hex.DecodeString(
+		"9dec41e9fb81c99bf053ecd9a55df08080bc53e073760f1ab1bbac9e9a46" +
+			"c9b495e7755c631aee04d867a4f91aa4adb5508082c61547a507" +
+			"a495396942145217eeb231308b1e13acbe49e4428d8606c21c32" +
+			"b14217a5f766fd",
+	)
+	secretStreamPlainText = "hello emergency recover, this is a test " +
+		"message!"
+)
+
+func TestSecretStream(t *testing.T) {
+	var key [32]byte
+	for idx := range key {
+		key[idx] = byte(idx)
+	}
+
+	plainText, err := DecryptSecretStream(key, secretStreamVector)
+	require.NoError(t, err)
+	require.Equal(t, secretStreamPlainText, string(plainText))
+
+	encrypted, err := EncryptSecretStream(key, plainText)
+	require.NoError(t, err)
+	require.Len(t, encrypted, len(secretStreamVector))
+	decrypted, err := DecryptSecretStream(key, encrypted)
+	require.NoError(t, err)
+	require.Equal(t, plainText, decrypted)
+
+	// Any modification must be detected.
+	encrypted[len(encrypted)-20] ^= 0x01
+	_, err = DecryptSecretStream(key, encrypted)
+	require.ErrorContains(t, err, "invalid MAC")
+}
+
+func TestEmergencyRecover(t *testing.T) {
+	newKey := func() *btcec.PublicKey {
+		priv, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+
+		return priv.PubKey()
+	}
+
+	remote := keychain.KeyDescriptor{PubKey: newKey()}
+	single := chanbackup.Single{
+		Version:     chanbackup.AnchorsZeroFeeHtlcTxCommitVersion,
+		IsInitiator: true,
+		ChainHash:   *chaincfg.RegressionNetParams.GenesisHash,
+		FundingOutpoint: wire.OutPoint{
+			Hash:  chainhash.Hash{1, 2, 3},
+			Index: 1,
+		},
+		RemoteNodePub: peerPubKey,
+		Addresses: []net.Addr{&tor.OnionAddr{
+			OnionService: strings.Repeat("a", 56) + tor.OnionSuffix,
+			Port:         9735,
+		}},
+		Capacity: 1_000_000,
+	}
+	single.RemoteChanCfg.CsvDelay = 2016
+	single.RemoteChanCfg.MultiSigKey = remote
+	single.RemoteChanCfg.RevocationBasePoint = remote
+	single.RemoteChanCfg.PaymentBasePoint = keychain.KeyDescriptor{
+		PubKey: newKey(),
+	}
+	single.RemoteChanCfg.DelayBasePoint = remote
+	single.RemoteChanCfg.HtlcBasePoint = remote
+	single.LocalChanCfg.MultiSigKey.Index = 7
+
+	scbChan, err := NewScbChan(&single)
+	require.NoError(t, err)
+	require.True(t, scbChan.ChannelType.IsSet(
+		lnwire.AnchorsZeroFeeHtlcTxRequired,
+	))
+
+	backup := &StaticChanBackup{
+		Version:   StaticChanBackupVersion,
+		Timestamp: 1_700_000_000,
+		Channels: []*ScbChan{scbChan, {
+			ID:          3,
+			NodeID:      peerPubKey,
+			Addr:        &HostAddr{Host: "node.example.com", Port: 9736},
+			Funding:     wire.OutPoint{Hash: chainhash.Hash{4}},
+			FundingSats: 500_000,
+			Shachain:    []byte{1, 2, 3},
+		}},
+	}
+	encrypted, err := EncryptEmergencyRecover(hsmSecret, backup)
+	require.NoError(t, err)
+	_, err = DecryptEmergencyRecover([32]byte{1}, encrypted)
+	require.ErrorContains(t, err, "invalid MAC")
+
+	decrypted, err := DecryptEmergencyRecover(hsmSecret, encrypted)
+	require.NoError(t, err)
+	require.Len(t, decrypted.Channels, 2)
+	require.EqualValues(t, 1_700_000_000, decrypted.Timestamp)
+
+	// A single channel can be encoded on its own, as the staticbackup RPC
+	// does.
+	var encodedChan bytes.Buffer
+	require.NoError(t, decrypted.Channels[1].Encode(&encodedChan))
+	hostChan, err := DecodeScbChan(encodedChan.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, "node.example.com:9736", hostChan.Addr.String())
+	require.Equal(t, []byte{1, 2, 3}, hostChan.Shachain)
+	require.True(t, hostChan.IsOpener.IsNone())
+
+	// Converting back into an lnd backup keeps all information CLN has.
+	result, err := decrypted.Channels[0].Single(
+		single.ChainHash, fn.None[[32]byte](),
+	)
+	require.NoError(t, err)
+	require.Equal(t, single.Version, result.Version)
+	require.Equal(t, single.FundingOutpoint, result.FundingOutpoint)
+	require.Equal(t, single.Capacity, result.Capacity)
+	require.True(t, result.IsInitiator)
+	require.Equal(t, single.Addresses, result.Addresses)
+	require.Equal(t, single.RemoteChanCfg.CsvDelay,
+		result.RemoteChanCfg.CsvDelay)
+	require.Equal(t, single.RemoteChanCfg.PaymentBasePoint.PubKey,
+		result.RemoteChanCfg.PaymentBasePoint.PubKey)
+	require.EqualValues(t, 7, result.LocalChanCfg.MultiSigKey.Index)
+	require.Nil(t, result.LocalChanCfg.MultiSigKey.PubKey)
+
+	var serialized bytes.Buffer
+	require.NoError(t, result.Serialize(&serialized))
+
+	// With the HSM secret, our keys are derived the way CLN does.
+	backup.Channels[0].ID = 1
+	result, err = backup.Channels[0].Single(
+		single.ChainHash, fn.Some(hsmSecret),
+	)
+	require.NoError(t, err)
+	require.Equal(t, expectedFundingKeyBytes,
+		result.LocalChanCfg.MultiSigKey.PubKey.SerializeCompressed())
+
+	// Host names can't be used by lnd.
+	result, err = hostChan.Single(single.ChainHash, fn.None[[32]byte]())
+	require.NoError(t, err)
+	require.Empty(t, result.Addresses)
+	require.EqualValues(t, chanbackup.DefaultSingleVersion, result.Version)
+}
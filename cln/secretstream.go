@@ -0,0 +1,178 @@
+package cln
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
+)
+
+const (
+	// SecretStreamHeaderBytes is the size of the header that is written
+	// before the first encrypted message of a libsodium secret stream.
+	SecretStreamHeaderBytes = 24
+
+	// SecretStreamABytes is the number of bytes an encrypted message is
+	// longer than its plaintext (one tag byte and the MAC).
+	SecretStreamABytes = 1 + poly1305.TagSize
+
+	// secretStreamTagMessage is the tag of a normal message in a secret
+	// stream.
+	secretStreamTagMessage = 0x00
+)
+
+// secretStreamState is the state of a libsodium
+// crypto_secretstream_xchacha20poly1305 stream.
+type secretStreamState struct {
+	key   [32]byte
+	nonce [chacha20.NonceSize]byte
+}
+
+// newSecretStreamState initializes the stream state from the key and the
+// header, the same way libsodium's init_push and init_pull do.
+func newSecretStreamState(key [32]byte,
+	header []byte) (*secretStreamState, error) {
+
+	subKey, err := chacha20.HChaCha20(key[:], header[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	state := &secretStreamState{}
+	copy(state.key[:], subKey)
+	binary.LittleEndian.PutUint32(state.nonce[:4], 1)
+	copy(state.nonce[4:], header[16:SecretStreamHeaderBytes])
+
+	return state, nil
+}
+
+// xor encrypts or decrypts the given bytes with the stream's current nonce,
+// starting at the given block counter.
+func (s *secretStreamState) xor(dst, src []byte, counter uint32) error {
+	cipher, err := chacha20.NewUnauthenticatedCipher(
+		s.key[:], s.nonce[:],
+	)
+	if err != nil {
+		return err
+	}
+	cipher.SetCounter(counter)
+	cipher.XORKeyStream(dst, src)
+
+	return nil
+}
+
+// mac computes the authentication tag over the encrypted tag block and the
+// cipher text of a message without additional data.
+func (s *secretStreamState) mac(block, cipherText []byte) ([]byte, error) {
+	var polyKey [32]byte
+	if err := s.xor(polyKey[:], polyKey[:], 0); err != nil {
+		return nil, err
+	}
+
+	var pad [16]byte
+	mac := poly1305.New(&polyKey)
+	_, _ = mac.Write(block)
+	_, _ = mac.Write(cipherText)
+
+	// This padding differs from the one used in the ChaCha20-Poly1305 AEAD,
+	// but is what libsodium does.
+	_, _ = mac.Write(pad[:(0x10-len(block)+len(cipherText))&0xf])
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(
+		lengths[8:], uint64(len(block)+len(cipherText)),
+	)
+	_, _ = mac.Write(lengths[:])
+
+	return mac.Sum(nil), nil
+}
+
+// EncryptSecretStream encrypts the given plaintext as the single message of a
+// libsodium crypto_secretstream_xchacha20poly1305 stream and returns the
+// header followed by the encrypted message.
+func EncryptSecretStream(key [32]byte, plainText []byte) ([]byte, error) {
+	header := make([]byte, SecretStreamHeaderBytes)
+	if _, err := rand.Read(header); err != nil {
+		return nil, fmt.Errorf("error creating header: %w", err)
+	}
+	state, err := newSecretStreamState(key, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var block [64]byte
+	block[0] = secretStreamTagMessage
+	if err := state.xor(block[:], block[:], 1); err != nil {
+		return nil, err
+	}
+
+	cipherText := make([]byte, len(plainText))
+	if err := state.xor(cipherText, plainText, 2); err != nil {
+		return nil, err
+	}
+	mac, err := state.mac(block[:], cipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, len(header)+SecretStreamABytes+len(plainText))
+	result = append(result, header...)
+	result = append(result, block[0])
+	result = append(result, cipherText...)
+
+	return append(result, mac...), nil
+}
+
+// DecryptSecretStream decrypts the first message of a libsodium
+// crypto_secretstream_xchacha20poly1305 stream that is prefixed with its
+// header.
+func DecryptSecretStream(key [32]byte, stream []byte) ([]byte, error) {
+	if len(stream) < SecretStreamHeaderBytes+SecretStreamABytes {
+		return nil, errors.New("encrypted stream too short")
+	}
+	state, err := newSecretStreamState(
+		key, stream[:SecretStreamHeaderBytes],
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	message := stream[SecretStreamHeaderBytes:]
+	cipherText := message[1 : len(message)-poly1305.TagSize]
+
+	// The tag byte is authenticated in its encrypted form, the MAC covers
+	// the full encrypted block.
+	var block [64]byte
+	block[0] = message[0]
+	if err := state.xor(block[:], block[:], 1); err != nil {
+		return nil, err
+	}
+	tag := block[0]
+	block[0] = message[0]
+
+	mac, err := state.mac(block[:], cipherText)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(
+		mac, message[len(message)-poly1305.TagSize:],
+	) != 1 {
+
+		return nil, errors.New("invalid MAC, wrong key or corrupted " +
+			"data")
+	}
+	if tag != secretStreamTagMessage {
+		return nil, fmt.Errorf("unexpected message tag %d", tag)
+	}
+
+	plainText := make([]byte, len(cipherText))
+	if err := state.xor(plainText, cipherText, 2); err != nil {
+		return nil, err
+	}
+
+	return plainText, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lightninglabs/chantools/cln"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/spf13/cobra"
+)
+
+type backupToClnCommand struct {
+	MultiFile string
+	HsmSecret string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newBackupToClnCommand() *cobra.Command {
+	cc := &backupToClnCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "backuptocln",
+		Short: "Convert an lnd channel.backup file into CLN's " +
+			"emergency.recover format",
+		Long: `Decrypts an lnd channel.backup file and converts the
+channels into Core Lightning's static channel backup format. Two files are
+written:
+ - an emergency.recover file, encrypted with the given CLN hsm_secret, to be
+   placed into the CLN node's network directory before running
+   'lightning-cli emergencyrecover'
+ - a JSON file in the format of 'lightning-cli staticbackup' that can be passed
+   to 'lightning-cli recoverchannel'
+
+CLN derives the channel keys from its own hsm_secret, which can't match the
+keys of the lnd node. CLN can therefore only use the backup to ask the peers to
+force close the channels (DLP). The funds of those channels can then be swept
+with chantools and the lnd seed, for example with the sweepremoteclosed
+command.
+
+Channels without a known peer address are skipped.`,
+		Example: `chantools backuptocln \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup \
+	--hsm_secret 3f0a06c6....`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", "", "lnd channel.backup file to "+
+			"convert",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.HsmSecret, "hsm_secret", "", "the hex encoded HSM secret "+
+			"of the CLN node to encrypt the emergency.recover "+
+			"file for; obtain by running 'xxd -p -c32 "+
+			"~/.lightning/bitcoin/hsm_secret'",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
+
+	return cc.cmd
+}
+
+func (c *backupToClnCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Check that we have a backup file and the secret to encrypt with.
+	if c.MultiFile == "" {
+		return errors.New("backup file is required")
+	}
+	if c.HsmSecret == "" {
+		return errors.New("HSM secret is required")
+	}
+	hsmSecret, err := parseHsmSecret(c.HsmSecret)
+	if err != nil {
+		return err
+	}
+
+	multiFile := chanbackup.NewMultiFile(c.MultiFile, noBackupArchive)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	multi, err := multiFile.ExtractMulti(keyRing)
+	if err != nil {
+		return fmt.Errorf("could not extract multi file: %w", err)
+	}
+
+	return backupToCln(multi, hsmSecret)
+}
+
+func backupToCln(multi *chanbackup.Multi, hsmSecret [32]byte) error {
+	backup := &cln.StaticChanBackup{
+		Version:   cln.StaticChanBackupVersion,
+		Timestamp: uint32(time.Now().Unix()),
+	}
+	var staticBackup clnStaticBackup
+	for _, single := range multi.StaticBackups {
+		channel, err := cln.NewScbChan(&single)
+		if err != nil {
+			log.Warnf("Skipping channel: %v", err)
+
+			continue
+		}
+
+		var encoded bytes.Buffer
+		if err := channel.Encode(&encoded); err != nil {
+			return fmt.Errorf("error encoding channel %v: %w",
+				single.FundingOutpoint, err)
+		}
+		staticBackup.SCB = append(
+			staticBackup.SCB, hex.EncodeToString(encoded.Bytes()),
+		)
+		backup.Channels = append(backup.Channels, channel)
+	}
+
+	encrypted, err := cln.EncryptEmergencyRecover(hsmSecret, backup)
+	if err != nil {
+		return fmt.Errorf("error encrypting backup: %w", err)
+	}
+	jsonBytes, err := json.MarshalIndent(staticBackup, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding JSON: %w", err)
+	}
+
+	timestamp := time.Now().Format("2006-01-02-15-04-05")
+	recoverFileName := fmt.Sprintf("%s/emergency-%s.recover", ResultsDir,
+		timestamp)
+	jsonFileName := fmt.Sprintf("%s/staticbackup-%s.json", ResultsDir,
+		timestamp)
+	log.Infof("Writing %d channels to %s and %s", len(backup.Channels),
+		recoverFileName, jsonFileName)
+	if err := os.WriteFile(recoverFileName, encrypted, 0600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(jsonFileName, jsonBytes, 0644)
+}
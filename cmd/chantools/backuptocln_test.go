@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/stretchr/testify/require"
+)
+
+const testHsmSecret = "3f0a06c6385b7493f75aa0089f316a13bf72beb430e59e71" +
+	"b5ac5a73581a6270"
+
+func TestBackupToClnAndBack(t *testing.T) {
+	h := newHarness(t)
+	ResultsDir = h.tempDir
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	hsmSecret, err := parseHsmSecret(testHsmSecret)
+	require.NoError(t, err)
+
+	// A channel without an address can't be used by CLN.
+	withAddr := newTestSingle(t, wire.OutPoint{Hash: chainhash.Hash{1}})
+	withAddr.LocalChanCfg.MultiSigKey.Index = 1
+	noAddr := newTestSingle(t, wire.OutPoint{Hash: chainhash.Hash{2}})
+	noAddr.Addresses = nil
+	multi := &chanbackup.Multi{
+		StaticBackups: []chanbackup.Single{withAddr, noAddr},
+	}
+	require.NoError(t, backupToCln(multi, hsmSecret))
+	h.assertLogContains("has no peer address")
+
+	recoverFiles, err := filepath.Glob(h.tempFile("emergency-*.recover"))
+	require.NoError(t, err)
+	require.Len(t, recoverFiles, 1)
+	jsonFiles, err := filepath.Glob(h.tempFile("staticbackup-*.json"))
+	require.NoError(t, err)
+	require.Len(t, jsonFiles, 1)
+
+	jsonBytes, err := os.ReadFile(jsonFiles[0])
+	require.NoError(t, err)
+	var staticBackup clnStaticBackup
+	require.NoError(t, json.Unmarshal(jsonBytes, &staticBackup))
+	require.Len(t, staticBackup.SCB, 1)
+
+	// The emergency.recover file can only be read with the HSM secret.
+	_, err = readClnBackup(recoverFiles[0], "", "")
+	require.ErrorContains(t, err, "HSM secret is required")
+
+	// Both CLN formats contain the same channel.
+	fromFile, err := readClnBackup(recoverFiles[0], "", testHsmSecret)
+	require.NoError(t, err)
+	fromJSON, err := readClnBackup("", jsonFiles[0], "")
+	require.NoError(t, err)
+	require.Len(t, fromFile, 1)
+	require.Len(t, fromJSON, 1)
+	require.Equal(
+		t, fromJSON[0].FundingOutpoint, fromFile[0].FundingOutpoint,
+	)
+
+	single := fromFile[0]
+	require.Equal(t, withAddr.FundingOutpoint, single.FundingOutpoint)
+	require.Equal(t, withAddr.Version, single.Version)
+	require.Equal(t, withAddr.Capacity, single.Capacity)
+	require.Len(t, single.Addresses, 1)
+	require.Equal(t, "127.0.0.1:9735", single.Addresses[0].String())
+	require.Equal(t, withAddr.RemoteNodePub, single.RemoteNodePub)
+	require.Equal(t, withAddr.RemoteChanCfg.HtlcBasePoint.PubKey,
+		single.RemoteChanCfg.HtlcBasePoint.PubKey)
+	require.EqualValues(t, 1, single.LocalChanCfg.MultiSigKey.Index)
+	require.NotNil(t, single.LocalChanCfg.MultiSigKey.PubKey)
+	require.Nil(t, fromJSON[0].LocalChanCfg.MultiSigKey.PubKey)
+
+	// Converting into an lnd backup file encrypts it with the seed.
+	clnToBackup := &clnToBackupCommand{
+		ScbJSON: jsonFiles[0],
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, clnToBackup.Execute(nil, nil))
+
+	backupFiles, err := filepath.Glob(
+		h.tempFile("backup-from-cln-*.backup"),
+	)
+	require.NoError(t, err)
+	require.Len(t, backupFiles, 1)
+	multiFile := chanbackup.NewMultiFile(backupFiles[0], noBackupArchive)
+	result, err := multiFile.ExtractMulti(keyRing)
+	require.NoError(t, err)
+	require.Len(t, result.StaticBackups, 1)
+	require.Equal(t, withAddr.FundingOutpoint,
+		result.StaticBackups[0].FundingOutpoint)
+
+	// The dumpbackup command can show CLN backups too.
+	dumpBackup := &dumpBackupCommand{
+		ClnFile:   recoverFiles[0],
+		HsmSecret: testHsmSecret,
+	}
+	require.NoError(t, dumpBackup.Execute(nil, nil))
+	h.assertLogContains(withAddr.FundingOutpoint.String())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lightninglabs/chantools/dataformat"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+type backupToJSONCommand struct {
+	MultiFile string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newBackupToJSONCommand() *cobra.Command {
+	cc := &backupToJSONCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "backuptojson",
+		Short: "Convert an lnd channel.backup file into an editable " +
+			"JSON file",
+		Long: `Decrypts an lnd channel.backup file and writes its
+content into a JSON file. Unlike the output of the dumpbackup command, the
+JSON file contains exactly the information stored in the backup and can be
+converted back into an encrypted channel.backup file with the jsontobackup
+command.
+
+This can be used to edit a backup with normal tools, for example to update the
+network addresses of a peer or to remove channels.
+
+The format of each channel entry is:
+{
+  "version": <backup version: 0=legacy, 1=tweakless, 2=anchors,
+              3=anchors_zero_fee_htlc, 4=script_enforced_lease,
+              5=simple_taproot, 6=tapscript_root>,
+  "is_initiator": <true if we opened the channel>,
+  "chain_hash": <genesis block hash of the chain>,
+  "funding_outpoint": "<txid>:<index>",
+  "short_channel_id": "<block>:<tx_index>:<output_index>",
+  "remote_node_pub": <hex encoded identity key of the peer>,
+  "addresses": ["<host>:<port>", ...],
+  "capacity": <channel capacity in satoshis>,
+  "local_chan_cfg": {
+    "csv_delay": <CSV delay of our outputs>,
+    "multisig_key": {"family": <key family>, "index": <key index>},
+    ... (same for revocation_base_point, payment_base_point,
+         delay_base_point and htlc_base_point)
+  },
+  "remote_chan_cfg": {
+    "csv_delay": <CSV delay of the peer's outputs>,
+    "multisig_key": <hex encoded public key>,
+    ... (same for revocation_base_point, payment_base_point,
+         delay_base_point and htlc_base_point)
+  },
+  "sha_chain_root": {"family": <key family>, "index": <key index>,
+                     "pubkey": <optional hex encoded public key>},
+  "lease_expiry": <only for version 4>,
+  "close_tx_inputs": {  (optional)
+    "commit_tx": <hex encoded unsigned commitment transaction>,
+    "commit_sig": <hex encoded signature of the peer>,
+    "commit_height": <only for taproot channels>,
+    "tapscript_root": <only for version 6>
+  }
+}`,
+		Example: `chantools backuptojson \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", "", "lnd channel.backup file to "+
+			"convert",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
+
+	return cc.cmd
+}
+
+func (c *backupToJSONCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Check that we have a backup file.
+	if c.MultiFile == "" {
+		return errors.New("backup file is required")
+	}
+	multiFile := chanbackup.NewMultiFile(c.MultiFile, noBackupArchive)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	return backupToJSON(multiFile, keyRing)
+}
+
+func backupToJSON(multiFile *chanbackup.MultiFile,
+	ring keychain.KeyRing) error {
+
+	multi, err := multiFile.ExtractMulti(ring)
+	if err != nil {
+		return fmt.Errorf("could not extract multi file: %w", err)
+	}
+
+	backupFile, err := dataformat.NewBackupFile(multi)
+	if err != nil {
+		return fmt.Errorf("error converting backup: %w", err)
+	}
+	fileBytes, err := json.MarshalIndent(backupFile, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding backup: %w", err)
+	}
+
+	fileName := fmt.Sprintf("%s/backup-%s.json", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing %d channels to %s", len(backupFile.Channels),
+		fileName)
+
+	return os.WriteFile(fileName, fileBytes, 0644)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/dataformat"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/tor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupToJSONAndBack(t *testing.T) {
+	h := newHarness(t)
+	ResultsDir = h.tempDir
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	commitTx := wire.NewMsgTx(2)
+	commitTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	commitTx.AddTxOut(wire.NewTxOut(990_000, []byte{0x00, 0x14}))
+
+	// Create one channel of each version, with all optional fields set.
+	versions := []chanbackup.SingleBackupVersion{
+		chanbackup.DefaultSingleVersion,
+		chanbackup.TweaklessCommitVersion,
+		chanbackup.AnchorsCommitVersion,
+		chanbackup.AnchorsZeroFeeHtlcTxCommitVersion,
+		chanbackup.ScriptEnforcedLeaseVersion,
+		chanbackup.SimpleTaprootVersion,
+		chanbackup.TapscriptRootVersion,
+	}
+	singles := make([]chanbackup.Single, len(versions))
+	for idx, version := range versions {
+		single := newTestSingle(t, wire.OutPoint{
+			Hash:  chainhash.Hash{byte(idx + 1)},
+			Index: uint32(idx),
+		})
+		single.Version = version
+		single.Addresses = append(single.Addresses, &tor.OnionAddr{
+			OnionService: strings.Repeat("a", 56) + ".onion",
+			Port:         9735,
+		})
+		if version == chanbackup.ScriptEnforcedLeaseVersion {
+			single.LeaseExpiry = 850_000
+		}
+
+		inputs := chanbackup.CloseTxInputs{
+			CommitTx:  commitTx,
+			CommitSig: []byte{1, 2, 3},
+		}
+		if version.IsTaproot() {
+			inputs.CommitHeight = 17
+		}
+		if version.HasTapscriptRoot() {
+			inputs.TapscriptRoot = fn.Some(chainhash.Hash{9, 9})
+		}
+		single.CloseTxInputs = fn.Some(inputs)
+
+		singles[idx] = single
+	}
+
+	multiFileName := h.tempFile("channel.backup")
+	f, err := os.Create(multiFileName)
+	require.NoError(t, err)
+	multi := chanbackup.Multi{StaticBackups: singles}
+	require.NoError(t, multi.PackToWriter(f, keyRing))
+	require.NoError(t, f.Close())
+
+	// Convert the backup into JSON.
+	backupToJSON := &backupToJSONCommand{
+		MultiFile: multiFileName,
+		rootKey:   &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, backupToJSON.Execute(nil, nil))
+
+	jsonFiles, err := filepath.Glob(h.tempFile("backup-*.json"))
+	require.NoError(t, err)
+	require.Len(t, jsonFiles, 1)
+
+	backupFile, err := dataformat.ReadBackupFile(jsonFiles[0])
+	require.NoError(t, err)
+	require.Len(t, backupFile.Channels, len(versions))
+	require.Equal(t, "127.0.0.1:9735", backupFile.Channels[0].Addresses[0])
+
+	// Edit the address of the first channel, the way a user would.
+	editedFileName := h.tempFile("edited.json")
+	editedBytes, err := os.ReadFile(jsonFiles[0])
+	require.NoError(t, err)
+	editedBytes = bytes.Replace(
+		editedBytes, []byte(`"127.0.0.1:9735"`), []byte(`"10.0.0.1"`),
+		1,
+	)
+	require.NoError(t, os.WriteFile(editedFileName, editedBytes, 0644))
+
+	// Convert it back into an encrypted backup.
+	jsonToBackup := &jsonToBackupCommand{
+		JSONFile: editedFileName,
+		rootKey:  &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, jsonToBackup.Execute(nil, nil))
+
+	backupFiles, err := filepath.Glob(
+		h.tempFile("backup-from-json-*.backup"),
+	)
+	require.NoError(t, err)
+	require.Len(t, backupFiles, 1)
+
+	multiFile := chanbackup.NewMultiFile(backupFiles[0], noBackupArchive)
+	result, err := multiFile.ExtractMulti(keyRing)
+	require.NoError(t, err)
+	require.Len(t, result.StaticBackups, len(versions))
+
+	// Apart from the edited address, everything must be identical.
+	singles[0].Addresses[0] = &net.TCPAddr{
+		IP:   net.ParseIP("10.0.0.1"),
+		Port: 9735,
+	}
+	for idx := range singles {
+		var expected, actual bytes.Buffer
+		require.NoError(t, singles[idx].Serialize(&expected))
+		require.NoError(t, result.StaticBackups[idx].Serialize(&actual))
+		require.Equal(t, expected.Bytes(), actual.Bytes(),
+			"version %d", versions[idx])
+	}
+
+	// Both short channel ID formats are accepted and typos in field names
+	// are rejected.
+	channel := backupFile.Channels[0]
+	channel.ShortChannelID = "800000x12x1"
+	single, err := channel.Single()
+	require.NoError(t, err)
+	require.Equal(t, "800000:12:1", single.ShortChannelID.String())
+
+	require.NoError(t, os.WriteFile(editedFileName, bytes.Replace(
+		editedBytes, []byte(`"capacity"`), []byte(`"capactiy"`), 1,
+	), 0644))
+	_, err = dataformat.ReadBackupFile(editedFileName)
+	require.ErrorContains(t, err, "capactiy")
+}
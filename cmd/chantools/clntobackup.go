@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lightninglabs/chantools/cln"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/spf13/cobra"
+)
+
+// clnStaticBackup is the JSON returned by CLN's staticbackup RPC and accepted
+// by its recoverchannel RPC.
+type clnStaticBackup struct {
+	SCB []string `json:"scb"`
+}
+
+type clnToBackupCommand struct {
+	ClnFile   string
+	ScbJSON   string
+	HsmSecret string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newClnToBackupCommand() *cobra.Command {
+	cc := &clnToBackupCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "clntobackup",
+		Short: "Convert a CLN emergency.recover file or static " +
+			"backup into an lnd channel.backup file",
+		Long: `Reads the static channel backup of a Core Lightning node,
+either from its encrypted emergency.recover file (requires the node's
+hsm_secret) or from the JSON output of 'lightning-cli staticbackup', and
+writes it as an lnd channel.backup file that is encrypted with the given seed.
+
+CLN doesn't back up the peer's multisig key, so the peer's node key is used as
+a placeholder, the same way the fakechanbackup command does. The backup can
+therefore only be used to ask the peers to force close the channels (DLP).
+The key locators of our keys contain CLN's channel database ID, which together
+with the peer's node key is what CLN derives the channel keys from. If the
+hsm_secret is given, those keys are derived and shown in the log.
+
+Peers that are only known by a host name are included without an address.
+
+Apart from dumpbackup, no other command reads CLN backups directly, so this
+command is how a CLN backup is used with chantools. scbforceclose can't use
+the converted backup, since CLN backups contain no commitment transaction.`,
+		Example: `chantools clntobackup \
+	--cln_file ~/.lightning/bitcoin/emergency.recover \
+	--hsm_secret 3f0a06c6....
+
+lightning-cli staticbackup > scb.json
+chantools clntobackup --scb_json scb.json`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ClnFile, "cln_file", "", "CLN emergency.recover file to "+
+			"convert",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ScbJSON, "scb_json", "", "JSON output of 'lightning-cli "+
+			"staticbackup' to convert",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.HsmSecret, "hsm_secret", "", "the hex encoded HSM secret "+
+			"of the CLN node, for decrypting the "+
+			"emergency.recover file and deriving the channel "+
+			"keys; obtain by running 'xxd -p -c32 "+
+			"~/.lightning/bitcoin/hsm_secret'",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "encrypting the backup")
+
+	return cc.cmd
+}
+
+func (c *clnToBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	singles, err := readClnBackup(c.ClnFile, c.ScbJSON, c.HsmSecret)
+	if err != nil {
+		return err
+	}
+	for _, single := range singles {
+		log.Infof("Channel %v with peer %x, CLN database ID %d, our "+
+			"multisig key %s", single.FundingOutpoint,
+			single.RemoteNodePub.SerializeCompressed(),
+			single.LocalChanCfg.MultiSigKey.Index,
+			pubKeyString(single.LocalChanCfg.MultiSigKey.PubKey))
+	}
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	fileName := fmt.Sprintf("%s/backup-from-cln-%s.backup", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing %d channels to %s", len(singles), fileName)
+	multiFile := chanbackup.NewMultiFile(fileName, noBackupArchive)
+
+	return writeBackups(singles, keyRing, multiFile)
+}
+
+// parseHsmSecret parses a hex encoded CLN HSM secret.
+func parseHsmSecret(hsmSecretHex string) ([32]byte, error) {
+	var hsmSecret [32]byte
+	secretBytes, err := hex.DecodeString(hsmSecretHex)
+	if err != nil {
+		return hsmSecret, fmt.Errorf("error decoding HSM secret: %w",
+			err)
+	}
+	if len(secretBytes) != len(hsmSecret) {
+		return hsmSecret, fmt.Errorf("HSM secret must be %d bytes",
+			len(hsmSecret))
+	}
+	copy(hsmSecret[:], secretBytes)
+
+	return hsmSecret, nil
+}
+
+// readClnBackup reads a CLN static channel backup, either from an
+// emergency.recover file or from the JSON output of the staticbackup RPC, and
+// converts it into lnd channel backups.
+func readClnBackup(clnFile, scbJSON,
+	hsmSecretHex string) ([]chanbackup.Single, error) {
+
+	hsmSecret := fn.None[[32]byte]()
+	if hsmSecretHex != "" {
+		secret, err := parseHsmSecret(hsmSecretHex)
+		if err != nil {
+			return nil, err
+		}
+		hsmSecret = fn.Some(secret)
+	}
+
+	var channels []*cln.ScbChan
+	switch {
+	case clnFile != "" && scbJSON != "":
+		return nil, errors.New("must not pass --cln_file and " +
+			"--scb_json together")
+
+	case clnFile != "":
+		if hsmSecret.IsNone() {
+			return nil, errors.New("HSM secret is required to " +
+				"decrypt the emergency.recover file")
+		}
+		content, err := os.ReadFile(clnFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", clnFile,
+				err)
+		}
+		backup, err := cln.DecryptEmergencyRecover(
+			hsmSecret.UnwrapOr([32]byte{}), content,
+		)
+		if err != nil {
+			return nil, err
+		}
+		channels = backup.Channels
+
+	case scbJSON != "":
+		content, err := os.ReadFile(scbJSON)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", scbJSON,
+				err)
+		}
+		var staticBackup clnStaticBackup
+		if err := json.Unmarshal(content, &staticBackup); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", scbJSON,
+				err)
+		}
+		for idx, scbHex := range staticBackup.SCB {
+			scbBytes, err := hex.DecodeString(scbHex)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding "+
+					"channel %d: %w", idx, err)
+			}
+			channel, err := cln.DecodeScbChan(scbBytes)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding "+
+					"channel %d: %w", idx, err)
+			}
+			channels = append(channels, channel)
+		}
+
+	default:
+		return nil, errors.New("either --cln_file or --scb_json is " +
+			"required")
+	}
+
+	singles := make([]chanbackup.Single, len(channels))
+	for idx, channel := range channels {
+		var err error
+		singles[idx], err = channel.Single(
+			*chainParams.GenesisHash, hsmSecret,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error converting channel %v: "+
+				"%w", channel.Funding, err)
+		}
+		if len(singles[idx].Addresses) == 0 {
+			log.Warnf("Address %v of peer %x of channel %v can't "+
+				"be used by lnd", channel.Addr,
+				channel.NodeID.SerializeCompressed(),
+				channel.Funding)
+		}
+	}
+
+	return singles, nil
+}
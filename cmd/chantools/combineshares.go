@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightninglabs/chantools/shamir"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/spf13/cobra"
+)
+
+const (
+	combineSharesAezeedFormat = `
+Your recovered 24 word lnd aezeed is: %s
+
+The seed is still protected by its original passphrase (if one was set).
+`
+
+	combineSharesRootKeyFormat = `
+Your recovered BIP32 HD root key is: %v
+`
+)
+
+type combineSharesCommand struct {
+	Shares []string
+
+	cmd *cobra.Command
+}
+
+func newCombineSharesCommand() *cobra.Command {
+	cc := &combineSharesCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "combineshares",
+		Short: "Recover the lnd aezeed or a root key from Shamir " +
+			"shares",
+		Long: `This command combines shares that were created with the
+splitseed command and shows the recovered 24 word lnd aezeed or BIP32 HD root
+key.
+
+The --shares flag must be given once for every share. If more shares than
+needed are given, they are checked to belong to the same secret.
+
+Instead of combining the shares with this command, the --shares flag can also
+be used as a root key source in all other commands directly.`,
+		Example: `chantools combineshares \
+	--shares "first share words ..." \
+	--shares "second share words ..."`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringSliceVar(
+		&cc.Shares, "shares", nil, "the shares to combine; specify "+
+			"the flag once per share",
+	)
+
+	return cc.cmd
+}
+
+func (c *combineSharesCommand) Execute(_ *cobra.Command, _ []string) error {
+	secretType, secret, err := combineShares(c.Shares)
+	if err != nil {
+		return err
+	}
+
+	var result string
+	switch secretType {
+	case shamir.SecretAezeed:
+		var cipherText [aezeed.EncipheredCipherSeedSize]byte
+		copy(cipherText[:], secret)
+
+		mnemonic := lnd.CipherTextToMnemonic(cipherText)
+		result = fmt.Sprintf(
+			combineSharesAezeedFormat,
+			strings.Join(mnemonic[:], " "),
+		)
+
+	case shamir.SecretRootKey:
+		extendedKey, err := rootKeyFromSecret(secret)
+		if err != nil {
+			return err
+		}
+		result = fmt.Sprintf(combineSharesRootKeyFormat, extendedKey)
+
+	default:
+		return fmt.Errorf("unsupported secret type %v", secretType)
+	}
+
+	fmt.Println(result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
+}
+
+// combineShares parses and combines the given share mnemonics.
+func combineShares(mnemonics []string) (shamir.SecretType, []byte, error) {
+	if len(mnemonics) == 0 {
+		return 0, nil, errors.New("no shares given")
+	}
+
+	shares := make([]*shamir.Share, len(mnemonics))
+	for idx, mnemonic := range mnemonics {
+		share, err := shamir.ParseShare(mnemonic)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error parsing share %d: %w",
+				idx+1, err)
+		}
+		shares[idx] = share
+	}
+
+	secretType, secret, err := shamir.Combine(shares)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error combining shares: %w", err)
+	}
+
+	return secretType, secret, nil
+}
+
+// rootKeyFromSecret creates the BIP32 HD root key from a combined root key
+// secret, which is the chain code followed by the private key.
+func rootKeyFromSecret(secret []byte) (*hdkeychain.ExtendedKey, error) {
+	if len(secret) != 64 {
+		return nil, fmt.Errorf("invalid root key secret length %d",
+			len(secret))
+	}
+
+	return hdkeychain.NewExtendedKey(
+		chainParams.HDPrivateKeyID[:], secret[32:], secret[:32],
+		[]byte{0, 0, 0, 0}, 0, 0, true,
+	), nil
+}
+
+// rootKeyFromShares combines the given shares and returns the root key and the
+// wallet birthday. For an aezeed, the passphrase is read from the terminal.
+func rootKeyFromShares(mnemonics []string) (*hdkeychain.ExtendedKey,
+	time.Time, error) {
+
+	secretType, secret, err := combineShares(mnemonics)
+	if err != nil {
+		return nil, time.Unix(0, 0), err
+	}
+
+	switch secretType {
+	case shamir.SecretAezeed:
+		var cipherText [aezeed.EncipheredCipherSeedSize]byte
+		copy(cipherText[:], secret)
+		mnemonic := lnd.CipherTextToMnemonic(cipherText)
+
+		passphrase, err := lnd.ReadPassphrase("doesn't have")
+		if err != nil {
+			return nil, time.Unix(0, 0), err
+		}
+
+		cipherSeed, err := mnemonic.ToCipherSeed(passphrase)
+		if err != nil {
+			return nil, time.Unix(0, 0), fmt.Errorf("failed to "+
+				"decrypt seed with passphrase: %w", err)
+		}
+		extendedKey, err := hdkeychain.NewMaster(
+			cipherSeed.Entropy[:], chainParams,
+		)
+		if err != nil {
+			return nil, time.Unix(0, 0), fmt.Errorf("failed to "+
+				"derive master extended key: %w", err)
+		}
+
+		return extendedKey, cipherSeed.BirthdayTime(), nil
+
+	case shamir.SecretRootKey:
+		extendedKey, err := rootKeyFromSecret(secret)
+		return extendedKey, time.Unix(0, 0), err
+
+	default:
+		return nil, time.Unix(0, 0), fmt.Errorf("unsupported secret "+
+			"type %v", secretType)
+	}
+}
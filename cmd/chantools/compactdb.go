@@ -95,6 +95,16 @@ func (c *compactDBCommand) openDB(path string, ro bool) (*bbolt.DB, error) {
 }
 
 func (c *compactDBCommand) compact(dst, src *bbolt.DB) error {
+	return copyDB(dst, c.TxMaxSize, func(fn walkFunc) error {
+		return c.walk(src, fn)
+	})
+}
+
+// copyDB writes all buckets and key/value pairs discovered by walk to the
+// destination DB.
+func copyDB(dst *bbolt.DB, txMaxSize int64,
+	walk func(walkFn walkFunc) error) error {
+
 	// commit regularly, or we'll run out of memory for large datasets if
 	// using one transaction.
 	var size int64
@@ -106,10 +116,10 @@ func (c *compactDBCommand) compact(dst, src *bbolt.DB) error {
 		_ = tx.Rollback()
 	}()
 
-	if err := c.walk(src, func(keys [][]byte, k, v []byte, seq uint64) error {
+	if err := walk(func(keys [][]byte, k, v []byte, seq uint64) error {
 		// On each key/value, check if we have exceeded tx size.
 		sz := int64(len(k) + len(v))
-		if size+sz > c.TxMaxSize && c.TxMaxSize != 0 {
+		if size+sz > txMaxSize && txMaxSize != 0 {
 			// Commit previous transaction.
 			if err := tx.Commit(); err != nil {
 				return err
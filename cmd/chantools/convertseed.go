@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightninglabs/chantools/shamir"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/spf13/cobra"
+)
+
+const (
+	convertSeedToAezeed  = "aezeed"
+	convertSeedToRootKey = "rootkey"
+	convertSeedToBIP39   = "bip39"
+
+	convertSeedRootKeyFormat = `
+Your BIP32 HD root key is: %v
+`
+
+	convertSeedAezeedFormat = `
+Your re-enciphered 24 word lnd aezeed is: %s
+Wallet birthday: %s
+BIP32 HD root key (unchanged): %v
+`
+)
+
+var (
+	errConvertRootKeyToSeed = errors.New("a BIP32 HD root key is " +
+		"derived from the seed with a one-way hash function, the " +
+		"seed can't be recovered from it")
+
+	errConvertBIP39ToAezeed = errors.New("a BIP39 mnemonic and its " +
+		"passphrase are stretched with PBKDF2 into a 64 byte BIP32 " +
+		"seed, which can't be represented by the 16 bytes of entropy " +
+		"of an aezeed; lnd can't use BIP39 seeds, use --to rootkey " +
+		"instead")
+
+	errConvertAezeedToBIP39 = errors.New("lnd uses the 16 bytes of " +
+		"entropy of an aezeed as the BIP32 seed directly while BIP39 " +
+		"derives it from the mnemonic and passphrase with PBKDF2, " +
+		"which can't be reversed; there is no BIP39 mnemonic for an " +
+		"lnd root key")
+)
+
+type convertSeedCommand struct {
+	To       string
+	Birthday string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newConvertSeedCommand() *cobra.Command {
+	cc := &convertSeedCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "convertseed",
+		Short: "Convert between the aezeed, BIP39 and BIP32 root key " +
+			"formats",
+		Long: `This command converts a seed into a different
+representation, as far as that is possible while keeping the same wallet keys.
+
+--to aezeed: Re-enciphers a 24 word lnd aezeed with a new passphrase and
+optionally a new wallet birthday. The wallet keys stay the same. The new
+passphrase is read from the terminal or the AEZEED_NEW_PASSPHRASE environment
+variable (use '-' for no passphrase). The aezeed can also be given as shares
+created with the splitseed command.
+
+--to rootkey: Shows the BIP32 HD root key (xprv) of any supported seed source,
+like the showrootkey command, together with the wallet birthday if known.
+
+--to bip39: Not possible for lnd wallets. A BIP39 mnemonic is stretched with
+PBKDF2 into the BIP32 seed, so no BIP39 mnemonic can be derived from an aezeed
+or root key. The same is true the other way around, a BIP39 seed (with or
+without passphrase) can't be converted into an aezeed. The command explains
+why instead of producing a seed with different keys.`,
+		Example: `chantools convertseed --to aezeed \
+	--birthday 2021-03-01
+
+chantools convertseed --to rootkey --bip39`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.To, "to", convertSeedToAezeed, "the format to convert "+
+			"the seed into; possible values are 'aezeed', "+
+			"'rootkey' and 'bip39'",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Birthday, "birthday", "", "the new wallet birthday of a "+
+			"re-enciphered aezeed in the format YYYY-MM-DD; leave "+
+			"empty to keep the birthday of the original seed",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "converting")
+
+	return cc.cmd
+}
+
+func (c *convertSeedCommand) Execute(_ *cobra.Command, _ []string) error {
+	var result string
+	switch c.To {
+	case convertSeedToRootKey:
+		extendedKey, birthday, err := c.rootKey.readWithBirthday()
+		if err != nil {
+			return fmt.Errorf("error reading root key: %w", err)
+		}
+
+		result = fmt.Sprintf(convertSeedRootKeyFormat, extendedKey)
+
+		// Root key sources without a birthday return the Unix epoch.
+		if birthday.Unix() > 0 {
+			result += fmt.Sprintf("Wallet birthday: %s\n",
+				birthday.Format("2006-01-02"))
+		}
+
+	case convertSeedToAezeed:
+		cipherSeed, err := c.readCipherSeed()
+		if err != nil {
+			return err
+		}
+
+		birthday := cipherSeed.BirthdayTime()
+		if c.Birthday != "" {
+			birthday, err = time.Parse("2006-01-02", c.Birthday)
+			if err != nil {
+				return fmt.Errorf("error parsing birthday: %w",
+					err)
+			}
+		}
+
+		passphrase, err := readNewPassphrase()
+		if err != nil {
+			return fmt.Errorf("error reading new passphrase: %w",
+				err)
+		}
+
+		mnemonic, birthday, err := reencipherAezeed(
+			cipherSeed, birthday, passphrase,
+		)
+		if err != nil {
+			return err
+		}
+		extendedKey, err := hdkeychain.NewMaster(
+			cipherSeed.Entropy[:], chainParams,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to derive master extended "+
+				"key: %w", err)
+		}
+
+		printCipherSeedWords(mnemonic[:])
+		result = fmt.Sprintf(
+			convertSeedAezeedFormat, strings.Join(mnemonic[:], " "),
+			birthday.Format("2006-01-02"), extendedKey,
+		)
+
+	case convertSeedToBIP39:
+		if c.rootKey.BIP39 {
+			return errors.New("the seed already is a BIP39 " +
+				"mnemonic")
+		}
+
+		return errConvertAezeedToBIP39
+
+	default:
+		return fmt.Errorf("invalid target format '%s'", c.To)
+	}
+
+	fmt.Println(result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
+}
+
+// readCipherSeed reads the aezeed from the terminal, the environment or from
+// shares and deciphers it with its passphrase.
+func (c *convertSeedCommand) readCipherSeed() (*aezeed.CipherSeed, error) {
+	var mnemonic *aezeed.Mnemonic
+	switch {
+	case c.rootKey.RootKey != "" || c.rootKey.WalletDB != "":
+		return nil, errConvertRootKeyToSeed
+
+	case c.rootKey.BIP39:
+		return nil, errConvertBIP39ToAezeed
+
+	case len(c.rootKey.Shares) > 0:
+		secretType, secret, err := combineShares(c.rootKey.Shares)
+		if err != nil {
+			return nil, err
+		}
+		if secretType != shamir.SecretAezeed {
+			return nil, errConvertRootKeyToSeed
+		}
+
+		var cipherText [aezeed.EncipheredCipherSeedSize]byte
+		copy(cipherText[:], secret)
+		shareMnemonic := lnd.CipherTextToMnemonic(cipherText)
+		mnemonic = &shareMnemonic
+
+	default:
+		words, err := lnd.ReadMnemonicWords()
+		if err != nil {
+			return nil, err
+		}
+		mnemonic, err = checkedMnemonic(words)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Println()
+	}
+
+	passphrase, err := lnd.ReadPassphrase("doesn't have")
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSeed, err := mnemonic.ToCipherSeed(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt seed with "+
+			"passphrase: %w", err)
+	}
+
+	return cipherSeed, nil
+}
+
+// reencipherAezeed enciphers the entropy of the given seed with a new birthday
+// and passphrase and makes sure the result deciphers to the same entropy. The
+// birthday of the new seed is returned, which is rounded down to the day
+// counted from the genesis block.
+func reencipherAezeed(cipherSeed *aezeed.CipherSeed, birthday time.Time,
+	passphrase []byte) (aezeed.Mnemonic, time.Time, error) {
+
+	var mnemonic aezeed.Mnemonic
+	if birthday.Before(aezeed.BitcoinGenesisDate) {
+		return mnemonic, birthday, fmt.Errorf("birthday can't be "+
+			"before the Bitcoin genesis date %s",
+			aezeed.BitcoinGenesisDate.Format("2006-01-02"))
+	}
+
+	newSeed, err := aezeed.New(
+		cipherSeed.InternalVersion, &cipherSeed.Entropy, birthday,
+	)
+	if err != nil {
+		return mnemonic, birthday, fmt.Errorf("error creating seed: "+
+			"%w", err)
+	}
+
+	mnemonic, err = newSeed.ToMnemonic(passphrase)
+	if err != nil {
+		return mnemonic, birthday, fmt.Errorf("error converting seed "+
+			"to mnemonic: %w", err)
+	}
+
+	// Better safe than sorry, make sure the new seed can be read back.
+	checkSeed, err := mnemonic.ToCipherSeed(passphrase)
+	if err != nil {
+		return mnemonic, birthday, fmt.Errorf("error checking new "+
+			"seed: %w", err)
+	}
+	if checkSeed.Entropy != cipherSeed.Entropy {
+		return mnemonic, birthday, errors.New("new seed doesn't " +
+			"decipher to the same entropy")
+	}
+
+	return mnemonic, checkSeed.BirthdayTime(), nil
+}
+
+// readNewPassphrase reads the new passphrase for a re-enciphered aezeed from
+// the environment or the terminal.
+func readNewPassphrase() ([]byte, error) {
+	passphrase := strings.TrimSpace(os.Getenv(lnd.NewPassphraseEnvName))
+
+	// Like with the other environment variables, a single dash indicates
+	// that no passphrase should be used.
+	switch {
+	case passphrase == "-":
+		return nil, nil
+
+	case passphrase == "":
+		pw, err := lnd.PasswordFromConsole("Input new cipher seed " +
+			"passphrase (press enter for no passphrase): ")
+		if err != nil {
+			return nil, err
+		}
+		pw2, err := lnd.PasswordFromConsole(
+			"Confirm new cipher seed passphrase: ",
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(pw, pw2) {
+			return nil, errors.New("passphrases don't match")
+		}
+
+		return pw, nil
+
+	default:
+		return []byte(passphrase), nil
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertSeedAezeed(t *testing.T) {
+	h := newHarness(t)
+
+	convert := &convertSeedCommand{
+		To:       convertSeedToAezeed,
+		Birthday: "2021-03-01",
+		rootKey:  &rootKey{},
+	}
+
+	t.Setenv(lnd.MnemonicEnvName, seedAezeedWithPassphrase)
+	t.Setenv(lnd.PassphraseEnvName, testPassPhrase)
+	t.Setenv(lnd.NewPassphraseEnvName, "-")
+
+	err := convert.Execute(nil, nil)
+	require.NoError(t, err)
+
+	// The birthday is counted in days from the genesis block, which was
+	// mined in the evening, so the date is rounded down.
+	h.assertLogContains(rootKeyAezeed)
+	h.assertLogContains("Wallet birthday: 2021-02-28")
+
+	// The re-enciphered seed gives the same root key without passphrase.
+	cipherSeed, err := convert.readCipherSeed()
+	require.NoError(t, err)
+
+	birthday := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	mnemonic, newBirthday, err := reencipherAezeed(
+		cipherSeed, birthday, nil,
+	)
+	require.NoError(t, err)
+	require.Equal(t, "2021-02-28", newBirthday.Format("2006-01-02"))
+
+	newSeed, err := mnemonic.ToCipherSeed(nil)
+	require.NoError(t, err)
+	require.Equal(t, cipherSeed.Entropy, newSeed.Entropy)
+	require.Equal(t, cipherSeed.InternalVersion, newSeed.InternalVersion)
+	require.Equal(t, newBirthday, newSeed.BirthdayTime())
+
+	_, _, err = reencipherAezeed(
+		cipherSeed, aezeed.BitcoinGenesisDate.Add(-time.Hour), nil,
+	)
+	require.ErrorContains(t, err, "before the Bitcoin genesis date")
+}
+
+func TestConvertSeedRootKey(t *testing.T) {
+	h := newHarness(t)
+
+	convert := &convertSeedCommand{
+		To:      convertSeedToRootKey,
+		rootKey: &rootKey{},
+	}
+
+	t.Setenv(lnd.MnemonicEnvName, seedAezeedNoPassphrase)
+	t.Setenv(lnd.PassphraseEnvName, "-")
+
+	err := convert.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(rootKeyAezeed)
+	h.assertLogContains("Wallet birthday: ")
+}
+
+func TestConvertSeedImpossible(t *testing.T) {
+	convert := &convertSeedCommand{
+		To:      convertSeedToAezeed,
+		rootKey: &rootKey{BIP39: true},
+	}
+	err := convert.Execute(nil, nil)
+	require.ErrorIs(t, err, errConvertBIP39ToAezeed)
+
+	convert.rootKey = &rootKey{RootKey: rootKeyAezeed}
+	err = convert.Execute(nil, nil)
+	require.ErrorIs(t, err, errConvertRootKeyToSeed)
+
+	convert.To = convertSeedToBIP39
+	err = convert.Execute(nil, nil)
+	require.ErrorIs(t, err, errConvertAezeedToBIP39)
+
+	convert.To = "xpub"
+	err = convert.Execute(nil, nil)
+	require.ErrorContains(t, err, "invalid target format")
+}
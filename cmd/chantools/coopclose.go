@@ -0,0 +1,1216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultCoopCloseSearchRange = 1000
+
+	// maxCloseNegotiationRounds is the maximum number of closing_signed
+	// messages we exchange with the peer before giving up on the fee
+	// negotiation.
+	maxCloseNegotiationRounds = 10
+)
+
+var (
+	// coopCloseReplyTimeout is the time we give the peer to answer any of
+	// our messages.
+	coopCloseReplyTimeout = time.Minute
+
+	// maxDefaultDustLimit is the highest dust limit any of the common
+	// implementations uses by default (the P2PKH dust limit). If the dust
+	// limits negotiated for a channel are unknown, no output of the legacy
+	// closing transaction may be below this value, as we couldn't know
+	// whether the peer omits it or not.
+	maxDefaultDustLimit = lnwallet.DustLimitForSize(input.P2PKHSize)
+)
+
+type coopCloseCommand struct {
+	APIURL       string
+	Peer         string
+	TorProxy     string
+	ChannelPoint string
+	CloseAddr    string
+	LocalAmount  uint64
+	SearchRange  uint64
+	FeeRate      uint32
+	MaxFeeRate   uint32
+	Publish      bool
+
+	ChannelDB       string
+	PeerInitiator   bool
+	LocalDustLimit  uint64
+	RemoteDustLimit uint64
+
+	// How the channel backup is provided.
+	SingleBackup string
+	SingleFile   string
+	MultiBackup  string
+	MultiFile    string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newCoopCloseCommand() *cobra.Command {
+	cc := &coopCloseCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "coopclose",
+		Short: "Cooperatively close a channel with a peer that is " +
+			"still online, using the seed and a channel backup",
+		Long: `If the channel.db of a node is lost but the remote peer
+is still online and knows the channel, a cooperative close is much cheaper and
+faster than a force close. This command connects to the peer with the node's
+identity key and negotiates a cooperative close of the given channel.
+
+The channel is looked up in the given channel backup (a real one or one
+created with the fakechanbackup command). The funding keys are taken from the
+backup if they match the funding output. Otherwise (e.g. for a fake backup) the
+channel announcement is requested from the peer and our funding key is searched
+for with the keys of the announcement, which only works for public channels.
+
+Who opened the channel is taken from the channel backup. Fake channel backups
+always claim that we opened the channel, so --peer_initiator must be set if
+the peer opened it. The legacy protocol also needs the dust limits negotiated
+for the channel. Both are read from the channel DB instead if --channeldb
+points to a (possibly outdated) channel.db that still contains the channel.
+Otherwise the dust limits can be set with --local_dust_limit and
+--remote_dust_limit. If they are unknown, the close is refused if any output
+would be small enough to be dust for one of the peers.
+
+Since the channel state is unknown, the amount of the channel capacity that
+belongs to us must be specified with --local_amount. This is the local balance
+plus, if we opened the channel, the commitment fee and the value of the anchor
+outputs. If the peer signs the closing transaction first, the exact amount is
+searched for in the range given by --search_range around that value.
+
+Both the legacy closing_signed fee negotiation and the RBF cooperative close
+protocol (closing_complete/closing_sig) are supported, depending on what the
+peer announces. Before the shutdown, the channel is re-established with the
+commitment heights the peer reports, as our own channel state is unknown. The
+peer should have no pending HTLCs on the channel.
+
+The fully signed closing transaction is printed and published if --publish is
+set. Usually the peer publishes it as well.`,
+		Example: `chantools coopclose \
+	--channel_point abcdef01234...:1 \
+	--multi_file channel.backup \
+	--local_amount 123456 \
+	--closeaddr bc1q..... \
+	--feerate 10 \
+	--publish`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
+			"be esplora compatible)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Peer, "peer", "", "address of the remote peer "+
+			"(<host>[:<port>]); if not set, the addresses from "+
+			"the channel backup are used",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.TorProxy, "torproxy", "", "SOCKS5 proxy to use for Tor "+
+			"connections (to .onion addresses)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelPoint, "channel_point", "", "funding transaction "+
+			"outpoint of the channel to close (<txid>:<txindex>)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.CloseAddr, "closeaddr", "", "address to send our share "+
+			"of the channel funds to; specify '"+
+			lnd.AddressDeriveFromWallet+"' to derive a new "+
+			"address from the seed automatically",
+	)
+	cc.cmd.Flags().Uint64Var(
+		&cc.LocalAmount, "local_amount", 0, "the amount in satoshis "+
+			"of the channel capacity that belongs to us, before "+
+			"the closing fee is deducted",
+	)
+	cc.cmd.Flags().Uint64Var(
+		&cc.SearchRange, "search_range", defaultCoopCloseSearchRange,
+		"the number of satoshis below and above --local_amount to "+
+			"try when matching the peer's signature",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"propose for the closing transaction in sat/vByte",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.MaxFeeRate, "max_feerate", 0, "maximum fee rate in "+
+			"sat/vByte we accept to pay if the peer asks for a "+
+			"higher fee than proposed; defaults to --feerate",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish the closing TX to "+
+			"the chain API instead of just printing the TX",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "optional lnd channel.db "+
+			"file that still contains the channel, used to read "+
+			"who opened the channel and the negotiated dust "+
+			"limits",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.PeerInitiator, "peer_initiator", false, "the peer opened "+
+			"the channel; needs to be set for fake channel "+
+			"backups, which always claim that we opened the "+
+			"channel",
+	)
+	cc.cmd.Flags().Uint64Var(
+		&cc.LocalDustLimit, "local_dust_limit", 0, "our dust limit "+
+			"in satoshis negotiated for the channel, if not "+
+			"read from --channeldb",
+	)
+	cc.cmd.Flags().Uint64Var(
+		&cc.RemoteDustLimit, "remote_dust_limit", 0, "the peer's "+
+			"dust limit in satoshis negotiated for the channel, "+
+			"if not read from --channeldb",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SingleBackup, "single_backup", "", "a hex encoded single "+
+			"channel backup obtained from exportchanbackup",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.MultiBackup, "multi_backup", "", "a hex encoded "+
+			"multi-channel backup obtained from exportchanbackup",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SingleFile, "single_file", "", "the path to a "+
+			"single-channel backup file",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", "", "the path to a "+
+			"multi-channel backup file (channel.backup)",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
+
+	return cc.cmd
+}
+
+// coopCloseChannel holds everything we need to know about a channel to create
+// and sign its cooperative close transaction.
+type coopCloseChannel struct {
+	chanPoint     wire.OutPoint
+	chanID        lnwire.ChannelID
+	capacity      btcutil.Amount
+	pkScript      []byte
+	witnessScript []byte
+	localKey      *keychain.KeyDescriptor
+	remoteKey     *btcec.PublicKey
+
+	// initiator is true if we opened the channel.
+	initiator bool
+
+	// localDust and remoteDust are the dust limits negotiated for the
+	// channel. They are zero if unknown.
+	localDust  btcutil.Amount
+	remoteDust btcutil.Amount
+}
+
+// coopCloseConfig holds the parameters of the close negotiation.
+type coopCloseConfig struct {
+	closeScript []byte
+	localAmount btcutil.Amount
+	searchRange btcutil.Amount
+	feeRate     chainfee.SatPerKWeight
+	maxFeeRate  chainfee.SatPerKWeight
+}
+
+func (c *coopCloseCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	if c.ChannelPoint == "" {
+		return errors.New("channel point is required")
+	}
+	chanPoint, err := parseOutPoint(c.ChannelPoint)
+	if err != nil {
+		return fmt.Errorf("error parsing channel point: %w", err)
+	}
+	if !c.cmd.Flags().Changed("local_amount") {
+		return errors.New("local amount is required")
+	}
+
+	err = lnd.CheckAddress(
+		c.CloseAddr, chainParams, true, "close", lnd.AddrTypeP2WKH,
+		lnd.AddrTypeP2WSH, lnd.AddrTypeP2TR,
+	)
+	if err != nil {
+		return err
+	}
+	closeScript, err := lnd.PrepareWalletAddress(
+		c.CloseAddr, chainParams, nil, extendedKey, "close",
+	)
+	if err != nil {
+		return err
+	}
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	backups, err := readChannelBackups(
+		c.SingleBackup, c.SingleFile, c.MultiBackup, c.MultiFile,
+		keyRing,
+	)
+	if err != nil {
+		return err
+	}
+	var backup *chanbackup.Single
+	for idx := range backups {
+		if backups[idx].FundingOutpoint == *chanPoint {
+			backup = &backups[idx]
+			break
+		}
+	}
+	if backup == nil {
+		return fmt.Errorf("channel %v not found in channel backup",
+			chanPoint)
+	}
+
+	// The funding output tells us the capacity of the channel and whether
+	// it's still open at all.
+	api := newExplorerAPI(c.APIURL)
+	fundingTx, err := api.Transaction(chanPoint.Hash.String())
+	if err != nil {
+		return fmt.Errorf("error fetching funding transaction: %w", err)
+	}
+	if int(chanPoint.Index) >= len(fundingTx.Vout) {
+		return fmt.Errorf("funding transaction has no output %d",
+			chanPoint.Index)
+	}
+	fundingOut := fundingTx.Vout[chanPoint.Index]
+	if fundingOut.Outspend != nil && fundingOut.Outspend.Spent {
+		return fmt.Errorf("channel %v is already closed by "+
+			"transaction %s", chanPoint, fundingOut.Outspend.Txid)
+	}
+	pkScript, err := hex.DecodeString(fundingOut.ScriptPubkey)
+	if err != nil {
+		return fmt.Errorf("error decoding funding script: %w", err)
+	}
+
+	// Both closing protocols assume that our amount is part of the
+	// funding output, otherwise we'd sign a transaction that spends more
+	// than the channel holds.
+	capacity := btcutil.Amount(fundingOut.Value)
+	if btcutil.Amount(c.LocalAmount) > capacity {
+		return fmt.Errorf("local amount %v is larger than the channel "+
+			"capacity %v", btcutil.Amount(c.LocalAmount), capacity)
+	}
+
+	identityPath := lnd.IdentityPath(chainParams)
+	child, _, _, err := lnd.DeriveKey(
+		extendedKey, identityPath, chainParams,
+	)
+	if err != nil {
+		return fmt.Errorf("could not derive identity key: %w", err)
+	}
+	identityPriv, err := child.ECPrivKey()
+	if err != nil {
+		return fmt.Errorf("could not get identity private key: %w",
+			err)
+	}
+	identity := &keychain.PrivKeyECDH{
+		PrivKey: identityPriv,
+	}
+
+	session, err := c.connect(identity, backup)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+
+	channel := &coopCloseChannel{
+		chanPoint:  *chanPoint,
+		chanID:     lnwire.NewChanIDFromOutPoint(*chanPoint),
+		capacity:   capacity,
+		pkScript:   pkScript,
+		initiator:  backup.IsInitiator && !c.PeerInitiator,
+		localDust:  btcutil.Amount(c.LocalDustLimit),
+		remoteDust: btcutil.Amount(c.RemoteDustLimit),
+	}
+	if c.ChannelDB != "" {
+		db, _, err := lnd.OpenDB(c.ChannelDB, true)
+		if err != nil {
+			return fmt.Errorf("error opening channel DB: %w", err)
+		}
+		dbChan, err := db.ChannelStateDB().FetchChannel(*chanPoint)
+		_ = db.Close()
+		if err != nil {
+			return fmt.Errorf("error loading channel %v from "+
+				"DB: %w", chanPoint, err)
+		}
+
+		channel.initiator = dbChan.IsInitiator
+		channel.localDust = dbChan.LocalChanCfg.DustLimit
+		channel.remoteDust = dbChan.RemoteChanCfg.DustLimit
+	}
+	log.Infof("Channel opened by us: %v, dust limits local %v, remote %v",
+		channel.initiator, channel.localDust, channel.remoteDust)
+
+	// The channel must be re-established before anything else is sent
+	// for it. The peer sends its channel_reestablish right after the init
+	// message, so we need to read it before querying anything else.
+	if err := reestablishChannel(session, channel.chanID); err != nil {
+		return err
+	}
+
+	err = channel.resolveFundingKeys(session, backup, keyRing, signer)
+	if err != nil {
+		return err
+	}
+
+	maxFeeRate := c.MaxFeeRate
+	if maxFeeRate < c.FeeRate {
+		maxFeeRate = c.FeeRate
+	}
+	cfg := &coopCloseConfig{
+		closeScript: closeScript,
+		localAmount: btcutil.Amount(c.LocalAmount),
+		searchRange: btcutil.Amount(c.SearchRange),
+		feeRate: chainfee.SatPerKVByte(
+			1000 * c.FeeRate,
+		).FeePerKWeight(),
+		maxFeeRate: chainfee.SatPerKVByte(
+			1000 * maxFeeRate,
+		).FeePerKWeight(),
+	}
+
+	remoteFeatures, err := session.RemoteFeatures()
+	if err != nil {
+		return err
+	}
+	useRbf := remoteFeatures.HasFeature(lnwire.RbfCoopCloseOptional) ||
+		remoteFeatures.HasFeature(lnwire.RbfCoopCloseOptionalStaging)
+
+	closeTx, err := coopClose(session, channel, signer, cfg, useRbf)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := closeTx.Serialize(&buf); err != nil {
+		return fmt.Errorf("error serializing closing tx: %w", err)
+	}
+	txHex := hex.EncodeToString(buf.Bytes())
+
+	log.Infof("Cooperative close of channel %v negotiated, closing "+
+		"transaction %v", chanPoint, closeTx.TxHash())
+	for idx, txOut := range closeTx.TxOut {
+		log.Infof("Output %d: %d sats to script %x", idx, txOut.Value,
+			txOut.PkScript)
+	}
+
+	// Publish TX.
+	if c.Publish {
+		response, err := api.PublishTx(txHex)
+		if err != nil {
+			return err
+		}
+		log.Infof("Published TX %s, response: %s",
+			closeTx.TxHash(), response)
+	}
+
+	log.Infof("Transaction: %s", txHex)
+
+	return nil
+}
+
+// connect establishes a session with the channel peer, trying all known
+// addresses of the peer.
+func (c *coopCloseCommand) connect(identity keychain.SingleKeyECDH,
+	backup *chanbackup.Single) (*lnd.Session, error) {
+
+	peer := hex.EncodeToString(backup.RemoteNodePub.SerializeCompressed())
+
+	addrs := []string{c.Peer}
+	if c.Peer == "" {
+		addrs = make([]string, 0, len(backup.Addresses))
+		for _, addr := range backup.Addresses {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address known for peer %s, use "+
+			"--peer", peer)
+	}
+
+	globalFeatures, localFeatures, err := lnd.DefaultInitFeatures()
+	if err != nil {
+		return nil, err
+	}
+	localFeatures.Set(lnwire.RbfCoopCloseOptional)
+
+	for _, addr := range addrs {
+		log.Infof("Connecting to peer %s at %s", peer, addr)
+		conn, err := dialPeer(
+			peer+"@"+addr, c.TorProxy, identity, dialTimeout,
+		)
+		if err != nil {
+			log.Errorf("Error connecting to %s: %v", addr, err)
+			continue
+		}
+
+		// The deadline only applies to the init handshake, all later
+		// reads set their own deadline.
+		session := lnd.NewSession(conn)
+		err = session.SetDeadline(time.Now().Add(coopCloseReplyTimeout))
+		if err == nil {
+			err = session.Init(globalFeatures, localFeatures)
+		}
+		if err == nil {
+			err = session.SetDeadline(time.Time{})
+		}
+		if err != nil {
+			log.Errorf("Error initializing session with %s: %v",
+				addr, err)
+			_ = session.Close()
+			continue
+		}
+
+		return session, nil
+	}
+
+	return nil, fmt.Errorf("could not connect to peer %s", peer)
+}
+
+// resolveFundingKeys finds our and the peer's funding keys of the channel,
+// either from the channel backup or from the channel announcement.
+func (c *coopCloseChannel) resolveFundingKeys(session *lnd.Session,
+	backup *chanbackup.Single, keyRing *lnd.HDKeyRing,
+	signer lnd.ChannelSigner) error {
+
+	if txscript.IsPayToTaproot(c.pkScript) {
+		return errors.New("taproot channels are not supported")
+	}
+
+	// A real channel backup contains both funding keys, a fake one
+	// doesn't.
+	localKey, err := keyRing.DeriveKey(
+		backup.LocalChanCfg.MultiSigKey.KeyLocator,
+	)
+	remoteKey := backup.RemoteChanCfg.MultiSigKey.PubKey
+	if err == nil && remoteKey != nil &&
+		c.setFundingKeys(&localKey, remoteKey) == nil {
+
+		log.Infof("Using funding keys from channel backup")
+		return nil
+	}
+
+	log.Infof("Funding keys in channel backup don't match, querying " +
+		"channel announcement from peer")
+	announcement, err := queryChannelAnnouncement(
+		session, backup.ShortChannelID,
+	)
+	if err != nil {
+		return err
+	}
+
+	nodeKey, err := keyRing.NodePubKey()
+	if err != nil {
+		return fmt.Errorf("error deriving node key: %w", err)
+	}
+	ourKeyBytes, theirKeyBytes := announcement.BitcoinKey1,
+		announcement.BitcoinKey2
+	if announcement.NodeID2 == [33]byte(nodeKey.SerializeCompressed()) {
+		ourKeyBytes, theirKeyBytes = theirKeyBytes, ourKeyBytes
+	}
+	ourKey, err := btcec.ParsePubKey(ourKeyBytes[:])
+	if err != nil {
+		return fmt.Errorf("error parsing funding key: %w", err)
+	}
+	theirKey, err := btcec.ParsePubKey(theirKeyBytes[:])
+	if err != nil {
+		return fmt.Errorf("error parsing funding key: %w", err)
+	}
+
+	localKeyDesc, err := signer.FindMultisigKey(
+		ourKey, theirKey, MaxChannelLookup,
+	)
+	if err != nil {
+		return fmt.Errorf("could not find local multisig key: %w", err)
+	}
+
+	return c.setFundingKeys(localKeyDesc, theirKey)
+}
+
+// setFundingKeys sets the funding keys of the channel after making sure they
+// match the funding output.
+func (c *coopCloseChannel) setFundingKeys(localKey *keychain.KeyDescriptor,
+	remoteKey *btcec.PublicKey) error {
+
+	witnessScript, err := input.GenMultiSigScript(
+		localKey.PubKey.SerializeCompressed(),
+		remoteKey.SerializeCompressed(),
+	)
+	if err != nil {
+		return err
+	}
+	pkScript, err := input.WitnessScriptHash(witnessScript)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(pkScript, c.pkScript) {
+		return errors.New("funding keys don't match funding output")
+	}
+
+	c.localKey = localKey
+	c.remoteKey = remoteKey
+	c.witnessScript = witnessScript
+
+	return nil
+}
+
+// queryChannelAnnouncement asks the peer for the announcement of the channel
+// with the given short channel ID.
+func queryChannelAnnouncement(session *lnd.Session,
+	scid lnwire.ShortChannelID) (*lnwire.ChannelAnnouncement1, error) {
+
+	if scid.ToUint64() == 0 {
+		return nil, errors.New("short channel ID unknown, cannot " +
+			"query channel announcement")
+	}
+
+	err := session.Send(lnwire.NewQueryShortChanIDs(
+		*chainParams.GenesisHash, lnwire.EncodingSortedPlain,
+		[]lnwire.ShortChannelID{scid},
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error querying channel: %w", err)
+	}
+
+	var announcement *lnwire.ChannelAnnouncement1
+	deadline := time.Now().Add(coopCloseReplyTimeout)
+	err = receiveUntil(session, deadline, func(msg lnwire.Message) bool {
+		switch m := msg.(type) {
+		case *lnwire.ChannelAnnouncement1:
+			if m.ShortChannelID == scid {
+				announcement = m
+			}
+
+		case *lnwire.ReplyShortChanIDsEnd:
+			return true
+		}
+
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for channel "+
+			"announcement: %w", err)
+	}
+	if announcement == nil {
+		return nil, fmt.Errorf("peer didn't send announcement for "+
+			"channel %v, maybe it's a private channel", scid)
+	}
+
+	return announcement, nil
+}
+
+// closeTx creates the unsigned cooperative close transaction of the legacy
+// protocol with the given balances. Outputs below the dust limits negotiated
+// for the channel are omitted. If those are unknown, balances that might be
+// dust for either peer are refused.
+func (c *coopCloseChannel) closeTx(ourBalance, theirBalance btcutil.Amount,
+	ourScript, theirScript []byte) (*wire.MsgTx, error) {
+
+	localDust, remoteDust := c.localDust, c.remoteDust
+	if localDust == 0 || remoteDust == 0 {
+		for _, balance := range []btcutil.Amount{
+			ourBalance, theirBalance,
+		} {
+			if balance > 0 && balance < maxDefaultDustLimit {
+				return nil, fmt.Errorf("balance %v might be "+
+					"dust, dust limits of channel are "+
+					"unknown", balance)
+			}
+		}
+
+		// Only empty outputs are omitted.
+		localDust, remoteDust = 1, 1
+	}
+
+	return lnwallet.CreateCooperativeCloseTx(
+		*wire.NewTxIn(&c.chanPoint, nil, nil), localDust, remoteDust,
+		ourBalance, theirBalance, ourScript, theirScript,
+	)
+}
+
+// rbfCloseTx creates the unsigned cooperative close transaction of the RBF
+// protocol with the given balances. In that protocol, outputs are omitted if
+// they are below the dust limit of their own script.
+func (c *coopCloseChannel) rbfCloseTx(ourBalance, theirBalance btcutil.Amount,
+	ourScript, theirScript []byte) (*wire.MsgTx, error) {
+
+	return lnwallet.CreateCooperativeCloseTx(
+		*wire.NewTxIn(&c.chanPoint, nil, nil),
+		lnwallet.DustLimitForSize(len(ourScript)),
+		lnwallet.DustLimitForSize(len(theirScript)),
+		ourBalance, theirBalance, ourScript, theirScript,
+		lnwallet.WithCustomTxInSequence(mempool.MaxRBFSequence),
+	)
+}
+
+// sigHash returns the signature hash of the funding input of the given close
+// transaction.
+func (c *coopCloseChannel) sigHash(tx *wire.MsgTx) ([]byte, error) {
+	return txscript.CalcWitnessSigHash(
+		c.witnessScript, input.NewTxSigHashesV0Only(tx),
+		txscript.SigHashAll, tx, 0, int64(c.capacity),
+	)
+}
+
+// sign creates our signature for the given close transaction.
+func (c *coopCloseChannel) sign(signer lnd.ChannelSigner,
+	tx *wire.MsgTx) (input.Signature, error) {
+
+	fundingOut := &wire.TxOut{
+		PkScript: c.pkScript,
+		Value:    int64(c.capacity),
+	}
+	return signer.SignOutputRaw(tx, &input.SignDescriptor{
+		KeyDesc:       *c.localKey,
+		WitnessScript: c.witnessScript,
+		Output:        fundingOut,
+		HashType:      txscript.SigHashAll,
+		SignMethod:    input.WitnessV0SignMethod,
+		PrevOutputFetcher: txscript.NewCannedPrevOutputFetcher(
+			c.pkScript, int64(c.capacity),
+		),
+		InputIndex: 0,
+	})
+}
+
+// verify checks the peer's signature for the given close transaction.
+func (c *coopCloseChannel) verify(tx *wire.MsgTx,
+	sig lnwire.Sig) (input.Signature, bool) {
+
+	remoteSig, err := sig.ToSignature()
+	if err != nil {
+		return nil, false
+	}
+	sigHash, err := c.sigHash(tx)
+	if err != nil {
+		return nil, false
+	}
+
+	return remoteSig, remoteSig.Verify(sigHash, c.remoteKey)
+}
+
+// complete adds the witness with both signatures to the close transaction.
+func (c *coopCloseChannel) complete(tx *wire.MsgTx, ourSig,
+	theirSig input.Signature) *wire.MsgTx {
+
+	tx.TxIn[0].Witness = input.SpendMultiSig(
+		c.witnessScript, c.localKey.PubKey.SerializeCompressed(),
+		ourSig, c.remoteKey.SerializeCompressed(), theirSig,
+	)
+
+	return tx
+}
+
+// closeFee returns the fee of a close transaction with both outputs at the
+// given fee rate.
+func closeFee(ourScript, theirScript []byte,
+	feeRate chainfee.SatPerKWeight) btcutil.Amount {
+
+	var estimator input.TxWeightEstimator
+	estimator.AddWitnessInput(MultiSigWitnessSize)
+	estimator.AddOutput(ourScript)
+	estimator.AddOutput(theirScript)
+
+	return feeRate.FeeForWeight(estimator.Weight())
+}
+
+// reestablishChannel waits for the peer's channel_reestablish message of the
+// channel and answers it with our own. BOLT#2 requires this exchange after
+// every reconnect before any other message of the channel, including
+// shutdown, can be sent. Since our channel state is lost, we mirror the
+// commitment heights of the peer, so it considers both commitment chains to be
+// in sync. We don't know the last per-commitment secret the peer revealed to
+// us, so the optional data loss protection fields are left out. Sending a
+// wrong secret would make the peer force close the channel.
+func reestablishChannel(session *lnd.Session, chanID lnwire.ChannelID) error {
+	var theirs *lnwire.ChannelReestablish
+	err := receiveChannelMsg(
+		session, chanID, coopCloseReplyTimeout,
+		func(msg lnwire.Message) bool {
+			theirs, _ = msg.(*lnwire.ChannelReestablish)
+			return theirs != nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error waiting for channel_reestablish: %w",
+			err)
+	}
+	if theirs == nil {
+		return errors.New("peer didn't send channel_reestablish, it " +
+			"might not know the channel anymore")
+	}
+
+	log.Infof("Peer re-established channel at next local commitment "+
+		"height %d, remote commitment tail height %d",
+		theirs.NextLocalCommitHeight, theirs.RemoteCommitTailHeight)
+
+	ours := &lnwire.ChannelReestablish{
+		ChanID:                chanID,
+		NextLocalCommitHeight: theirs.RemoteCommitTailHeight + 1,
+	}
+	if theirs.NextLocalCommitHeight > 0 {
+		ours.RemoteCommitTailHeight = theirs.NextLocalCommitHeight - 1
+	}
+	if err := session.Send(ours); err != nil {
+		return fmt.Errorf("error sending channel_reestablish: %w", err)
+	}
+
+	return nil
+}
+
+// coopClose runs the shutdown and closing transaction negotiation with the
+// peer and returns the fully signed closing transaction. The channel must
+// already be re-established with reestablishChannel.
+func coopClose(session *lnd.Session, channel *coopCloseChannel,
+	signer lnd.ChannelSigner, cfg *coopCloseConfig,
+	useRbf bool) (*wire.MsgTx, error) {
+
+	theirScript, err := exchangeShutdown(
+		session, channel.chanID, cfg.closeScript,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if useRbf {
+		log.Infof("Using RBF cooperative close protocol")
+		return rbfCoopClose(session, channel, signer, cfg, theirScript)
+	}
+
+	log.Infof("Using legacy cooperative close protocol")
+	return legacyCoopClose(session, channel, signer, cfg, theirScript)
+}
+
+// exchangeShutdown sends our shutdown message and waits for the peer's. The
+// peer's delivery script is returned.
+func exchangeShutdown(session *lnd.Session, chanID lnwire.ChannelID,
+	closeScript []byte) ([]byte, error) {
+
+	err := session.Send(lnwire.NewShutdown(chanID, closeScript))
+	if err != nil {
+		return nil, fmt.Errorf("error sending shutdown: %w", err)
+	}
+
+	var shutdown *lnwire.Shutdown
+	err = receiveChannelMsg(
+		session, chanID, coopCloseReplyTimeout,
+		func(msg lnwire.Message) bool {
+			shutdown, _ = msg.(*lnwire.Shutdown)
+			return shutdown != nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for shutdown: %w", err)
+	}
+	if shutdown == nil {
+		return nil, errors.New("peer didn't respond with shutdown")
+	}
+
+	log.Infof("Peer agreed to shutdown, closing to their script %x",
+		shutdown.Address)
+
+	return shutdown.Address, nil
+}
+
+// receiveChannelMsg waits for a message of the given channel that is accepted
+// by the handler. An error or warning sent by the peer aborts the wait. No
+// error is returned if the timeout is reached.
+func receiveChannelMsg(session *lnd.Session, chanID lnwire.ChannelID,
+	timeout time.Duration, handle func(lnwire.Message) bool) error {
+
+	var peerErr error
+	isOurs := func(id lnwire.ChannelID) bool {
+		return id == chanID || id == lnwire.ConnectionWideID
+	}
+	deadline := time.Now().Add(timeout)
+	err := receiveUntil(session, deadline, func(msg lnwire.Message) bool {
+		switch m := msg.(type) {
+		case *lnwire.Error:
+			if isOurs(m.ChanID) {
+				peerErr = fmt.Errorf("peer sent error: %v",
+					m.Error())
+				return true
+			}
+
+		case *lnwire.Warning:
+			if isOurs(m.ChanID) {
+				peerErr = fmt.Errorf("peer sent warning: %v",
+					m.Warning())
+				return true
+			}
+
+		case *lnwire.ChannelReestablish:
+			return m.ChanID == chanID && handle(msg)
+
+		case *lnwire.Shutdown:
+			return m.ChannelID == chanID && handle(msg)
+
+		case *lnwire.ClosingSigned:
+			return m.ChannelID == chanID && handle(msg)
+
+		case *lnwire.ClosingSig:
+			return m.ChannelID == chanID && handle(msg)
+		}
+
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	return peerErr
+}
+
+// legacyCoopClose negotiates the closing fee with closing_signed messages. The
+// funder of the channel pays the fee and makes the first proposal.
+func legacyCoopClose(session *lnd.Session, channel *coopCloseChannel,
+	signer lnd.ChannelSigner, cfg *coopCloseConfig,
+	theirScript []byte) (*wire.MsgTx, error) {
+
+	receiveClosingSigned := func(
+		timeout time.Duration) (*lnwire.ClosingSigned, error) {
+
+		var closingSigned *lnwire.ClosingSigned
+		err := receiveChannelMsg(
+			session, channel.chanID, timeout,
+			func(msg lnwire.Message) bool {
+				closingSigned, _ = msg.(*lnwire.ClosingSigned)
+				return closingSigned != nil
+			},
+		)
+
+		return closingSigned, err
+	}
+
+	// If the peer is the funder of the channel, it sends the first
+	// proposal.
+	weAreFunder := channel.initiator
+	var (
+		proposal *lnwire.ClosingSigned
+		err      error
+	)
+	if !weAreFunder {
+		proposal, err = receiveClosingSigned(coopCloseReplyTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("error waiting for "+
+				"closing_signed: %w", err)
+		}
+		if proposal == nil {
+			return nil, errors.New("peer didn't send " +
+				"closing_signed, check --peer_initiator")
+		}
+	}
+
+	balances := func(localAmount,
+		fee btcutil.Amount) (btcutil.Amount, btcutil.Amount) {
+
+		if weAreFunder {
+			return localAmount - fee, channel.capacity - localAmount
+		}
+
+		return localAmount, channel.capacity - localAmount - fee
+	}
+	closeTx := func(localAmount, fee btcutil.Amount) (*wire.MsgTx,
+		error) {
+
+		ourBalance, theirBalance := balances(localAmount, fee)
+		if ourBalance < 0 || theirBalance < 0 {
+			return nil, fmt.Errorf("fee %v not affordable", fee)
+		}
+
+		return channel.closeTx(
+			ourBalance, theirBalance, cfg.closeScript, theirScript,
+		)
+	}
+	sendClosingSigned := func(tx *wire.MsgTx,
+		fee btcutil.Amount) (input.Signature, error) {
+
+		ourSig, err := channel.sign(signer, tx)
+		if err != nil {
+			return nil, fmt.Errorf("error signing close tx: %w",
+				err)
+		}
+		wireSig, err := lnwire.NewSigFromSignature(ourSig)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Sending closing_signed with fee %v", fee)
+		err = session.Send(lnwire.NewClosingSigned(
+			channel.chanID, fee, wireSig,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("error sending closing_signed: "+
+				"%w", err)
+		}
+
+		return ourSig, nil
+	}
+
+	// The peer pays the fee, so we accept whatever it proposes, as long
+	// as its signature matches a transaction that pays us our share.
+	localAmount := cfg.localAmount
+	if !weAreFunder {
+		log.Infof("Peer is funder of the channel and proposed fee %v",
+			proposal.FeeSatoshis)
+
+		localAmount, err = findLocalAmount(
+			channel, cfg, proposal,
+			func(amount btcutil.Amount) (*wire.MsgTx, error) {
+				return closeTx(amount, proposal.FeeSatoshis)
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tx, err := closeTx(localAmount, proposal.FeeSatoshis)
+		if err != nil {
+			return nil, err
+		}
+		theirSig, ok := channel.verify(tx, proposal.Signature)
+		if !ok {
+			return nil, fmt.Errorf("peer signature for fee %v "+
+				"doesn't match local amount %v",
+				proposal.FeeSatoshis, localAmount)
+		}
+		ourSig, err := sendClosingSigned(tx, proposal.FeeSatoshis)
+		if err != nil {
+			return nil, err
+		}
+
+		return channel.complete(tx, ourSig, theirSig), nil
+	}
+
+	// We are the funder, so we make the first proposal and accept any
+	// counter proposal up to our maximum fee.
+	log.Infof("We are funder of the channel, proposing fee")
+	fee := closeFee(cfg.closeScript, theirScript, cfg.feeRate)
+	maxFee := closeFee(cfg.closeScript, theirScript, cfg.maxFeeRate)
+	for range maxCloseNegotiationRounds {
+		tx, err := closeTx(localAmount, fee)
+		if err != nil {
+			return nil, err
+		}
+		ourSig, err := sendClosingSigned(tx, fee)
+		if err != nil {
+			return nil, err
+		}
+
+		proposal, err := receiveClosingSigned(coopCloseReplyTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("error waiting for "+
+				"closing_signed: %w", err)
+		}
+		if proposal == nil {
+			return nil, errors.New("peer didn't respond with " +
+				"closing_signed")
+		}
+
+		// The peer accepted our proposal.
+		if proposal.FeeSatoshis == fee {
+			theirSig, ok := channel.verify(tx, proposal.Signature)
+			if !ok {
+				return nil, errors.New("peer signature " +
+					"invalid, check --local_amount")
+			}
+
+			return channel.complete(tx, ourSig, theirSig), nil
+		}
+
+		log.Infof("Peer proposed fee %v", proposal.FeeSatoshis)
+		if proposal.FeeSatoshis > maxFee {
+			if fee == maxFee {
+				return nil, fmt.Errorf("peer insists on fee "+
+					"%v, higher than our maximum %v",
+					proposal.FeeSatoshis, maxFee)
+			}
+
+			fee = maxFee
+			continue
+		}
+
+		// Their fee is acceptable, so we sign the same transaction.
+		fee = proposal.FeeSatoshis
+		tx, err = closeTx(localAmount, fee)
+		if err != nil {
+			return nil, err
+		}
+		theirSig, ok := channel.verify(tx, proposal.Signature)
+		if !ok {
+			return nil, errors.New("peer signature invalid, " +
+				"check --local_amount")
+		}
+		ourSig, err = sendClosingSigned(tx, fee)
+		if err != nil {
+			return nil, err
+		}
+
+		return channel.complete(tx, ourSig, theirSig), nil
+	}
+
+	return nil, errors.New("no agreement on closing fee reached")
+}
+
+// findLocalAmount searches for the amount that belongs to us by trying to
+// verify the peer's signature with transactions paying us amounts close to
+// the configured one.
+func findLocalAmount(channel *coopCloseChannel, cfg *coopCloseConfig,
+	proposal *lnwire.ClosingSigned,
+	closeTx func(btcutil.Amount) (*wire.MsgTx, error)) (btcutil.Amount,
+	error) {
+
+	for delta := btcutil.Amount(0); delta <= cfg.searchRange; delta++ {
+		for _, amount := range []btcutil.Amount{
+			cfg.localAmount + delta, cfg.localAmount - delta,
+		} {
+			if amount < 0 || amount > channel.capacity {
+				continue
+			}
+
+			tx, err := closeTx(amount)
+			if err != nil {
+				continue
+			}
+			if _, ok := channel.verify(tx, proposal.Signature); ok {
+				log.Infof("Peer signature matches local "+
+					"amount %v", amount)
+				return amount, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("peer signature doesn't match any local amount "+
+		"between %v and %v, adjust --local_amount or --search_range",
+		cfg.localAmount-cfg.searchRange,
+		cfg.localAmount+cfg.searchRange)
+}
+
+// rbfCoopClose signs a closing transaction that pays the fee from our output
+// and asks the peer to counter sign it with closing_complete.
+func rbfCoopClose(session *lnd.Session, channel *coopCloseChannel,
+	signer lnd.ChannelSigner, cfg *coopCloseConfig,
+	theirScript []byte) (*wire.MsgTx, error) {
+
+	fee := closeFee(cfg.closeScript, theirScript, cfg.feeRate)
+	ourBalance := cfg.localAmount - fee
+	theirBalance := channel.capacity - cfg.localAmount
+	if ourBalance < lnwallet.DustLimitForSize(len(cfg.closeScript)) {
+		return nil, fmt.Errorf("local amount %v can't pay fee %v",
+			cfg.localAmount, fee)
+	}
+
+	tx, err := channel.rbfCloseTx(
+		ourBalance, theirBalance, cfg.closeScript, theirScript,
+	)
+	if err != nil {
+		return nil, err
+	}
+	ourSig, err := channel.sign(signer, tx)
+	if err != nil {
+		return nil, fmt.Errorf("error signing close tx: %w", err)
+	}
+	wireSig, err := lnwire.NewSigFromSignature(ourSig)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the peer's output is dust, it's not part of the transaction and
+	// we need to tell the peer about it.
+	noClosee := theirBalance < lnwallet.DustLimitForSize(len(theirScript))
+	closingComplete := &lnwire.ClosingComplete{
+		ChannelID:    channel.chanID,
+		CloserScript: cfg.closeScript,
+		CloseeScript: theirScript,
+		FeeSatoshis:  fee,
+		LockTime:     tx.LockTime,
+	}
+	if noClosee {
+		closingComplete.CloserNoClosee = tlv.SomeRecordT(
+			tlv.NewRecordT[tlv.TlvType1](wireSig),
+		)
+	} else {
+		closingComplete.CloserAndClosee = tlv.SomeRecordT(
+			tlv.NewRecordT[tlv.TlvType3](wireSig),
+		)
+	}
+
+	log.Infof("Sending closing_complete with fee %v", fee)
+	if err := session.Send(closingComplete); err != nil {
+		return nil, fmt.Errorf("error sending closing_complete: %w",
+			err)
+	}
+
+	var closingSig *lnwire.ClosingSig
+	err = receiveChannelMsg(
+		session, channel.chanID, coopCloseReplyTimeout,
+		func(msg lnwire.Message) bool {
+			closingSig, _ = msg.(*lnwire.ClosingSig)
+			return closingSig != nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for closing_sig: %w", err)
+	}
+	if closingSig == nil {
+		return nil, errors.New("peer didn't respond with closing_sig")
+	}
+
+	theirSigOpt := closingSig.CloserAndClosee.ValOpt()
+	if noClosee {
+		theirSigOpt = closingSig.CloserNoClosee.ValOpt()
+	}
+	theirWireSig, err := theirSigOpt.UnwrapOrErr(
+		errors.New("peer didn't send expected signature"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	theirSig, ok := channel.verify(tx, theirWireSig)
+	if !ok {
+		return nil, errors.New("peer signature invalid, check " +
+			"--local_amount")
+	}
+
+	return channel.complete(tx, ourSig, theirSig), nil
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/brontide"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/lightningnetwork/lnd/tor"
+	"github.com/stretchr/testify/require"
+)
+
+const testCoopCloseCapacity = btcutil.Amount(1_000_000)
+
+var (
+	errShutdownBeforeReestablish = errors.New("shutdown before " +
+		"channel_reestablish")
+
+	testOurCloseScript = append(
+		[]byte{txscript.OP_0, 20}, bytes.Repeat([]byte{1}, 20)...,
+	)
+	testTheirCloseScript = append(
+		[]byte{txscript.OP_0, 20}, bytes.Repeat([]byte{2}, 20)...,
+	)
+)
+
+// coopCloseTestPeer is the remote side of a cooperative close test.
+type coopCloseTestPeer struct {
+	session *lnd.Session
+	channel *coopCloseChannel
+	priv    *btcec.PrivateKey
+}
+
+// sign creates the peer's signature for the given close transaction.
+func (p *coopCloseTestPeer) sign(tx *wire.MsgTx) (lnwire.Sig, error) {
+	sigHash, err := p.channel.sigHash(tx)
+	if err != nil {
+		return lnwire.Sig{}, err
+	}
+
+	return lnwire.NewSigFromSignature(ecdsa.Sign(p.priv, sigHash))
+}
+
+// verify checks our signature for the given close transaction.
+func (p *coopCloseTestPeer) verify(tx *wire.MsgTx, sig lnwire.Sig) error {
+	ourSig, err := sig.ToSignature()
+	if err != nil {
+		return err
+	}
+	sigHash, err := p.channel.sigHash(tx)
+	if err != nil {
+		return err
+	}
+	if !ourSig.Verify(sigHash, p.channel.localKey.PubKey) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
+
+// reestablish sends the peer's channel_reestablish message and expects ours
+// with mirrored commitment heights. Like a real peer, it refuses a shutdown
+// sent before the channel is re-established.
+func (p *coopCloseTestPeer) reestablish() error {
+	err := p.session.Send(&lnwire.ChannelReestablish{
+		ChanID:                 p.channel.chanID,
+		NextLocalCommitHeight:  8,
+		RemoteCommitTailHeight: 6,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := p.session.Receive()
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*lnwire.Shutdown); ok {
+		err := p.session.Send(&lnwire.Error{
+			ChanID: p.channel.chanID,
+			Data:   []byte("shutdown before channel_reestablish"),
+		})
+		if err != nil {
+			return err
+		}
+
+		return errShutdownBeforeReestablish
+	}
+
+	reestablish, ok := msg.(*lnwire.ChannelReestablish)
+	if !ok {
+		return fmt.Errorf("unexpected message %v", msg.MsgType())
+	}
+	if reestablish.ChanID != p.channel.chanID ||
+		reestablish.NextLocalCommitHeight != 7 ||
+		reestablish.RemoteCommitTailHeight != 7 ||
+		reestablish.LocalUnrevokedCommitPoint != nil {
+
+		return fmt.Errorf("unexpected channel_reestablish %v",
+			spew.Sdump(reestablish))
+	}
+
+	return nil
+}
+
+// shutdown answers our shutdown message.
+func (p *coopCloseTestPeer) shutdown() error {
+	msg, err := p.session.Receive()
+	if err != nil {
+		return err
+	}
+	shutdown, ok := msg.(*lnwire.Shutdown)
+	if !ok || !bytes.Equal(shutdown.Address, testOurCloseScript) {
+		return fmt.Errorf("unexpected message %v", msg.MsgType())
+	}
+
+	return p.session.Send(lnwire.NewShutdown(
+		p.channel.chanID, testTheirCloseScript,
+	))
+}
+
+// receiveClosingSigned waits for our closing_signed and checks its signature
+// against the transaction with the given balances.
+func (p *coopCloseTestPeer) receiveClosingSigned(ourBalance,
+	theirBalance btcutil.Amount) (*lnwire.ClosingSigned, error) {
+
+	msg, err := p.session.Receive()
+	if err != nil {
+		return nil, err
+	}
+	closingSigned, ok := msg.(*lnwire.ClosingSigned)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message %v", msg.MsgType())
+	}
+
+	tx, err := p.channel.closeTx(
+		ourBalance-closingSigned.FeeSatoshis, theirBalance,
+		testOurCloseScript, testTheirCloseScript,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return closingSigned, p.verify(tx, closingSigned.Signature)
+}
+
+// newCoopCloseTest creates a channel between us and a test peer and a
+// connected session to that peer.
+func newCoopCloseTest(t *testing.T) (*lnd.Session, *coopCloseChannel,
+	lnd.ChannelSigner, *coopCloseTestPeer) {
+
+	t.Helper()
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	localKey, err := keyRing.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+		Index:  3,
+	})
+	require.NoError(t, err)
+	remotePriv, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	witnessScript, err := input.GenMultiSigScript(
+		localKey.PubKey.SerializeCompressed(),
+		remotePriv.PubKey().SerializeCompressed(),
+	)
+	require.NoError(t, err)
+	pkScript, err := input.WitnessScriptHash(witnessScript)
+	require.NoError(t, err)
+
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{4, 5, 6}, Index: 2}
+	channel := &coopCloseChannel{
+		chanPoint:  chanPoint,
+		chanID:     lnwire.NewChanIDFromOutPoint(chanPoint),
+		capacity:   testCoopCloseCapacity,
+		pkScript:   pkScript,
+		localDust:  lnwallet.DustLimitUnknownWitness(),
+		remoteDust: lnwallet.DustLimitUnknownWitness(),
+	}
+
+	// Keys that don't belong to the funding output are rejected.
+	otherKey, err := btcec.NewPrivateKey()
+	require.Error(t, channel.setFundingKeys(&localKey, otherKey.PubKey()))
+	require.NoError(t, channel.setFundingKeys(
+		&localKey, remotePriv.PubKey(),
+	))
+
+	ours, theirs := newCoopCloseTestSessions(t)
+
+	return ours, channel, signer, &coopCloseTestPeer{
+		session: theirs,
+		channel: channel,
+		priv:    remotePriv,
+	}
+}
+
+// newCoopCloseTestSessions creates two initialized sessions that are connected
+// to each other.
+func newCoopCloseTestSessions(t *testing.T) (*lnd.Session, *lnd.Session) {
+	t.Helper()
+
+	ours, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	theirs, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	acceptAll := func(*btcec.PublicKey) (bool, error) {
+		return true, nil
+	}
+	listener, err := brontide.NewListener(
+		&keychain.PrivKeyECDH{PrivKey: theirs}, "127.0.0.1:0",
+		acceptAll,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := acceptWithTimeout(listener, 10*time.Second)
+		accepted <- conn
+	}()
+
+	conn, err := brontide.Dial(
+		&keychain.PrivKeyECDH{PrivKey: ours}, &lnwire.NetAddress{
+			IdentityKey: theirs.PubKey(),
+			Address:     listener.Addr(),
+		}, 10*time.Second, (&tor.ClearNet{}).Dial,
+	)
+	require.NoError(t, err)
+	theirConn := <-accepted
+	require.NotNil(t, theirConn)
+
+	ourSession := lnd.NewSession(conn)
+	theirSession := lnd.NewSession(theirConn.(*brontide.Conn))
+	t.Cleanup(func() {
+		_ = ourSession.Close()
+		_ = theirSession.Close()
+	})
+
+	initErr := make(chan error, 1)
+	go func() {
+		initErr <- theirSession.Init(
+			lnwire.NewRawFeatureVector(),
+			lnwire.NewRawFeatureVector(),
+		)
+	}()
+	require.NoError(t, ourSession.Init(
+		lnwire.NewRawFeatureVector(), lnwire.NewRawFeatureVector(),
+	))
+	require.NoError(t, <-initErr)
+
+	return ourSession, theirSession
+}
+
+// requireValidCloseTx makes sure the closing transaction is fully signed and
+// spends the funding output.
+func requireValidCloseTx(t *testing.T, channel *coopCloseChannel,
+	tx *wire.MsgTx) {
+
+	t.Helper()
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		channel.pkScript, int64(channel.capacity),
+	)
+	vm, err := txscript.NewEngine(
+		channel.pkScript, tx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(tx, prevOutFetcher),
+		int64(channel.capacity), prevOutFetcher,
+	)
+	require.NoError(t, err)
+	require.NoError(t, vm.Execute())
+}
+
+// outputValue returns the value of the output with the given script.
+func outputValue(tx *wire.MsgTx, pkScript []byte) btcutil.Amount {
+	for _, txOut := range tx.TxOut {
+		if bytes.Equal(txOut.PkScript, pkScript) {
+			return btcutil.Amount(txOut.Value)
+		}
+	}
+
+	return 0
+}
+
+func TestCoopCloseLegacyPeerFunder(t *testing.T) {
+	_ = newHarness(t)
+
+	session, channel, signer, peer := newCoopCloseTest(t)
+
+	// The peer funded the channel and pays the fee. The exact amount that
+	// belongs to us isn't known, so it must be found through the peer's
+	// signature.
+	const (
+		ourBalance = btcutil.Amount(400_000)
+		fee        = btcutil.Amount(1234)
+	)
+	theirBalance := testCoopCloseCapacity - ourBalance - fee
+
+	peerErr := make(chan error, 1)
+	go func() {
+		peerErr <- func() error {
+			if err := peer.reestablish(); err != nil {
+				return err
+			}
+			if err := peer.shutdown(); err != nil {
+				return err
+			}
+
+			tx, err := channel.closeTx(
+				ourBalance, theirBalance, testOurCloseScript,
+				testTheirCloseScript,
+			)
+			if err != nil {
+				return err
+			}
+			sig, err := peer.sign(tx)
+			if err != nil {
+				return err
+			}
+			err = peer.session.Send(lnwire.NewClosingSigned(
+				channel.chanID, fee, sig,
+			))
+			if err != nil {
+				return err
+			}
+
+			closingSigned, err := peer.receiveClosingSigned(
+				ourBalance+fee, theirBalance,
+			)
+			if err != nil {
+				return err
+			}
+			if closingSigned.FeeSatoshis != fee {
+				return fmt.Errorf("unexpected fee %v",
+					closingSigned.FeeSatoshis)
+			}
+
+			return nil
+		}()
+	}()
+
+	require.NoError(t, reestablishChannel(session, channel.chanID))
+	closeTx, err := coopClose(session, channel, signer, &coopCloseConfig{
+		closeScript: testOurCloseScript,
+		localAmount: ourBalance - 7,
+		searchRange: 10,
+		feeRate:     chainfee.FeePerKwFloor,
+		maxFeeRate:  chainfee.FeePerKwFloor,
+	}, false)
+	require.NoError(t, err)
+	require.NoError(t, <-peerErr)
+
+	requireValidCloseTx(t, channel, closeTx)
+	require.Equal(t, ourBalance, outputValue(closeTx, testOurCloseScript))
+	require.Equal(
+		t, theirBalance, outputValue(closeTx, testTheirCloseScript),
+	)
+	require.Equal(t, wire.MaxTxInSequenceNum, closeTx.TxIn[0].Sequence)
+}
+
+func TestCoopCloseLegacyWeFund(t *testing.T) {
+	_ = newHarness(t)
+
+	session, channel, signer, peer := newCoopCloseTest(t)
+	channel.initiator = true
+
+	const localAmount = btcutil.Amount(700_000)
+	theirBalance := testCoopCloseCapacity - localAmount
+	feeRate := chainfee.SatPerKVByte(2000).FeePerKWeight()
+	maxFeeRate := chainfee.SatPerKVByte(4000).FeePerKWeight()
+	maxFee := closeFee(testOurCloseScript, testTheirCloseScript, maxFeeRate)
+
+	// We make the first proposal. The peer asks for a higher fee than we
+	// are willing to pay, so we counter with our maximum fee, which it
+	// accepts.
+	peerErr := make(chan error, 1)
+	go func() {
+		peerErr <- func() error {
+			if err := peer.reestablish(); err != nil {
+				return err
+			}
+			if err := peer.shutdown(); err != nil {
+				return err
+			}
+
+			_, err := peer.receiveClosingSigned(
+				localAmount, theirBalance,
+			)
+			if err != nil {
+				return err
+			}
+
+			counterFee := maxFee * 2
+			tx, err := channel.closeTx(
+				localAmount-counterFee, theirBalance,
+				testOurCloseScript, testTheirCloseScript,
+			)
+			if err != nil {
+				return err
+			}
+			sig, err := peer.sign(tx)
+			if err != nil {
+				return err
+			}
+			err = peer.session.Send(lnwire.NewClosingSigned(
+				channel.chanID, counterFee, sig,
+			))
+			if err != nil {
+				return err
+			}
+
+			closingSigned, err := peer.receiveClosingSigned(
+				localAmount, theirBalance,
+			)
+			if err != nil {
+				return err
+			}
+			if closingSigned.FeeSatoshis != maxFee {
+				return fmt.Errorf("unexpected fee %v",
+					closingSigned.FeeSatoshis)
+			}
+
+			tx, err = channel.closeTx(
+				localAmount-maxFee, theirBalance,
+				testOurCloseScript, testTheirCloseScript,
+			)
+			if err != nil {
+				return err
+			}
+			sig, err = peer.sign(tx)
+			if err != nil {
+				return err
+			}
+
+			return peer.session.Send(lnwire.NewClosingSigned(
+				channel.chanID, maxFee, sig,
+			))
+		}()
+	}()
+
+	require.NoError(t, reestablishChannel(session, channel.chanID))
+	closeTx, err := coopClose(session, channel, signer, &coopCloseConfig{
+		closeScript: testOurCloseScript,
+		localAmount: localAmount,
+		feeRate:     feeRate,
+		maxFeeRate:  maxFeeRate,
+	}, false)
+	require.NoError(t, err)
+	require.NoError(t, <-peerErr)
+
+	requireValidCloseTx(t, channel, closeTx)
+	require.Equal(
+		t, localAmount-maxFee,
+		outputValue(closeTx, testOurCloseScript),
+	)
+	require.Equal(
+		t, theirBalance, outputValue(closeTx, testTheirCloseScript),
+	)
+}
+
+func TestCoopCloseRbf(t *testing.T) {
+	_ = newHarness(t)
+
+	session, channel, signer, peer := newCoopCloseTest(t)
+
+	const localAmount = btcutil.Amount(300_000)
+	theirBalance := testCoopCloseCapacity - localAmount
+	feeRate := chainfee.SatPerKVByte(5000).FeePerKWeight()
+	fee := closeFee(testOurCloseScript, testTheirCloseScript, feeRate)
+
+	peerErr := make(chan error, 1)
+	go func() {
+		peerErr <- func() error {
+			if err := peer.reestablish(); err != nil {
+				return err
+			}
+			if err := peer.shutdown(); err != nil {
+				return err
+			}
+
+			msg, err := peer.session.Receive()
+			if err != nil {
+				return err
+			}
+			complete, ok := msg.(*lnwire.ClosingComplete)
+			if !ok {
+				return fmt.Errorf("unexpected message %v",
+					msg.MsgType())
+			}
+			if complete.FeeSatoshis != fee {
+				return fmt.Errorf("unexpected fee %v",
+					complete.FeeSatoshis)
+			}
+
+			// As the closee, the peer signs the transaction with
+			// the RBF sequence that pays the fee from our output.
+			tx, err := channel.rbfCloseTx(
+				localAmount-fee, theirBalance,
+				testOurCloseScript, testTheirCloseScript,
+			)
+			if err != nil {
+				return err
+			}
+			ourSig, err := complete.CloserAndClosee.ValOpt().
+				UnwrapOrErr(fmt.Errorf("signature missing"))
+			if err != nil {
+				return err
+			}
+			if err := peer.verify(tx, ourSig); err != nil {
+				return err
+			}
+
+			sig, err := peer.sign(tx)
+			if err != nil {
+				return err
+			}
+
+			return peer.session.Send(&lnwire.ClosingSig{
+				ChannelID:    channel.chanID,
+				CloserScript: complete.CloserScript,
+				CloseeScript: complete.CloseeScript,
+				FeeSatoshis:  complete.FeeSatoshis,
+				LockTime:     complete.LockTime,
+				ClosingSigs: lnwire.ClosingSigs{
+					CloserAndClosee: tlv.SomeRecordT(
+						tlv.NewRecordT[tlv.TlvType3](
+							sig,
+						),
+					),
+				},
+			})
+		}()
+	}()
+
+	require.NoError(t, reestablishChannel(session, channel.chanID))
+	closeTx, err := coopClose(session, channel, signer, &coopCloseConfig{
+		closeScript: testOurCloseScript,
+		localAmount: localAmount,
+		feeRate:     feeRate,
+		maxFeeRate:  feeRate,
+	}, true)
+	require.NoError(t, err)
+	require.NoError(t, <-peerErr)
+
+	requireValidCloseTx(t, channel, closeTx)
+	require.Equal(
+		t, localAmount-fee, outputValue(closeTx, testOurCloseScript),
+	)
+	require.Equal(
+		t, theirBalance, outputValue(closeTx, testTheirCloseScript),
+	)
+	require.EqualValues(
+		t, mempool.MaxRBFSequence, closeTx.TxIn[0].Sequence,
+	)
+}
+
+func TestCoopCloseShutdownBeforeReestablish(t *testing.T) {
+	_ = newHarness(t)
+
+	session, channel, signer, peer := newCoopCloseTest(t)
+
+	peerErr := make(chan error, 1)
+	go func() {
+		peerErr <- peer.reestablish()
+	}()
+
+	// Sending shutdown without answering the peer's channel_reestablish
+	// first is refused by the peer.
+	_, err := coopClose(session, channel, signer, &coopCloseConfig{
+		closeScript: testOurCloseScript,
+		localAmount: 500_000,
+		feeRate:     chainfee.FeePerKwFloor,
+		maxFeeRate:  chainfee.FeePerKwFloor,
+	}, false)
+	require.ErrorContains(t, err, "shutdown before channel_reestablish")
+	require.ErrorIs(t, <-peerErr, errShutdownBeforeReestablish)
+}
+
+func TestCoopCloseDustLimits(t *testing.T) {
+	channel := &coopCloseChannel{
+		chanPoint: wire.OutPoint{Index: 1},
+		capacity:  testCoopCloseCapacity,
+	}
+
+	// Without known dust limits, small outputs are refused.
+	_, err := channel.closeTx(
+		400, 990_000, testOurCloseScript, testTheirCloseScript,
+	)
+	require.ErrorContains(t, err, "might be dust")
+
+	// An empty output is simply omitted.
+	tx, err := channel.closeTx(
+		0, 990_000, testOurCloseScript, testTheirCloseScript,
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 1)
+
+	// With the negotiated dust limits, each output is checked against
+	// the limit of its owner.
+	channel.localDust, channel.remoteDust = 500, 300
+	tx, err = channel.closeTx(
+		400, 990_000, testOurCloseScript, testTheirCloseScript,
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 1)
+	tx, err = channel.closeTx(
+		990_000, 400, testOurCloseScript, testTheirCloseScript,
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+
+	// The RBF protocol uses the dust limit of each output's script.
+	tx, err = channel.rbfCloseTx(
+		990_000, 200, testOurCloseScript, testTheirCloseScript,
+	)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 1)
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// dbShellHexPrefix is the prefix of keys and values that are given or
+	// shown as hex.
+	dbShellHexPrefix = "0x"
+)
+
+var (
+	errDBShellReadOnly = errors.New("DB is opened read-only, restart " +
+		"with --write to make changes")
+
+	errDBShellTopLevelKey = errors.New("only buckets can be stored at " +
+		"the top level")
+
+	dbShellHelp = `Available commands:
+  ls                    list the buckets and keys of the current bucket
+  cd <bucket>|..|/      change into a nested bucket, the parent or the root
+  pwd                   show the path of the current bucket
+  get <key>             show a value as hex and decode it if it's known
+  hexdump <key>         show a value as a hex dump
+  put <key> <value>     store a value given as hex (requires --write)
+  rm <key>              delete a value (requires --write)
+  mkbucket <bucket>     create a nested bucket (requires --write)
+  rmbucket <bucket>     delete a nested bucket and all its content
+                        (requires --write)
+  help                  show this help
+  exit, quit            leave the shell
+
+Keys and bucket names can be given as plain text or as hex with the 0x prefix.`
+)
+
+type dbShellCommand struct {
+	DB    string
+	Write bool
+	Exec  []string
+
+	cmd *cobra.Command
+}
+
+func newDBShellCommand() *cobra.Command {
+	cc := &dbShellCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "dbshell",
+		Short: "Interactively inspect and edit the buckets of any " +
+			"lnd bbolt DB",
+		Long: `This command opens an interactive shell to list, show and
+hexdump the buckets and keys of any bbolt based lnd database file, for example
+channel.db, wallet.db, sphinxreplay.db or watchtower.db. Known lnd records (for
+example closed channel summaries, the wallet birthday, short channel IDs or
+channel points) are decoded and shown in a human readable form.
+
+The DB is opened read-only unless the --write flag is specified, which is
+required to put or delete keys and buckets. Instead of reading commands from
+the terminal, they can also be passed with one or more --exec flags. Run the
+shell and type 'help' to see all available commands.
+
+CAUTION: Changing any of the lnd databases can lead to loss of funds if done
+wrong. Always create a backup of the DB file before running this command with
+the --write flag and make sure lnd is not running.`,
+		Example: `chantools dbshell \
+	--db ~/.lnd/data/graph/mainnet/channel.db
+
+chantools dbshell --db ~/.lnd/data/chain/bitcoin/mainnet/wallet.db \
+	--exec "cd waddrmgr" --exec "cd sync" --exec "get birthday"`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.DB, "db", "", "lnd bbolt DB file (channel.db, wallet.db, "+
+			"...) to open",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Write, "write", false, "open the DB writable to allow "+
+			"putting and deleting keys and buckets",
+	)
+	cc.cmd.Flags().StringArrayVar(
+		&cc.Exec, "exec", nil, "run the given shell command instead "+
+			"of reading commands from the terminal; can be "+
+			"specified multiple times",
+	)
+
+	return cc.cmd
+}
+
+func (c *dbShellCommand) Execute(_ *cobra.Command, _ []string) error {
+	// Check that we have a DB.
+	if c.DB == "" {
+		return errors.New("DB is required")
+	}
+	db, err := lnd.OpenBackend(c.DB, !c.Write)
+	if err != nil {
+		return fmt.Errorf("error opening DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	shell := &dbShell{
+		db:       db,
+		writable: c.Write,
+		out:      os.Stdout,
+	}
+
+	if len(c.Exec) == 0 {
+		return shell.run(os.Stdin, true)
+	}
+
+	for _, command := range c.Exec {
+		quit, err := shell.exec(command)
+		if err != nil {
+			return fmt.Errorf("error running '%s': %w", command,
+				err)
+		}
+		if quit {
+			break
+		}
+	}
+
+	return nil
+}
+
+// dbShell is a simple shell that runs commands against the buckets of a DB.
+// Every command runs in its own transaction.
+type dbShell struct {
+	db       kvdb.Backend
+	writable bool
+	path     [][]byte
+	out      io.Writer
+}
+
+// run reads commands line by line until the reader is exhausted or the shell
+// is quit. Errors of single commands are printed and don't end the shell.
+func (s *dbShell) run(r io.Reader, prompt bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	for {
+		if prompt {
+			_, _ = fmt.Fprintf(s.out, "%s> ", dbShellPath(s.path))
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		quit, err := s.exec(scanner.Text())
+		if err != nil {
+			_, _ = fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+		if quit {
+			return nil
+		}
+	}
+	if prompt {
+		_, _ = fmt.Fprintln(s.out)
+	}
+
+	return scanner.Err()
+}
+
+// exec runs a single command and returns true if the shell should be quit.
+func (s *dbShell) exec(line string) (bool, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	command, args := args[0], args[1:]
+	numArgs := map[string]int{
+		"ls": 0, "pwd": 0, "help": 0, "exit": 0, "quit": 0, "cd": 1,
+		"get": 1, "hexdump": 1, "rm": 1, "mkbucket": 1, "rmbucket": 1,
+		"put": 2,
+	}
+	expected, ok := numArgs[command]
+	switch {
+	case !ok:
+		return false, fmt.Errorf("unknown command '%s', type 'help' "+
+			"to see all commands", command)
+
+	case len(args) != expected:
+		return false, fmt.Errorf("command '%s' expects %d argument(s)",
+			command, expected)
+	}
+
+	switch command {
+	case "exit", "quit":
+		return true, nil
+
+	case "help":
+		_, _ = fmt.Fprintln(s.out, dbShellHelp)
+
+	case "pwd":
+		_, _ = fmt.Fprintln(s.out, dbShellPath(s.path))
+
+	case "ls":
+		return false, s.ls()
+
+	case "cd":
+		return false, s.cd(args[0])
+
+	case "get", "hexdump":
+		return false, s.get(args[0], command == "hexdump")
+
+	case "put":
+		return false, s.put(args[0], args[1])
+
+	case "rm":
+		return false, s.rm(args[0])
+
+	case "mkbucket":
+		return false, s.mkBucket(args[0])
+
+	case "rmbucket":
+		return false, s.rmBucket(args[0])
+	}
+
+	return false, nil
+}
+
+// ls lists all nested buckets and keys of the current bucket.
+func (s *dbShell) ls() error {
+	return kvdb.View(s.db, func(tx kvdb.RTx) error {
+		if len(s.path) == 0 {
+			return tx.ForEachBucket(func(k []byte) error {
+				_, err := fmt.Fprintf(
+					s.out, "%s/\n", dbShellKey(k),
+				)
+				return err
+			})
+		}
+
+		bucket, err := s.bucket(tx, s.path)
+		if err != nil {
+			return err
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				_, err := fmt.Fprintf(
+					s.out, "%s/\n", dbShellKey(k),
+				)
+				return err
+			}
+
+			_, err := fmt.Fprintf(s.out, "%s (%d bytes)\n",
+				dbShellKey(k), len(v))
+			return err
+		})
+	}, func() {})
+}
+
+// cd changes the current bucket.
+func (s *dbShell) cd(name string) error {
+	switch name {
+	case "/":
+		s.path = nil
+		return nil
+
+	case "..":
+		if len(s.path) > 0 {
+			s.path = s.path[:len(s.path)-1]
+		}
+		return nil
+	}
+
+	key, err := parseDBShellKey(name)
+	if err != nil {
+		return err
+	}
+
+	path := append(s.path[:len(s.path):len(s.path)], key)
+	err = kvdb.View(s.db, func(tx kvdb.RTx) error {
+		_, err := s.bucket(tx, path)
+		return err
+	}, func() {})
+	if err != nil {
+		return err
+	}
+
+	s.path = path
+
+	return nil
+}
+
+// get shows a value of the current bucket, either as hex together with all
+// known decodings or as a hex dump.
+func (s *dbShell) get(name string, dump bool) error {
+	key, err := parseDBShellKey(name)
+	if err != nil {
+		return err
+	}
+	if len(s.path) == 0 {
+		return errDBShellTopLevelKey
+	}
+
+	var value []byte
+	err = kvdb.View(s.db, func(tx kvdb.RTx) error {
+		bucket, err := s.bucket(tx, s.path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bucket.NestedReadBucket(key) != nil:
+			return fmt.Errorf("'%s' is a bucket, use 'cd' to show "+
+				"its content", name)
+
+		case bucket.Get(key) == nil:
+			return fmt.Errorf("key '%s' not found", name)
+		}
+
+		value = bytes.Clone(bucket.Get(key))
+
+		return nil
+	}, func() {})
+	if err != nil {
+		return err
+	}
+
+	if dump {
+		_, _ = fmt.Fprint(s.out, hex.Dump(value))
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(s.out, "%s%x\n", dbShellHexPrefix, value)
+	for _, decoded := range decodeDBRecord(s.path, key, value) {
+		_, _ = fmt.Fprintf(s.out, "%s: %s\n", decoded.name,
+			decoded.value)
+	}
+
+	return nil
+}
+
+// put stores a value in the current bucket.
+func (s *dbShell) put(name, hexValue string) error {
+	key, err := parseDBShellKey(name)
+	if err != nil {
+		return err
+	}
+	value, err := hex.DecodeString(
+		strings.TrimPrefix(hexValue, dbShellHexPrefix),
+	)
+	if err != nil {
+		return fmt.Errorf("error decoding hex value: %w", err)
+	}
+	if len(s.path) == 0 {
+		return errDBShellTopLevelKey
+	}
+
+	return s.update(func(tx kvdb.RwTx) error {
+		bucket, err := s.rwBucket(tx, s.path)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(key, value)
+	})
+}
+
+// rm deletes a value from the current bucket.
+func (s *dbShell) rm(name string) error {
+	key, err := parseDBShellKey(name)
+	if err != nil {
+		return err
+	}
+	if len(s.path) == 0 {
+		return errDBShellTopLevelKey
+	}
+
+	return s.update(func(tx kvdb.RwTx) error {
+		bucket, err := s.rwBucket(tx, s.path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bucket.NestedReadWriteBucket(key) != nil:
+			return fmt.Errorf("'%s' is a bucket, use 'rmbucket' "+
+				"to delete it", name)
+
+		case bucket.Get(key) == nil:
+			return fmt.Errorf("key '%s' not found", name)
+		}
+
+		return bucket.Delete(key)
+	})
+}
+
+// mkBucket creates a nested bucket in the current bucket or a top level bucket.
+func (s *dbShell) mkBucket(name string) error {
+	key, err := parseDBShellKey(name)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx kvdb.RwTx) error {
+		if len(s.path) == 0 {
+			_, err := tx.CreateTopLevelBucket(key)
+			return err
+		}
+
+		bucket, err := s.rwBucket(tx, s.path)
+		if err != nil {
+			return err
+		}
+
+		_, err = bucket.CreateBucket(key)
+		return err
+	})
+}
+
+// rmBucket deletes a nested bucket of the current bucket or a top level bucket.
+func (s *dbShell) rmBucket(name string) error {
+	key, err := parseDBShellKey(name)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx kvdb.RwTx) error {
+		if len(s.path) == 0 {
+			return tx.DeleteTopLevelBucket(key)
+		}
+
+		bucket, err := s.rwBucket(tx, s.path)
+		if err != nil {
+			return err
+		}
+
+		return bucket.DeleteNestedBucket(key)
+	})
+}
+
+// update runs a write transaction if the DB was opened writable.
+func (s *dbShell) update(f func(tx kvdb.RwTx) error) error {
+	if !s.writable {
+		return errDBShellReadOnly
+	}
+
+	return kvdb.Update(s.db, f, func() {})
+}
+
+// bucket returns the bucket with the given path.
+func (s *dbShell) bucket(tx kvdb.RTx,
+	path [][]byte) (kvdb.RBucket, error) {
+
+	bucket := tx.ReadBucket(path[0])
+	for _, name := range path[1:] {
+		if bucket == nil {
+			break
+		}
+		bucket = bucket.NestedReadBucket(name)
+	}
+	if bucket == nil {
+		return nil, fmt.Errorf("bucket '%s' not found",
+			dbShellPath(path))
+	}
+
+	return bucket, nil
+}
+
+// rwBucket returns the bucket with the given path for writing.
+func (s *dbShell) rwBucket(tx kvdb.RwTx,
+	path [][]byte) (kvdb.RwBucket, error) {
+
+	bucket := tx.ReadWriteBucket(path[0])
+	for _, name := range path[1:] {
+		if bucket == nil {
+			break
+		}
+		bucket = bucket.NestedReadWriteBucket(name)
+	}
+	if bucket == nil {
+		return nil, fmt.Errorf("bucket '%s' not found",
+			dbShellPath(path))
+	}
+
+	return bucket, nil
+}
+
+// parseDBShellKey parses a key given either as plain text or as hex with the
+// 0x prefix.
+func parseDBShellKey(name string) ([]byte, error) {
+	if !strings.HasPrefix(name, dbShellHexPrefix) {
+		return []byte(name), nil
+	}
+
+	key, err := hex.DecodeString(strings.TrimPrefix(name, dbShellHexPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding hex key '%s': %w", name,
+			err)
+	}
+
+	return key, nil
+}
+
+// dbShellKey formats a key as plain text if it can be given as such in the
+// shell or as hex with the 0x prefix otherwise.
+func dbShellKey(key []byte) string {
+	if len(key) == 0 || bytes.HasPrefix(key, []byte(dbShellHexPrefix)) {
+		return dbShellHexPrefix + hex.EncodeToString(key)
+	}
+
+	for _, b := range key {
+		if b <= 0x20 || b > 0x7e || b == '/' {
+			return dbShellHexPrefix + hex.EncodeToString(key)
+		}
+	}
+
+	return string(key)
+}
+
+// dbShellPath formats a bucket path.
+func dbShellPath(path [][]byte) string {
+	names := make([]string, len(path))
+	for idx, name := range path {
+		names[idx] = dbShellKey(name)
+	}
+
+	return "/" + strings.Join(names, "/")
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// dbShellField is a single decoded field of a DB record.
+type dbShellField struct {
+	name  string
+	value string
+}
+
+// dbShellDecoder decodes a known lnd record type. See lnd's channeldb and
+// graph/db packages and btcwallet's waddrmgr package for the formats.
+type dbShellDecoder struct {
+	// bucket is the name of the bucket the record is stored in. An empty
+	// name matches records in any bucket.
+	bucket string
+
+	// key is the key of the record. An empty key matches all records of
+	// the bucket.
+	key string
+
+	// decode returns the decoded fields or false if the record doesn't
+	// look like the type handled by the decoder.
+	decode func(key, value []byte) ([]dbShellField, bool)
+}
+
+var dbShellDecoders = []dbShellDecoder{{
+	bucket: "closed-chan-bucket",
+	decode: decodeDBShellCloseSummary,
+}, {
+	bucket: "sync",
+	key:    "birthday",
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		if len(value) != 8 {
+			return nil, false
+		}
+
+		return []dbShellField{{
+			"wallet birthday", dbShellTime(
+				binary.BigEndian.Uint64(value),
+			),
+		}}, true
+	},
+}, {
+	bucket: "sync",
+	key:    "birthdayblock",
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		if len(value) != 44 {
+			return nil, false
+		}
+
+		return dbShellBlock(
+			"wallet birthday block",
+			binary.BigEndian.Uint32(value[:4]), value[4:36],
+			binary.BigEndian.Uint64(value[36:]),
+		), true
+	},
+}, {
+	bucket: "sync",
+	key:    "syncedto",
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		if len(value) != 36 && len(value) != 40 {
+			return nil, false
+		}
+
+		var timestamp uint64
+		if len(value) == 40 {
+			timestamp = uint64(
+				binary.LittleEndian.Uint32(value[36:]),
+			)
+		}
+
+		return dbShellBlock(
+			"wallet synced to block",
+			binary.LittleEndian.Uint32(value[:4]), value[4:36],
+			timestamp,
+		), true
+	},
+}, {
+	bucket: "main",
+	key:    "mgrcreated",
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		if len(value) != 8 {
+			return nil, false
+		}
+
+		return []dbShellField{{
+			"wallet created", dbShellTime(
+				binary.LittleEndian.Uint64(value),
+			),
+		}}, true
+	},
+}, {
+	bucket: "metadata",
+	key:    "dbp",
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		if len(value) != 4 {
+			return nil, false
+		}
+
+		return []dbShellField{{
+			"channel DB version", fmt.Sprintf(
+				"%d", binary.BigEndian.Uint32(value),
+			),
+		}}, true
+	},
+}, {
+	bucket: "confirm-hints",
+	decode: decodeDBShellHeightHint,
+}, {
+	bucket: "spend-hints",
+	decode: decodeDBShellHeightHint,
+}, {
+	bucket: "edge-index",
+	decode: func(key, _ []byte) ([]dbShellField, bool) {
+		return decodeDBShellShortChanID(key)
+	},
+}, {
+	bucket: "chan-index",
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		return decodeDBShellShortChanID(value)
+	},
+}, {
+	bucket: "alias",
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		return []dbShellField{{"node alias", string(value)}}, true
+	},
+}, {
+	// Many buckets use a channel point as the key.
+	decode: func(key, _ []byte) ([]dbShellField, bool) {
+		if len(key) != chainhash.HashSize+4 {
+			return nil, false
+		}
+
+		return []dbShellField{{
+			"channel point (key)", salvageChanPoint(key),
+		}}, true
+	},
+}, {
+	decode: func(_, value []byte) ([]dbShellField, bool) {
+		if len(value) != btcec.PubKeyBytesLenCompressed {
+			return nil, false
+		}
+		if _, err := btcec.ParsePubKey(value); err != nil {
+			return nil, false
+		}
+
+		return []dbShellField{{
+			"public key", fmt.Sprintf("%x", value),
+		}}, true
+	},
+}}
+
+// decodeDBRecord returns the fields of all known record types the given
+// record in the bucket with the given path matches.
+func decodeDBRecord(path [][]byte, key, value []byte) []dbShellField {
+	var (
+		bucket []byte
+		fields []dbShellField
+	)
+	if len(path) > 0 {
+		bucket = path[len(path)-1]
+	}
+
+	for _, decoder := range dbShellDecoders {
+		if decoder.bucket != "" &&
+			!bytes.Equal([]byte(decoder.bucket), bucket) {
+
+			continue
+		}
+		if decoder.key != "" &&
+			!bytes.Equal([]byte(decoder.key), key) {
+
+			continue
+		}
+
+		decoded, ok := decoder.decode(key, value)
+		if ok {
+			fields = append(fields, decoded...)
+		}
+	}
+
+	return fields
+}
+
+// decodeDBShellCloseSummary decodes the fields of a closed channel summary
+// that are always present.
+func decodeDBShellCloseSummary(_, value []byte) ([]dbShellField, bool) {
+	var (
+		chanPoint         wire.OutPoint
+		shortChanID       lnwire.ShortChannelID
+		chainHash         chainhash.Hash
+		closingTXID       chainhash.Hash
+		closeHeight       uint32
+		remotePub         *btcec.PublicKey
+		capacity          btcutil.Amount
+		settledBalance    btcutil.Amount
+		timeLockedBalance btcutil.Amount
+		closeType         channeldb.ClosureType
+		isPending         bool
+	)
+	err := channeldb.ReadElements(
+		bytes.NewReader(value), &chanPoint, &shortChanID, &chainHash,
+		&closingTXID, &closeHeight, &remotePub, &capacity,
+		&settledBalance, &timeLockedBalance, &closeType, &isPending,
+	)
+	if err != nil {
+		return nil, false
+	}
+
+	return []dbShellField{
+		{"channel point", chanPoint.String()},
+		{"short channel ID", shortChanID.String()},
+		{"chain hash", chainHash.String()},
+		{"closing TXID", closingTXID.String()},
+		{"close height", fmt.Sprintf("%d", closeHeight)},
+		{"remote public key", fmt.Sprintf(
+			"%x", remotePub.SerializeCompressed(),
+		)},
+		{"capacity", capacity.String()},
+		{"settled balance", settledBalance.String()},
+		{"time locked balance", timeLockedBalance.String()},
+		{"close type", dbShellCloseType(closeType)},
+		{"pending", fmt.Sprintf("%v", isPending)},
+	}, true
+}
+
+// decodeDBShellHeightHint decodes a confirmation or spend height hint.
+func decodeDBShellHeightHint(_, value []byte) ([]dbShellField, bool) {
+	if len(value) != 4 {
+		return nil, false
+	}
+
+	height := binary.BigEndian.Uint32(value)
+
+	return []dbShellField{{"height hint", fmt.Sprintf("%d", height)}}, true
+}
+
+// decodeDBShellShortChanID decodes a short channel ID.
+func decodeDBShellShortChanID(data []byte) ([]dbShellField, bool) {
+	if len(data) != 8 {
+		return nil, false
+	}
+
+	shortChanID := lnwire.NewShortChanIDFromInt(
+		binary.BigEndian.Uint64(data),
+	)
+
+	return []dbShellField{{"short channel ID", shortChanID.String()}}, true
+}
+
+// dbShellBlock returns the fields of a block stamp.
+func dbShellBlock(name string, height uint32, hash []byte,
+	timestamp uint64) []dbShellField {
+
+	var blockHash chainhash.Hash
+	copy(blockHash[:], hash)
+
+	fields := []dbShellField{
+		{name + " height", fmt.Sprintf("%d", height)},
+		{name + " hash", blockHash.String()},
+	}
+	if timestamp != 0 {
+		fields = append(fields, dbShellField{
+			name + " time", dbShellTime(timestamp),
+		})
+	}
+
+	return fields
+}
+
+// dbShellTime formats a unix timestamp.
+func dbShellTime(timestamp uint64) string {
+	return time.Unix(int64(timestamp), 0).UTC().Format(time.RFC3339)
+}
+
+// dbShellCloseType returns the name of a channel closure type.
+func dbShellCloseType(closeType channeldb.ClosureType) string {
+	names := map[channeldb.ClosureType]string{
+		channeldb.CooperativeClose: "cooperative close",
+		channeldb.LocalForceClose:  "local force close",
+		channeldb.RemoteForceClose: "remote force close",
+		channeldb.BreachClose:      "breach close",
+		channeldb.FundingCanceled:  "funding canceled",
+		channeldb.Abandoned:        "abandoned",
+	}
+	if name, ok := names[closeType]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown (%d)", closeType)
+}
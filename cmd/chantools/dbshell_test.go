@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDBShell opens the given DB file in a shell that writes its output to
+// the returned buffer.
+func newTestDBShell(t *testing.T, fileName string,
+	writable bool) (*dbShell, *bytes.Buffer) {
+
+	db, err := lnd.OpenBackend(fileName, !writable)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	out := &bytes.Buffer{}
+
+	return &dbShell{db: db, writable: writable, out: out}, out
+}
+
+// execDBShell runs the given commands and returns the output of the last one.
+func execDBShell(t *testing.T, shell *dbShell, out *bytes.Buffer,
+	commands ...string) string {
+
+	for _, command := range commands {
+		out.Reset()
+		_, err := shell.exec(command)
+		require.NoError(t, err)
+	}
+
+	return out.String()
+}
+
+func TestDBShellChannelDB(t *testing.T) {
+	h := newHarness(t)
+
+	shell, out := newTestDBShell(t, h.testdataFile("channel.db"), false)
+
+	require.Contains(t, execDBShell(t, shell, out, "ls"),
+		"open-chan-bucket/\n")
+	require.Equal(t, "dbp (4 bytes)\n", execDBShell(
+		t, shell, out, "cd metadata", "ls",
+	))
+	require.Contains(t, execDBShell(t, shell, out, "get dbp"),
+		"channel DB version: 20\n")
+
+	// Non-printable keys are shown and can be given as hex.
+	execDBShell(t, shell, out, "cd ..", "cd graph-node", "cd alias")
+	require.Equal(t, "/graph-node/alias\n", execDBShell(
+		t, shell, out, "pwd",
+	))
+	require.Contains(t, execDBShell(t, shell, out, "ls"),
+		"0x03b99ab108e39e9e4cf565c1b706480180a70a4fdc4828e44c504530c05"+
+			"6be5b5f (4 bytes)\n")
+	require.Equal(t, "0x7a616e65\nnode alias: zane\n", execDBShell(
+		t, shell, out, "get 0x03b99ab108e39e9e4cf565c1b706480180a70a4f"+
+			"dc4828e44c504530c056be5b5f",
+	))
+
+	output := execDBShell(
+		t, shell, out, "cd /", "cd confirm-hints",
+		"get 0x11ff7bf1024157ad2fa9bc659bf413830fcba64500af63d38fac02c"+
+			"487aa30d1",
+	)
+	require.Contains(t, output, "height hint: 125\n")
+
+	output = execDBShell(
+		t, shell, out, "cd /", "cd graph-edge", "cd chan-index",
+		"get 0x11ff7bf1024157ad2fa9bc659bf413830fcba64500af63d38fac02c"+
+			"487aa30d100000000",
+	)
+	require.Contains(t, output, "short channel ID: 125:1:0\n")
+	require.Contains(t, output, "channel point (key): d130aa87c402ac"+
+		"8fd363af0045a6cb0f8313f49b65bca92fad574102f17bff11:0\n")
+
+	// Errors don't change the current bucket.
+	_, err := shell.exec("cd does-not-exist")
+	require.ErrorContains(t, err, "bucket '/graph-edge/chan-index/does-"+
+		"not-exist' not found")
+	_, err = shell.exec("get does-not-exist")
+	require.ErrorContains(t, err, "key 'does-not-exist' not found")
+	_, err = shell.exec("get")
+	require.ErrorContains(t, err, "expects 1 argument(s)")
+	_, err = shell.exec("foo")
+	require.ErrorContains(t, err, "unknown command 'foo'")
+
+	// Nothing can be changed in a read-only DB.
+	_, err = shell.exec("put foo 0x01")
+	require.ErrorIs(t, err, errDBShellReadOnly)
+	_, err = shell.exec("rmbucket foo")
+	require.ErrorIs(t, err, errDBShellReadOnly)
+}
+
+func TestDBShellWalletDB(t *testing.T) {
+	h := newHarness(t)
+
+	dbShell := &dbShellCommand{
+		DB: h.testdataFile("wallet.db"),
+		Exec: []string{
+			"cd waddrmgr", "cd sync", "get birthday", "quit",
+			"get does-not-exist",
+		},
+	}
+	err := dbShell.Execute(nil, nil)
+	require.NoError(t, err)
+
+	shell, out := newTestDBShell(t, dbShell.DB, false)
+	require.Equal(t, "0x000000005fea20a9\nwallet birthday: "+
+		"2020-12-28T18:15:05Z\n", execDBShell(
+		t, shell, out, "cd waddrmgr", "cd sync", "get birthday",
+	))
+	require.Equal(t, "0x07e6ed5f00000000\nwallet created: "+
+		"2020-12-31T14:53:59Z\n", execDBShell(
+		t, shell, out, "cd ..", "cd main", "get mgrcreated",
+	))
+	require.Contains(t, execDBShell(t, shell, out, "hexdump mpriv"),
+		"00000050")
+}
+
+func TestDBShellWrite(t *testing.T) {
+	h := newHarness(t)
+
+	fileName := h.tempFile("channel.db")
+	err := os.WriteFile(fileName, h.readTestdataFile("channel.db"), 0600)
+	require.NoError(t, err)
+
+	shell, out := newTestDBShell(t, fileName, true)
+
+	// The shell reads commands until it's quit and reports errors without
+	// stopping.
+	input := strings.Join([]string{
+		"mkbucket test", "put foo 0x01", "cd test", "put foo 0xc0ffee",
+		"mkbucket nested", "ls", "get nested", "rm nested", "quit",
+		"ls",
+	}, "\n")
+	require.NoError(t, shell.run(strings.NewReader(input), false))
+	require.Equal(t, "error: only buckets can be stored at the top "+
+		"level\nfoo (3 bytes)\nnested/\nerror: 'nested' is a bucket, "+
+		"use 'cd' to show its content\nerror: 'nested' is a bucket, "+
+		"use 'rmbucket' to delete it\n", out.String())
+
+	require.Equal(t, "0xc0ffee\n", execDBShell(
+		t, shell, out, "get foo",
+	))
+	require.Empty(t, execDBShell(
+		t, shell, out, "rm foo", "rmbucket nested", "ls",
+	))
+	require.NotContains(t, execDBShell(
+		t, shell, out, "cd ..", "rmbucket test", "ls",
+	), "test/")
+}
+
+func TestDBShellCloseSummary(t *testing.T) {
+	remotePub, err := btcec.ParsePubKey(
+		[]byte{0x02, 0x79, 0xbe, 0x66, 0x7e, 0xf9, 0xdc, 0xbb, 0xac,
+			0x55, 0xa0, 0x62, 0x95, 0xce, 0x87, 0x0b, 0x07, 0x02,
+			0x9b, 0xfc, 0xdb, 0x2d, 0xce, 0x28, 0xd9, 0x59, 0xf2,
+			0x81, 0x5b, 0x16, 0xf8, 0x17, 0x98},
+	)
+	require.NoError(t, err)
+
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: 2}
+	var buf bytes.Buffer
+	err = channeldb.WriteElements(
+		&buf, chanPoint, lnwire.NewShortChanIDFromInt(123<<40),
+		chainhash.Hash{0x02}, chainhash.Hash{0x03}, uint32(456),
+		remotePub, btcutil.Amount(100_000), btcutil.Amount(40_000),
+		btcutil.Amount(0), channeldb.RemoteForceClose, true,
+	)
+	require.NoError(t, err)
+
+	key := make([]byte, chainhash.HashSize+4)
+	key[0] = 0x01
+	key[35] = 0x02
+	fields := decodeDBRecord(
+		[][]byte{[]byte("closed-chan-bucket")}, key, buf.Bytes(),
+	)
+	require.Contains(t, fields, dbShellField{
+		"channel point", chanPoint.String(),
+	})
+	require.Contains(t, fields, dbShellField{"short channel ID", "123:0:0"})
+	require.Contains(t, fields, dbShellField{"close height", "456"})
+	require.Contains(t, fields, dbShellField{
+		"settled balance", "0.00040000 BTC",
+	})
+	require.Contains(t, fields, dbShellField{
+		"close type", "remote force close",
+	})
+	require.Contains(t, fields, dbShellField{"pending", "true"})
+	require.Contains(t, fields, dbShellField{
+		"channel point (key)", chanPoint.String(),
+	})
+
+	// Garbage isn't decoded.
+	require.Empty(t, decodeDBRecord(
+		[][]byte{[]byte("closed-chan-bucket")}, []byte("foo"),
+		[]byte{0x01},
+	))
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultDescriptorRange = 2500
+)
+
+// descriptorBranch is a branch of an account that is described by a single
+// ranged descriptor.
+type descriptorBranch struct {
+	name     string
+	format   string
+	index    uint32
+	internal bool
+}
+
+// descriptorAccount is an account (a derivation path up to the hardened
+// account level) that lnd derives keys from.
+type descriptorAccount struct {
+	name     string
+	path     string
+	branches []descriptorBranch
+}
+
+// importDescriptor is a single entry of bitcoind's importdescriptors RPC.
+type importDescriptor struct {
+	Desc      string    `json:"desc"`
+	Range     [2]uint32 `json:"range"`
+	Timestamp int64     `json:"timestamp"`
+	Internal  bool      `json:"internal"`
+	Active    bool      `json:"active"`
+
+	// name describes the account and branch of the descriptor.
+	name string
+}
+
+type descriptorsCommand struct {
+	Range  uint32
+	Stdout bool
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newDescriptorsCommand() *cobra.Command {
+	cc := &descriptorsCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "descriptors",
+		Short: "Create watch-only output descriptors for all lnd " +
+			"wallet accounts and key families",
+		Long: `Creates ranged BIP380 output descriptors (with xpubs and
+checksums) for all keys an lnd node derives from its seed. The result is a
+JSON array that can directly be passed to bitcoind's importdescriptors RPC of a
+watch-only descriptor wallet, or to any other monitoring tool that understands
+descriptors.
+
+The following descriptors are created:
+ - the on-chain wallet accounts: np2wkh (m/49'), p2wkh (m/84') and p2tr
+   (m/86'), each with a receive and a change descriptor; note that lnd uses
+   p2wkh change addresses for the np2wkh account
+ - the channel key families (m/1017'/<coin type>'/<family>'/0/*): multisig,
+   revocation base, HTLC base, payment base, delay base and node key
+
+The keys of the channel key families are mostly used inside of scripts that
+also contain keys of the peer, so they are described as p2wkh outputs. This
+allows watching for funds sent directly to those keys (for example the
+to_remote output of a channel that uses the payment base key); the key
+expressions themselves can be used to watch for any script containing them.
+
+The timestamp of the descriptors is set to the wallet birthday if the lnd 24
+word aezeed is entered, otherwise to 0, so bitcoind rescans the whole chain.
+
+With --stdout, only the JSON is written to standard out and all log output
+goes to standard error.`,
+		Example: `chantools descriptors --range 5000
+
+chantools descriptors --stdout > descriptors.json
+bitcoin-cli -rpcwallet=watchonly importdescriptors "$(cat descriptors.json)"`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().Uint32Var(
+		&cc.Range, "range", defaultDescriptorRange, "the end of the "+
+			"index range of each descriptor (number of keys to "+
+			"watch per descriptor)",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Stdout, "stdout", false, "write the descriptors to "+
+			"standard out instead of writing them to a file",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving the descriptors")
+
+	return cc.cmd
+}
+
+func (c *descriptorsCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, birthday, err := c.rootKey.readWithBirthday()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Only the aezeed contains a birthday, for all other root key sources
+	// the Unix epoch is returned. Without a birthday, a timestamp of 0
+	// makes bitcoind rescan the whole chain.
+	timestamp := max(birthday.Unix(), 0)
+
+	descriptors, err := lndDescriptors(
+		extendedKey, chainParams, c.Range, timestamp,
+	)
+	if err != nil {
+		return err
+	}
+
+	result, err := json.MarshalIndent(descriptors, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding descriptors: %w", err)
+	}
+
+	if c.Stdout {
+		fmt.Println(string(result))
+
+		return nil
+	}
+
+	for _, desc := range descriptors {
+		log.Infof("%s: %s", desc.name, desc.Desc)
+	}
+	fileName := fmt.Sprintf("%s/descriptors-%s.json", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing %d descriptors to %s", len(descriptors), fileName)
+
+	return os.WriteFile(fileName, result, 0644)
+}
+
+// descriptorAccounts returns all accounts lnd derives keys from.
+func descriptorAccounts(params *chaincfg.Params) []descriptorAccount {
+	walletBranches := func(external, internal string) []descriptorBranch {
+		return []descriptorBranch{
+			{name: "receive", format: external},
+			{
+				name: "change", format: internal, index: 1,
+				internal: true,
+			},
+		}
+	}
+	accounts := []descriptorAccount{{
+		name:     "np2wkh wallet",
+		path:     fmt.Sprintf("m/49'/%d'/0'", params.HDCoinType),
+		branches: walletBranches("sh(wpkh(%s))", "wpkh(%s)"),
+	}, {
+		name:     "p2wkh wallet",
+		path:     fmt.Sprintf("m/84'/%d'/0'", params.HDCoinType),
+		branches: walletBranches("wpkh(%s)", "wpkh(%s)"),
+	}, {
+		name:     "p2tr wallet",
+		path:     fmt.Sprintf("m/86'/%d'/0'", params.HDCoinType),
+		branches: walletBranches("tr(%s)", "tr(%s)"),
+	}}
+
+	families := []struct {
+		name   string
+		family keychain.KeyFamily
+	}{
+		{"multisig", keychain.KeyFamilyMultiSig},
+		{"revocation base", keychain.KeyFamilyRevocationBase},
+		{"HTLC base", keychain.KeyFamilyHtlcBase},
+		{"payment base", keychain.KeyFamilyPaymentBase},
+		{"delay base", keychain.KeyFamilyDelayBase},
+		{"node key", keychain.KeyFamilyNodeKey},
+	}
+	for _, family := range families {
+		accounts = append(accounts, descriptorAccount{
+			name: family.name,
+			path: fmt.Sprintf(
+				lnd.LndDerivationPath, params.HDCoinType,
+				uint32(family.family),
+			),
+			branches: []descriptorBranch{
+				{name: "key family", format: "wpkh(%s)"},
+			},
+		})
+	}
+
+	return accounts
+}
+
+// lndDescriptors creates the ranged descriptors of all accounts lnd derives
+// keys from.
+func lndDescriptors(rootKey *hdkeychain.ExtendedKey, params *chaincfg.Params,
+	rangeEnd uint32, timestamp int64) ([]importDescriptor, error) {
+
+	_, fingerprintBytes, err := fingerprint(rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not get fingerprint: %w", err)
+	}
+
+	var descriptors []importDescriptor
+	for _, account := range descriptorAccounts(params) {
+		path, err := lnd.ParsePath(account.path)
+		if err != nil {
+			return nil, err
+		}
+
+		// We derive the account key the same way lnd does. Everything
+		// below the account level is public derivation, so the xpub
+		// describes exactly the keys lnd uses.
+		accountKey, err := lnd.DeriveChildren(rootKey, path)
+		if err != nil {
+			return nil, fmt.Errorf("error deriving %s: %w",
+				account.path, err)
+		}
+		accountPub, err := accountKey.Neuter()
+		if err != nil {
+			return nil, err
+		}
+		origin := fmt.Sprintf("[%s%s]%s", hex.EncodeToString(
+			fingerprintBytes,
+		), account.path[1:], accountPub.String())
+
+		for _, branch := range account.branches {
+			desc := btc.DescriptorSumCreate(fmt.Sprintf(
+				branch.format, fmt.Sprintf(
+					"%s/%d/*", origin, branch.index,
+				),
+			))
+			descriptors = append(descriptors, importDescriptor{
+				Desc:      desc,
+				Range:     [2]uint32{0, rangeEnd},
+				Timestamp: timestamp,
+				Internal:  branch.internal,
+				name: fmt.Sprintf(
+					"%s %s", account.name, branch.name,
+				),
+			})
+		}
+	}
+
+	return descriptors, nil
+}
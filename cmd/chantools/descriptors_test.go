@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+var descriptorKeyRegex = regexp.MustCompile(
+	`\[([0-9a-f]{8})(/[0-9'/]+)\](tpub[1-9A-Za-z]+)/([01])/\*`,
+)
+
+func TestDescriptors(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	descriptors, err := lndDescriptors(
+		extendedKey, chainParams, 100, 0,
+	)
+	require.NoError(t, err)
+
+	// Three wallet accounts with two branches each and six key families.
+	require.Len(t, descriptors, 12)
+	require.True(t, strings.HasPrefix(descriptors[0].Desc, "sh(wpkh(["))
+	require.True(t, strings.HasPrefix(descriptors[1].Desc, "wpkh(["))
+	require.True(t, descriptors[1].Internal)
+	require.True(t, strings.HasPrefix(descriptors[4].Desc, "tr(["))
+	require.Equal(t, "payment base key family", descriptors[9].name)
+	require.True(t, strings.HasPrefix(descriptors[9].Desc, "wpkh(["))
+
+	_, fingerprintBytes, err := fingerprint(extendedKey)
+	require.NoError(t, err)
+	fingerprintHex := hex.EncodeToString(fingerprintBytes)
+
+	for _, desc := range descriptors {
+		withoutSum := desc.Desc[:len(desc.Desc)-9]
+		require.Equal(t, btc.DescriptorSumCreate(withoutSum), desc.Desc)
+		require.Equal(t, [2]uint32{0, 100}, desc.Range)
+		require.Zero(t, desc.Timestamp)
+
+		matches := descriptorKeyRegex.FindStringSubmatch(desc.Desc)
+		require.Len(t, matches, 5, desc.Desc)
+		require.Equal(t, fingerprintHex, matches[1])
+	}
+
+	// The first key of the multisig family must be the key lnd derives.
+	multiSigDesc := descriptors[6].Desc
+	require.Contains(t, multiSigDesc, "/1017'/1'/0']")
+	matches := descriptorKeyRegex.FindStringSubmatch(multiSigDesc)
+	accountPub, err := hdkeychain.NewKeyFromString(matches[3])
+	require.NoError(t, err)
+	derived, err := lnd.DeriveChildren(accountPub, []uint32{0, 7})
+	require.NoError(t, err)
+	derivedPub, err := derived.ECPubKey()
+	require.NoError(t, err)
+
+	expected, err := keyRing.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+		Index:  7,
+	})
+	require.NoError(t, err)
+	require.Equal(t, expected.PubKey, derivedPub)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcwallet/walletdb"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// The names of the buckets and keys touched by the migrations we can
+	// revert. See lnd's channeldb package and its migrationXX packages.
+	downgradeMetaBucket         = []byte("metadata")
+	downgradeVersionKey         = []byte("dbp")
+	downgradeMCResultsBucket    = []byte("missioncontrol-results")
+	downgradeMCDefaultNamespace = []byte("default")
+	downgradeLastTxBucket       = []byte("sweeper-last-tx")
+	downgradeChanIDBucket       = []byte("chan-id-bucket")
+
+	errDowngradeOneWay = errors.New("migration can't be reverted")
+)
+
+// downgradeStep describes how a single channel DB migration can be reverted.
+type downgradeStep struct {
+	// version is the DB version the migration upgrades to.
+	version uint32
+
+	// description is a short description of the migration.
+	description string
+
+	// revert reverts the migration. It is nil if the migration is one-way.
+	revert func(tx kvdb.RwTx) error
+
+	// intermediate is true if no release of lnd uses this version, so it
+	// can only be reverted as part of a downgrade to a lower version.
+	intermediate bool
+}
+
+// downgradeSteps are all migrations we know about, starting with the most
+// recent one.
+var downgradeSteps = []downgradeStep{{
+	version:     33,
+	description: "mission control results stored in namespaces",
+	revert:      revertMCNamespaces,
+}, {
+	// The old route encoding can't be restored from the minimal one. But
+	// the mission control results are only a cache of past payment
+	// attempts that lnd learns again, so we drop them instead.
+	version:     32,
+	description: "minimal route encoding in mission control results",
+	revert:      revertMCRouteSerialization,
+}, {
+	version:     31,
+	description: "deletion of the sweeper-last-tx bucket",
+	revert: func(tx kvdb.RwTx) error {
+		_, err := tx.CreateTopLevelBucket(downgradeLastTxBucket)
+		return err
+	},
+}, {
+	// Version 30 is used by the optional revocation log migration which
+	// doesn't touch the mandatory version number.
+	version:     30,
+	description: "unused version number",
+	revert: func(kvdb.RwTx) error {
+		return nil
+	},
+	intermediate: true,
+}, {
+	version:     29,
+	description: "population of the channel ID index",
+	revert: func(tx kvdb.RwTx) error {
+		bucket := tx.ReadWriteBucket(downgradeChanIDBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		return deleteAllKeys(bucket)
+	},
+}, {
+	version:     28,
+	description: "creation of the channel ID index",
+	revert: func(tx kvdb.RwTx) error {
+		err := tx.DeleteTopLevelBucket(downgradeChanIDBucket)
+		if errors.Is(err, walletdb.ErrBucketNotFound) {
+			return nil
+		}
+
+		return err
+	},
+}, {
+	version:     27,
+	description: "patch of the balances of historical channels",
+}, {
+	version:     26,
+	description: "channel balances stored as TLV records",
+}, {
+	version:     25,
+	description: "initial balances of open channels",
+}}
+
+type downgradeDBCommand struct {
+	ChannelDB     string
+	TargetVersion uint32
+
+	cmd *cobra.Command
+}
+
+func newDowngradeDBCommand() *cobra.Command {
+	cc := &downgradeDBCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "downgradedb",
+		Short: "Revert the most recent lnd channel database " +
+			"migrations if possible",
+		Long: `This command opens an lnd channel database in write mode
+and reverts all database migrations down to the given target version. This can
+be used to go back to an older version of lnd after an upgrade went wrong,
+without restoring the database from a backup.
+
+Not all migrations can be reverted. The command checks all migrations between
+the current and the target version first and refuses to change anything if one
+of them is one-way. The mission control results (the history of past payment
+attempts) can't be converted back to their old format and are deleted when
+reverting version 32, lnd will learn them again. Version 30 isn't used by any
+lnd release and can't be chosen as the target version.
+
+The current version of the database can be found with the dbshell command in
+the 'metadata' bucket under the key 'dbp'.
+
+CAUTION: Create a backup of the channel DB before running this command and
+make sure lnd is not running. Only use the downgraded database with a version
+of lnd that expects exactly the target version.`,
+		Example: `chantools downgradedb --targetversion 29 \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to "+
+			"downgrade",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.TargetVersion, "targetversion", 0, "the channel DB "+
+			"version to downgrade to",
+	)
+
+	return cc.cmd
+}
+
+func (c *downgradeDBCommand) Execute(_ *cobra.Command, _ []string) error {
+	// Check that we have a channel DB.
+	if c.ChannelDB == "" {
+		return errors.New("channel DB is required")
+	}
+	if c.TargetVersion == 0 {
+		return errors.New("target version is required")
+	}
+
+	// We can't use lnd.OpenDB here as that would apply all migrations
+	// again.
+	db, err := lnd.OpenBackend(c.ChannelDB, false)
+	if err != nil {
+		return fmt.Errorf("error opening DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var currentVersion uint32
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		currentVersion, err = readDBVersion(tx)
+		if err != nil {
+			return err
+		}
+
+		return downgradeDB(tx, currentVersion, c.TargetVersion)
+	}, func() {})
+	if err != nil {
+		return fmt.Errorf("error downgrading DB: %w", err)
+	}
+
+	log.Infof("Downgraded channel DB from version %d to %d",
+		currentVersion, c.TargetVersion)
+
+	return nil
+}
+
+// downgradeDB reverts all migrations from the current version down to the
+// target version. Nothing is changed if any of them can't be reverted.
+func downgradeDB(tx kvdb.RwTx, currentVersion, targetVersion uint32) error {
+	if targetVersion >= currentVersion {
+		return fmt.Errorf("DB is at version %d, can only downgrade to "+
+			"a lower version", currentVersion)
+	}
+	target, ok := findDowngradeStep(targetVersion)
+	if ok && target.intermediate {
+		return fmt.Errorf("version %d (%s) isn't used by any lnd "+
+			"release, choose version %d instead", targetVersion,
+			target.description, targetVersion-1)
+	}
+
+	// Collect and check all migrations first.
+	var steps []downgradeStep
+	for version := currentVersion; version > targetVersion; version-- {
+		step, ok := findDowngradeStep(version)
+		if !ok {
+			return fmt.Errorf("%w: migration to version %d is "+
+				"unknown to this version of chantools",
+				errDowngradeOneWay, version)
+		}
+		if step.revert == nil {
+			return fmt.Errorf("%w: migration to version %d "+
+				"(%s) is one-way, lowest possible version is "+
+				"%d",
+				errDowngradeOneWay, version, step.description,
+				version)
+		}
+
+		steps = append(steps, step)
+	}
+
+	for _, step := range steps {
+		log.Infof("Reverting migration to version %d (%s)",
+			step.version, step.description)
+
+		if err := step.revert(tx); err != nil {
+			return fmt.Errorf("error reverting migration to "+
+				"version %d: %w", step.version, err)
+		}
+	}
+
+	metaBucket := tx.ReadWriteBucket(downgradeMetaBucket)
+	var versionBytes [4]byte
+	binary.BigEndian.PutUint32(versionBytes[:], targetVersion)
+
+	return metaBucket.Put(downgradeVersionKey, versionBytes[:])
+}
+
+// findDowngradeStep returns the step that reverts the migration to the given
+// version.
+func findDowngradeStep(version uint32) (downgradeStep, bool) {
+	for _, step := range downgradeSteps {
+		if step.version == version {
+			return step, true
+		}
+	}
+
+	return downgradeStep{}, false
+}
+
+// readDBVersion reads the version number of a channel DB.
+func readDBVersion(tx kvdb.RTx) (uint32, error) {
+	metaBucket := tx.ReadBucket(downgradeMetaBucket)
+	if metaBucket == nil {
+		return 0, errors.New("metadata bucket not found, not a " +
+			"channel DB?")
+	}
+
+	versionBytes := metaBucket.Get(downgradeVersionKey)
+	if len(versionBytes) != 4 {
+		return 0, errors.New("invalid or missing DB version")
+	}
+
+	return binary.BigEndian.Uint32(versionBytes), nil
+}
+
+// revertMCNamespaces moves the mission control results of the default
+// namespace back into the top level results bucket. Results of any other
+// namespace didn't exist before and are deleted.
+func revertMCNamespaces(tx kvdb.RwTx) error {
+	resultsBucket := tx.ReadWriteBucket(downgradeMCResultsBucket)
+	if resultsBucket == nil {
+		return nil
+	}
+
+	var namespaces [][]byte
+	err := resultsBucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			namespaces = append(namespaces, bytes.Clone(k))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces {
+		if !bytes.Equal(namespace, downgradeMCDefaultNamespace) {
+			log.Warnf("Deleting mission control results of "+
+				"namespace '%s'", namespace)
+
+			err := resultsBucket.DeleteNestedBucket(namespace)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		defaultBucket := resultsBucket.NestedReadWriteBucket(namespace)
+		err := defaultBucket.ForEach(func(k, v []byte) error {
+			return resultsBucket.Put(k, v)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = resultsBucket.DeleteNestedBucket(namespace)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revertMCRouteSerialization deletes all mission control results.
+func revertMCRouteSerialization(tx kvdb.RwTx) error {
+	resultsBucket := tx.ReadWriteBucket(downgradeMCResultsBucket)
+	if resultsBucket == nil {
+		return nil
+	}
+
+	log.Warnf("Deleting all mission control results")
+
+	return deleteAllKeys(resultsBucket)
+}
+
+// deleteAllKeys deletes all keys (but no nested buckets) of a bucket.
+func deleteAllKeys(bucket kvdb.RwBucket) error {
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if v != nil {
+			keys = append(keys, bytes.Clone(k))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// latestDBVersion is the channel DB version of the lnd version we
+	// use.
+	latestDBVersion = 33
+)
+
+// createMigratedDB creates a copy of the test channel DB and applies all
+// migrations to it. Some mission control results are added to the default
+// and a custom namespace.
+func createMigratedDB(h *harness) string {
+	fileName := h.tempFile("channel.db")
+	err := os.WriteFile(fileName, h.readTestdataFile("channel.db"), 0600)
+	require.NoError(h.t, err)
+
+	db, _, err := lnd.OpenDB(fileName, false)
+	require.NoError(h.t, err)
+
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		results, err := tx.CreateTopLevelBucket(
+			downgradeMCResultsBucket,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, namespace := range []string{"default", "custom"} {
+			bucket, err := results.CreateBucketIfNotExists(
+				[]byte(namespace),
+			)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(namespace+"-key"), []byte{0x01})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func() {})
+	require.NoError(h.t, err)
+	require.NoError(h.t, db.Close())
+
+	return fileName
+}
+
+// dbVersion returns the version of a channel DB.
+func dbVersion(h *harness, fileName string) uint32 {
+	db, err := lnd.OpenBackend(fileName, true)
+	require.NoError(h.t, err)
+	defer func() { _ = db.Close() }()
+
+	var version uint32
+	err = kvdb.View(db, func(tx kvdb.RTx) error {
+		version, err = readDBVersion(tx)
+		return err
+	}, func() {})
+	require.NoError(h.t, err)
+
+	return version
+}
+
+func TestDowngradeDB(t *testing.T) {
+	h := newHarness(t)
+
+	fileName := createMigratedDB(h)
+	require.EqualValues(t, latestDBVersion, dbVersion(h, fileName))
+
+	// Only the results of the default namespace are moved back.
+	downgrade := &downgradeDBCommand{
+		ChannelDB:     fileName,
+		TargetVersion: 32,
+	}
+	require.NoError(t, downgrade.Execute(nil, nil))
+	require.EqualValues(t, 32, dbVersion(h, fileName))
+
+	db, err := lnd.OpenBackend(fileName, true)
+	require.NoError(t, err)
+	err = kvdb.View(db, func(tx kvdb.RTx) error {
+		results := tx.ReadBucket(downgradeMCResultsBucket)
+		require.Equal(
+			t, []byte{0x01}, results.Get([]byte("default-key")),
+		)
+		require.Nil(t, results.NestedReadBucket([]byte("default")))
+		require.Nil(t, results.NestedReadBucket([]byte("custom")))
+
+		return nil
+	}, func() {})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// The balance migrations are one-way, so nothing is changed.
+	downgrade.TargetVersion = 26
+	err = downgrade.Execute(nil, nil)
+	require.ErrorIs(t, err, errDowngradeOneWay)
+	require.ErrorContains(t, err, "lowest possible version is 27")
+	require.EqualValues(t, 32, dbVersion(h, fileName))
+
+	downgrade.TargetVersion = 27
+	require.NoError(t, downgrade.Execute(nil, nil))
+	require.EqualValues(t, 27, dbVersion(h, fileName))
+	h.assertLogContains("Downgraded channel DB from version 32 to 27")
+
+	db, err = lnd.OpenBackend(fileName, true)
+	require.NoError(t, err)
+	err = kvdb.View(db, func(tx kvdb.RTx) error {
+		results := tx.ReadBucket(downgradeMCResultsBucket)
+		require.Nil(t, results.Get([]byte("default-key")))
+		require.NotNil(t, tx.ReadBucket(downgradeLastTxBucket))
+		require.Nil(t, tx.ReadBucket(downgradeChanIDBucket))
+
+		return nil
+	}, func() {})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Migrating the DB up again works and doesn't lose any channels.
+	chanDB, _, err := lnd.OpenDB(fileName, false)
+	require.NoError(t, err)
+	channels, err := chanDB.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.Len(t, channels, 4)
+	require.NoError(t, chanDB.Close())
+	require.EqualValues(t, latestDBVersion, dbVersion(h, fileName))
+
+	// Version 30 is only an intermediate step.
+	downgrade.TargetVersion = 30
+	require.ErrorContains(
+		t, downgrade.Execute(nil, nil), "isn't used by any lnd release",
+	)
+	require.EqualValues(t, latestDBVersion, dbVersion(h, fileName))
+
+	// We can't upgrade or revert migrations we don't know about.
+	downgrade.TargetVersion = latestDBVersion
+	require.ErrorContains(
+		t, downgrade.Execute(nil, nil), "can only downgrade to a lower",
+	)
+
+	db, err = lnd.OpenBackend(fileName, false)
+	require.NoError(t, err)
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		return downgradeDB(tx, latestDBVersion+1, latestDBVersion)
+	}, func() {})
+	require.ErrorContains(t, err, "unknown to this version")
+	require.NoError(t, db.Close())
+}
+
+func TestDowngradeDBVersions(t *testing.T) {
+	// Every version down to the oldest reversible one results in a DB
+	// that can be migrated up again.
+	for version := uint32(latestDBVersion - 1); version >= 27; version-- {
+		if step, _ := findDowngradeStep(version); step.intermediate {
+			continue
+		}
+
+		h := newHarness(t)
+
+		fileName := createMigratedDB(h)
+		downgrade := &downgradeDBCommand{
+			ChannelDB:     fileName,
+			TargetVersion: version,
+		}
+		require.NoError(t, downgrade.Execute(nil, nil))
+		require.Equal(t, version, dbVersion(h, fileName))
+
+		chanDB, _, err := lnd.OpenDB(fileName, false)
+		require.NoError(t, err)
+		require.NoError(t, chanDB.Close())
+		require.EqualValues(t, latestDBVersion, dbVersion(h, fileName))
+	}
+}
@@ -14,6 +14,9 @@ import (
 
 type dumpBackupCommand struct {
 	MultiFile string
+	ClnFile   string
+	ScbJSON   string
+	HsmSecret string
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -25,15 +28,36 @@ func newDumpBackupCommand() *cobra.Command {
 		Use:   "dumpbackup",
 		Short: "Dump the content of a channel.backup file",
 		Long: `This command dumps all information that is inside a 
-channel.backup file in a human readable format.`,
+channel.backup file in a human readable format.
+
+A Core Lightning static channel backup can be dumped as well, either from its
+emergency.recover file or the JSON output of 'lightning-cli staticbackup'. It
+is shown the way it is converted by the clntobackup command.`,
 		Example: `chantools dumpbackup \
-	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup`,
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup
+
+chantools dumpbackup \
+	--cln_file ~/.lightning/bitcoin/emergency.recover \
+	--hsm_secret 3f0a06c6....`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
 		&cc.MultiFile, "multi_file", "", "lnd channel.backup file to "+
 			"dump",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.ClnFile, "cln_file", "", "CLN emergency.recover file to "+
+			"dump instead of an lnd backup",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ScbJSON, "scb_json", "", "JSON output of 'lightning-cli "+
+			"staticbackup' to dump instead of an lnd backup",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.HsmSecret, "hsm_secret", "", "the hex encoded HSM secret "+
+			"of the CLN node, for decrypting the "+
+			"emergency.recover file and deriving the channel keys",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
 
@@ -41,6 +65,18 @@ channel.backup file in a human readable format.`,
 }
 
 func (c *dumpBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	// A CLN backup isn't encrypted with the lnd seed.
+	if c.ClnFile != "" || c.ScbJSON != "" {
+		singles, err := readClnBackup(c.ClnFile, c.ScbJSON, c.HsmSecret)
+		if err != nil {
+			return err
+		}
+
+		dumpMulti(&chanbackup.Multi{StaticBackups: singles})
+
+		return nil
+	}
+
 	extendedKey, err := c.rootKey.read()
 	if err != nil {
 		return fmt.Errorf("error reading root key: %w", err)
@@ -65,6 +101,12 @@ func dumpChannelBackup(multiFile *chanbackup.MultiFile,
 	if err != nil {
 		return fmt.Errorf("could not extract multi file: %w", err)
 	}
+	dumpMulti(multi)
+
+	return nil
+}
+
+func dumpMulti(multi *chanbackup.Multi) {
 	content := dump.BackupMulti{
 		Version:       multi.Version,
 		StaticBackups: dump.BackupDump(multi, chainParams),
@@ -73,6 +115,4 @@ func dumpChannelBackup(multiFile *chanbackup.MultiFile,
 
 	// For the tests, also log as trace level which is disabled by default.
 	log.Tracef(spew.Sdump(content))
-
-	return nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -12,10 +13,13 @@ import (
 )
 
 type dumpChannelsCommand struct {
-	ChannelDB    string
-	Closed       bool
-	Pending      bool
-	WaitingClose bool
+	ChannelDB      string
+	Closed         bool
+	Pending        bool
+	WaitingClose   bool
+	Deep           bool
+	ChanPoint      string
+	MaxRevocations int
 
 	cmd *cobra.Command
 }
@@ -27,8 +31,22 @@ func newDumpChannelsCommand() *cobra.Command {
 		Short: "Dump all channel information from an lnd channel " +
 			"database",
 		Long: `This command dumps all open and pending channels from the
-given lnd channel.db gile in a human readable format.`,
+given lnd channel.db gile in a human readable format.
+
+With the --deep flag, the full state of each channel is dumped as JSON instead.
+This includes the commit heights, the HTLCs of the local, remote and pending
+remote commitments (with payment hashes, amounts and expiries), the unsigned
+updates, the revocation log and the forwarding packages. This can be used to
+plan the recovery of HTLCs or the punishment of a breach from a copy of the
+channel DB. Closed channels are dumped from the historical channel bucket,
+their revocation log is deleted by lnd when the channel is closed. The
+revocation log of a busy channel can contain millions of entries, use
+--maxrevocations to only dump the most recent ones.`,
 		Example: `chantools dumpchannels \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db
+
+chantools dumpchannels --deep --waiting_close \
+	--chanpoint 3c3f9b1f...a95b:1 \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db`,
 		RunE: cc.Execute,
 	}
@@ -48,6 +66,22 @@ given lnd channel.db gile in a human readable format.`,
 		&cc.WaitingClose, "waiting_close", false, "dump waiting close "+
 			"channels instead of open",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Deep, "deep", false, "dump the full state of each "+
+			"channel including HTLCs, the revocation log and "+
+			"forwarding packages as JSON",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChanPoint, "chanpoint", "", "only dump the channel with "+
+			"the given channel point (format <txid>:<idx>), can "+
+			"only be used with --deep",
+	)
+	cc.cmd.Flags().IntVar(
+		&cc.MaxRevocations, "maxrevocations", 0, "only dump the "+
+			"given number of most recent revocation log entries "+
+			"of each channel, 0 dumps all of them, can only be "+
+			"used with --deep",
+	)
 
 	return cc.cmd
 }
@@ -70,6 +104,20 @@ func (c *dumpChannelsCommand) Execute(_ *cobra.Command, _ []string) error {
 		return errors.New("can only specify one flag at a time")
 	}
 
+	if c.ChanPoint != "" && !c.Deep {
+		return errors.New("--chanpoint can only be used with --deep")
+	}
+	if c.MaxRevocations != 0 && !c.Deep {
+		return errors.New("--maxrevocations can only be used with " +
+			"--deep")
+	}
+	if c.MaxRevocations < 0 {
+		return errors.New("--maxrevocations can't be negative")
+	}
+	if c.Deep {
+		return c.dumpChannelDetails(db.ChannelStateDB())
+	}
+
 	if c.Closed {
 		return dumpClosedChannelInfo(db.ChannelStateDB())
 	}
@@ -108,6 +156,32 @@ func dumpClosedChannelInfo(chanDb *channeldb.ChannelStateDB) error {
 		return err
 	}
 
+	historicalChannels, err := fetchHistoricalChannels(chanDb, channels)
+	if err != nil {
+		return err
+	}
+
+	dumpChannels, err := dump.ClosedChannelDump(
+		channels, historicalChannels, chainParams,
+	)
+	if err != nil {
+		return fmt.Errorf("error converting to dump format: %w", err)
+	}
+
+	spew.Dump(dumpChannels)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(spew.Sdump(dumpChannels))
+
+	return nil
+}
+
+// fetchHistoricalChannels returns the last state of the given closed channels
+// or nil for channels that were closed before lnd started to keep it.
+func fetchHistoricalChannels(chanDb *channeldb.ChannelStateDB,
+	channels []*channeldb.ChannelCloseSummary) ([]*channeldb.OpenChannel,
+	error) {
+
 	historicalChannels := make([]*channeldb.OpenChannel, len(channels))
 	for idx := range channels {
 		closedChan := channels[idx]
@@ -126,23 +200,11 @@ func dumpClosedChannelInfo(chanDb *channeldb.ChannelStateDB) error {
 
 		// Non-nil error not due to older versions of lnd.
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	dumpChannels, err := dump.ClosedChannelDump(
-		channels, historicalChannels, chainParams,
-	)
-	if err != nil {
-		return fmt.Errorf("error converting to dump format: %w", err)
-	}
-
-	spew.Dump(dumpChannels)
-
-	// For the tests, also log as trace level which is disabled by default.
-	log.Tracef(spew.Sdump(dumpChannels))
-
-	return nil
+	return historicalChannels, nil
 }
 
 func dumpPendingChannelInfo(chanDb *channeldb.ChannelStateDB) error {
@@ -182,3 +244,120 @@ func dumpWaitingCloseChannelInfo(chanDb *channeldb.ChannelStateDB) error {
 
 	return nil
 }
+
+// dumpChannelDetails dumps the full state of the selected channels as JSON.
+func (c *dumpChannelsCommand) dumpChannelDetails(
+	chanDb *channeldb.ChannelStateDB) error {
+
+	var (
+		channels []*channeldb.OpenChannel
+		err      error
+	)
+	switch {
+	case c.Closed:
+		closedChannels, err := chanDb.FetchClosedChannels(false)
+		if err != nil {
+			return err
+		}
+
+		historicalChannels, err := fetchHistoricalChannels(
+			chanDb, closedChannels,
+		)
+		if err != nil {
+			return err
+		}
+
+		for idx, histChan := range historicalChannels {
+			if histChan == nil {
+				log.Warnf("No historical state found for "+
+					"closed channel %v, skipping",
+					closedChannels[idx].ChanPoint)
+
+				continue
+			}
+
+			channels = append(channels, histChan)
+		}
+
+	case c.Pending:
+		channels, err = chanDb.FetchPendingChannels()
+
+	case c.WaitingClose:
+		channels, err = chanDb.FetchWaitingCloseChannels()
+
+	default:
+		channels, err = chanDb.FetchAllChannels()
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.ChanPoint != "" {
+		chanPoint, err := lnd.ParseOutpoint(c.ChanPoint)
+		if err != nil {
+			return fmt.Errorf("error parsing channel point: %w",
+				err)
+		}
+
+		var filtered []*channeldb.OpenChannel
+		for _, channel := range channels {
+			if channel.FundingOutpoint == *chanPoint {
+				filtered = append(filtered, channel)
+			}
+		}
+		if len(filtered) == 0 {
+			return fmt.Errorf("channel %v not found", chanPoint)
+		}
+		channels = filtered
+	}
+
+	// The channels are converted and printed one by one, so only the
+	// state of a single channel is held in memory at any time. The output
+	// is the same as if the whole list was encoded at once.
+	if len(channels) == 0 {
+		fmt.Println("[]")
+		return nil
+	}
+
+	fmt.Println("[")
+	for idx, channel := range channels {
+		// The revocation log of a closed channel is deleted.
+		var readRevocationLog dump.RevocationLogReader
+		if !c.Closed {
+			readRevocationLog = func(fn func(uint64,
+				*channeldb.RevocationLog,
+				*channeldb.ChannelCommitment) error) error {
+
+				return lnd.ForEachRevocationLogEntry(
+					channel, c.MaxRevocations, fn,
+				)
+			}
+		}
+
+		details, err := dump.ChannelDetailsDump(
+			channel, c.Closed, readRevocationLog,
+		)
+		if err != nil {
+			return fmt.Errorf("error dumping channel %v: %w",
+				channel.FundingOutpoint, err)
+		}
+
+		result, err := json.MarshalIndent(details, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		separator := ","
+		if idx == len(channels)-1 {
+			separator = ""
+		}
+		fmt.Printf("  %s%s\n", result, separator)
+
+		// For the tests, also log as trace level which is disabled by
+		// default.
+		log.Tracef(string(result))
+	}
+	fmt.Println("]")
+
+	return nil
+}
@@ -1,4 +1,153 @@
 package main
 
-// This file is empty for now, the dumpchannels command is covered by the test
-// in compactdb_test.go.
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+// revokedHeightRegex matches the commit height of a revocation log entry in
+// the deep dump.
+var revokedHeightRegex = regexp.MustCompile(
+	`"commit_height": (\d+),\s+"legacy"`,
+)
+
+// advanceRemoteCommit adds a new remote commitment with the given HTLCs to the
+// channel. If revoke is true, the previous remote commitment is revoked and
+// added to the revocation log.
+func advanceRemoteCommit(t *testing.T, channel *channeldb.OpenChannel,
+	htlcs []channeldb.HTLC, revoke bool) {
+
+	chanID := lnwire.NewChanIDFromOutPoint(channel.FundingOutpoint)
+	commit := channel.RemoteCommitment
+	commit.CommitHeight++
+	commit.Htlcs = htlcs
+
+	var updates []channeldb.LogUpdate
+	for idx := range htlcs {
+		updates = append(updates, channeldb.LogUpdate{
+			LogIndex: htlcs[idx].LogIndex,
+			UpdateMsg: &lnwire.UpdateAddHTLC{
+				ChanID:      chanID,
+				ID:          htlcs[idx].HtlcIndex,
+				Amount:      htlcs[idx].Amt,
+				PaymentHash: htlcs[idx].RHash,
+				Expiry:      htlcs[idx].RefundTimeout,
+			},
+		})
+	}
+
+	err := channel.AppendRemoteCommitChain(&channeldb.CommitDiff{
+		Commitment: commit,
+		CommitSig:  &lnwire.CommitSig{ChanID: chanID},
+		LogUpdates: updates,
+	})
+	require.NoError(t, err)
+
+	if !revoke {
+		return
+	}
+
+	fwdPkg := channeldb.NewFwdPkg(
+		channel.ShortChannelID, commit.CommitHeight, updates, nil,
+	)
+	err = channel.AdvanceCommitChainTail(fwdPkg, nil, 0, 1)
+	require.NoError(t, err)
+}
+
+func TestDumpChannelsDeep(t *testing.T) {
+	h := newHarness(t)
+
+	// We add some HTLCs and revoked states to a channel of a migrated copy
+	// of the test DB.
+	fileName := createMigratedDB(h)
+	db, _, err := lnd.OpenDB(fileName, false)
+	require.NoError(t, err)
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.NotEmpty(t, channels)
+	channel := channels[0]
+
+	htlc := channeldb.HTLC{
+		RHash:         [32]byte(bytes.Repeat([]byte{0xaa}, 32)),
+		Amt:           lnwire.NewMSatFromSatoshis(12345),
+		RefundTimeout: 800_123,
+		OutputIndex:   2,
+		HtlcIndex:     7,
+		LogIndex:      9,
+	}
+	advanceRemoteCommit(t, channel, []channeldb.HTLC{htlc}, true)
+	advanceRemoteCommit(t, channel, nil, true)
+	advanceRemoteCommit(t, channel, []channeldb.HTLC{htlc}, false)
+	require.NoError(t, db.Close())
+
+	dump := &dumpChannelsCommand{
+		ChannelDB: fileName,
+		Deep:      true,
+		ChanPoint: channel.FundingOutpoint.String(),
+	}
+	require.NoError(t, dump.Execute(nil, nil))
+
+	h.assertLogContains(fmt.Sprintf(
+		`"chan_point": "%v"`, channel.FundingOutpoint,
+	))
+	h.assertLogContains(`"remote_commit_height": 2`)
+	h.assertLogContains(`"pending_remote_commitment": {`)
+	h.assertLogContains(fmt.Sprintf(`"payment_hash": "%x"`, htlc.RHash))
+	h.assertLogContains(`"refund_timeout": 800123`)
+	h.assertLogContains(`"amount": 12345`)
+	h.assertLogContains(`"amount_msat": 12345000`)
+	h.assertLogContains(`"state": "locked_in"`)
+
+	// The first two remote commitments were revoked, only the second one
+	// had an HTLC.
+	log := h.getLog()
+	require.Contains(t, log, `"commit_height": 0`)
+	require.Contains(t, log, `"commit_height": 1`)
+	require.Contains(t, log, `"our_output_index": 0`)
+	require.Contains(t, log, `"their_output_index": 1`)
+	require.Contains(t, log, `"htlc_index": 7`)
+
+	// Only a single channel is dumped.
+	require.Equal(t, 1, bytes.Count([]byte(log), []byte(`"chan_point"`)))
+	require.Equal(t, 2, bytes.Count([]byte(log), []byte(`"legacy"`)))
+
+	// The number of revocation log entries can be limited to the most
+	// recent ones.
+	revokedHeights := func(maxRevocations int) []string {
+		h.clearLog()
+		dump.MaxRevocations = maxRevocations
+		require.NoError(t, dump.Execute(nil, nil))
+
+		var heights []string
+		matches := revokedHeightRegex.FindAllStringSubmatch(
+			h.getLog(), -1,
+		)
+		for _, match := range matches {
+			heights = append(heights, match[1])
+		}
+
+		return heights
+	}
+	require.Equal(t, []string{"0", "1"}, revokedHeights(0))
+	require.Equal(t, []string{"1"}, revokedHeights(1))
+	require.Equal(t, []string{"0", "1"}, revokedHeights(5))
+
+	// Unknown channels and the channel point filter without the deep dump
+	// are rejected.
+	dump.ChanPoint = "00000000000000000000000000000000" +
+		"00000000000000000000000000000000:0"
+	require.ErrorContains(t, dump.Execute(nil, nil), "not found")
+
+	dump.Deep = false
+	require.ErrorContains(
+		t, dump.Execute(nil, nil), "can only be used with --deep",
+	)
+}
@@ -67,6 +67,10 @@ network graph (must be provided in the JSON format that the
 most convenient way to use this command but requires one to have a fully synced
 lnd node.
 
+The channels of a Core Lightning node can't be read from its emergency.recover
+file by this command. The clntobackup command creates the same kind of backup
+from it (or from the output of 'lightning-cli staticbackup').
+
 Any fake channel backup _needs_ to be used with the custom fork of lnd
 specifically built for this purpose: https://github.com/guggero/lnd/releases
 Also the debuglevel must be set to debug (lnd.conf, set 'debuglevel=debug') when
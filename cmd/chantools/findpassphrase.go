@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/btcsuite/btcwallet/snacl"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/spf13/cobra"
+)
+
+const (
+	passphraseRuleCase   = "case"
+	passphraseRuleDigits = "digits"
+	passphraseRuleLeet   = "leet"
+	passphraseRuleConcat = "concat"
+
+	// passphraseBatchSize is the number of candidates each thread tests
+	// before the progress is written to the checkpoint file.
+	passphraseBatchSize = 16
+)
+
+var (
+	// leetReplacer replaces letters with the digits that look similar.
+	leetReplacer = strings.NewReplacer(
+		"a", "4", "A", "4", "e", "3", "E", "3", "i", "1", "I", "1",
+		"o", "0", "O", "0", "s", "5", "S", "5", "t", "7", "T", "7",
+	)
+)
+
+// passphraseCheckpoint is the progress of a search as it is written to the
+// checkpoint file.
+type passphraseCheckpoint struct {
+	Search string `json:"search"`
+	Tested uint64 `json:"tested"`
+}
+
+// passphraseRules are the mutations that are applied to the words of the
+// word list.
+type passphraseRules struct {
+	changeCase bool
+	leet       bool
+	concat     bool
+	maxDigits  int
+}
+
+type findPassphraseCommand struct {
+	WalletDB   string
+	WordList   string
+	Rules      []string
+	MaxDigits  uint8
+	Checkpoint string
+	Threads    uint8
+
+	cmd *cobra.Command
+}
+
+func newFindPassphraseCommand() *cobra.Command {
+	cc := &findPassphraseCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "findpassphrase",
+		Short: "Try to find a forgotten aezeed passphrase or " +
+			"wallet.db password with a word list",
+		Long: `Tries all passphrases created from a word list until the
+one that decrypts the lnd aezeed (the cipher seed passphrase) or the
+wallet.db (the wallet password, if --walletdb is set) is found.
+
+Each line of the word list is a word. The following rules can be enabled to
+create more candidates from the words:
+ - case: try the word in lower case, upper case and capitalized
+ - leet: also try the word with letters replaced by similar digits (a=4, e=3,
+   i=1, o=0, s=5, t=7)
+ - digits: also try the word followed by all numbers with up to --maxdigits
+   digits
+ - concat: also try all combinations of two words
+
+Because both the aezeed and the wallet use scrypt, each try is slow on
+purpose. The progress is therefore written to a checkpoint file regularly. If
+the command is run again with the same seed or wallet, word list and rules, the
+search continues where it stopped.`,
+		Example: `chantools findpassphrase \
+	--wordlist words.txt \
+	--rules case,digits,leet \
+	--threads 8
+
+chantools findpassphrase \
+	--walletdb ~/.lnd/data/chain/bitcoin/mainnet/wallet.db \
+	--wordlist words.txt \
+	--rules concat`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.WalletDB, "walletdb", "", "lnd wallet.db file to find the "+
+			"password of; if empty, the passphrase of an aezeed "+
+			"that is entered is searched",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.WordList, "wordlist", "", "file with one word per line to "+
+			"create the passphrase candidates from",
+	)
+	cc.cmd.Flags().StringSliceVar(
+		&cc.Rules, "rules", nil, "comma separated list of rules to "+
+			"apply to the words; possible values: "+
+			passphraseRuleCase+", "+passphraseRuleDigits+", "+
+			passphraseRuleLeet+", "+passphraseRuleConcat,
+	)
+	cc.cmd.Flags().Uint8Var(
+		&cc.MaxDigits, "maxdigits", 2, "maximum number of digits to "+
+			"append to each word with the digits rule",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Checkpoint, "checkpoint", "", "file to write the progress "+
+			"to and resume from; defaults to "+
+			"findpassphrase.checkpoint in the results "+
+			"directory",
+	)
+	cc.cmd.Flags().Uint8Var(
+		&cc.Threads, "threads", 4, "number of parallel threads",
+	)
+
+	return cc.cmd
+}
+
+func (c *findPassphraseCommand) Execute(_ *cobra.Command,
+	_ []string) error {
+
+	if c.WordList == "" {
+		return errors.New("word list is required")
+	}
+	if c.Threads == 0 {
+		return errors.New("at least one thread is required")
+	}
+	rules := &passphraseRules{maxDigits: int(c.MaxDigits)}
+	hasDigits := false
+	for _, rule := range c.Rules {
+		switch rule {
+		case passphraseRuleCase:
+			rules.changeCase = true
+
+		case passphraseRuleDigits:
+			hasDigits = true
+
+		case passphraseRuleLeet:
+			rules.leet = true
+
+		case passphraseRuleConcat:
+			rules.concat = true
+
+		default:
+			return fmt.Errorf("unknown rule %s", rule)
+		}
+	}
+	if !hasDigits {
+		rules.maxDigits = 0
+	}
+
+	wordListBytes, err := os.ReadFile(c.WordList)
+	if err != nil {
+		return fmt.Errorf("error reading word list: %w", err)
+	}
+	var words []string
+	scanner := bufio.NewScanner(bytes.NewReader(wordListBytes))
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading word list: %w", err)
+	}
+
+	// The search is identified by the target, the words and the rules, so
+	// we only resume a search that would try the same candidates.
+	var (
+		check  func(passphrase []byte) (bool, error)
+		search = sha256.New()
+	)
+	switch {
+	case c.WalletDB != "":
+		db, err := lnd.OpenWalletDB(c.WalletDB, true)
+		if err != nil {
+			return err
+		}
+		rootKey, err := lnd.ReadEncryptedWalletRootKey(db)
+		_ = db.Close()
+		if err != nil {
+			return fmt.Errorf("error reading wallet: %w", err)
+		}
+
+		check = walletPassphraseCheck(rootKey)
+		search.Write(rootKey.MasterKeyParams())
+
+	default:
+		mnemonicWords, err := lnd.ReadMnemonicWords()
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+
+		mnemonic, err := checkedMnemonic(mnemonicWords)
+		if err != nil {
+			return err
+		}
+
+		check = aezeedPassphraseCheck(mnemonic)
+		search.Write([]byte(strings.Join(mnemonic[:], " ")))
+	}
+	search.Write(wordListBytes)
+	_, _ = fmt.Fprintf(search, "%v", *rules)
+
+	checkpointFile := c.Checkpoint
+	if checkpointFile == "" {
+		checkpointFile = fmt.Sprintf(
+			"%s/findpassphrase.checkpoint", ResultsDir,
+		)
+	}
+
+	passphrase, err := bruteForcePassphrase(
+		words, rules, check, checkpointFile,
+		hex.EncodeToString(search.Sum(nil)), c.Threads,
+	)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nFound passphrase: %s\n", passphrase)
+
+	return nil
+}
+
+// checkedMnemonic converts the words into an aezeed mnemonic and makes sure
+// it has a valid checksum, otherwise no passphrase can be found.
+func checkedMnemonic(words []string) (*aezeed.Mnemonic, error) {
+	if len(words) != aezeed.NumMnemonicWords {
+		return nil, fmt.Errorf("wrong cipher seed mnemonic length: "+
+			"got %d words, expecting %d words", len(words),
+			aezeed.NumMnemonicWords)
+	}
+
+	var (
+		mnemonic aezeed.Mnemonic
+		indexes  seedWords
+	)
+	for idx, word := range words {
+		wordIndex, ok := aezeed.ReverseWordMap[word]
+		if !ok {
+			return nil, fmt.Errorf("word %d (%s) is not on the "+
+				"word list, use the repairseed command", idx+1,
+				word)
+		}
+		mnemonic[idx] = word
+		indexes[idx] = uint16(wordIndex)
+	}
+	if !aezeedChecksumValid(&indexes) {
+		return nil, errors.New("seed checksum is invalid, use the " +
+			"repairseed command")
+	}
+
+	return &mnemonic, nil
+}
+
+// aezeedPassphraseCheck returns a function that checks whether a passphrase
+// decrypts the given mnemonic.
+func aezeedPassphraseCheck(
+	mnemonic *aezeed.Mnemonic) func([]byte) (bool, error) {
+
+	return func(passphrase []byte) (bool, error) {
+		_, err := mnemonic.ToCipherSeed(passphrase)
+		switch {
+		case errors.Is(err, aezeed.ErrInvalidPass):
+			return false, nil
+
+		case err != nil:
+			return false, err
+
+		default:
+			return true, nil
+		}
+	}
+}
+
+// walletPassphraseCheck returns a function that checks whether a passphrase
+// decrypts the root key of a wallet.
+func walletPassphraseCheck(
+	rootKey *lnd.EncryptedWalletRootKey) func([]byte) (bool, error) {
+
+	return func(passphrase []byte) (bool, error) {
+		_, err := rootKey.Decrypt(passphrase)
+		switch {
+		case errors.Is(err, snacl.ErrInvalidPassword):
+			return false, nil
+
+		case err != nil:
+			return false, err
+
+		default:
+			return true, nil
+		}
+	}
+}
+
+// generate calls the yield function with all candidates created from the
+// words, in a stable order. It stops if yield returns false.
+func (r *passphraseRules) generate(words []string, yield func(string) bool) {
+	suffixes := []string{""}
+	for numDigits := 1; numDigits <= r.maxDigits; numDigits++ {
+		limit := 1
+		for range numDigits {
+			limit *= 10
+		}
+		for number := range limit {
+			suffix := fmt.Sprintf("%0*d", numDigits, number)
+			suffixes = append(suffixes, suffix)
+		}
+	}
+
+	variants := func(base string) []string {
+		result := []string{base}
+		if r.changeCase {
+			result = append(
+				result, strings.ToLower(base),
+				strings.ToUpper(base), capitalize(base),
+			)
+		}
+		if r.leet {
+			for _, variant := range result {
+				result = append(
+					result, leetReplacer.Replace(variant),
+				)
+			}
+		}
+
+		// Remove duplicates while keeping the order.
+		seen := make(map[string]struct{}, len(result))
+		unique := result[:0]
+		for _, variant := range result {
+			if _, ok := seen[variant]; ok {
+				continue
+			}
+			seen[variant] = struct{}{}
+			unique = append(unique, variant)
+		}
+
+		return unique
+	}
+
+	tryBase := func(base string) bool {
+		for _, variant := range variants(base) {
+			for _, suffix := range suffixes {
+				if !yield(variant + suffix) {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	for _, word := range words {
+		if !tryBase(word) {
+			return
+		}
+	}
+	if !r.concat {
+		return
+	}
+	for _, first := range words {
+		for _, second := range words {
+			if !tryBase(first + second) {
+				return
+			}
+		}
+	}
+}
+
+// capitalize returns the word with the first letter in upper case and the
+// rest in lower case.
+func capitalize(word string) string {
+	runes := []rune(strings.ToLower(word))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+
+	return string(runes)
+}
+
+// bruteForcePassphrase tries all candidates in parallel and returns the first
+// one the check function accepts. The number of tested candidates is written
+// to the checkpoint file after each batch.
+func bruteForcePassphrase(words []string, rules *passphraseRules,
+	check func([]byte) (bool, error), checkpointFile, search string,
+	threads uint8) (string, error) {
+
+	checkpoint := &passphraseCheckpoint{Search: search}
+	checkpointBytes, err := os.ReadFile(checkpointFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+
+	case err != nil:
+		return "", fmt.Errorf("error reading checkpoint: %w", err)
+
+	default:
+		var existing passphraseCheckpoint
+		err := json.Unmarshal(checkpointBytes, &existing)
+		if err != nil {
+			return "", fmt.Errorf("error parsing checkpoint: %w",
+				err)
+		}
+		if existing.Search != search {
+			return "", fmt.Errorf("checkpoint file %s belongs to "+
+				"a different search, remove it or choose "+
+				"another file with --checkpoint",
+				checkpointFile)
+		}
+
+		log.Infof("Resuming search after %d tested candidates",
+			existing.Tested)
+		checkpoint.Tested = existing.Tested
+	}
+
+	var (
+		found    string
+		foundMtx sync.Mutex
+		skip     = checkpoint.Tested
+		batch    = make([]string, 0, int(threads)*passphraseBatchSize)
+		start    = time.Now()
+		lastLog  = time.Now()
+		checkErr error
+	)
+	runBatch := func() error {
+		var (
+			wg      sync.WaitGroup
+			errMtx  sync.Mutex
+			batchCh = make(chan string, len(batch))
+		)
+		for _, candidate := range batch {
+			batchCh <- candidate
+		}
+		close(batchCh)
+
+		for range threads {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for candidate := range batchCh {
+					ok, err := check([]byte(candidate))
+					if err != nil {
+						errMtx.Lock()
+						checkErr = err
+						errMtx.Unlock()
+
+						return
+					}
+					if ok {
+						foundMtx.Lock()
+						found = candidate
+						foundMtx.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if checkErr != nil {
+			return checkErr
+		}
+
+		checkpoint.Tested += uint64(len(batch))
+		batch = batch[:0]
+		if found != "" {
+			return nil
+		}
+
+		if time.Since(lastLog) > 10*time.Second {
+			log.Infof("Tested %s candidates, elapsed=%v",
+				format(int64(checkpoint.Tested)),
+				time.Since(start).Truncate(time.Second))
+			lastLog = time.Now()
+		}
+
+		return writeCheckpoint(checkpointFile, checkpoint)
+	}
+
+	var batchErr error
+	rules.generate(words, func(candidate string) bool {
+		if skip > 0 {
+			skip--
+
+			return true
+		}
+
+		batch = append(batch, candidate)
+		if len(batch) < cap(batch) {
+			return true
+		}
+
+		batchErr = runBatch()
+
+		return batchErr == nil && found == ""
+	})
+	if batchErr == nil && found == "" && len(batch) > 0 {
+		batchErr = runBatch()
+	}
+	if batchErr != nil {
+		return "", batchErr
+	}
+
+	log.Infof("Tested %s candidates in %v", format(int64(
+		checkpoint.Tested,
+	)), time.Since(start).Truncate(time.Second))
+
+	if found == "" {
+		return "", errors.New("passphrase not found, try more words " +
+			"or rules")
+	}
+
+	// The search is done, there's nothing to resume.
+	if err := os.Remove(checkpointFile); err != nil &&
+		!errors.Is(err, os.ErrNotExist) {
+
+		return "", err
+	}
+
+	return found, nil
+}
+
+// writeCheckpoint writes the progress of a search to the checkpoint file.
+func writeCheckpoint(fileName string, checkpoint *passphraseCheckpoint) error {
+	checkpointBytes, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, checkpointBytes, 0644)
+}
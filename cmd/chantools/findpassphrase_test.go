@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassphraseRules(t *testing.T) {
+	rules := &passphraseRules{
+		changeCase: true,
+		leet:       true,
+		concat:     true,
+		maxDigits:  1,
+	}
+
+	var candidates []string
+	rules.generate([]string{"Pass", "x"}, func(candidate string) bool {
+		candidates = append(candidates, candidate)
+
+		return true
+	})
+
+	// Pass, pass, PASS, P455 and p455, x and X and the 24 unique variants
+	// of the four combinations, each with 11 suffixes.
+	require.Equal(t, []string{"Pass", "Pass0", "Pass1"}, candidates[:3])
+	require.Contains(t, candidates, "p4559")
+	require.Contains(t, candidates, "X7")
+	require.Contains(t, candidates, "P455x3")
+	require.Contains(t, candidates, "xpass")
+	require.NotContains(t, candidates, "")
+	require.Len(t, candidates, (5+2+24)*11)
+
+	// The generation stops as soon as yield returns false.
+	var count int
+	rules.generate([]string{"Pass"}, func(string) bool {
+		count++
+
+		return count < 5
+	})
+	require.Equal(t, 5, count)
+}
+
+func TestFindPassphraseCheckpoint(t *testing.T) {
+	h := newHarness(t)
+
+	rules := &passphraseRules{maxDigits: 2}
+	words := []string{"foo", "bar"}
+	checkpointFile := h.tempFile("test.checkpoint")
+
+	var numChecks atomic.Int32
+	check := func(passphrase []byte) (bool, error) {
+		numChecks.Add(1)
+
+		return string(passphrase) == "bar42", nil
+	}
+
+	// A checkpoint of another search is refused.
+	err := writeCheckpoint(checkpointFile, &passphraseCheckpoint{
+		Search: "other",
+		Tested: 10,
+	})
+	require.NoError(t, err)
+	_, err = bruteForcePassphrase(
+		words, rules, check, checkpointFile, "search", 2,
+	)
+	require.ErrorContains(t, err, "belongs to a different search")
+
+	// The search resumes after the candidates that were already tested.
+	err = writeCheckpoint(checkpointFile, &passphraseCheckpoint{
+		Search: "search",
+		Tested: 111,
+	})
+	require.NoError(t, err)
+	found, err := bruteForcePassphrase(
+		words, rules, check, checkpointFile, "search", 2,
+	)
+	require.NoError(t, err)
+	require.Equal(t, "bar42", found)
+	require.Less(t, numChecks.Load(), int32(111))
+	h.assertLogContains("Resuming search after 111 tested candidates")
+
+	// A finished search doesn't leave a checkpoint behind.
+	_, err = os.Stat(checkpointFile)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// If the passphrase isn't found, the checkpoint contains all tested
+	// candidates.
+	_, err = bruteForcePassphrase(
+		words, &passphraseRules{}, check, checkpointFile, "search", 2,
+	)
+	require.ErrorContains(t, err, "passphrase not found")
+	h.assertLogContains("Tested 2 candidates")
+	_, err = os.Stat(checkpointFile)
+	require.NoError(t, err)
+}
+
+func TestFindPassphraseAezeed(t *testing.T) {
+	h := newHarness(t)
+	ResultsDir = h.tempDir
+
+	wordList := h.tempFile("words.txt")
+	require.NoError(t, os.WriteFile(wordList, []byte("foo\nTestnet\n"),
+		0644))
+
+	t.Setenv(lnd.MnemonicEnvName, seedAezeedWithPassphrase)
+	findPassphrase := &findPassphraseCommand{
+		WordList:  wordList,
+		Rules:     []string{passphraseRuleCase, passphraseRuleDigits},
+		MaxDigits: 1,
+		Threads:   4,
+	}
+	require.NoError(t, findPassphrase.Execute(nil, nil))
+
+	_, err := os.Stat(h.tempFile("findpassphrase.checkpoint"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFindPassphraseWallet(t *testing.T) {
+	h := newHarness(t)
+
+	wordList := h.tempFile("words.txt")
+	require.NoError(t, os.WriteFile(wordList, []byte(testPassPhrase),
+		0644))
+
+	findPassphrase := &findPassphraseCommand{
+		WalletDB:   h.testdataFile("wallet.db"),
+		WordList:   wordList,
+		Checkpoint: h.tempFile("wallet.checkpoint"),
+		Threads:    1,
+	}
+	require.NoError(t, findPassphrase.Execute(nil, nil))
+	h.assertLogContains("Tested 1 candidates")
+}
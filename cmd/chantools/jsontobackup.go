@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lightninglabs/chantools/dataformat"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+type jsonToBackupCommand struct {
+	JSONFile string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newJSONToBackupCommand() *cobra.Command {
+	cc := &jsonToBackupCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "jsontobackup",
+		Short: "Convert a JSON file created by backuptojson back " +
+			"into an encrypted lnd channel.backup file",
+		Long: `Reads a JSON file in the format created by the
+backuptojson command (see 'chantools backuptojson --help' for a description
+of the format) and writes its content as an lnd channel.backup file that is
+encrypted with the given seed.
+
+The resulting file can be used with 'lncli restorechanbackup' or
+'chantools scbforceclose'.`,
+		Example: `chantools jsontobackup \
+	--json_file results/backup-2024-01-01-12-00-00.json`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.JSONFile, "json_file", "", "the JSON file to convert "+
+			"into an encrypted channel.backup file",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "encrypting the backup")
+
+	return cc.cmd
+}
+
+func (c *jsonToBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Check that we have a JSON file.
+	if c.JSONFile == "" {
+		return errors.New("JSON file is required")
+	}
+	backupFile, err := dataformat.ReadBackupFile(c.JSONFile)
+	if err != nil {
+		return err
+	}
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	return jsonToBackup(backupFile, keyRing)
+}
+
+func jsonToBackup(backupFile *dataformat.BackupFile,
+	ring keychain.KeyRing) error {
+
+	multi, err := backupFile.Multi()
+	if err != nil {
+		return err
+	}
+
+	// Make sure the channels are for the chain we're operating on, a
+	// backup restored on the wrong network would just be ignored by lnd.
+	for _, single := range multi.StaticBackups {
+		if single.ChainHash != *chainParams.GenesisHash {
+			return fmt.Errorf("channel %v is for chain %v but "+
+				"we're on %s", single.FundingOutpoint,
+				single.ChainHash, chainParams.Name)
+		}
+	}
+
+	fileName := fmt.Sprintf("%s/backup-from-json-%s.backup", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing %d channels to %s", len(multi.StaticBackups),
+		fileName)
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = multi.PackToWriter(f, ring)
+	_ = f.Close()
+	if err != nil {
+		return fmt.Errorf("error packing backup: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+type mergeBackupCommand struct {
+	MultiFiles []string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newMergeBackupCommand() *cobra.Command {
+	cc := &mergeBackupCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "mergebackup",
+		Short: "Merge multiple lnd channel.backup files into a " +
+			"single one",
+		Long: `Decrypts multiple lnd channel.backup files (for example
+several generations of the backup file or copies from different machines),
+de-duplicates the channels by their funding outpoint and writes a single new
+channel.backup file that contains every channel exactly once.
+
+If the same channel is found in multiple files, the newest version of the
+backup entry is kept. An entry that contains the close transaction inputs
+(the latest commitment transaction and signature) is always preferred over one
+that doesn't, then the higher backup version and finally the entry from the
+most recently modified file wins. For each channel that is found more than
+once, the differences between the versions are shown.
+
+The resulting file is encrypted with the same seed and can be used with
+'lncli restorechanbackup' or 'chantools scbforceclose'.`,
+		Example: `chantools mergebackup \
+	--multi_file ~/old-node/channel.backup \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringSliceVar(
+		&cc.MultiFiles, "multi_file", nil, "lnd channel.backup file "+
+			"to merge; can be specified multiple times or as a "+
+			"comma separated list",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "decrypting the backups")
+
+	return cc.cmd
+}
+
+func (c *mergeBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Check that we have at least two backup files.
+	if len(c.MultiFiles) < 2 {
+		return errors.New("at least two backup files are required")
+	}
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	sources := make([]*backupSource, 0, len(c.MultiFiles))
+	for _, fileName := range c.MultiFiles {
+		source, err := readBackupSource(fileName, keyRing)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Read %d channels from %s (last modified %v)",
+			len(source.singles), fileName,
+			source.modTime.Format(time.RFC3339))
+		sources = append(sources, source)
+	}
+
+	merged := mergeChannelBackups(sources)
+	logMergedChannels(merged, len(sources))
+
+	multi := chanbackup.Multi{
+		Version:       chanbackup.DefaultMultiVersion,
+		StaticBackups: make([]chanbackup.Single, len(merged)),
+	}
+	for idx, channel := range merged {
+		multi.StaticBackups[idx] = channel.chosen.single
+	}
+
+	fileName := fmt.Sprintf("%s/backup-merged-%s.backup", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing %d channels to %s", len(multi.StaticBackups),
+		fileName)
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = multi.PackToWriter(f, keyRing)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// backupSource is a decrypted channel.backup file.
+type backupSource struct {
+	fileName string
+	modTime  time.Time
+	singles  []chanbackup.Single
+}
+
+// backupCandidate is a single channel backup entry together with the file it
+// was read from.
+type backupCandidate struct {
+	single chanbackup.Single
+	source *backupSource
+}
+
+// mergedChannel holds all versions of a channel's backup entry and the one
+// that was chosen to be written to the merged file.
+type mergedChannel struct {
+	chosen     backupCandidate
+	candidates []backupCandidate
+}
+
+// readBackupSource decrypts the given multi backup file.
+func readBackupSource(fileName string,
+	keyRing keychain.KeyRing) (*backupSource, error) {
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup file %s: %w",
+			fileName, err)
+	}
+
+	multiFile := chanbackup.NewMultiFile(fileName, noBackupArchive)
+	multi, err := multiFile.ExtractMulti(keyRing)
+	if err != nil {
+		return nil, fmt.Errorf("could not extract multi file %s: %w",
+			fileName, err)
+	}
+
+	return &backupSource{
+		fileName: fileName,
+		modTime:  info.ModTime(),
+		singles:  multi.StaticBackups,
+	}, nil
+}
+
+// mergeChannelBackups de-duplicates the channels of all sources by their
+// funding outpoint and picks the newest version of each. The result is sorted
+// by the order in which the channels were first encountered.
+func mergeChannelBackups(sources []*backupSource) []*mergedChannel {
+	var (
+		merged  []*mergedChannel
+		indexes = make(map[string]int)
+	)
+	for _, source := range sources {
+		for _, single := range source.singles {
+			candidate := backupCandidate{
+				single: single,
+				source: source,
+			}
+
+			key := single.FundingOutpoint.String()
+			idx, ok := indexes[key]
+			if !ok {
+				indexes[key] = len(merged)
+				merged = append(merged, &mergedChannel{
+					chosen: candidate,
+					candidates: []backupCandidate{
+						candidate,
+					},
+				})
+
+				continue
+			}
+
+			channel := merged[idx]
+			channel.candidates = append(
+				channel.candidates, candidate,
+			)
+			if isNewerBackup(candidate, channel.chosen) {
+				channel.chosen = candidate
+			}
+		}
+	}
+
+	return merged
+}
+
+// isNewerBackup returns true if the candidate a is a more recent version of a
+// channel's backup entry than b.
+func isNewerBackup(a, b backupCandidate) bool {
+	// An entry that allows us to force close the channel is always the
+	// most valuable one. If both have one, the higher commitment wins.
+	aHeight, aHasClose := closeTxHeight(a.single)
+	bHeight, bHasClose := closeTxHeight(b.single)
+	switch {
+	case aHasClose && !bHasClose:
+		return true
+
+	case !aHasClose && bHasClose:
+		return false
+
+	case aHeight != bHeight:
+		return aHeight > bHeight
+	}
+
+	if a.single.Version != b.single.Version {
+		return a.single.Version > b.single.Version
+	}
+
+	return a.source.modTime.After(b.source.modTime)
+}
+
+// closeTxHeight returns the commitment height of the close transaction inputs
+// of the single and whether it has any.
+func closeTxHeight(single chanbackup.Single) (uint64, bool) {
+	var (
+		height uint64
+		hasTx  bool
+	)
+	single.CloseTxInputs.WhenSome(func(inputs chanbackup.CloseTxInputs) {
+		height = inputs.CommitHeight
+		hasTx = true
+	})
+
+	return height, hasTx
+}
+
+// logMergedChannels logs which version of each channel was chosen and how the
+// versions found in the different files differ from it.
+func logMergedChannels(merged []*mergedChannel, numSources int) {
+	for _, channel := range merged {
+		chosen := channel.chosen
+		chanPoint := chosen.single.FundingOutpoint
+
+		if len(channel.candidates) < numSources {
+			log.Infof("Channel %v: only found in %s", chanPoint,
+				candidateFiles(channel.candidates))
+		}
+
+		if len(channel.candidates) == 1 {
+			continue
+		}
+
+		log.Infof("Channel %v: found %d times, using version from %s",
+			chanPoint, len(channel.candidates),
+			chosen.source.fileName)
+		for _, candidate := range channel.candidates {
+			if candidate.source == chosen.source {
+				continue
+			}
+
+			diff := diffSingles(candidate.single, chosen.single)
+			if len(diff) == 0 {
+				log.Infof("    %s: identical",
+					candidate.source.fileName)
+
+				continue
+			}
+
+			log.Infof("    %s: differs in %d field(s)",
+				candidate.source.fileName, len(diff))
+			for _, line := range diff {
+				log.Infof("        %s", line)
+			}
+		}
+	}
+}
+
+// candidateFiles returns a comma separated list of the files the candidates
+// were read from.
+func candidateFiles(candidates []backupCandidate) string {
+	fileNames := make([]string, len(candidates))
+	for idx, candidate := range candidates {
+		fileNames[idx] = candidate.source.fileName
+	}
+
+	return strings.Join(fileNames, ", ")
+}
+
+// diffSingles returns a human-readable list of the fields that differ between
+// the old and new version of a channel's backup entry.
+func diffSingles(oldSingle, newSingle chanbackup.Single) []string {
+	var diff []string
+	addDiff := func(field string, oldValue, newValue any) {
+		o, n := fmt.Sprintf("%v", oldValue), fmt.Sprintf("%v", newValue)
+		if o != n {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", field,
+				o, n))
+		}
+	}
+
+	addDiff("Version", oldSingle.Version, newSingle.Version)
+	addDiff("IsInitiator", oldSingle.IsInitiator, newSingle.IsInitiator)
+	addDiff("ChainHash", oldSingle.ChainHash, newSingle.ChainHash)
+	addDiff("ShortChannelID", oldSingle.ShortChannelID,
+		newSingle.ShortChannelID)
+	addDiff("RemoteNodePub", pubKeyString(oldSingle.RemoteNodePub),
+		pubKeyString(newSingle.RemoteNodePub))
+	addDiff("Addresses", addrsString(oldSingle), addrsString(newSingle))
+	addDiff("Capacity", oldSingle.Capacity, newSingle.Capacity)
+	addDiff("LeaseExpiry", oldSingle.LeaseExpiry, newSingle.LeaseExpiry)
+	addDiff("ShaChainRootDesc", keyDescString(oldSingle.ShaChainRootDesc),
+		keyDescString(newSingle.ShaChainRootDesc))
+
+	chanCfgs := []struct {
+		name           string
+		oldCfg, newCfg channeldb.ChannelConfig
+	}{
+		{"LocalChanCfg", oldSingle.LocalChanCfg,
+			newSingle.LocalChanCfg},
+		{"RemoteChanCfg", oldSingle.RemoteChanCfg,
+			newSingle.RemoteChanCfg},
+	}
+	for _, cfg := range chanCfgs {
+		addDiff(cfg.name+".ChannelStateBounds",
+			cfg.oldCfg.ChannelStateBounds,
+			cfg.newCfg.ChannelStateBounds)
+		addDiff(cfg.name+".CommitmentParams",
+			cfg.oldCfg.CommitmentParams,
+			cfg.newCfg.CommitmentParams)
+
+		keys := []struct {
+			name           string
+			oldKey, newKey keychain.KeyDescriptor
+		}{
+			{"MultiSigKey", cfg.oldCfg.MultiSigKey,
+				cfg.newCfg.MultiSigKey},
+			{"RevocationBasePoint", cfg.oldCfg.RevocationBasePoint,
+				cfg.newCfg.RevocationBasePoint},
+			{"PaymentBasePoint", cfg.oldCfg.PaymentBasePoint,
+				cfg.newCfg.PaymentBasePoint},
+			{"DelayBasePoint", cfg.oldCfg.DelayBasePoint,
+				cfg.newCfg.DelayBasePoint},
+			{"HtlcBasePoint", cfg.oldCfg.HtlcBasePoint,
+				cfg.newCfg.HtlcBasePoint},
+		}
+		for _, key := range keys {
+			addDiff(cfg.name+"."+key.name,
+				keyDescString(key.oldKey),
+				keyDescString(key.newKey))
+		}
+	}
+
+	addDiff("CloseTxInputs", closeTxInputsString(oldSingle),
+		closeTxInputsString(newSingle))
+
+	return diff
+}
+
+// addrsString returns the sorted list of a single's addresses as a string.
+func addrsString(single chanbackup.Single) string {
+	addrs := make([]string, len(single.Addresses))
+	for idx, addr := range single.Addresses {
+		addrs[idx] = addr.String()
+	}
+	sort.Strings(addrs)
+
+	return "[" + strings.Join(addrs, " ") + "]"
+}
+
+// keyDescString returns the key locator and public key of a key descriptor as
+// a string.
+func keyDescString(desc keychain.KeyDescriptor) string {
+	return fmt.Sprintf("%d/%d:%s", desc.Family, desc.Index,
+		pubKeyString(desc.PubKey))
+}
+
+// closeTxInputsString returns a short description of a single's close
+// transaction inputs.
+func closeTxInputsString(single chanbackup.Single) string {
+	desc := "none"
+	single.CloseTxInputs.WhenSome(func(inputs chanbackup.CloseTxInputs) {
+		desc = fmt.Sprintf("commit_height=%d", inputs.CommitHeight)
+		if inputs.CommitTx != nil {
+			desc += fmt.Sprintf(" commit_tx=%v",
+				inputs.CommitTx.TxHash())
+		}
+	})
+
+	return desc
+}
+
+// pubKeyString returns the hex encoded compressed public key or "nil".
+func pubKeyString(pubKey *btcec.PublicKey) string {
+	if pubKey == nil {
+		return "nil"
+	}
+
+	return hex.EncodeToString(pubKey.SerializeCompressed())
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSingle creates a channel backup entry with random keys for the given
+// funding outpoint.
+func newTestSingle(t *testing.T, chanPoint wire.OutPoint) chanbackup.Single {
+	t.Helper()
+
+	randomKey := func(family keychain.KeyFamily) keychain.KeyDescriptor {
+		priv, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+
+		return keychain.KeyDescriptor{
+			KeyLocator: keychain.KeyLocator{Family: family},
+			PubKey:     priv.PubKey(),
+		}
+	}
+
+	single := chanbackup.Single{
+		Version:         chanbackup.AnchorsCommitVersion,
+		IsInitiator:     true,
+		ChainHash:       *chainParams.GenesisHash,
+		FundingOutpoint: chanPoint,
+		ShortChannelID:  lnwire.NewShortChanIDFromInt(123),
+		RemoteNodePub:   randomKey(0).PubKey,
+		Addresses: []net.Addr{&net.TCPAddr{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 9735,
+		}},
+		Capacity:         1_000_000,
+		ShaChainRootDesc: randomKey(keychain.KeyFamilyRevocationRoot),
+	}
+	single.LocalChanCfg.MultiSigKey = randomKey(keychain.KeyFamilyMultiSig)
+	single.RemoteChanCfg.MultiSigKey = randomKey(0)
+	single.RemoteChanCfg.RevocationBasePoint = randomKey(0)
+	single.RemoteChanCfg.PaymentBasePoint = randomKey(0)
+	single.RemoteChanCfg.DelayBasePoint = randomKey(0)
+	single.RemoteChanCfg.HtlcBasePoint = randomKey(0)
+
+	return single
+}
+
+func TestMergeBackup(t *testing.T) {
+	h := newHarness(t)
+	ResultsDir = h.tempDir
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	chanPoint1 := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+	chanPoint2 := wire.OutPoint{Hash: chainhash.Hash{2}, Index: 1}
+	chanPoint3 := wire.OutPoint{Hash: chainhash.Hash{3}, Index: 2}
+
+	// The old file has the close transaction inputs for the first channel
+	// and a second channel that only exists there.
+	withCloseTx := newTestSingle(t, chanPoint1)
+	commitTx := wire.NewMsgTx(2)
+	commitTx.AddTxIn(wire.NewTxIn(&chanPoint1, nil, nil))
+	commitTx.AddTxOut(wire.NewTxOut(990_000, []byte{0x00, 0x14}))
+	withCloseTx.CloseTxInputs = fn.Some(chanbackup.CloseTxInputs{
+		CommitTx:  commitTx,
+		CommitSig: []byte{1, 2, 3},
+	})
+	oldSecond := newTestSingle(t, chanPoint2)
+
+	// The new file has the first channel without close transaction inputs
+	// but with a new address, a newer version of the second channel and
+	// a third channel.
+	withoutCloseTx := withCloseTx
+	withoutCloseTx.CloseTxInputs = fn.None[chanbackup.CloseTxInputs]()
+	withoutCloseTx.Addresses = []net.Addr{&net.TCPAddr{
+		IP:   net.ParseIP("10.0.0.1"),
+		Port: 9736,
+	}}
+	newSecond := oldSecond
+	newSecond.Version = chanbackup.AnchorsZeroFeeHtlcTxCommitVersion
+	third := newTestSingle(t, chanPoint3)
+
+	writeMulti := func(name string, modTime time.Time,
+		singles ...chanbackup.Single) string {
+
+		fileName := h.tempFile(name)
+		f, err := os.Create(fileName)
+		require.NoError(t, err)
+		multi := chanbackup.Multi{StaticBackups: singles}
+		require.NoError(t, multi.PackToWriter(f, keyRing))
+		require.NoError(t, f.Close())
+		require.NoError(t, os.Chtimes(fileName, modTime, modTime))
+
+		return fileName
+	}
+	now := time.Now()
+	oldFile := writeMulti(
+		"old.backup", now.Add(-time.Hour), withCloseTx, oldSecond,
+	)
+	newFile := writeMulti(
+		"new.backup", now, withoutCloseTx, newSecond, third,
+	)
+
+	mergeBackup := &mergeBackupCommand{
+		MultiFiles: []string{oldFile, newFile},
+		rootKey:    &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, mergeBackup.Execute(nil, nil))
+
+	h.assertLogContains("Channel " + chanPoint1.String() + ": found 2 " +
+		"times, using version from " + oldFile)
+	h.assertLogContains("Addresses: [10.0.0.1:9736] -> [127.0.0.1:9735]")
+	h.assertLogContains("CloseTxInputs: none -> commit_height=0 " +
+		"commit_tx=" + commitTx.TxHash().String())
+	h.assertLogContains("Channel " + chanPoint2.String() + ": found 2 " +
+		"times, using version from " + newFile)
+	h.assertLogContains("Channel " + chanPoint3.String() + ": only " +
+		"found in " + newFile)
+
+	// The merged file contains every channel exactly once.
+	merged, err := filepath.Glob(h.tempFile("backup-merged-*.backup"))
+	require.NoError(t, err)
+	require.Len(t, merged, 1)
+
+	multiFile := chanbackup.NewMultiFile(merged[0], noBackupArchive)
+	multi, err := multiFile.ExtractMulti(keyRing)
+	require.NoError(t, err)
+	require.Len(t, multi.StaticBackups, 3)
+
+	require.Equal(t, chanPoint1, multi.StaticBackups[0].FundingOutpoint)
+	require.True(t, multi.StaticBackups[0].CloseTxInputs.IsSome())
+	require.Equal(t, chanPoint2, multi.StaticBackups[1].FundingOutpoint)
+	require.EqualValues(
+		t, chanbackup.AnchorsZeroFeeHtlcTxCommitVersion,
+		multi.StaticBackups[1].Version,
+	)
+	require.Equal(t, chanPoint3, multi.StaticBackups[2].FundingOutpoint)
+}
@@ -25,8 +25,9 @@ an old database file to be compatible with the current version that chantools
 needs to read the database content.
 
 CAUTION: Running this command will make it impossible to use the channel DB
-with an older version of lnd. Downgrading is not possible and you'll need to
-run lnd ` + lndVersion + ` or later after using this command!'`,
+with an older version of lnd. Only some of the migrations can be reverted with
+the downgradedb command, so you'll most likely need to run
+lnd ` + lndVersion + ` or later after using this command!'`,
 		Example: `chantools migratedb \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db`,
 		RunE: cc.Execute,
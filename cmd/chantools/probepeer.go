@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/hasura/go-graphql-client"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultProbeTimeout = 30 * time.Second
+
+	// probePingBytes is the number of bytes we ask the peer to respond
+	// with in the pong message.
+	probePingBytes = 16
+
+	addrNetworkIPv4    = "ipv4"
+	addrNetworkIPv6    = "ipv6"
+	addrNetworkOnionV2 = "onion_v2"
+	addrNetworkOnionV3 = "onion_v3"
+	addrNetworkDNS     = "dns"
+)
+
+type gqGetNodeAddressesQuery struct {
+	GetNode struct {
+		GraphInfo struct {
+			Node struct {
+				Addresses []*gqAddress `graphql:"addresses"`
+			} `graphql:"node"`
+		} `graphql:"graph_info"`
+	} `graphql:"getNode(pubkey: $pubkey)"`
+}
+
+type probePeerCommand struct {
+	Peer     string
+	Peers    string
+	TorProxy string
+	Timeout  time.Duration
+
+	cmd *cobra.Command
+}
+
+func newProbePeerCommand() *cobra.Command {
+	cc := &probePeerCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "probepeer",
+		Short: "Check whether a Lightning Network peer is reachable " +
+			"and what it announces",
+		Long: `Connects to every address of the given peer(s), completes
+the brontide handshake and exchanges init messages, using the same feature bits
+lnd would use. For each address the command reports whether the peer was
+reachable, the feature bits and networks (chain hashes) it announced, the
+latency of the handshake and of a ping and any error or warning message the
+peer sent.
+
+If a peer is given without an address, its advertised addresses are looked up
+from the Amboss API. Tor addresses can only be probed if --torproxy is set.
+
+The probe uses a random, throw-away node identity, so no seed is required.
+
+The results are written to a JSON file in the results directory.`,
+		Example: `chantools probepeer \
+	--peer 03abce...@xx.yy.zz.aa:9735
+
+chantools probepeer --peers peers.txt --torproxy 127.0.0.1:9050`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Peer, "peer", "", "remote peer to probe "+
+			"(<pubkey>[@<host>[:<port>]]); if no address is "+
+			"given, all advertised addresses are probed",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Peers, "peers", "", "comma separated list of remote "+
+			"peers to probe, in the same format as --peer; can "+
+			"also be a file name to a file that contains the "+
+			"peers, one per line",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.TorProxy, "torproxy", "", "SOCKS5 proxy to use for Tor "+
+			"connections (to .onion addresses)",
+	)
+	cc.cmd.Flags().DurationVar(
+		&cc.Timeout, "timeout", defaultProbeTimeout, "maximum time "+
+			"to spend on probing a single address",
+	)
+
+	return cc.cmd
+}
+
+// probeAddrResult is the outcome of probing a single address of a peer.
+type probeAddrResult struct {
+	Address                 string   `json:"address"`
+	Network                 string   `json:"network"`
+	Reachable               bool     `json:"reachable"`
+	InitReceived            bool     `json:"init_received"`
+	HandshakeLatencyMs      int64    `json:"handshake_latency_ms,omitempty"`
+	PingLatencyMs           int64    `json:"ping_latency_ms,omitempty"`
+	Features                []string `json:"features,omitempty"`
+	UnknownRequiredFeatures []string `json:"unknown_required_features,omitempty"`
+	Chains                  []string `json:"chains,omitempty"`
+	PeerError               string   `json:"peer_error,omitempty"`
+	PeerWarning             string   `json:"peer_warning,omitempty"`
+	Error                   string   `json:"error,omitempty"`
+}
+
+// probeResult is the outcome of probing all addresses of a peer.
+type probeResult struct {
+	PubKey    string             `json:"pubkey"`
+	Addresses []*probeAddrResult `json:"addresses"`
+	Error     string             `json:"error,omitempty"`
+}
+
+func (c *probePeerCommand) Execute(_ *cobra.Command, _ []string) error {
+	var peers []string
+	switch {
+	case c.Peer != "":
+		peers = []string{c.Peer}
+
+	case c.Peers != "":
+		var err error
+		peers, err = listOrFile(c.Peers)
+		if err != nil {
+			return fmt.Errorf("error reading peers: %w", err)
+		}
+
+	default:
+		return errors.New("either --peer or --peers must be specified")
+	}
+
+	identityPriv, err := btcec.NewPrivateKey()
+	if err != nil {
+		return fmt.Errorf("error generating identity key: %w", err)
+	}
+	identity := &keychain.PrivKeyECDH{
+		PrivKey: identityPriv,
+	}
+
+	var client *graphql.Client
+	results := make([]*probeResult, 0, len(peers))
+	for _, peer := range peers {
+		pubKey, host, _ := strings.Cut(strings.TrimSpace(peer), "@")
+		result := &probeResult{
+			PubKey: pubKey,
+		}
+		results = append(results, result)
+
+		if _, err := pubKeyFromHex(pubKey); err != nil {
+			result.Error = fmt.Sprintf("invalid pubkey: %v", err)
+			log.Errorf("Skipping peer %s: %s", peer, result.Error)
+			continue
+		}
+
+		addrs := []string{host}
+		if host == "" {
+			if client == nil {
+				client = graphql.NewClient(
+					"https://api.amboss.space/graphql", nil,
+				)
+			}
+
+			addrs, err = fetchNodeAddresses(client, pubKey)
+			if err != nil {
+				result.Error = fmt.Sprintf("error fetching "+
+					"addresses: %v", err)
+				log.Errorf("Skipping peer %s: %s", pubKey,
+					result.Error)
+				continue
+			}
+		}
+
+		if len(addrs) == 0 {
+			result.Error = "peer has no advertised addresses"
+			log.Errorf("Skipping peer %s: %s", pubKey, result.Error)
+			continue
+		}
+
+		for _, addr := range addrs {
+			log.Infof("Probing peer %s at %s", pubKey, addr)
+			addrResult := probeAddress(
+				identity, pubKey, addr, c.TorProxy, c.Timeout,
+			)
+			logProbeResult(pubKey, addrResult)
+
+			result.Addresses = append(
+				result.Addresses, addrResult,
+			)
+		}
+	}
+
+	resultBytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding results: %w", err)
+	}
+
+	fileName := fmt.Sprintf("%s/probepeer-%s.json", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing results to %s", fileName)
+	return os.WriteFile(fileName, resultBytes, 0644)
+}
+
+// probeAddress connects to the peer at the given address, exchanges init
+// messages and pings the peer.
+func probeAddress(identity keychain.SingleKeyECDH, pubKey, addr,
+	torProxy string, timeout time.Duration) *probeAddrResult {
+
+	result := &probeAddrResult{
+		Address: addr,
+		Network: addrNetwork(addr),
+	}
+
+	isOnion := result.Network == addrNetworkOnionV2 ||
+		result.Network == addrNetworkOnionV3
+	if isOnion && torProxy == "" {
+		result.Error = "Tor address requires --torproxy"
+		return result
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	conn, err := dialPeer(pubKey+"@"+addr, torProxy, identity, timeout)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Reachable = true
+
+	session := lnd.NewSession(conn)
+	defer func() {
+		_ = session.Close()
+	}()
+
+	if err := session.SetDeadline(deadline); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	globalFeatures, localFeatures, err := lnd.DefaultInitFeatures()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if err := session.Init(globalFeatures, localFeatures); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.InitReceived = true
+	result.HandshakeLatencyMs = time.Since(start).Milliseconds()
+
+	features, err := session.RemoteFeatures()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Features = featureNames(features, features.Features())
+
+	unknownRequired := make(map[lnwire.FeatureBit]struct{})
+	for _, bit := range features.UnknownRequiredFeatures() {
+		unknownRequired[bit] = struct{}{}
+	}
+	result.UnknownRequiredFeatures = featureNames(
+		features, unknownRequired,
+	)
+
+	chains, err := session.RemoteChains()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, chain := range chains {
+		result.Chains = append(result.Chains, chainName(chain))
+	}
+
+	// A ping tells us the round trip time and gives the peer the chance
+	// to complain about anything it doesn't like about us.
+	pingStart := time.Now()
+	if err := session.Send(lnwire.NewPing(probePingBytes)); err != nil {
+		result.Error = fmt.Sprintf("error sending ping: %v", err)
+		return result
+	}
+	err = receiveUntil(session, deadline, func(msg lnwire.Message) bool {
+		switch m := msg.(type) {
+		case *lnwire.Pong:
+			result.PingLatencyMs = time.Since(
+				pingStart,
+			).Milliseconds()
+			return true
+
+		case *lnwire.Error:
+			result.PeerError = m.Error()
+			return true
+
+		case *lnwire.Warning:
+			result.PeerWarning = m.Warning()
+		}
+
+		return false
+	})
+	if err != nil {
+		result.Error = fmt.Sprintf("error waiting for pong: %v", err)
+	}
+
+	return result
+}
+
+// logProbeResult prints a human readable summary of the probe result.
+func logProbeResult(pubKey string, result *probeAddrResult) {
+	switch {
+	case !result.Reachable:
+		log.Infof("Peer %s at %s (%s) is not reachable: %s", pubKey,
+			result.Address, result.Network, result.Error)
+		return
+
+	case !result.InitReceived:
+		log.Infof("Peer %s at %s (%s) is reachable but init failed: %s",
+			pubKey, result.Address, result.Network, result.Error)
+		return
+	}
+
+	log.Infof("Peer %s at %s (%s) is reachable, handshake took %d ms, "+
+		"ping took %d ms", pubKey, result.Address, result.Network,
+		result.HandshakeLatencyMs, result.PingLatencyMs)
+	log.Infof("  Features: %s", strings.Join(result.Features, ", "))
+	if len(result.UnknownRequiredFeatures) > 0 {
+		log.Infof("  Unknown required features: %s",
+			strings.Join(result.UnknownRequiredFeatures, ", "))
+	}
+	if len(result.Chains) > 0 {
+		log.Infof("  Chains: %s", strings.Join(result.Chains, ", "))
+	}
+	if result.PeerError != "" {
+		log.Infof("  Peer sent error: %s", result.PeerError)
+	}
+	if result.PeerWarning != "" {
+		log.Infof("  Peer sent warning: %s", result.PeerWarning)
+	}
+	if result.Error != "" {
+		log.Infof("  Error: %s", result.Error)
+	}
+}
+
+// addrNetwork returns the type of network of the given peer address.
+func addrNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+	}
+
+	if strings.HasSuffix(host, ".onion") {
+		// A v3 onion address consists of 56 base32 characters.
+		if len(strings.TrimSuffix(host, ".onion")) == 56 {
+			return addrNetworkOnionV3
+		}
+
+		return addrNetworkOnionV2
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return addrNetworkDNS
+
+	case ip.To4() != nil:
+		return addrNetworkIPv4
+
+	default:
+		return addrNetworkIPv6
+	}
+}
+
+// featureNames returns the names of the given feature bits, ordered by bit.
+func featureNames(features *lnwire.FeatureVector,
+	bits map[lnwire.FeatureBit]struct{}) []string {
+
+	sortedBits := make([]lnwire.FeatureBit, 0, len(bits))
+	for bit := range bits {
+		sortedBits = append(sortedBits, bit)
+	}
+	sort.Slice(sortedBits, func(i, j int) bool {
+		return sortedBits[i] < sortedBits[j]
+	})
+
+	names := make([]string, 0, len(sortedBits))
+	for _, bit := range sortedBits {
+		names = append(
+			names, fmt.Sprintf("%s(%d)", features.Name(bit), bit),
+		)
+	}
+
+	return names
+}
+
+// chainName returns the name of the chain with the given genesis hash.
+func chainName(genesisHash chainhash.Hash) string {
+	for _, params := range []*chaincfg.Params{
+		&chaincfg.MainNetParams, &chaincfg.TestNet3Params,
+		&chaincfg.TestNet4Params, &chaincfg.SigNetParams,
+		&chaincfg.RegressionNetParams,
+	} {
+		if params.GenesisHash.IsEqual(&genesisHash) {
+			return params.Name
+		}
+	}
+
+	return genesisHash.String()
+}
+
+// fetchNodeAddresses returns the advertised addresses of the given node from
+// the Amboss API.
+func fetchNodeAddresses(client *graphql.Client, pubkey string) ([]string,
+	error) {
+
+	variables := map[string]any{
+		"pubkey": pubkey,
+	}
+
+	for {
+		var query gqGetNodeAddressesQuery
+		err := client.Query(context.Background(), &query, variables)
+		if err != nil {
+			if isServerErr(err) {
+				time.Sleep(1 * time.Second)
+				continue
+			}
+
+			return nil, err
+		}
+
+		addresses := query.GetNode.GraphInfo.Node.Addresses
+		result := make([]string, 0, len(addresses))
+		for _, addr := range addresses {
+			result = append(result, addr.Address)
+		}
+
+		return result, nil
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/brontide"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrNetwork(t *testing.T) {
+	onionV3 := strings.Repeat("a", 56) + ".onion"
+
+	testCases := map[string]string{
+		"1.2.3.4:9735":           addrNetworkIPv4,
+		"[2001:db8::1]:9735":     addrNetworkIPv6,
+		"2001:db8::1":            addrNetworkIPv6,
+		onionV3 + ":9735":        addrNetworkOnionV3,
+		"abcdefghijklmnop.onion": addrNetworkOnionV2,
+		"node.example.com:9735":  addrNetworkDNS,
+	}
+	for addr, expected := range testCases {
+		require.Equal(t, expected, addrNetwork(addr), addr)
+	}
+}
+
+func TestProbeAddress(t *testing.T) {
+	_ = newHarness(t)
+
+	ours, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	theirs, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	acceptAll := func(*btcec.PublicKey) (bool, error) {
+		return true, nil
+	}
+	listener, err := brontide.NewListener(
+		&keychain.PrivKeyECDH{PrivKey: theirs}, "127.0.0.1:0",
+		acceptAll,
+	)
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	// The peer requires an unknown feature, is only interested in testnet
+	// and warns us after answering our ping.
+	peerErr := make(chan error, 1)
+	go func() {
+		conn, err := acceptWithTimeout(listener, 10*time.Second)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+		session := lnd.NewSession(conn)
+		defer func() {
+			_ = session.Close()
+		}()
+
+		init := lnwire.NewInitMessage(
+			lnwire.NewRawFeatureVector(), lnwire.NewRawFeatureVector(
+				lnwire.DataLossProtectRequired, 100,
+			),
+		)
+		networks := chaincfg.TestNet3Params.GenesisHash.CloneBytes()
+		networksRecord := tlv.MakePrimitiveRecord(1, &networks)
+		err = init.ExtraData.PackRecords(&networksRecord)
+		if err != nil {
+			peerErr <- err
+			return
+		}
+		if err := session.Send(init); err != nil {
+			peerErr <- err
+			return
+		}
+		if _, err := session.Receive(); err != nil {
+			peerErr <- err
+			return
+		}
+
+		// The ping is answered automatically while we wait for the
+		// connection to be closed.
+		err = session.Send(&lnwire.Warning{
+			Data: []byte("you look suspicious"),
+		})
+		if err != nil {
+			peerErr <- err
+			return
+		}
+		_, _ = session.Receive()
+		peerErr <- nil
+	}()
+
+	pubKey := hex.EncodeToString(theirs.PubKey().SerializeCompressed())
+	result := probeAddress(
+		&keychain.PrivKeyECDH{PrivKey: ours}, pubKey,
+		listener.Addr().String(), "", 10*time.Second,
+	)
+	require.NoError(t, <-peerErr)
+
+	require.Empty(t, result.Error)
+	require.Equal(t, addrNetworkIPv4, result.Network)
+	require.True(t, result.Reachable)
+	require.True(t, result.InitReceived)
+	require.Equal(t, []string{
+		"data-loss-protect(0)", "unknown(100)",
+	}, result.Features)
+	require.Equal(t, []string{"unknown(100)"},
+		result.UnknownRequiredFeatures)
+	require.Equal(t, []string{chaincfg.TestNet3Params.Name}, result.Chains)
+	require.Contains(t, result.PeerWarning, "you look suspicious")
+
+	// Tor addresses can't be probed without a proxy.
+	result = probeAddress(
+		&keychain.PrivKeyECDH{PrivKey: ours}, pubKey,
+		strings.Repeat("a", 56)+".onion:9735", "", time.Second,
+	)
+	require.False(t, result.Reachable)
+	require.Contains(t, result.Error, "--torproxy")
+}
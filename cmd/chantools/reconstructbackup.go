@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/graph/db/models"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/routing/route"
+	"github.com/spf13/cobra"
+)
+
+type reconstructBackupCommand struct {
+	APIURL     string
+	ChannelDB  string
+	FromHeight uint32
+	ToHeight   uint32
+	NumKeys    uint32
+	MultiFile  string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newReconstructBackupCommand() *cobra.Command {
+	cc := &reconstructBackupCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "reconstructbackup",
+		Short: "Reconstruct a channel backup from the seed, the " +
+			"chain and optionally the public channel graph",
+		Long: `Reconstructs a best-effort channel.backup file without
+any per-channel information from the user, to be used to ask the peers to
+force close the channels (DLP, Data Loss Protection).
+
+Channels are searched for with the first --num_keys multisig keys derived
+from the seed (m/1017'/<coin type>'/0'/0/<index>) in two sources:
+
+The blocks in the range given by --from_height and --to_height are scanned for
+transactions spending a P2WSH 2-of-2 funding output that contains one of our
+multisig keys. The witness of such a spend reveals the peer's multisig key as
+well. A P2WSH funding output only commits to a hash of both multisig keys and
+a P2TR (MuSig2) funding output to their aggregate key, so an unspent funding
+output or a P2TR funding output spent through the key path can't be
+recognized on chain from our keys alone. The chain scan therefore only finds
+channels that were already closed. Their funds need to be swept with the
+sweepremoteclosed or rescueclosed commands. Scanning the chain requires one
+request per block to the API, so the range should be limited to the lifetime
+of the node.
+
+The channel announcements of the public network graph contain both multisig
+keys and the node keys of a channel, so channels that are still open can be
+found there, even if our node announcement is long gone. The graph is read
+from the channel.db of any lnd node that has a synced graph, given with
+--channeldb. Private channels are never announced and can't be found with
+this method.
+
+Each match is then verified against the chain: the P2WSH or P2TR funding
+output script built from our derived key and the peer's key must match the
+output on chain. Channels that are still open and whose peer is known are
+written to the backup file, closed channels are only reported.
+
+The created backup contains our real multisig key locator and the peer's
+real multisig key. All other keys are guessed and it can only be used to
+trigger a force close by the peer, the same way a backup created with the
+fakechanbackup command is used. Peers without a known network address are
+included but need an address added manually (see the backuptojson and
+jsontobackup commands).`,
+		Example: `chantools reconstructbackup \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--from_height 700000 \
+	--num_keys 5000`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
+			"be esplora compatible)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "optional lnd channel.db "+
+			"file that contains a synced channel graph, will be "+
+			"opened in read-only mode",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.FromHeight, "from_height", 0, "first block height to "+
+			"scan for spent funding outputs of our channels; the "+
+			"chain isn't scanned if not set",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.ToHeight, "to_height", 0, "last block height to scan "+
+			"for spent funding outputs; defaults to the current "+
+			"chain tip",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.NumKeys, "num_keys", MaxChannelLookup, "number of "+
+			"multisig keys to derive and look for",
+	)
+	multiFileName := fmt.Sprintf("%s/reconstructed-%s.backup", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", multiFileName, "the channel "+
+			"backup file to create",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving keys and encrypting the "+
+		"backup")
+
+	return cc.cmd
+}
+
+// reconstructedChannel is a channel of ours that was found in the graph or on
+// chain.
+type reconstructedChannel struct {
+	chanPoint     wire.OutPoint
+	shortChanID   lnwire.ShortChannelID
+	multiSigIndex uint32
+	remoteKey     *btcec.PublicKey
+
+	// peer is the node key of the peer. It's only known for channels
+	// found in the graph.
+	peer *btcec.PublicKey
+}
+
+// nodeAddrLookup returns the announced network addresses of a node.
+type nodeAddrLookup func(node route.Vertex) ([]net.Addr, error)
+
+// blockLookup returns the block at the given height.
+type blockLookup func(height uint32) (*wire.MsgBlock, error)
+
+func (c *reconstructBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	if c.ChannelDB == "" && c.FromHeight == 0 {
+		return errors.New("channel DB or block range to scan is " +
+			"required")
+	}
+	if c.NumKeys == 0 {
+		return errors.New("number of keys must be positive")
+	}
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	multiSigKeys, err := deriveMultiSigKeys(keyRing, c.NumKeys)
+	if err != nil {
+		return err
+	}
+
+	var (
+		api        = newExplorerAPI(c.APIURL)
+		channels   []*reconstructedChannel
+		addrLookup = func(route.Vertex) ([]net.Addr, error) {
+			return nil, nil
+		}
+	)
+	if c.ChannelDB != "" {
+		db, graphDB, err := lnd.OpenDB(c.ChannelDB, true)
+		if err != nil {
+			return fmt.Errorf("error opening channel DB: %w", err)
+		}
+		defer func() { _ = db.Close() }()
+
+		log.Infof("Searching channel graph for %d multisig keys",
+			c.NumKeys)
+		err = graphDB.ForEachChannel(func(info *models.ChannelEdgeInfo,
+			_, _ *models.ChannelEdgePolicy) error {
+
+			channel, err := matchChannelEdge(info, multiSigKeys)
+			if err != nil || channel == nil {
+				return err
+			}
+
+			log.Infof("Found channel %v with peer %x using "+
+				"multisig key %s", info.ChannelPoint,
+				channel.peer.SerializeCompressed(),
+				lnd.MultisigPath(
+					chainParams, int(channel.multiSigIndex),
+				))
+			channels = append(channels, channel)
+
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error reading channel graph: %w",
+				err)
+		}
+
+		addrLookup = func(node route.Vertex) ([]net.Addr, error) {
+			lightningNode, err := graphDB.FetchLightningNode(node)
+			if err != nil {
+				return nil, err
+			}
+
+			return lightningNode.Addresses, nil
+		}
+	}
+
+	if c.FromHeight > 0 {
+		toHeight := c.ToHeight
+		if toHeight == 0 {
+			toHeight, err = api.TipHeight()
+			if err != nil {
+				return fmt.Errorf("error fetching chain tip: "+
+					"%w", err)
+			}
+		}
+
+		log.Infof("Scanning blocks %d to %d for spent funding "+
+			"outputs of %d multisig keys", c.FromHeight, toHeight,
+			c.NumKeys)
+		chainChannels, err := scanChainForChannels(
+			c.FromHeight, toHeight, multiSigKeys,
+			func(height uint32) (*wire.MsgBlock, error) {
+				hash, err := api.BlockHash(height)
+				if err != nil {
+					return nil, err
+				}
+
+				return api.RawBlock(hash)
+			},
+		)
+		if err != nil {
+			return err
+		}
+		channels = mergeChannels(channels, chainChannels)
+	}
+
+	outputLookup := func(chanPoint wire.OutPoint) (*btc.Vout, error) {
+		tx, err := api.Transaction(chanPoint.Hash.String())
+		if err != nil {
+			return nil, err
+		}
+		if int(chanPoint.Index) >= len(tx.Vout) {
+			return nil, nil
+		}
+
+		return tx.Vout[chanPoint.Index], nil
+	}
+
+	singles, err := reconstructSingles(
+		channels, keyRing, addrLookup, outputLookup,
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Found %d channels, %d of them are still open",
+		len(channels), len(singles))
+	if len(singles) == 0 {
+		return nil
+	}
+
+	log.Infof("Writing backup of %d channels to %s", len(singles),
+		c.MultiFile)
+	multiFile := chanbackup.NewMultiFile(c.MultiFile, noBackupArchive)
+
+	return writeBackups(singles, keyRing, multiFile)
+}
+
+// deriveMultiSigKeys derives the given number of multisig keys and returns
+// them indexed by their serialized public key.
+func deriveMultiSigKeys(keyRing *lnd.HDKeyRing,
+	numKeys uint32) (map[[33]byte]uint32, error) {
+
+	keys := make(map[[33]byte]uint32, numKeys)
+	for index := range numKeys {
+		keyDesc, err := keyRing.DeriveKey(keychain.KeyLocator{
+			Family: keychain.KeyFamilyMultiSig,
+			Index:  index,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error deriving multisig key "+
+				"%d: %w", index, err)
+		}
+
+		var pubKey [33]byte
+		copy(pubKey[:], keyDesc.PubKey.SerializeCompressed())
+		keys[pubKey] = index
+	}
+
+	return keys, nil
+}
+
+// matchChannelEdge checks if one of the multisig keys of the given channel
+// announcement is one of ours. If it is, the peer's node and multisig keys are
+// returned. Nil is returned if the channel isn't ours.
+func matchChannelEdge(info *models.ChannelEdgeInfo,
+	multiSigKeys map[[33]byte]uint32) (*reconstructedChannel, error) {
+
+	var remoteKey, peer [33]byte
+	index, ok := multiSigKeys[info.BitcoinKey1Bytes]
+	if ok {
+		remoteKey, peer = info.BitcoinKey2Bytes, info.NodeKey2Bytes
+	} else {
+		index, ok = multiSigKeys[info.BitcoinKey2Bytes]
+		if !ok {
+			return nil, nil
+		}
+		remoteKey, peer = info.BitcoinKey1Bytes, info.NodeKey1Bytes
+	}
+
+	remoteKeyParsed, err := btcec.ParsePubKey(remoteKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing multisig key of channel "+
+			"%v: %w", info.ChannelPoint, err)
+	}
+	peerParsed, err := btcec.ParsePubKey(peer[:])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing node key of channel "+
+			"%v: %w", info.ChannelPoint, err)
+	}
+
+	return &reconstructedChannel{
+		chanPoint: info.ChannelPoint,
+		shortChanID: lnwire.NewShortChanIDFromInt(
+			info.ChannelID,
+		),
+		multiSigIndex: index,
+		remoteKey:     remoteKeyParsed,
+		peer:          peerParsed,
+	}, nil
+}
+
+// scanChainForChannels searches the blocks in the given height range for
+// transactions that spend a P2WSH funding output with one of our multisig
+// keys.
+func scanChainForChannels(fromHeight, toHeight uint32,
+	multiSigKeys map[[33]byte]uint32,
+	lookup blockLookup) ([]*reconstructedChannel, error) {
+
+	var channels []*reconstructedChannel
+	for height := fromHeight; height <= toHeight; height++ {
+		if (height-fromHeight)%1000 == 0 {
+			log.Infof("Scanning block %d of %d", height, toHeight)
+		}
+
+		block, err := lookup(height)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching block %d: %w",
+				height, err)
+		}
+
+		for _, tx := range block.Transactions {
+			for _, txIn := range tx.TxIn {
+				channel, err := matchFundingSpend(
+					txIn, multiSigKeys,
+				)
+				if err != nil || channel == nil {
+					continue
+				}
+
+				log.Infof("Found channel %v closed in block "+
+					"%d by transaction %v using multisig "+
+					"key %s", channel.chanPoint, height,
+					tx.TxHash(), lnd.MultisigPath(
+						chainParams,
+						int(channel.multiSigIndex),
+					))
+				channels = append(channels, channel)
+			}
+		}
+	}
+
+	return channels, nil
+}
+
+// matchFundingSpend checks if the given input spends a P2WSH 2-of-2 multisig
+// funding output that contains one of our multisig keys. The witness of such a
+// spend contains the funding script and with it the peer's multisig key. Nil
+// is returned if the input doesn't spend a funding output of ours.
+func matchFundingSpend(txIn *wire.TxIn,
+	multiSigKeys map[[33]byte]uint32) (*reconstructedChannel, error) {
+
+	// The witness of a funding output spend is: <empty> <sig> <sig>
+	// <funding script>.
+	if len(txIn.Witness) != 4 {
+		return nil, nil
+	}
+	script := txIn.Witness[3]
+	if len(script) != input.MultiSigSize ||
+		script[0] != txscript.OP_2 ||
+		script[1] != txscript.OP_DATA_33 ||
+		script[35] != txscript.OP_DATA_33 ||
+		script[69] != txscript.OP_2 ||
+		script[70] != txscript.OP_CHECKMULTISIG {
+
+		return nil, nil
+	}
+
+	var key1, key2 [33]byte
+	copy(key1[:], script[2:35])
+	copy(key2[:], script[36:69])
+
+	remoteKey := key2
+	index, ok := multiSigKeys[key1]
+	if !ok {
+		remoteKey = key1
+		index, ok = multiSigKeys[key2]
+		if !ok {
+			return nil, nil
+		}
+	}
+
+	remoteKeyParsed, err := btcec.ParsePubKey(remoteKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &reconstructedChannel{
+		chanPoint:     txIn.PreviousOutPoint,
+		multiSigIndex: index,
+		remoteKey:     remoteKeyParsed,
+	}, nil
+}
+
+// mergeChannels adds the channels found on chain to the ones found in the
+// graph. Channels found in both are only kept once, with the information from
+// the graph.
+func mergeChannels(graphChannels,
+	chainChannels []*reconstructedChannel) []*reconstructedChannel {
+
+	known := make(map[wire.OutPoint]struct{}, len(graphChannels))
+	for _, channel := range graphChannels {
+		known[channel.chanPoint] = struct{}{}
+	}
+
+	channels := graphChannels
+	for _, channel := range chainChannels {
+		if _, ok := known[channel.chanPoint]; ok {
+			continue
+		}
+
+		known[channel.chanPoint] = struct{}{}
+		channels = append(channels, channel)
+	}
+
+	return channels
+}
+
+// fundingScriptVersion returns the channel backup version that matches the
+// given funding output script of a channel with the given multisig keys. Both
+// P2WSH and simple taproot (MuSig2) funding outputs are recognized. False is
+// returned if the script doesn't belong to the keys.
+func fundingScriptVersion(pkScript []byte, localKey,
+	remoteKey *btcec.PublicKey) (chanbackup.SingleBackupVersion, bool,
+	error) {
+
+	if txscript.IsPayToTaproot(pkScript) {
+		taprootScript, _, err := input.GenTaprootFundingScript(
+			localKey, remoteKey, 0, fn.None[chainhash.Hash](),
+		)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return chanbackup.SimpleTaprootVersion,
+			bytes.Equal(pkScript, taprootScript), nil
+	}
+
+	witnessScript, err := input.GenMultiSigScript(
+		localKey.SerializeCompressed(), remoteKey.SerializeCompressed(),
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	p2wshScript, err := input.WitnessScriptHash(witnessScript)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return chanbackup.DefaultSingleVersion,
+		bytes.Equal(pkScript, p2wshScript), nil
+}
+
+// reconstructSingles verifies the found channels against the chain and creates
+// a backup entry for each channel that is still open.
+func reconstructSingles(channels []*reconstructedChannel,
+	keyRing *lnd.HDKeyRing, addrLookup nodeAddrLookup,
+	outputLookup fundingOutputLookup) ([]chanbackup.Single, error) {
+
+	singles := make([]chanbackup.Single, 0, len(channels))
+	for _, channel := range channels {
+		chanPoint := channel.chanPoint
+		localKey, err := keyRing.DeriveKey(keychain.KeyLocator{
+			Family: keychain.KeyFamilyMultiSig,
+			Index:  channel.multiSigIndex,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		fundingOut, err := outputLookup(chanPoint)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up funding "+
+				"output of channel %v: %w", chanPoint, err)
+		}
+		if fundingOut == nil {
+			log.Warnf("Funding output of channel %v not found on "+
+				"chain, skipping", chanPoint)
+
+			continue
+		}
+
+		pkScript, err := hex.DecodeString(fundingOut.ScriptPubkey)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding funding script "+
+				"of channel %v: %w", chanPoint, err)
+		}
+		version, ok, err := fundingScriptVersion(
+			pkScript, localKey.PubKey, channel.remoteKey,
+		)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("error creating funding script "+
+				"of channel %v: %w", chanPoint, err)
+
+		case !ok:
+			log.Warnf("Funding output script of channel %v "+
+				"doesn't match our keys, skipping", chanPoint)
+
+			continue
+
+		case fundingOut.Outspend != nil && fundingOut.Outspend.Spent:
+			log.Infof("Channel %v was already closed in "+
+				"transaction %s, use sweepremoteclosed or "+
+				"rescueclosed to sweep the funds", chanPoint,
+				fundingOut.Outspend.Txid)
+
+			continue
+
+		case channel.peer == nil:
+			log.Warnf("Peer of open channel %v is unknown, "+
+				"skipping", chanPoint)
+
+			continue
+		}
+
+		addrs, err := addrLookup(route.NewVertex(channel.peer))
+		if err != nil {
+			log.Warnf("Could not look up addresses of peer %x: %v",
+				channel.peer.SerializeCompressed(), err)
+		}
+		if len(addrs) == 0 {
+			log.Warnf("No address known for peer %x of channel "+
+				"%v, an address needs to be added manually",
+				channel.peer.SerializeCompressed(), chanPoint)
+		}
+
+		single := newSingle(
+			chanPoint, channel.shortChanID, channel.peer, addrs,
+			btcutil.Amount(fundingOut.Value),
+		)
+		single.Version = version
+
+		// Unlike a completely faked backup, we know our real multisig
+		// key and the peer's. Our other keys most likely use the same
+		// index as lnd derives one key of each family per channel.
+		single.LocalChanCfg.MultiSigKey = keychain.KeyDescriptor{
+			KeyLocator: localKey.KeyLocator,
+		}
+		single.RemoteChanCfg.MultiSigKey = keychain.KeyDescriptor{
+			PubKey: channel.remoteKey,
+		}
+		for _, keyDesc := range []*keychain.KeyDescriptor{
+			&single.LocalChanCfg.RevocationBasePoint,
+			&single.LocalChanCfg.PaymentBasePoint,
+			&single.LocalChanCfg.DelayBasePoint,
+			&single.LocalChanCfg.HtlcBasePoint,
+		} {
+			keyDesc.Index = channel.multiSigIndex
+		}
+		single.ShaChainRootDesc = keychain.KeyDescriptor{
+			KeyLocator: keychain.KeyLocator{
+				Family: keychain.KeyFamilyRevocationRoot,
+			},
+		}
+
+		singles = append(singles, single)
+	}
+
+	return singles, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/graph/db/models"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/routing/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconstructBackup(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	multiSigKeys, err := deriveMultiSigKeys(keyRing, 5)
+	require.NoError(t, err)
+	require.Len(t, multiSigKeys, 5)
+
+	newKey := func() [33]byte {
+		priv, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+
+		var key [33]byte
+		copy(key[:], priv.PubKey().SerializeCompressed())
+
+		return key
+	}
+	ourKey := func(index uint32) [33]byte {
+		keyDesc, err := keyRing.DeriveKey(keychain.KeyLocator{
+			Family: keychain.KeyFamilyMultiSig,
+			Index:  index,
+		})
+		require.NoError(t, err)
+
+		var key [33]byte
+		copy(key[:], keyDesc.PubKey.SerializeCompressed())
+
+		return key
+	}
+	newEdge := func(id byte) *models.ChannelEdgeInfo {
+		return &models.ChannelEdgeInfo{
+			ChannelID: uint64(id),
+			ChannelPoint: wire.OutPoint{
+				Hash: chainhash.Hash{id},
+			},
+			Capacity:         btcutil.Amount(id) * 100_000,
+			NodeKey1Bytes:    newKey(),
+			NodeKey2Bytes:    newKey(),
+			BitcoinKey1Bytes: newKey(),
+			BitcoinKey2Bytes: newKey(),
+		}
+	}
+
+	// Our key can be on either side of the channel.
+	open := newEdge(1)
+	open.BitcoinKey2Bytes = ourKey(2)
+	closed := newEdge(2)
+	closed.BitcoinKey1Bytes = ourKey(4)
+	mismatch := newEdge(3)
+	mismatch.BitcoinKey1Bytes = ourKey(0)
+	notOurs := newEdge(4)
+	taproot := newEdge(5)
+	taproot.BitcoinKey1Bytes = ourKey(3)
+
+	var channels []*reconstructedChannel
+	for _, edge := range []*models.ChannelEdgeInfo{
+		open, closed, mismatch, notOurs, taproot,
+	} {
+		channel, err := matchChannelEdge(edge, multiSigKeys)
+		require.NoError(t, err)
+		if channel != nil {
+			channels = append(channels, channel)
+		}
+	}
+	require.Len(t, channels, 4)
+	require.EqualValues(t, 2, channels[0].multiSigIndex)
+	require.Equal(t, open.BitcoinKey1Bytes[:],
+		channels[0].remoteKey.SerializeCompressed())
+	require.Equal(t, open.NodeKey1Bytes[:],
+		channels[0].peer.SerializeCompressed())
+	require.EqualValues(t, 4, channels[1].multiSigIndex)
+	require.Equal(t, closed.NodeKey2Bytes[:],
+		channels[1].peer.SerializeCompressed())
+
+	fundingScript := func(edge *models.ChannelEdgeInfo) string {
+		witnessScript, err := input.GenMultiSigScript(
+			edge.BitcoinKey1Bytes[:], edge.BitcoinKey2Bytes[:],
+		)
+		require.NoError(t, err)
+		pkScript, err := input.WitnessScriptHash(witnessScript)
+		require.NoError(t, err)
+
+		return hex.EncodeToString(pkScript)
+	}
+	taprootScript := func(edge *models.ChannelEdgeInfo) string {
+		key1, err := btcec.ParsePubKey(edge.BitcoinKey1Bytes[:])
+		require.NoError(t, err)
+		key2, err := btcec.ParsePubKey(edge.BitcoinKey2Bytes[:])
+		require.NoError(t, err)
+		pkScript, _, err := input.GenTaprootFundingScript(
+			key1, key2, 0, fn.None[chainhash.Hash](),
+		)
+		require.NoError(t, err)
+
+		return hex.EncodeToString(pkScript)
+	}
+	outputs := map[wire.OutPoint]*btc.Vout{
+		open.ChannelPoint: {
+			ScriptPubkey: fundingScript(open),
+			Value:        uint64(open.Capacity),
+			Outspend:     &btc.Outspend{},
+		},
+		taproot.ChannelPoint: {
+			ScriptPubkey: taprootScript(taproot),
+			Value:        uint64(taproot.Capacity),
+			Outspend:     &btc.Outspend{},
+		},
+		closed.ChannelPoint: {
+			ScriptPubkey: fundingScript(closed),
+			Outspend:     &btc.Outspend{Spent: true, Txid: "abcd"},
+		},
+		mismatch.ChannelPoint: {
+			ScriptPubkey: "0020" + hex.EncodeToString(
+				make([]byte, 32),
+			),
+			Outspend: &btc.Outspend{},
+		},
+	}
+	outputLookup := func(chanPoint wire.OutPoint) (*btc.Vout, error) {
+		return outputs[chanPoint], nil
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9735}
+	addrLookup := func(node route.Vertex) ([]net.Addr, error) {
+		if node != route.Vertex(open.NodeKey1Bytes) {
+			return nil, nil
+		}
+
+		return []net.Addr{addr}, nil
+	}
+
+	// Only the channels that are still open and match our keys on chain
+	// end up in the backup.
+	singles, err := reconstructSingles(
+		channels, keyRing, addrLookup, outputLookup,
+	)
+	require.NoError(t, err)
+	require.Len(t, singles, 2)
+
+	single := singles[0]
+	require.Equal(t, open.ChannelPoint, single.FundingOutpoint)
+	require.EqualValues(t, open.Capacity, single.Capacity)
+	require.Equal(t, []net.Addr{addr}, single.Addresses)
+	require.Equal(t, open.NodeKey1Bytes[:],
+		single.RemoteNodePub.SerializeCompressed())
+	require.Equal(t, open.BitcoinKey1Bytes[:],
+		single.RemoteChanCfg.MultiSigKey.PubKey.SerializeCompressed())
+	require.Equal(t, keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+		Index:  2,
+	}, single.LocalChanCfg.MultiSigKey.KeyLocator)
+	require.EqualValues(t, 2, single.LocalChanCfg.HtlcBasePoint.Index)
+	require.Equal(t, keychain.KeyFamilyRevocationRoot,
+		single.ShaChainRootDesc.Family)
+	require.EqualValues(t, chanbackup.DefaultSingleVersion, single.Version)
+
+	// A P2TR funding output is recognized as a simple taproot channel.
+	require.Equal(t, taproot.ChannelPoint, singles[1].FundingOutpoint)
+	require.EqualValues(
+		t, chanbackup.SimpleTaprootVersion, singles[1].Version,
+	)
+	require.EqualValues(t, 3, singles[1].LocalChanCfg.MultiSigKey.Index)
+}
+
+func TestScanChainForChannels(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	multiSigKeys, err := deriveMultiSigKeys(keyRing, 5)
+	require.NoError(t, err)
+
+	ourKey, err := keyRing.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+		Index:  4,
+	})
+	require.NoError(t, err)
+	remotePriv, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	otherPriv, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	spend := func(id byte, key1, key2 *btcec.PublicKey) *wire.MsgTx {
+		witnessScript, err := input.GenMultiSigScript(
+			key1.SerializeCompressed(), key2.SerializeCompressed(),
+		)
+		require.NoError(t, err)
+
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{
+				Hash: chainhash.Hash{id},
+			},
+			Witness: wire.TxWitness{
+				nil, {1}, {2}, witnessScript,
+			},
+		})
+
+		return tx
+	}
+
+	// Only the spend of a funding output with one of our keys is found,
+	// no matter on which side of the script our key is.
+	blocks := map[uint32]*wire.MsgBlock{
+		100: {Transactions: []*wire.MsgTx{
+			spend(1, remotePriv.PubKey(), otherPriv.PubKey()),
+		}},
+		101: {Transactions: []*wire.MsgTx{
+			spend(2, remotePriv.PubKey(), ourKey.PubKey),
+		}},
+	}
+	channels, err := scanChainForChannels(
+		100, 101, multiSigKeys,
+		func(height uint32) (*wire.MsgBlock, error) {
+			return blocks[height], nil
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, channels, 1)
+	require.Equal(t, wire.OutPoint{Hash: chainhash.Hash{2}},
+		channels[0].chanPoint)
+	require.EqualValues(t, 4, channels[0].multiSigIndex)
+	require.True(t, channels[0].remoteKey.IsEqual(remotePriv.PubKey()))
+	require.Nil(t, channels[0].peer)
+
+	// Channels also found in the graph are only kept once.
+	graphChannel := &reconstructedChannel{
+		chanPoint: channels[0].chanPoint,
+		peer:      otherPriv.PubKey(),
+	}
+	merged := mergeChannels(
+		[]*reconstructedChannel{graphChannel}, channels,
+	)
+	require.Equal(t, []*reconstructedChannel{graphChannel}, merged)
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// unknownSeedWord is the placeholder for a word that is unknown.
+	unknownSeedWord = "?"
+
+	// maxUnknownSeedWords is the maximum number of unknown words we try
+	// to find. Each unknown word multiplies the number of candidates by
+	// 2048.
+	maxUnknownSeedWords = 3
+
+	// birthdayTolerance is the maximum difference between the given
+	// birthday and the birthday encoded in a candidate seed. Seeds only
+	// encode the day since the genesis block, so a single day isn't
+	// precise enough.
+	birthdayTolerance = 48 * time.Hour
+)
+
+var (
+	// aezeedCrcTable is the table of the checksum of an aezeed.
+	aezeedCrcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// seedWords is a mnemonic in the form of indexes into the aezeed word list.
+type seedWords [aezeed.NumMnemonicWords]uint16
+
+// repairSeedJob is a mnemonic for which all combinations of words at the
+// given positions should be tried.
+type repairSeedJob struct {
+	words     seedWords
+	positions []int
+}
+
+// repairedSeed is a candidate with a valid checksum.
+type repairedSeed struct {
+	mnemonic     aezeed.Mnemonic
+	birthday     time.Time
+	invalidPass  bool
+	confirmed    bool
+	notConfirmed string
+}
+
+type repairSeedCommand struct {
+	Positions  []uint
+	NoSwaps    bool
+	NodePubKey string
+	Address    string
+	Birthday   string
+	Lookahead  uint32
+	Threads    uint8
+
+	cmd *cobra.Command
+}
+
+func newRepairSeedCommand() *cobra.Command {
+	cc := &repairSeedCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "repairseed",
+		Short: "Try to repair an lnd aezeed with a missing or " +
+			"mistyped word or swapped words",
+		Long: `Tries to find the correct lnd aezeed if one or more
+words of it are missing or wrong. Enter the seed with a question mark (?) in
+place of each unknown word. Words that are not on the aezeed word list are
+treated as unknown too.
+
+The following candidates are tried:
+ - all words at the unknown positions and the positions given with
+   --positions (at most 3 positions)
+ - if there are no unknown positions: all words at each single position, for
+   a mistyped word at an unknown position
+ - if all words are on the word list: all swaps of two adjacent words (unless
+   --noswaps is set)
+
+The aezeed checksum is used to filter the candidates; only candidates with a
+valid checksum are decrypted with the passphrase. If the correct seed has a
+valid checksum but can't be decrypted, the passphrase is wrong.
+
+To be sure that the right seed was found, the candidate can be confirmed
+against the node's identity public key, a wallet address or the wallet birthday
+date (YYYY-MM-DD). All given values must match.`,
+		Example: `chantools repairseed \
+	--nodepubkey 03xxxxxxx...
+
+chantools repairseed --positions 5,17 \
+	--address bc1q..... \
+	--lookahead 1000 \
+	--threads 8`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().UintSliceVar(
+		&cc.Positions, "positions", nil, "the positions (starting at "+
+			"1) of words that are known to be wrong, in addition "+
+			"to unknown words",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.NoSwaps, "noswaps", false, "don't try to swap adjacent "+
+			"words",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.NodePubKey, "nodepubkey", "", "the hex encoded identity "+
+			"public key of the node to confirm a candidate with",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Address, "address", "", "an address of the on-chain "+
+			"wallet to confirm a candidate with",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Birthday, "birthday", "", "the date the seed was created "+
+			"(YYYY-MM-DD) to confirm a candidate with",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.Lookahead, "lookahead", 200, "number of addresses per "+
+			"wallet branch to compare with --address",
+	)
+	cc.cmd.Flags().Uint8Var(
+		&cc.Threads, "threads", 4, "number of parallel threads",
+	)
+
+	return cc.cmd
+}
+
+func (c *repairSeedCommand) Execute(_ *cobra.Command, _ []string) error {
+	repairer := &seedRepairer{
+		address:   c.Address,
+		lookahead: c.Lookahead,
+		params:    chainParams,
+	}
+	if c.NodePubKey != "" {
+		var err error
+		repairer.nodePubKey, err = hex.DecodeString(c.NodePubKey)
+		if err != nil {
+			return fmt.Errorf("error decoding node pubkey: %w", err)
+		}
+	}
+	if c.Birthday != "" {
+		var err error
+		repairer.birthday, err = time.Parse("2006-01-02", c.Birthday)
+		if err != nil {
+			return fmt.Errorf("error parsing birthday: %w", err)
+		}
+	}
+	if c.Threads == 0 {
+		return errors.New("at least one thread is required")
+	}
+
+	words, err := lnd.ReadMnemonicWords()
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+
+	repairer.passphrase, err = lnd.ReadPassphrase("doesn't have")
+	if err != nil {
+		return err
+	}
+
+	positions := make([]int, len(c.Positions))
+	for idx, position := range c.Positions {
+		positions[idx] = int(position) - 1
+	}
+
+	results, err := repairer.repair(words, positions, !c.NoSwaps, c.Threads)
+	if err != nil {
+		return err
+	}
+
+	var numConfirmed int
+	for _, result := range results {
+		switch {
+		case result.invalidPass:
+			fmt.Printf("\nFound seed with valid checksum that "+
+				"can't be decrypted, the passphrase is "+
+				"wrong:\n%v\n",
+				strings.Join(result.mnemonic[:], " "))
+
+		case !result.confirmed:
+			log.Infof("Candidate with birthday %s doesn't match: "+
+				"%s", result.birthday.Format("2006-01-02"),
+				result.notConfirmed)
+
+		default:
+			numConfirmed++
+			fmt.Printf("\nFound seed with birthday %s:\n%v\n",
+				result.birthday.Format("2006-01-02"),
+				strings.Join(result.mnemonic[:], " "))
+		}
+	}
+
+	if numConfirmed == 0 {
+		return errors.New("no matching seed found")
+	}
+
+	return nil
+}
+
+// seedRepairer tries to find the correct seed among candidates of a broken
+// mnemonic.
+type seedRepairer struct {
+	passphrase []byte
+	nodePubKey []byte
+	address    string
+	birthday   time.Time
+	lookahead  uint32
+	params     *chaincfg.Params
+
+	resultsMtx sync.Mutex
+	results    []*repairedSeed
+	seen       map[seedWords]struct{}
+	numTried   atomic.Uint64
+}
+
+// repair tries all candidates for the given words and returns those with a
+// valid checksum.
+func (r *seedRepairer) repair(words []string, positions []int, swaps bool,
+	threads uint8) ([]*repairedSeed, error) {
+
+	if len(words) != aezeed.NumMnemonicWords {
+		return nil, fmt.Errorf("wrong cipher seed mnemonic length: "+
+			"got %d words, expecting %d words", len(words),
+			aezeed.NumMnemonicWords)
+	}
+
+	var (
+		base      seedWords
+		unknown   = make(map[int]struct{})
+		allKnown  = true
+		unknownAt []int
+	)
+	for idx, word := range words {
+		wordIndex, ok := aezeed.ReverseWordMap[word]
+		if !ok {
+			if word != unknownSeedWord {
+				log.Infof("Word %d (%s) is not on the word "+
+					"list", idx+1, word)
+			}
+			unknown[idx] = struct{}{}
+			allKnown = false
+
+			continue
+		}
+		base[idx] = uint16(wordIndex)
+	}
+	for _, position := range positions {
+		if position < 0 || position >= aezeed.NumMnemonicWords {
+			return nil, fmt.Errorf("invalid position %d",
+				position+1)
+		}
+		unknown[position] = struct{}{}
+	}
+	for position := range aezeed.NumMnemonicWords {
+		if _, ok := unknown[position]; ok {
+			unknownAt = append(unknownAt, position)
+		}
+	}
+	if len(unknownAt) > maxUnknownSeedWords {
+		return nil, fmt.Errorf("too many unknown words (%d), can "+
+			"find at most %d", len(unknownAt), maxUnknownSeedWords)
+	}
+
+	// We create the jobs in the background, the workers enumerate all
+	// words of the positions of a job.
+	jobs := make(chan *repairSeedJob)
+	go func() {
+		defer close(jobs)
+
+		switch {
+		// We fix the first unknown word of each job, so we get enough
+		// jobs to keep all threads busy.
+		case len(unknownAt) > 0:
+			for wordIndex := range len(aezeed.DefaultWordList) {
+				words := base
+				words[unknownAt[0]] = uint16(wordIndex)
+				jobs <- &repairSeedJob{
+					words:     words,
+					positions: unknownAt[1:],
+				}
+			}
+
+		// We don't know where the wrong word is, so we try every
+		// single position.
+		default:
+			for position := range aezeed.NumMnemonicWords {
+				jobs <- &repairSeedJob{
+					words:     base,
+					positions: []int{position},
+				}
+			}
+		}
+
+		if !swaps || !allKnown {
+			return
+		}
+		for idx := range aezeed.NumMnemonicWords - 1 {
+			words := base
+			words[idx], words[idx+1] = words[idx+1], words[idx]
+			jobs <- &repairSeedJob{words: words}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		errMtx   sync.Mutex
+		firstErr error
+		done     = make(chan struct{})
+		start    = time.Now()
+	)
+	for range threads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				err := r.tryJob(job.words, job.positions)
+				if err != nil {
+					errMtx.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMtx.Unlock()
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			fmt.Printf("\rTested %s candidates in %v\n",
+				format(int64(r.numTried.Load())),
+				time.Since(start).Truncate(time.Second))
+
+			return r.results, firstErr
+
+		case <-time.After(1 * time.Second):
+			fmt.Printf("\rTested %s candidates, elapsed=%v",
+				format(int64(r.numTried.Load())),
+				time.Since(start).Truncate(time.Second))
+		}
+	}
+}
+
+// tryJob tries all words at the given positions and checks the candidates
+// with a valid checksum.
+func (r *seedRepairer) tryJob(words seedWords, positions []int) error {
+	if len(positions) == 0 {
+		r.numTried.Add(1)
+		if !aezeedChecksumValid(&words) {
+			return nil
+		}
+
+		return r.checkCandidate(&words)
+	}
+
+	for wordIndex := range len(aezeed.DefaultWordList) {
+		words[positions[0]] = uint16(wordIndex)
+		if err := r.tryJob(words, positions[1:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkCandidate decrypts a candidate with a valid checksum and confirms it
+// against the known information about the seed.
+func (r *seedRepairer) checkCandidate(words *seedWords) error {
+	// The same candidate can be found through different jobs, for example
+	// the unchanged words when trying all words at each position.
+	r.resultsMtx.Lock()
+	if r.seen == nil {
+		r.seen = make(map[seedWords]struct{})
+	}
+	_, seen := r.seen[*words]
+	r.seen[*words] = struct{}{}
+	r.resultsMtx.Unlock()
+	if seen {
+		return nil
+	}
+
+	result := &repairedSeed{}
+	for idx, wordIndex := range words {
+		result.mnemonic[idx] = aezeed.DefaultWordList[wordIndex]
+	}
+
+	cipherSeed, err := result.mnemonic.ToCipherSeed(r.passphrase)
+	switch {
+	case errors.Is(err, aezeed.ErrInvalidPass):
+		result.invalidPass = true
+
+	case err != nil:
+		return fmt.Errorf("error decrypting candidate: %w", err)
+
+	default:
+		result.birthday = cipherSeed.BirthdayTime()
+		result.notConfirmed, err = r.confirm(cipherSeed)
+		if err != nil {
+			return err
+		}
+		result.confirmed = result.notConfirmed == ""
+	}
+
+	r.resultsMtx.Lock()
+	r.results = append(r.results, result)
+	r.resultsMtx.Unlock()
+
+	return nil
+}
+
+// confirm checks the decrypted seed against all given information and
+// returns what didn't match.
+func (r *seedRepairer) confirm(cipherSeed *aezeed.CipherSeed) (string,
+	error) {
+
+	birthday := cipherSeed.BirthdayTime()
+	if !r.birthday.IsZero() &&
+		(birthday.Sub(r.birthday) > birthdayTolerance ||
+			r.birthday.Sub(birthday) > birthdayTolerance) {
+
+		return "birthday", nil
+	}
+
+	if r.nodePubKey == nil && r.address == "" {
+		return "", nil
+	}
+
+	rootKey, err := hdkeychain.NewMaster(cipherSeed.Entropy[:], r.params)
+	if err != nil {
+		return "", fmt.Errorf("error deriving root key: %w", err)
+	}
+
+	if r.nodePubKey != nil {
+		path, err := lnd.ParsePath(fmt.Sprintf(
+			nodeKeyDerivationPath, r.params.HDCoinType,
+			keychain.KeyFamilyNodeKey,
+		))
+		if err != nil {
+			return "", err
+		}
+		nodeKey, err := lnd.DeriveChildren(rootKey, path)
+		if err != nil {
+			return "", err
+		}
+		nodePubKey, err := nodeKey.ECPubKey()
+		if err != nil {
+			return "", err
+		}
+		nodePubKeyBytes := nodePubKey.SerializeCompressed()
+		if !bytes.Equal(nodePubKeyBytes, r.nodePubKey) {
+			return "node public key", nil
+		}
+	}
+
+	if r.address == "" {
+		return "", nil
+	}
+	for _, branch := range walletScanBranches(r.params, 1) {
+		path, err := lnd.ParsePath(branch.path)
+		if err != nil {
+			return "", err
+		}
+		branchKey, err := lnd.DeriveChildren(rootKey, path)
+		if err != nil {
+			return "", err
+		}
+		for index := range r.lookahead {
+			key, err := branchKey.DeriveNonStandard(index)
+			if err != nil {
+				return "", err
+			}
+			pubKey, err := key.ECPubKey()
+			if err != nil {
+				return "", err
+			}
+			addr, err := walletAddress(
+				pubKey, branch.addrType, r.params,
+			)
+			if err != nil {
+				return "", err
+			}
+			if addr.String() == r.address {
+				return "", nil
+			}
+		}
+	}
+
+	return "address", nil
+}
+
+// aezeedChecksumValid returns true if the words encode an aezeed of the
+// version we know with a valid checksum.
+func aezeedChecksumValid(words *seedWords) bool {
+	cipherText := lnd.WordIndexesToCipherText(*words)
+	if cipherText[0] != aezeed.CipherSeedVersion {
+		return false
+	}
+
+	checksumOffset := len(cipherText) - 4
+	checksum := crc32.Checksum(cipherText[:checksumOffset], aezeedCrcTable)
+
+	return checksum == binary.BigEndian.Uint32(cipherText[checksumOffset:])
+}
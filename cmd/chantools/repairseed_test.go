@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairSeed(t *testing.T) {
+	h := newHarness(t)
+
+	// The identity key of the test seed confirms the repaired seed.
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	path, err := lnd.ParsePath(fmt.Sprintf(
+		nodeKeyDerivationPath, chainParams.HDCoinType,
+		keychain.KeyFamilyNodeKey,
+	))
+	require.NoError(t, err)
+	nodeKey, err := lnd.DeriveChildren(extendedKey, path)
+	require.NoError(t, err)
+	nodePubKey, err := nodeKey.ECPubKey()
+	require.NoError(t, err)
+	nodePubKeyHex := hex.EncodeToString(nodePubKey.SerializeCompressed())
+
+	words := strings.Split(seedAezeedNoPassphrase, " ")
+	withWords := func(modify func([]string)) string {
+		modified := make([]string, len(words))
+		copy(modified, words)
+		modify(modified)
+
+		return strings.Join(modified, " ")
+	}
+	testCases := []struct {
+		name     string
+		mnemonic string
+	}{{
+		name: "unknown word",
+		mnemonic: withWords(func(w []string) {
+			w[4] = unknownSeedWord
+		}),
+	}, {
+		name: "word not on list",
+		mnemonic: withWords(func(w []string) {
+			w[20] = "machina"
+		}),
+	}, {
+		name: "mistyped word on list",
+		mnemonic: withWords(func(w []string) {
+			w[11] = "tissue"
+		}),
+	}, {
+		name: "swapped words",
+		mnemonic: withWords(func(w []string) {
+			w[7], w[8] = w[8], w[7]
+		}),
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(lnd.MnemonicEnvName, tc.mnemonic)
+			t.Setenv(lnd.PassphraseEnvName, "-")
+
+			repairSeed := &repairSeedCommand{
+				NodePubKey: nodePubKeyHex,
+				Lookahead:  10,
+				Threads:    4,
+			}
+			require.NoError(t, repairSeed.Execute(nil, nil))
+		})
+	}
+
+	// A wrong node public key doesn't confirm the seed.
+	t.Setenv(lnd.MnemonicEnvName, withWords(func(w []string) {
+		w[0] = unknownSeedWord
+	}))
+	t.Setenv(lnd.PassphraseEnvName, "-")
+	repairSeed := &repairSeedCommand{
+		NodePubKey: "02" + strings.Repeat("00", 32),
+		Threads:    4,
+	}
+	require.ErrorContains(
+		t, repairSeed.Execute(nil, nil), "no matching seed found",
+	)
+	h.assertLogContains("doesn't match: node public key")
+
+	// Too many unknown words are refused.
+	repairer := &seedRepairer{params: chainParams}
+	_, err = repairer.repair(words, []int{1, 2, 3, 4}, true, 1)
+	require.ErrorContains(t, err, "too many unknown words")
+
+	// The correct seed with a wrong passphrase is still found.
+	passWords := strings.Split(seedAezeedWithPassphrase, " ")
+	passWords[3] = unknownSeedWord
+	repairer = &seedRepairer{
+		passphrase: []byte("wrong"),
+		params:     chainParams,
+	}
+	results, err := repairer.repair(passWords, nil, true, 4)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.True(t, results[0].invalidPass)
+	require.Equal(
+		t, seedAezeedWithPassphrase,
+		strings.Join(results[0].mnemonic[:], " "),
+	)
+}
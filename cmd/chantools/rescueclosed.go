@@ -40,6 +40,7 @@ type rescueClosedCommand struct {
 	Addr        string
 	CommitPoint string
 	LndLog      string
+	Reestablish string
 	NumKeys     uint32
 
 	rootKey *rootKey
@@ -76,7 +77,13 @@ specify the --fromsummary and --lnd_log flags to automatically look for force
 close addresses in the summary and the corresponding commit points in the
 lnd log file. This only works if lnd is running the fund-recovery branch of my
 guggero/lnd (https://github.com/guggero/lnd/releases) fork and only if the
-debuglevel is set to debug (lnd.conf, set 'debuglevel=debug').`,
+debuglevel is set to debug (lnd.conf, set 'debuglevel=debug').
+
+Instead of running the guggero/lnd fork, the commit points can also be obtained
+with the triggerforceclose command, which records the channel re-establish
+messages sent by the remote peers in a results/reestablish-xxxx.json file. Use
+the --fromsummary and --reestablish_file flags to use the commit points from
+that file.`,
 		Example: `chantools rescueclosed \
 	--fromsummary results/summary-xxxxxx.json \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db
@@ -84,7 +91,10 @@ debuglevel is set to debug (lnd.conf, set 'debuglevel=debug').`,
 chantools rescueclosed --force_close_addr bc1q... --commit_point 03xxxx
 
 chantools rescueclosed --fromsummary results/summary-xxxxxx.json \
-	--lnd_log ~/.lnd/logs/bitcoin/mainnet/lnd.log`,
+	--lnd_log ~/.lnd/logs/bitcoin/mainnet/lnd.log
+
+chantools rescueclosed --fromsummary results/summary-xxxxxx.json \
+	--reestablish_file results/reestablish-xxxxxx.json`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
@@ -106,6 +116,13 @@ chantools rescueclosed --fromsummary results/summary-xxxxxx.json \
 			"the commit_point values when rescuing multiple "+
 			"channels at the same time",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.Reestablish, "reestablish_file", "", "the file written "+
+			"by the triggerforceclose command that contains the "+
+			"channel re-establish messages received from the "+
+			"remote peers, to read the commit points from when "+
+			"rescuing multiple channels at the same time",
+	)
 	cc.cmd.Flags().Uint32Var(
 		&cc.NumKeys, "num_keys", defaultNumKeys, "the number of keys "+
 			"to derive for the brute force attack",
@@ -184,6 +201,32 @@ func (c *rescueClosedCommand) Execute(_ *cobra.Command, _ []string) error {
 			c.NumKeys, extendedKey, entries, commitPoints,
 		)
 
+	case c.Reestablish != "":
+		// Parse channel entries from any of the possible input files.
+		entries, err := c.inputs.parseInputType()
+		if err != nil {
+			return err
+		}
+
+		reestablishFile, err := dataformat.ReadReestablishFile(
+			c.Reestablish,
+		)
+		if err != nil {
+			return err
+		}
+		commitPoints, err := reestablishFile.CommitPoints()
+		if err != nil {
+			return fmt.Errorf("error parsing commit points from "+
+				"re-establish file: %w", err)
+		}
+
+		log.Infof("Extracted %d commit points from re-establish file "+
+			"%s", len(commitPoints), c.Reestablish)
+
+		return rescueClosedChannels(
+			c.NumKeys, extendedKey, entries, commitPoints,
+		)
+
 	default:
 		return errors.New("you either need to specify --channeldb and " +
 			"--fromsummary or --force_close_addr and " +
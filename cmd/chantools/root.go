@@ -68,7 +68,7 @@ funds locked in lnd channels in case lnd itself cannot run properly anymore.
 Complete documentation is available at
 https://github.com/lightninglabs/chantools/.`,
 	Version: fmt.Sprintf("v%s, commit %s", version, Commit),
-	PersistentPreRun: func(_ *cobra.Command, _ []string) {
+	PersistentPreRun: func(cmd *cobra.Command, _ []string) {
 		switch {
 		case Testnet:
 			chainParams = &chaincfg.TestNet3Params
@@ -86,7 +86,14 @@ https://github.com/lightninglabs/chantools/.`,
 			chainParams = &chaincfg.MainNetParams
 		}
 
-		setupLogging()
+		// Commands that write their result to standard out log to
+		// standard error instead, so the result can be piped.
+		var logOutput io.Writer = os.Stdout
+		stdout, err := cmd.Flags().GetBool("stdout")
+		if err == nil && stdout {
+			logOutput = os.Stderr
+		}
+		setupLogging(logOutput)
 
 		log.Infof("chantools version v%s commit %s", version,
 			Commit)
@@ -122,13 +129,22 @@ func main() {
 	)
 
 	rootCmd.AddCommand(
+		newBackupToClnCommand(),
+		newBackupToJSONCommand(),
 		newChanBackupCommand(),
+		newClnToBackupCommand(),
+		newCombineSharesCommand(),
 		newClosePoolAccountCommand(),
+		newCoopCloseCommand(),
 		newCreateWalletCommand(),
+		newConvertSeedCommand(),
 		newCompactDBCommand(),
+		newDBShellCommand(),
 		newDeletePaymentsCommand(),
 		newDeriveKeyCommand(),
+		newDescriptorsCommand(),
 		newDoubleSpendInputsCommand(),
+		newDowngradeDBCommand(),
 		newDropChannelGraphCommand(),
 		newDropGraphZombiesCommand(),
 		newDumpBackupCommand(),
@@ -136,27 +152,37 @@ func main() {
 		newDocCommand(),
 		newFakeChanBackupCommand(),
 		newFilterBackupCommand(),
+		newFindPassphraseCommand(),
 		newFixOldBackupCommand(),
 		newForceCloseCommand(),
 		newScbForceCloseCommand(),
 		newGenImportScriptCommand(),
+		newJSONToBackupCommand(),
+		newMergeBackupCommand(),
 		newMigrateDBCommand(),
+		newProbePeerCommand(),
 		newPullAnchorCommand(),
+		newReconstructBackupCommand(),
 		newRecoverLoopInCommand(),
 		newRemoveChannelCommand(),
+		newRepairSeedCommand(),
 		newRescueClosedCommand(),
 		newRescueFundingCommand(),
 		newRescueTweakedKeyCommand(),
+		newSalvageDBCommand(),
+		newScanWalletCommand(),
 		newShowRootKeyCommand(),
 		newSignMessageCommand(),
 		newSignRescueFundingCommand(),
 		newSignPSBTCommand(),
+		newSplitSeedCommand(),
 		newSummaryCommand(),
 		newSweepTimeLockCommand(),
 		newSweepTimeLockManualCommand(),
 		newSweepRemoteClosedCommand(),
 		newTriggerForceCloseCommand(),
 		newVanityGenCommand(),
+		newVerifyBackupCommand(),
 		newWalletInfoCommand(),
 		newZombieRecoveryCommand(),
 	)
@@ -171,6 +197,7 @@ type rootKey struct {
 	RootKey  string
 	BIP39    bool
 	WalletDB string
+	Shares   []string
 }
 
 func newRootKey(cmd *cobra.Command, desc string) *rootKey {
@@ -191,6 +218,13 @@ func newRootKey(cmd *cobra.Command, desc string) *rootKey {
 			"instead of asking for a seed or providing the "+
 			"--rootkey flag",
 	)
+	cmd.Flags().StringSliceVar(
+		&r.Shares, "shares", nil, "combine the seed/master root key "+
+			"to use for "+desc+" from shares created with the "+
+			"splitseed command; specify the flag once per share "+
+			"instead of asking for a seed or providing the "+
+			"--rootkey flag",
+	)
 
 	return r
 }
@@ -246,6 +280,9 @@ func (r *rootKey) readWithBirthday() (*hdkeychain.ExtendedKey, time.Time,
 
 		return extendedKey, wallet.Manager.Birthday(), nil
 
+	case len(r.Shares) > 0:
+		return rootKeyFromShares(r.Shares)
+
 	default:
 		return lnd.ReadAezeed(chainParams)
 	}
@@ -344,11 +381,17 @@ func readInput(input string) ([]byte, error) {
 	return os.ReadFile(input)
 }
 
-func setupLogging() {
+func setupLogging(console io.Writer) {
 	logWriter := build.NewRotatingLogWriter()
-	subLogMgr := build.NewSubLoggerManager(build.NewDefaultLogHandlers(
-		build.DefaultLogConfig(), logWriter,
-	)...)
+	logConfig := build.DefaultLogConfig()
+	subLogMgr := build.NewSubLoggerManager(
+		btclog.NewDefaultHandler(
+			console, logConfig.Console.HandlerOptions()...,
+		),
+		btclog.NewDefaultHandler(
+			logWriter, logConfig.File.HandlerOptions()...,
+		),
+	)
 
 	log = build.NewSubLogger("CHAN", genSubLogger(subLogMgr))
 	log.SetLevel(btclog.LevelDebug)
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/coreos/bbolt"
+	"github.com/lightninglabs/chantools/rawbolt"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// The names of the channel DB buckets we look at more closely when
+	// reporting damages. See lnd's channeldb package.
+	salvageOpenChannelBucket   = []byte("open-chan-bucket")
+	salvageClosedChannelBucket = []byte("closed-chan-bucket")
+	salvageRevocationLogBucket = []byte("revocation-log")
+	salvageLegacyRevLogBucket  = []byte("revocation-log-key")
+)
+
+type salvageDBCommand struct {
+	TxMaxSize int64
+	SourceDB  string
+	DestDB    string
+
+	cmd *cobra.Command
+}
+
+func newSalvageDBCommand() *cobra.Command {
+	cc := &salvageDBCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "salvagedb",
+		Short: "Copy everything that is still readable from a " +
+			"corrupted channel.db file into a new file",
+		Long: `This command reads a bbolt database file (for example an
+lnd channel.db) page by page, without opening it with bbolt itself. All buckets
+and keys that can still be read are copied into a fresh database file, damaged
+pages are skipped.
+
+Unlike compactdb, this also works if the database has a corrupted freelist or
+if some of its pages are damaged. Every damaged part is reported, together with
+the channels it affects if it's part of the open or closed channel buckets or a
+revocation log. The new file can then be used with commands like forceclose or
+chanbackup, keeping in mind that the reported channels might be incomplete.
+
+CAUTION: Never use a salvaged channel.db with lnd itself without understanding
+what was lost. Running lnd with outdated or incomplete channel state can lead
+to loss of funds.`,
+		Example: `chantools salvagedb \
+	--sourcedb ~/.lnd/data/graph/mainnet/channel.db \
+	--destdb ./results/salvaged.db`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().Int64Var(
+		&cc.TxMaxSize, "txmaxsize", defaultTxMaxSize, "maximum "+
+			"transaction size",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SourceDB, "sourcedb", "", "corrupted lnd channel.db (or "+
+			"any other bbolt) file to salvage",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.DestDB, "destdb", "", "new file to copy the salvaged "+
+			"content to, must not exist yet",
+	)
+
+	return cc.cmd
+}
+
+func (c *salvageDBCommand) Execute(_ *cobra.Command, _ []string) error {
+	// Check that we have a source and destination channel DB.
+	if c.SourceDB == "" {
+		return errors.New("source DB is required")
+	}
+	if c.DestDB == "" {
+		return errors.New("destination DB is required")
+	}
+	if c.TxMaxSize <= 0 {
+		c.TxMaxSize = defaultTxMaxSize
+	}
+
+	// Merging into an existing DB would mix the salvaged content with
+	// whatever is already in there.
+	if lnrpc.FileExists(c.DestDB) {
+		return fmt.Errorf("destination DB %s already exists", c.DestDB)
+	}
+
+	src, err := rawbolt.Open(c.SourceDB)
+	if err != nil {
+		return fmt.Errorf("error reading source DB: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	compact := &compactDBCommand{}
+	dst, err := compact.openDB(c.DestDB, false)
+	if err != nil {
+		return fmt.Errorf("error opening destination DB: %w", err)
+	}
+	defer func() { _ = dst.Close() }()
+
+	result, err := salvageDB(src, dst, c.TxMaxSize)
+	if err != nil {
+		return fmt.Errorf("error salvaging DB: %w", err)
+	}
+
+	for _, damage := range result.damages {
+		log.Warnf("Lost %s", damage)
+	}
+	for _, skipped := range result.skipped {
+		log.Warnf("Skipped %s", skipped)
+	}
+
+	summary := fmt.Sprintf("Salvaged %d buckets and %d keys into %s, "+
+		"found %d damaged parts, skipped %d entries", result.buckets,
+		result.keys, c.DestDB, len(result.damages),
+		len(result.skipped))
+	fmt.Println(summary)
+	log.Infof(summary)
+
+	if len(result.channels) > 0 {
+		fmt.Println("The following channels are affected by the " +
+			"damage, their state in the salvaged DB might be " +
+			"incomplete:")
+		for _, channel := range result.channels {
+			fmt.Println("  " + channel)
+		}
+	}
+
+	return nil
+}
+
+// salvageResult is the outcome of salvaging a DB.
+type salvageResult struct {
+	buckets  int
+	keys     int
+	damages  []string
+	skipped  []string
+	channels []string
+}
+
+// salvageDB copies everything that can still be read from the source file into
+// the destination DB.
+func salvageDB(src *rawbolt.File, dst *bbolt.DB,
+	txMaxSize int64) (*salvageResult, error) {
+
+	var (
+		result         = &salvageResult{}
+		skippedBuckets = make(map[string]struct{})
+		channels       = make(map[string]struct{})
+		damages        []*rawbolt.Damage
+	)
+	addChannel := func(channel string) {
+		if _, ok := channels[channel]; !ok {
+			channels[channel] = struct{}{}
+			result.channels = append(result.channels, channel)
+		}
+	}
+
+	err := copyDB(dst, txMaxSize, func(walkFn walkFunc) error {
+		var err error
+		damages, err = src.Walk(func(keys [][]byte, k, v []byte,
+			seq uint64) error {
+
+			// The content of a bucket that couldn't be created
+			// can't be copied either.
+			for i := 1; i <= len(keys); i++ {
+				_, ok := skippedBuckets[salvagePath(keys[:i])]
+				if ok {
+					return nil
+				}
+			}
+
+			err := walkFn(keys, k, v, seq)
+			switch {
+			// Invalid or duplicate entries in the source are
+			// skipped, everything else is a problem with the
+			// destination DB. Because the destination DB is new,
+			// an existing bucket can only be a duplicate key in a
+			// damaged part of the source.
+			case errors.Is(err, bbolt.ErrBucketExists),
+				errors.Is(err, bbolt.ErrIncompatibleValue),
+				errors.Is(err, bbolt.ErrBucketNameRequired),
+				errors.Is(err, bbolt.ErrKeyRequired),
+				errors.Is(err, bbolt.ErrKeyTooLarge),
+				errors.Is(err, bbolt.ErrValueTooLarge):
+
+				path := append(keys[:len(keys):len(keys)], k)
+				if v == nil {
+					skippedBuckets[salvagePath(path)] =
+						struct{}{}
+				}
+				result.skipped = append(result.skipped,
+					fmt.Sprintf("%s: %v", salvagePath(path),
+						err))
+
+				channel := salvageChannel(path)
+				if channel != "" {
+					addChannel(channel)
+				}
+
+				return nil
+
+			case err != nil:
+				return err
+			}
+
+			if v == nil {
+				result.buckets++
+			} else {
+				result.keys++
+			}
+
+			return nil
+		})
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, damage := range damages {
+		description := fmt.Sprintf("page %d in bucket '%s'",
+			damage.Page, salvagePath(damage.Path))
+		if damage.FirstKey != nil {
+			description += fmt.Sprintf(" starting at key '%s'",
+				salvageKey(damage.FirstKey))
+		}
+		description += fmt.Sprintf(": %v", damage.Err)
+		result.damages = append(result.damages, description)
+
+		path := damage.Path
+		if damage.FirstKey != nil {
+			path = append(path[:len(path):len(path)],
+				damage.FirstKey)
+		}
+		if channel := salvageChannel(path); channel != "" {
+			addChannel(channel)
+		}
+	}
+
+	return result, nil
+}
+
+// salvageChannel returns a description of the channel the given bucket path
+// belongs to or an empty string if it's not part of the channel state.
+func salvageChannel(path [][]byte) string {
+	switch {
+	case len(path) == 0:
+		return ""
+
+	// The open channel bucket is nested by node public key, chain hash
+	// and channel point.
+	case bytes.Equal(path[0], salvageOpenChannelBucket):
+		if len(path) < 4 {
+			return "unknown open channels (damage in " +
+				salvagePath(path) + ")"
+		}
+
+		channel := "open channel " + salvageChanPoint(path[3])
+		for _, key := range path[4:] {
+			if bytes.Equal(key, salvageRevocationLogBucket) ||
+				bytes.Equal(key, salvageLegacyRevLogBucket) {
+
+				channel += " (revocation log)"
+				break
+			}
+		}
+
+		return channel
+
+	// The closed channel bucket maps the channel point to the summary.
+	case bytes.Equal(path[0], salvageClosedChannelBucket):
+		if len(path) < 2 {
+			return "unknown closed channels"
+		}
+
+		return "closed channel " + salvageChanPoint(path[1])
+
+	default:
+		return ""
+	}
+}
+
+// salvageChanPoint formats a channel point the way lnd serializes it as a
+// bucket key (the transaction hash followed by the big endian output index).
+func salvageChanPoint(key []byte) string {
+	if len(key) != chainhash.HashSize+4 {
+		return salvageKey(key)
+	}
+
+	var hash chainhash.Hash
+	copy(hash[:], key)
+
+	return fmt.Sprintf("%v:%d", hash,
+		binary.BigEndian.Uint32(key[chainhash.HashSize:]))
+}
+
+// salvagePath formats a list of bucket names.
+func salvagePath(path [][]byte) string {
+	names := make([]string, len(path))
+	for idx, name := range path {
+		names[idx] = salvageKey(name)
+	}
+
+	return "/" + strings.Join(names, "/")
+}
+
+// salvageKey formats a key as a string if it's printable or as hex otherwise.
+func salvageKey(key []byte) string {
+	for _, b := range key {
+		if b < 0x20 || b > 0x7e || b == '/' {
+			return hex.EncodeToString(key)
+		}
+	}
+
+	return string(key)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/lightninglabs/chantools/rawbolt"
+	"github.com/stretchr/testify/require"
+)
+
+// dbContent returns all buckets and key/value pairs of a DB file.
+func dbContent(t *testing.T, fileName string) map[string]string {
+	compact := &compactDBCommand{}
+	db, err := compact.openDB(fileName, true)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	content := make(map[string]string)
+	collect := func(keys [][]byte, k, v []byte, _ uint64) error {
+		path := append(keys[:len(keys):len(keys)], k)
+		content[salvagePath(path)] = string(v)
+
+		return nil
+	}
+	require.NoError(t, compact.walk(db, collect))
+
+	return content
+}
+
+func TestSalvageDB(t *testing.T) {
+	h := newHarness(t)
+
+	// Salvaging an intact DB copies everything.
+	salvage := &salvageDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("salvaged.db"),
+	}
+	err := salvage.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains("found 0 damaged parts, skipped 0 entries")
+	require.Equal(
+		t, dbContent(t, salvage.SourceDB), dbContent(t, salvage.DestDB),
+	)
+
+	dump := &dumpChannelsCommand{
+		ChannelDB: salvage.DestDB,
+	}
+	err = dump.Execute(nil, nil)
+	require.NoError(t, err)
+	h.assertLogContains("10279f62619634058b6133cb7ac6c1693a8e6df7caa91c" +
+		"6263ca3d0bf704ad4d:0")
+
+	// The salvaged content is never merged into an existing DB.
+	err = salvage.Execute(nil, nil)
+	require.ErrorContains(t, err, "already exists")
+}
+
+func TestSalvageDBCorrupted(t *testing.T) {
+	h := newHarness(t)
+
+	// Page 15 contains the state of one of the open channels.
+	const (
+		pageSize    = 4096
+		damagedPage = 15
+	)
+	data := h.readTestdataFile("channel.db")
+	for i := damagedPage*pageSize + 8; i < (damagedPage+1)*pageSize; i++ {
+		data[i] = 0xff
+	}
+	sourceDB := h.tempFile("corrupted.db")
+	require.NoError(t, os.WriteFile(sourceDB, data, 0600))
+
+	src, err := rawbolt.Open(sourceDB)
+	require.NoError(t, err)
+	defer func() { _ = src.Close() }()
+
+	compact := &compactDBCommand{}
+	dst, err := compact.openDB(h.tempFile("salvaged.db"), false)
+	require.NoError(t, err)
+	defer func() { _ = dst.Close() }()
+
+	result, err := salvageDB(src, dst, defaultTxMaxSize)
+	require.NoError(t, err)
+
+	require.Equal(t, 48, result.buckets)
+	require.Equal(t, 97, result.keys)
+	require.Len(t, result.damages, 1)
+	require.Contains(t, result.damages[0], "page 15 in bucket "+
+		"'/open-chan-bucket/02aad76b7ec22006f88588f3004a7e74be22023dd"+
+		"51fc2c1de7d5e15cd8c5311b8/")
+	require.Equal(t, []string{
+		"open channel 10279f62619634058b6133cb7ac6c1693a8e6df7caa91c" +
+			"6263ca3d0bf704ad4d:0",
+	}, result.channels)
+}
+
+func TestSalvageChannel(t *testing.T) {
+	chanPoint := make([]byte, 36)
+	chanPoint[0] = 0x01
+	chanPoint[35] = 0x02
+
+	openPath := [][]byte{
+		salvageOpenChannelBucket, {0x02}, {0x03}, chanPoint,
+	}
+	require.Equal(t, "open channel 00000000000000000000000000000000000"+
+		"00000000000000000000000000001:2", salvageChannel(openPath))
+	require.Equal(t, "open channel 00000000000000000000000000000000000"+
+		"00000000000000000000000000001:2 (revocation log)",
+		salvageChannel(append(openPath, salvageRevocationLogBucket)))
+	require.Contains(t, salvageChannel(openPath[:2]), "unknown open")
+
+	require.Equal(t, "closed channel 0000000000000000000000000000000000"+
+		"000000000000000000000000000001:2", salvageChannel([][]byte{
+		salvageClosedChannelBucket, chanPoint,
+	}))
+	require.Empty(t, salvageChannel([][]byte{[]byte("graph-edge")}))
+
+	require.Equal(t, "/open-chan-bucket/0203", salvagePath([][]byte{
+		salvageOpenChannelBucket, {0x02, 0x03},
+	}))
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultGapLimit = 20
+)
+
+// walletScanAPI is the part of the chain API the wallet scan needs.
+type walletScanAPI interface {
+	AddressInfo(addr string) (*btc.AddressStats, error)
+	Unspent(addr string) ([]*btc.Vout, error)
+}
+
+// walletAddrType is the type of address lnd creates for a wallet branch.
+type walletAddrType string
+
+const (
+	walletAddrNP2WKH walletAddrType = "np2wkh"
+	walletAddrP2WKH  walletAddrType = "p2wkh"
+	walletAddrP2TR   walletAddrType = "p2tr"
+)
+
+// walletScanBranch is a branch of an account that lnd creates addresses on
+// sequentially, which means the gap limit applies to it.
+type walletScanBranch struct {
+	path     string
+	addrType walletAddrType
+}
+
+// walletUtxo is an unspent output that belongs to a key of the wallet.
+type walletUtxo struct {
+	Outpoint string         `json:"outpoint"`
+	Value    uint64         `json:"value"`
+	Address  string         `json:"address"`
+	AddrType walletAddrType `json:"addr_type"`
+	Path     string         `json:"path"`
+
+	outpoint wire.OutPoint
+	pkScript []byte
+	key      *hdkeychain.ExtendedKey
+}
+
+// walletScanResult is the result of a wallet scan as written to the results
+// file.
+type walletScanResult struct {
+	TotalBalance uint64        `json:"total_balance"`
+	Utxos        []*walletUtxo `json:"utxos"`
+}
+
+type scanWalletCommand struct {
+	APIURL    string
+	GapLimit  uint32
+	Accounts  uint32
+	SweepAddr string
+	FeeRate   uint32
+	Publish   bool
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newScanWalletCommand() *cobra.Command {
+	cc := &scanWalletCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "scanwallet",
+		Short: "Scan the on-chain wallet of the seed for funds and " +
+			"optionally sweep them",
+		Long: `Derives the addresses of lnd's on-chain wallet from the
+seed and looks them up on the chain API to find all unspent outputs. The
+following branches are scanned for each account:
+ - np2wkh (m/49'/<coin type>'/<account>'): receive addresses are np2wkh, change
+   addresses are p2wkh
+ - p2wkh (m/84'/<coin type>'/<account>'): receive and change addresses
+ - p2tr (m/86'/<coin type>'/<account>'): receive and change addresses (BIP86
+   key spend only)
+ - the payment base key family (m/1017'/<coin type>'/3'/0), which legacy
+   channels pay the to_remote output to
+
+Addresses are derived one after another until --gaplimit addresses in a row
+have never received any funds. All unspent outputs and the total balance are
+shown and written to a results file.
+
+If --sweepaddr is given, all unspent outputs are swept to that address in a
+single transaction.`,
+		Example: `chantools scanwallet --gaplimit 50
+
+chantools scanwallet --accounts 2 \
+	--sweepaddr bc1q..... \
+	--feerate 10 \
+	--publish`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
+			"be esplora compatible)",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.GapLimit, "gaplimit", defaultGapLimit, "number of unused "+
+			"addresses in a row after which the scan of a branch "+
+			"is stopped",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.Accounts, "accounts", 1, "number of accounts to scan for "+
+			"each address type; set this higher if additional "+
+			"wallet accounts were created in lnd",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep all funds "+
+			"to; specify '"+lnd.AddressDeriveFromWallet+"' to "+
+			"derive a new address from the seed automatically; "+
+			"if empty, the funds are only shown",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
+			"API instead of just printing the TX",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving the wallet keys")
+
+	return cc.cmd
+}
+
+func (c *scanWalletCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	if c.SweepAddr != "" {
+		err = lnd.CheckAddress(
+			c.SweepAddr, chainParams, true, "sweep",
+			lnd.AddrTypeP2WKH, lnd.AddrTypeP2TR,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if c.GapLimit == 0 {
+		return errors.New("gap limit must be greater than zero")
+	}
+
+	api := newExplorerAPI(c.APIURL)
+	utxos, err := scanWallet(
+		api, extendedKey, chainParams, c.Accounts, c.GapLimit,
+	)
+	if err != nil {
+		return err
+	}
+
+	result := &walletScanResult{Utxos: utxos}
+	for _, utxo := range utxos {
+		result.TotalBalance += utxo.Value
+	}
+	log.Infof("Found %d unspent outputs with a total balance of %d sats",
+		len(utxos), result.TotalBalance)
+
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding result: %w", err)
+	}
+	fileName := fmt.Sprintf("%s/scanwallet-%s.json", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing result to %s", fileName)
+	if err := os.WriteFile(fileName, resultBytes, 0644); err != nil {
+		return err
+	}
+
+	if c.SweepAddr == "" || len(utxos) == 0 {
+		return nil
+	}
+
+	sweepTx, err := createWalletSweepTx(
+		utxos, c.SweepAddr, extendedKey, c.FeeRate,
+	)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := sweepTx.Serialize(&buf); err != nil {
+		return err
+	}
+
+	// Publish TX.
+	if c.Publish {
+		response, err := api.PublishTx(
+			hex.EncodeToString(buf.Bytes()),
+		)
+		if err != nil {
+			return err
+		}
+		log.Infof("Published TX %s, response: %s",
+			sweepTx.TxHash().String(), response)
+	}
+
+	log.Infof("Transaction: %x", buf.Bytes())
+
+	return nil
+}
+
+// walletScanBranches returns all branches of the given number of accounts
+// that lnd creates addresses on.
+func walletScanBranches(params *chaincfg.Params,
+	numAccounts uint32) []walletScanBranch {
+
+	var branches []walletScanBranch
+	for account := range numAccounts {
+		accountPath := func(purpose uint32, branch int) string {
+			return fmt.Sprintf("m/%d'/%d'/%d'/%d", purpose,
+				params.HDCoinType, account, branch)
+		}
+		branches = append(
+			branches,
+			walletScanBranch{accountPath(49, 0), walletAddrNP2WKH},
+			walletScanBranch{accountPath(49, 1), walletAddrP2WKH},
+			walletScanBranch{accountPath(84, 0), walletAddrP2WKH},
+			walletScanBranch{accountPath(84, 1), walletAddrP2WKH},
+			walletScanBranch{accountPath(86, 0), walletAddrP2TR},
+			walletScanBranch{accountPath(86, 1), walletAddrP2TR},
+		)
+	}
+
+	return append(branches, walletScanBranch{
+		path: fmt.Sprintf(
+			lnd.LndDerivationPath+"/0", params.HDCoinType,
+			uint32(keychain.KeyFamilyPaymentBase),
+		),
+		addrType: walletAddrP2WKH,
+	})
+}
+
+// walletAddress returns the address of the given type for a public key.
+func walletAddress(pubKey *btcec.PublicKey, addrType walletAddrType,
+	params *chaincfg.Params) (btcutil.Address, error) {
+
+	switch addrType {
+	case walletAddrNP2WKH:
+		return lnd.NP2WKHAddr(pubKey, params)
+
+	case walletAddrP2WKH:
+		return lnd.P2WKHAddr(pubKey, params)
+
+	case walletAddrP2TR:
+		return lnd.P2TRAddr(pubKey, params)
+
+	default:
+		return nil, fmt.Errorf("unknown address type %s", addrType)
+	}
+}
+
+// scanWallet scans all wallet branches for unspent outputs, stopping each
+// branch after gapLimit unused addresses in a row.
+func scanWallet(api walletScanAPI, rootKey *hdkeychain.ExtendedKey,
+	params *chaincfg.Params, numAccounts,
+	gapLimit uint32) ([]*walletUtxo, error) {
+
+	var utxos []*walletUtxo
+	for _, branch := range walletScanBranches(params, numAccounts) {
+		path, err := lnd.ParsePath(branch.path)
+		if err != nil {
+			return nil, err
+		}
+		branchKey, err := lnd.DeriveChildren(rootKey, path)
+		if err != nil {
+			return nil, fmt.Errorf("error deriving %s: %w",
+				branch.path, err)
+		}
+
+		var numUsed, gap uint32
+		for index := uint32(0); gap < gapLimit; index++ {
+			used, addrUtxos, err := scanWalletAddress(
+				api, branchKey, branch, index, params,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if !used {
+				gap++
+
+				continue
+			}
+
+			gap = 0
+			numUsed++
+			utxos = append(utxos, addrUtxos...)
+		}
+
+		log.Infof("Scanned %s: %d used addresses", branch.path, numUsed)
+	}
+
+	return utxos, nil
+}
+
+// scanWalletAddress looks up the address with the given index of a branch
+// and returns whether it was ever used and its unspent outputs.
+func scanWalletAddress(api walletScanAPI, branchKey *hdkeychain.ExtendedKey,
+	branch walletScanBranch, index uint32,
+	params *chaincfg.Params) (bool, []*walletUtxo, error) {
+
+	key, err := branchKey.DeriveNonStandard(index)
+	if err != nil {
+		return false, nil, err
+	}
+	pubKey, err := key.ECPubKey()
+	if err != nil {
+		return false, nil, err
+	}
+	addr, err := walletAddress(pubKey, branch.addrType, params)
+	if err != nil {
+		return false, nil, err
+	}
+
+	stats, err := api.AddressInfo(addr.String())
+	if err != nil {
+		return false, nil, fmt.Errorf("error looking up address %s: %w",
+			addr, err)
+	}
+	if stats.ChainStats.TXCount+stats.MempoolStats.TXCount == 0 {
+		return false, nil, nil
+	}
+
+	unspent, err := api.Unspent(addr.String())
+	if err != nil {
+		return false, nil, fmt.Errorf("error looking up unspent "+
+			"outputs of %s: %w", addr, err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return false, nil, err
+	}
+
+	utxos := make([]*walletUtxo, 0, len(unspent))
+	for _, vout := range unspent {
+		// The chain API stores the outpoint of unspent outputs in the
+		// outspend field.
+		txHash, err := chainhash.NewHashFromStr(vout.Outspend.Txid)
+		if err != nil {
+			return false, nil, err
+		}
+		utxo := &walletUtxo{
+			Value:    vout.Value,
+			Address:  addr.String(),
+			AddrType: branch.addrType,
+			Path:     fmt.Sprintf("%s/%d", branch.path, index),
+			outpoint: wire.OutPoint{
+				Hash:  *txHash,
+				Index: uint32(vout.Outspend.Vin),
+			},
+			pkScript: pkScript,
+			key:      key,
+		}
+		utxo.Outpoint = utxo.outpoint.String()
+		log.Infof("Found unspent output %s with %d sats on address %s "+
+			"(%s)", utxo.Outpoint, utxo.Value, utxo.Address,
+			utxo.Path)
+
+		utxos = append(utxos, utxo)
+	}
+
+	return true, utxos, nil
+}
+
+// createWalletSweepTx creates a transaction that sweeps all given wallet
+// outputs to the sweep address.
+func createWalletSweepTx(utxos []*walletUtxo, sweepAddr string,
+	rootKey *hdkeychain.ExtendedKey, feeRate uint32) (*wire.MsgTx, error) {
+
+	var estimator input.TxWeightEstimator
+	sweepScript, err := lnd.PrepareWalletAddress(
+		sweepAddr, chainParams, &estimator, rootKey, "sweep",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sweepTx := wire.NewMsgTx(2)
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut, len(utxos))
+	var totalInput btcutil.Amount
+	for _, utxo := range utxos {
+		switch utxo.AddrType {
+		case walletAddrNP2WKH:
+			estimator.AddNestedP2WKHInput()
+
+		case walletAddrP2WKH:
+			estimator.AddP2WKHInput()
+
+		case walletAddrP2TR:
+			estimator.AddTaprootKeySpendInput(
+				txscript.SigHashDefault,
+			)
+		}
+
+		sweepTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: utxo.outpoint,
+			Sequence:         mempool.MaxRBFSequence,
+		})
+		prevOuts[utxo.outpoint] = &wire.TxOut{
+			Value:    int64(utxo.Value),
+			PkScript: utxo.pkScript,
+		}
+		totalInput += btcutil.Amount(utxo.Value)
+	}
+
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(estimator.Weight())
+	if totalInput-totalFee < sweepDustLimit {
+		return nil, fmt.Errorf("total balance of %d sats is too low "+
+			"to pay the fee of %d sats", totalInput, totalFee)
+	}
+
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, totalInput, estimator.Weight())
+
+	sweepTx.AddTxOut(&wire.TxOut{
+		Value:    int64(totalInput - totalFee),
+		PkScript: sweepScript,
+	})
+
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(sweepTx, prevOutFetcher)
+	for idx, utxo := range utxos {
+		privKey, err := utxo.key.ECPrivKey()
+		if err != nil {
+			return nil, err
+		}
+
+		switch utxo.AddrType {
+		case walletAddrNP2WKH:
+			// The witness commits to the P2WKH script that is
+			// pushed as the redeem script in the signature script.
+			p2wkhAddr, err := lnd.P2WKHAddr(
+				privKey.PubKey(), chainParams,
+			)
+			if err != nil {
+				return nil, err
+			}
+			redeemScript, err := txscript.PayToAddrScript(p2wkhAddr)
+			if err != nil {
+				return nil, err
+			}
+			witness, err := txscript.WitnessSignature(
+				sweepTx, sigHashes, idx, int64(utxo.Value),
+				redeemScript, txscript.SigHashAll, privKey,
+				true,
+			)
+			if err != nil {
+				return nil, err
+			}
+			sigScript, err := txscript.NewScriptBuilder().AddData(
+				redeemScript,
+			).Script()
+			if err != nil {
+				return nil, err
+			}
+
+			sweepTx.TxIn[idx].Witness = witness
+			sweepTx.TxIn[idx].SignatureScript = sigScript
+
+		case walletAddrP2WKH:
+			witness, err := txscript.WitnessSignature(
+				sweepTx, sigHashes, idx, int64(utxo.Value),
+				utxo.pkScript, txscript.SigHashAll, privKey,
+				true,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			sweepTx.TxIn[idx].Witness = witness
+
+		case walletAddrP2TR:
+			// An empty script root hash results in the BIP86 key
+			// spend tweak.
+			rawSig, err := txscript.RawTxInTaprootSignature(
+				sweepTx, sigHashes, idx, int64(utxo.Value),
+				utxo.pkScript, []byte{},
+				txscript.SigHashDefault, privKey,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			sweepTx.TxIn[idx].Witness = wire.TxWitness{rawSig}
+		}
+	}
+
+	return sweepTx, nil
+}
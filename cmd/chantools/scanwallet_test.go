@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+// mockWalletScanAPI is a chain API that knows a fixed set of used addresses
+// and their unspent outputs.
+type mockWalletScanAPI struct {
+	used    map[string][]*btc.Vout
+	lookups int
+}
+
+func (m *mockWalletScanAPI) AddressInfo(addr string) (*btc.AddressStats,
+	error) {
+
+	m.lookups++
+	stats := &btc.AddressStats{
+		Address:      addr,
+		ChainStats:   &btc.Stats{},
+		MempoolStats: &btc.Stats{},
+	}
+	if _, ok := m.used[addr]; ok {
+		stats.ChainStats.TXCount = 1
+	}
+
+	return stats, nil
+}
+
+func (m *mockWalletScanAPI) Unspent(addr string) ([]*btc.Vout, error) {
+	return m.used[addr], nil
+}
+
+func testWalletAddress(t *testing.T, rootKey *hdkeychain.ExtendedKey,
+	path string, addrType walletAddrType) string {
+
+	parsedPath, err := lnd.ParsePath(path)
+	require.NoError(t, err)
+	key, err := lnd.DeriveChildren(rootKey, parsedPath)
+	require.NoError(t, err)
+	pubKey, err := key.ECPubKey()
+	require.NoError(t, err)
+	addr, err := walletAddress(pubKey, addrType, chainParams)
+	require.NoError(t, err)
+
+	return addr.String()
+}
+
+func TestScanWallet(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+
+	const gapLimit = 5
+	coinType := chainParams.HDCoinType
+
+	// The p2wkh address at index 9 is only found because the one at index
+	// 4 was used. The p2wkh address at index 15 is beyond the gap limit and
+	// must not be looked up.
+	np2wkhAddr := testWalletAddress(
+		t, extendedKey, fmt.Sprintf("m/49'/%d'/0'/0/0", coinType),
+		walletAddrNP2WKH,
+	)
+	p2wkhAddr := testWalletAddress(
+		t, extendedKey, fmt.Sprintf("m/84'/%d'/0'/0/4", coinType),
+		walletAddrP2WKH,
+	)
+	spentAddr := testWalletAddress(
+		t, extendedKey, fmt.Sprintf("m/84'/%d'/0'/0/9", coinType),
+		walletAddrP2WKH,
+	)
+	tooFarAddr := testWalletAddress(
+		t, extendedKey, fmt.Sprintf("m/84'/%d'/0'/0/15", coinType),
+		walletAddrP2WKH,
+	)
+	p2trAddr := testWalletAddress(
+		t, extendedKey, fmt.Sprintf("m/86'/%d'/0'/1/2", coinType),
+		walletAddrP2TR,
+	)
+
+	newVout := func(txid string, index int, value uint64) *btc.Vout {
+		return &btc.Vout{
+			Value:    value,
+			Outspend: &btc.Outspend{Txid: txid, Vin: index},
+		}
+	}
+	txid := strings.Repeat("01", 32)
+	api := &mockWalletScanAPI{
+		used: map[string][]*btc.Vout{
+			np2wkhAddr: {newVout(txid, 0, 100_000)},
+			p2wkhAddr: {
+				newVout(txid, 1, 200_000),
+				newVout(txid, 2, 300_000),
+			},
+			spentAddr:  nil,
+			tooFarAddr: {newVout(txid, 3, 400_000)},
+			p2trAddr:   {newVout(txid, 4, 500_000)},
+		},
+	}
+
+	utxos, err := scanWallet(api, extendedKey, chainParams, 1, gapLimit)
+	require.NoError(t, err)
+	require.Len(t, utxos, 4)
+
+	var total uint64
+	for _, utxo := range utxos {
+		total += utxo.Value
+		require.NotEqual(t, tooFarAddr, utxo.Address)
+	}
+	require.EqualValues(t, 1_100_000, total)
+	require.Equal(t, walletAddrNP2WKH, utxos[0].AddrType)
+	require.Equal(t, walletAddrP2TR, utxos[3].AddrType)
+	require.Equal(
+		t, fmt.Sprintf("m/86'/%d'/0'/1/2", coinType), utxos[3].Path,
+	)
+	require.Equal(t, txid+":4", utxos[3].Outpoint)
+
+	// Six wallet branches and the payment base branch are scanned. Each
+	// branch is looked up until the gap limit is reached after the last
+	// used address.
+	require.Equal(t, 7*gapLimit+1+10+3, api.lookups)
+	h.assertLogContains(fmt.Sprintf(
+		"Scanned m/84'/%d'/0'/0: 2 used addresses", coinType,
+	))
+
+	// All outputs are swept in a single valid transaction.
+	sweepAddr := testWalletAddress(
+		t, extendedKey, fmt.Sprintf("m/84'/%d'/0'/0/99", coinType),
+		walletAddrP2WKH,
+	)
+	sweepTx, err := createWalletSweepTx(utxos, sweepAddr, extendedKey, 10)
+	require.NoError(t, err)
+	require.Len(t, sweepTx.TxIn, 4)
+	require.Len(t, sweepTx.TxOut, 1)
+	require.Less(t, sweepTx.TxOut[0].Value, int64(total))
+
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut, len(utxos))
+	for _, utxo := range utxos {
+		prevOuts[utxo.outpoint] = &wire.TxOut{
+			Value:    int64(utxo.Value),
+			PkScript: utxo.pkScript,
+		}
+	}
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(sweepTx, prevOutFetcher)
+	for idx, utxo := range utxos {
+		vm, err := txscript.NewEngine(
+			utxo.pkScript, sweepTx, idx,
+			txscript.StandardVerifyFlags, nil, sigHashes,
+			int64(utxo.Value), prevOutFetcher,
+		)
+		require.NoError(t, err)
+		require.NoError(t, vm.Execute(), utxo.AddrType)
+	}
+
+	// Dust can't be swept.
+	_, err = createWalletSweepTx(
+		[]*walletUtxo{utxos[0]}, sweepAddr, extendedKey, 10_000,
+	)
+	require.ErrorContains(t, err, "too low")
+}
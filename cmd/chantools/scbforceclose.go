@@ -15,6 +15,7 @@ import (
 	"github.com/lightningnetwork/lnd/chanbackup"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/spf13/cobra"
 )
@@ -41,7 +42,13 @@ func newScbForceCloseCommand() *cobra.Command {
 		Use: "scbforceclose",
 		Short: "Force-close the last state that is in the SCB " +
 			"provided",
-		Long:    forceCloseWarning,
+		Long: forceCloseWarning + `
+
+Only lnd channel backups can be used. A Core Lightning emergency.recover file
+doesn't contain the commitment transaction and CLN's channel keys aren't
+derived from the lnd seed, so there is nothing that could be signed. Use the
+clntobackup command to convert it into a backup that can be used to ask the
+peers to force close the channels instead.`,
 		Example: `chantools scbforceclose --multi_file channel.backup`,
 		RunE:    cc.Execute,
 	}
@@ -107,57 +114,12 @@ func (c *scbForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 		signer.FetchPrivateKey,
 	)
 
-	var backups []chanbackup.Single
-	if c.SingleBackup != "" || c.SingleFile != "" {
-		if c.SingleBackup != "" && c.SingleFile != "" {
-			return errors.New("must not pass --single_backup and " +
-				"--single_file together")
-		}
-		var singleBackupBytes []byte
-		if c.SingleBackup != "" {
-			singleBackupBytes, err = hex.DecodeString(
-				c.SingleBackup,
-			)
-		} else if c.SingleFile != "" {
-			singleBackupBytes, err = os.ReadFile(c.SingleFile)
-		}
-		if err != nil {
-			return fmt.Errorf("failed to get single backup: %w",
-				err)
-		}
-		var s chanbackup.Single
-		r := bytes.NewReader(singleBackupBytes)
-		if err := s.UnpackFromReader(r, keyRing); err != nil {
-			return fmt.Errorf("failed to unpack single backup: %w",
-				err)
-		}
-		backups = append(backups, s)
-	}
-	if c.MultiBackup != "" || c.MultiFile != "" {
-		if len(backups) != 0 {
-			return errors.New("must not pass single and multi " +
-				"backups together")
-		}
-		if c.MultiBackup != "" && c.MultiFile != "" {
-			return errors.New("must not pass --multi_backup and " +
-				"--multi_file together")
-		}
-		var multiBackupBytes []byte
-		if c.MultiBackup != "" {
-			multiBackupBytes, err = hex.DecodeString(c.MultiBackup)
-		} else if c.MultiFile != "" {
-			multiBackupBytes, err = os.ReadFile(c.MultiFile)
-		}
-		if err != nil {
-			return fmt.Errorf("failed to get multi backup: %w", err)
-		}
-		var m chanbackup.Multi
-		r := bytes.NewReader(multiBackupBytes)
-		if err := m.UnpackFromReader(r, keyRing); err != nil {
-			return fmt.Errorf("failed to unpack multi backup: %w",
-				err)
-		}
-		backups = append(backups, m.StaticBackups...)
+	backups, err := readChannelBackups(
+		c.SingleBackup, c.SingleFile, c.MultiBackup, c.MultiFile,
+		keyRing,
+	)
+	if err != nil {
+		return err
 	}
 
 	backupsWithInputs := make([]chanbackup.Single, 0, len(backups))
@@ -261,6 +223,70 @@ func (c *scbForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
+// readChannelBackups reads and decrypts the channel backups given either as a
+// single or multi backup, hex encoded or as a file.
+func readChannelBackups(singleBackup, singleFile, multiBackup,
+	multiFile string, keyRing keychain.KeyRing) ([]chanbackup.Single,
+	error) {
+
+	var (
+		backups []chanbackup.Single
+		err     error
+	)
+	if singleBackup != "" || singleFile != "" {
+		if singleBackup != "" && singleFile != "" {
+			return nil, errors.New("must not pass " +
+				"--single_backup and --single_file together")
+		}
+		var singleBackupBytes []byte
+		if singleBackup != "" {
+			singleBackupBytes, err = hex.DecodeString(singleBackup)
+		} else if singleFile != "" {
+			singleBackupBytes, err = os.ReadFile(singleFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get single backup: "+
+				"%w", err)
+		}
+		var s chanbackup.Single
+		r := bytes.NewReader(singleBackupBytes)
+		if err := s.UnpackFromReader(r, keyRing); err != nil {
+			return nil, fmt.Errorf("failed to unpack single "+
+				"backup: %w", err)
+		}
+		backups = append(backups, s)
+	}
+	if multiBackup != "" || multiFile != "" {
+		if len(backups) != 0 {
+			return nil, errors.New("must not pass single and " +
+				"multi backups together")
+		}
+		if multiBackup != "" && multiFile != "" {
+			return nil, errors.New("must not pass --multi_backup " +
+				"and --multi_file together")
+		}
+		var multiBackupBytes []byte
+		if multiBackup != "" {
+			multiBackupBytes, err = hex.DecodeString(multiBackup)
+		} else if multiFile != "" {
+			multiBackupBytes, err = os.ReadFile(multiFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get multi backup: "+
+				"%w", err)
+		}
+		var m chanbackup.Multi
+		r := bytes.NewReader(multiBackupBytes)
+		if err := m.UnpackFromReader(r, keyRing); err != nil {
+			return nil, fmt.Errorf("failed to unpack multi "+
+				"backup: %w", err)
+		}
+		backups = append(backups, m.StaticBackups...)
+	}
+
+	return backups, nil
+}
+
 // classifyAndLogOutputs attempts to identify the to_remote output by comparing
 // against known script templates (p2wkh, delayed p2wsh, lease), marks 330-sat
 // anchors, and logs remaining outputs as to_local/htlc without deriving
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightninglabs/chantools/shamir"
+	"github.com/spf13/cobra"
+)
+
+const splitSeedFormat = `
+Share %d of %d (any %d shares recover the %v):
+%s
+`
+
+type splitSeedCommand struct {
+	Threshold uint8
+	NumShares uint8
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newSplitSeedCommand() *cobra.Command {
+	cc := &splitSeedCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "splitseed",
+		Short: "Split the lnd aezeed or a root key into M-of-N " +
+			"Shamir shares",
+		Long: `This command splits a secret into --num_shares shares of
+which any --threshold shares are needed to recover it. The shares can then be
+handed to different people (or stored in different places) so that no single
+person can access the funds on their own.
+
+If no root key flag is given, the 24 word lnd aezeed is split as it is. The
+aezeed passphrase is NOT part of the shares and is still required after the
+shares were combined. The aezeed is checked against the passphrase before it is
+split.
+
+If one of the --rootkey, --bip39, --walletdb or --shares flags is given, the
+BIP32 HD root key is split instead. This loses the wallet birthday of an aezeed.
+
+Each share is encoded as words of the BIP39 word list with a checksum. Shares
+can be combined again with the combineshares command or used directly as a root
+key source in all other commands with the --shares flag.`,
+		Example: `chantools splitseed --threshold 2 --num_shares 3
+
+chantools splitseed --threshold 3 --num_shares 5 \
+	--rootkey xprvxxxxxxxxxx`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().Uint8Var(
+		&cc.Threshold, "threshold", 2, "number of shares needed to "+
+			"recover the seed",
+	)
+	cc.cmd.Flags().Uint8Var(
+		&cc.NumShares, "num_shares", 3, "total number of shares to "+
+			"create",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "splitting")
+
+	return cc.cmd
+}
+
+func (c *splitSeedCommand) Execute(_ *cobra.Command, _ []string) error {
+	shares, err := c.splitShares()
+	if err != nil {
+		return err
+	}
+
+	for _, share := range shares {
+		result := fmt.Sprintf(
+			splitSeedFormat, share.Index, len(shares),
+			share.Threshold, share.Type,
+			strings.Join(share.Mnemonic(), " "),
+		)
+		fmt.Println(result)
+
+		// For the tests, also log as trace level which is disabled by
+		// default.
+		log.Tracef(result)
+	}
+
+	return nil
+}
+
+func (c *splitSeedCommand) splitShares() ([]*shamir.Share, error) {
+	r := c.rootKey
+	if r.RootKey != "" || r.BIP39 || r.WalletDB != "" ||
+		len(r.Shares) > 0 {
+
+		extendedKey, err := r.read()
+		if err != nil {
+			return nil, fmt.Errorf("error reading root key: %w",
+				err)
+		}
+		if extendedKey.Depth() != 0 || !extendedKey.IsPrivate() {
+			return nil, errors.New("only a private root key can " +
+				"be split")
+		}
+
+		privKey, err := extendedKey.ECPrivKey()
+		if err != nil {
+			return nil, fmt.Errorf("error deriving private key: %w",
+				err)
+		}
+
+		secret := make([]byte, 0, 64)
+		secret = append(secret, extendedKey.ChainCode()...)
+		secret = append(secret, privKey.Serialize()...)
+
+		return shamir.Split(
+			shamir.SecretRootKey, secret, c.Threshold, c.NumShares,
+		)
+	}
+
+	words, err := lnd.ReadMnemonicWords()
+	if err != nil {
+		return nil, err
+	}
+	mnemonic, err := checkedMnemonic(words)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println()
+
+	// Make sure we don't split a seed that can't be deciphered later on.
+	passphrase, err := lnd.ReadPassphrase("doesn't have")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := mnemonic.ToCipherSeed(passphrase); err != nil {
+		return nil, fmt.Errorf("failed to decrypt seed with "+
+			"passphrase: %w", err)
+	}
+
+	cipherText, err := lnd.MnemonicToCipherText(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+
+	return shamir.Split(
+		shamir.SecretAezeed, cipherText[:], c.Threshold, c.NumShares,
+	)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func shareMnemonics(t *testing.T, split *splitSeedCommand) []string {
+	shares, err := split.splitShares()
+	require.NoError(t, err)
+	require.Len(t, shares, int(split.NumShares))
+
+	mnemonics := make([]string, len(shares))
+	for idx, share := range shares {
+		mnemonics[idx] = strings.Join(share.Mnemonic(), " ")
+	}
+
+	return mnemonics
+}
+
+func TestSplitSeedAezeed(t *testing.T) {
+	h := newHarness(t)
+
+	split := &splitSeedCommand{
+		Threshold: 2,
+		NumShares: 3,
+		rootKey:   &rootKey{},
+	}
+
+	t.Setenv(lnd.MnemonicEnvName, seedAezeedWithPassphrase)
+	t.Setenv(lnd.PassphraseEnvName, testPassPhrase)
+
+	mnemonics := shareMnemonics(t, split)
+
+	// Combining the shares shows the original seed.
+	combine := &combineSharesCommand{
+		Shares: []string{mnemonics[2], mnemonics[0]},
+	}
+	err := combine.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(seedAezeedWithPassphrase)
+
+	// The shares can be used as a root key source directly, which still
+	// requires the passphrase.
+	r := &rootKey{Shares: mnemonics[1:]}
+	extendedKey, birthday, err := r.readWithBirthday()
+	require.NoError(t, err)
+	require.Equal(t, rootKeyAezeed, extendedKey.String())
+	require.Greater(t, birthday.Unix(), int64(0))
+
+	t.Setenv(lnd.PassphraseEnvName, "-")
+	_, _, err = r.readWithBirthday()
+	require.ErrorContains(t, err, "failed to decrypt seed")
+
+	// A single share isn't enough.
+	r = &rootKey{Shares: mnemonics[:1]}
+	_, _, err = r.readWithBirthday()
+	require.ErrorContains(t, err, "need 2 shares")
+
+	// A seed with the wrong passphrase isn't split.
+	_, err = split.splitShares()
+	require.ErrorContains(t, err, "failed to decrypt seed")
+}
+
+func TestSplitSeedRootKey(t *testing.T) {
+	h := newHarness(t)
+
+	split := &splitSeedCommand{
+		Threshold: 3,
+		NumShares: 5,
+		rootKey:   &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	mnemonics := shareMnemonics(t, split)
+
+	combine := &combineSharesCommand{
+		Shares: []string{mnemonics[4], mnemonics[1], mnemonics[3]},
+	}
+	err := combine.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(rootKeyAezeed)
+
+	r := &rootKey{Shares: mnemonics}
+	extendedKey, err := r.read()
+	require.NoError(t, err)
+	require.Equal(t, rootKeyAezeed, extendedKey.String())
+
+	// Shares can be split again with a different threshold.
+	split = &splitSeedCommand{
+		Threshold: 2,
+		NumShares: 2,
+		rootKey:   &rootKey{Shares: mnemonics[:3]},
+	}
+	mnemonics = shareMnemonics(t, split)
+
+	r = &rootKey{Shares: mnemonics}
+	extendedKey, err = r.read()
+	require.NoError(t, err)
+	require.Equal(t, rootKeyAezeed, extendedKey.String())
+
+	// Only a root key can be split.
+	childKey, err := extendedKey.Derive(0)
+	require.NoError(t, err)
+	split.rootKey = &rootKey{RootKey: childKey.String()}
+	_, err = split.splitShares()
+	require.ErrorContains(t, err, "only a private root key")
+}
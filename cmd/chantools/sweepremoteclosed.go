@@ -21,6 +21,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightninglabs/chantools/btc"
 	"github.com/lightninglabs/chantools/cln"
+	"github.com/lightninglabs/chantools/dataformat"
 	"github.com/lightninglabs/chantools/lnd"
 	"github.com/lightningnetwork/lnd/fn/v2"
 	"github.com/lightningnetwork/lnd/input"
@@ -46,9 +47,10 @@ type sweepRemoteClosedCommand struct {
 	SweepAddr      string
 	FeeRate        uint32
 
-	HsmSecret    string
-	PeerPubKeys  string
-	KnownOutputs string
+	HsmSecret       string
+	PeerPubKeys     string
+	KnownOutputs    string
+	ReestablishFile string
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -73,6 +75,12 @@ Supported remote force-closed channel types are:
  - STATIC_REMOTE_KEY (a.k.a. tweakless channels)
  - ANCHOR (a.k.a. anchor output channels)
  - SIMPLE_TAPROOT (a.k.a. simple taproot channels)
+
+Legacy channels (opened before 2019) additionally require the commit point of
+the remote party's commitment transaction. If the force close was triggered
+with the triggerforceclose command, the commit points the remote peers sent in
+their channel re-establish messages can be used by specifying the
+--reestablish_file flag (lnd only).
 `,
 		Example: `chantools sweepremoteclosed \
 	--recoverywindow 300 \
@@ -125,6 +133,13 @@ Supported remote force-closed channel types are:
 			"a file name to a file that contains the known "+
 			"outputs, one per line",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.ReestablishFile, "reestablish_file", "", "the file "+
+			"written by the triggerforceclose command that "+
+			"contains the channel re-establish messages received "+
+			"from the remote peers; the commit points in it are "+
+			"used to find funds of legacy channels",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "sweeping the wallet")
 
@@ -177,6 +192,10 @@ func (c *sweepRemoteClosedCommand) Execute(_ *cobra.Command, _ []string) error {
 	}
 
 	switch {
+	case c.HsmSecret != "" && c.ReestablishFile != "":
+		return errors.New("--reestablish_file is only supported for " +
+			"lnd nodes")
+
 	case c.HsmSecret != "":
 		secretBytes, err := hex.DecodeString(c.HsmSecret)
 		if err != nil {
@@ -247,8 +266,26 @@ func (c *sweepRemoteClosedCommand) Execute(_ *cobra.Command, _ []string) error {
 			ChainParams: chainParams,
 		}
 
+		var reestablishChans []ancientChannel
+		if c.ReestablishFile != "" {
+			file, err := dataformat.ReadReestablishFile(
+				c.ReestablishFile,
+			)
+			if err != nil {
+				return err
+			}
+
+			reestablishChans, err = reestablishChannels(
+				newExplorerAPI(c.APIURL), file,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
 		targets, err = findTargetsLnd(
 			extendedKey, c.APIURL, c.RecoveryWindow, knownOutputs,
+			reestablishChans,
 		)
 		if err != nil {
 			return fmt.Errorf("error finding targets: %w", err)
@@ -279,7 +316,8 @@ type targetAddr struct {
 }
 
 func findTargetsLnd(extendedKey *hdkeychain.ExtendedKey, apiURL string,
-	recoveryWindow uint32, knownOutputs []string) ([]*targetAddr, error) {
+	recoveryWindow uint32, knownOutputs []string,
+	reestablishChans []ancientChannel) ([]*targetAddr, error) {
 
 	var (
 		targets []*targetAddr
@@ -324,8 +362,14 @@ func findTargetsLnd(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 
 	// Also check if there are any funds in channels with the initial,
 	// tweaked channel type that requires a channel point.
+	var ancientChans []ancientChannel
+	err := json.Unmarshal(ancientChannelPoints, &ancientChans)
+	if err != nil {
+		return nil, err
+	}
 	ancientChannelTargets, err := checkAncientChannelPoints(
-		api, recoveryWindow, extendedKey,
+		api, ancientChans, &chaincfg.MainNetParams, recoveryWindow,
+		extendedKey,
 	)
 	if err != nil && !errors.Is(err, errAddrNotFound) {
 		return nil, fmt.Errorf("could not check ancient channel "+
@@ -336,6 +380,21 @@ func findTargetsLnd(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 		targets = append(targets, ancientChannelTargets...)
 	}
 
+	// The commit points of the re-establish messages we received from our
+	// peers can be used the same way as the ones of the ancient channels.
+	if len(reestablishChans) > 0 {
+		reestablishTargets, err := checkAncientChannelPoints(
+			api, reestablishChans, chainParams, recoveryWindow,
+			extendedKey,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not check re-establish "+
+				"commit points: %w", err)
+		}
+
+		targets = append(targets, reestablishTargets...)
+	}
+
 	return targets, nil
 }
 
@@ -665,8 +724,8 @@ type ancientChannel struct {
 	Node string `json:"node"`
 }
 
-func findAncientChannels(channels []ancientChannel, numKeys uint32,
-	key *hdkeychain.ExtendedKey) ([]ancientChannel, error) {
+func findAncientChannels(channels []ancientChannel, params *chaincfg.Params,
+	numKeys uint32, key *hdkeychain.ExtendedKey) ([]ancientChannel, error) {
 
 	if err := fillCache(numKeys, key); err != nil {
 		return nil, err
@@ -686,10 +745,9 @@ func findAncientChannels(channels []ancientChannel, numKeys uint32,
 				"point: %w", err)
 		}
 
-		// Create the address for the commit key. The addresses in the
-		// file are always for mainnet.
+		// Create the address for the commit key.
 		targetPubKeyHash, _, err := lnd.DecodeAddressHash(
-			channel.Addr, &chaincfg.MainNetParams,
+			channel.Addr, params,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing addr: %w", err)
@@ -711,16 +769,13 @@ func findAncientChannels(channels []ancientChannel, numKeys uint32,
 	return foundChannels, nil
 }
 
-func checkAncientChannelPoints(api *btc.ExplorerAPI, numKeys uint32,
+func checkAncientChannelPoints(api *btc.ExplorerAPI, channels []ancientChannel,
+	params *chaincfg.Params, numKeys uint32,
 	key *hdkeychain.ExtendedKey) ([]*targetAddr, error) {
 
-	var channels []ancientChannel
-	err := json.Unmarshal(ancientChannelPoints, &channels)
-	if err != nil {
-		return nil, err
-	}
-
-	ancientChannels, err := findAncientChannels(channels, numKeys, key)
+	ancientChannels, err := findAncientChannels(
+		channels, params, numKeys, key,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -739,17 +794,14 @@ func checkAncientChannelPoints(api *btc.ExplorerAPI, numKeys uint32,
 				"%w", err)
 		}
 
-		// Create the address for the commit key. The addresses in the
-		// file are always for mainnet.
+		// Create the address for the commit key.
 		targetPubKeyHash, _, err := lnd.DecodeAddressHash(
-			ancientChannel.Addr, &chaincfg.MainNetParams,
+			ancientChannel.Addr, params,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing addr: %w", err)
 		}
-		addr, err := lnd.ParseAddress(
-			ancientChannel.Addr, &chaincfg.MainNetParams,
-		)
+		addr, err := lnd.ParseAddress(ancientChannel.Addr, params)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing addr: %w", err)
 		}
@@ -781,6 +833,83 @@ func checkAncientChannelPoints(api *btc.ExplorerAPI, numKeys uint32,
 	return targets, nil
 }
 
+// reestablishChannels looks up the closing transaction of each channel in the
+// given re-establish file and returns all its P2WKH outputs together with the
+// commit point the remote peer sent us. A peer can send the same message more
+// than once, each output is only returned once per commit point so it isn't
+// added to the sweep transaction twice.
+func reestablishChannels(api *btc.ExplorerAPI,
+	file *dataformat.ReestablishFile) ([]ancientChannel, error) {
+
+	var (
+		channels []ancientChannel
+		seen     = make(map[ancientChannel]struct{})
+	)
+	for _, msg := range file.Messages {
+		// Without a channel point we can't find the closing
+		// transaction.
+		if msg.ChannelPoint == "" ||
+			msg.LocalUnrevokedCommitPoint == "" {
+
+			continue
+		}
+
+		op, err := parseOutPoint(msg.ChannelPoint)
+		if err != nil {
+			return nil, err
+		}
+
+		fundingTx, err := api.Transaction(op.Hash.String())
+		if err != nil {
+			return nil, fmt.Errorf("error fetching funding "+
+				"transaction of channel %s: %w",
+				msg.ChannelPoint, err)
+		}
+		if int(op.Index) >= len(fundingTx.Vout) {
+			return nil, fmt.Errorf("invalid channel point %s",
+				msg.ChannelPoint)
+		}
+
+		outspend := fundingTx.Vout[op.Index].Outspend
+		if outspend == nil || !outspend.Spent {
+			log.Infof("Channel %s is not closed yet, skipping",
+				msg.ChannelPoint)
+			continue
+		}
+
+		closeTx, err := api.Transaction(outspend.Txid)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching closing "+
+				"transaction of channel %s: %w",
+				msg.ChannelPoint, err)
+		}
+
+		for idx, vout := range closeTx.Vout {
+			if vout.ScriptPubkeyType != "v0_p2wpkh" {
+				continue
+			}
+
+			channel := ancientChannel{
+				OP: fmt.Sprintf("%s:%d", closeTx.TXID, idx),
+				CP: msg.LocalUnrevokedCommitPoint,
+			}
+			if _, ok := seen[channel]; ok {
+				continue
+			}
+			seen[channel] = struct{}{}
+
+			channel.Addr = vout.ScriptPubkeyAddr
+			channel.Node = msg.Peer
+			channels = append(channels, channel)
+		}
+	}
+
+	log.Infof("Found %d closing transaction outputs for the channels in "+
+		"the re-establish file", len(channels))
+
+	return channels, nil
+}
+
 func listOrFile(listOrPath string) ([]string, error) {
 	if lnrpc.FileExists(lncfg.CleanAndExpandPath(listOrPath)) {
 		contents, err := os.ReadFile(listOrPath)
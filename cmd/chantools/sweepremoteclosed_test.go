@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -12,6 +15,8 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/dataformat"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,7 +38,9 @@ func TestAncientKeyDerivation(t *testing.T) {
 	oldRootKey, err := hdkeychain.NewKeyFromString(oldNodeRootKey)
 	require.NoError(t, err)
 
-	oldChans, err := findAncientChannels(ancients, 5, oldRootKey)
+	oldChans, err := findAncientChannels(
+		ancients, &chaincfg.MainNetParams, 5, oldRootKey,
+	)
 	require.NoError(t, err)
 
 	require.NotEmpty(t, oldChans)
@@ -107,3 +114,88 @@ func TestCreateTx(t *testing.T) {
 	require.NoError(t, err)
 	t.Logf("%x", buf.Bytes())
 }
+
+func TestReestablishChannelsDuplicates(t *testing.T) {
+	h := newHarness(t)
+
+	const (
+		fundingTxid = "1111111111111111111111111111111111111111111111111" +
+			"111111111111111"
+		closeTxid = "2222222222222222222222222222222222222222222222222" +
+			"222222222222222"
+		commitPoint = "02aad76b7ec22006f88588f3004a7e74be22023dd51fc2c1" +
+			"de7d5e15cd8c5311b8"
+	)
+	api := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var response any
+			switch r.URL.Path {
+			case "/tx/" + fundingTxid:
+				response = &btc.TX{
+					TXID: fundingTxid,
+					Vout: []*btc.Vout{{}},
+				}
+
+			case "/tx/" + fundingTxid + "/outspend/0":
+				response = &btc.Outspend{
+					Spent: true,
+					Txid:  closeTxid,
+				}
+
+			case "/tx/" + closeTxid:
+				response = &btc.TX{
+					TXID: closeTxid,
+					Vout: []*btc.Vout{{
+						ScriptPubkeyType: "v0_p2wsh",
+					}, {
+						ScriptPubkeyType: "v0_p2wpkh",
+						ScriptPubkeyAddr: "bcrt1qaddr",
+					}},
+				}
+
+			default:
+				response = &btc.Outspend{}
+			}
+
+			require.NoError(t, json.NewEncoder(w).Encode(response))
+		},
+	))
+	defer api.Close()
+
+	// The peer sent the same message twice, the output must only be
+	// swept once.
+	msg := &dataformat.ChannelReestablish{
+		Peer:                      "peer",
+		ChannelPoint:              fundingTxid + ":0",
+		LocalUnrevokedCommitPoint: commitPoint,
+	}
+	file := &dataformat.ReestablishFile{
+		Messages: []*dataformat.ChannelReestablish{msg, msg},
+	}
+	channels, err := reestablishChannels(
+		&btc.ExplorerAPI{BaseURL: api.URL}, file,
+	)
+	require.NoError(t, err)
+	require.Equal(t, []ancientChannel{{
+		OP:   closeTxid + ":1",
+		Addr: "bcrt1qaddr",
+		CP:   commitPoint,
+		Node: "peer",
+	}}, channels)
+
+	// Duplicates are also not written to the file in the first place.
+	ResultsDir = h.tempDir
+	duplicate := *msg
+	require.NoError(t, writeReestablishFile([]*forceCloseReport{{
+		reestablishes: []*dataformat.ChannelReestablish{msg},
+	}, {
+		reestablishes: []*dataformat.ChannelReestablish{&duplicate},
+	}}))
+
+	fileNames, err := filepath.Glob(h.tempFile("reestablish-*.json"))
+	require.NoError(t, err)
+	require.Len(t, fileNames, 1)
+	written, err := dataformat.ReadReestablishFile(fileNames[0])
+	require.NoError(t, err)
+	require.Equal(t, []*dataformat.ChannelReestablish{msg}, written.Messages)
+}
@@ -2,27 +2,29 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/btcsuite/btcd/connmgr"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/hasura/go-graphql-client"
 	"github.com/lightninglabs/chantools/btc"
 	"github.com/lightninglabs/chantools/cln"
+	"github.com/lightninglabs/chantools/dataformat"
 	"github.com/lightninglabs/chantools/lnd"
 	"github.com/lightningnetwork/lnd/brontide"
 	"github.com/lightningnetwork/lnd/fn/v2"
 	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/lightningnetwork/lnd/lncfg"
 	"github.com/lightningnetwork/lnd/lnwire"
-	"github.com/lightningnetwork/lnd/peer"
 	"github.com/lightningnetwork/lnd/tor"
 	"github.com/spf13/cobra"
 )
@@ -31,6 +33,24 @@ var (
 	dialTimeout = time.Minute
 
 	defaultTorDNSHostPort = "soa.nodes.lightning.directory:53"
+
+	// reestablishReplyTimeout is the time we wait for the peer to answer
+	// our channel re-establish message.
+	reestablishReplyTimeout = 10 * time.Second
+
+	// errorReplyTimeout is the time we give the peer to process our error
+	// message before we disconnect.
+	errorReplyTimeout = 5 * time.Second
+
+	// closingTxPollInterval is the interval in which we check the chain
+	// for closing transactions.
+	closingTxPollInterval = 10 * time.Second
+)
+
+const (
+	defaultForceCloseWorkers = 10
+	defaultForceCloseRetries = 2
+	defaultCloseWait         = 2 * time.Minute
 )
 
 type triggerForceCloseCommand struct {
@@ -40,6 +60,11 @@ type triggerForceCloseCommand struct {
 	APIURL            string
 	AllPublicChannels bool
 
+	Workers     uint32
+	PeerTimeout time.Duration
+	Retries     uint32
+	CloseWait   time.Duration
+
 	TorProxy string
 
 	HsmSecret string
@@ -58,10 +83,26 @@ func newTriggerForceCloseCommand() *cobra.Command {
 		Long: `Asks the specified remote peer to force close a specific
 channel by first sending a channel re-establish message, and if that doesn't
 work, a custom error message (in case the peer is a specific version of CLN that
-does not properly respond to a Data Loss Protection re-establish message).'`,
+does not properly respond to a Data Loss Protection re-establish message).'
+
+When using --all_public_channels, the peers are contacted in parallel by
+--workers workers. All channels with the same peer are handled over a single
+connection. Every advertised address of a peer is tried (.onion addresses only
+if --torproxy is set), up to --retries additional times. After
+all peers were contacted, the command waits up to --close_wait for the closing
+transactions to appear on chain and then writes a JSON report with the outcome
+for each channel to the results directory.
+
+All channel re-establish messages received from the peers are written to a
+reestablish-xxxx.json file in the results directory. The commit points they
+contain can be used with the --reestablish_file flag of the rescueclosed and
+sweepremoteclosed commands to recover funds from legacy channels.`,
 		Example: `chantools triggerforceclose \
 	--peer 03abce...@xx.yy.zz.aa:9735 \
-	--channel_point abcdef01234...:x`,
+	--channel_point abcdef01234...:x
+
+chantools triggerforceclose --all_public_channels \
+	--workers 20 --torproxy 127.0.0.1:9050`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
@@ -82,6 +123,26 @@ does not properly respond to a Data Loss Protection re-establish message).'`,
 		"query all public channels from the Amboss API and attempt "+
 			"to trigger a force close for each of them",
 	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.Workers, "workers", defaultForceCloseWorkers, "number of "+
+			"peers to contact in parallel when using "+
+			"--all_public_channels",
+	)
+	cc.cmd.Flags().DurationVar(
+		&cc.PeerTimeout, "peer_timeout", dialTimeout, "maximum time "+
+			"to spend on a single connection attempt to a peer",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.Retries, "retries", defaultForceCloseRetries, "number of "+
+			"times to retry all addresses of a peer if none of "+
+			"them could be reached",
+	)
+	cc.cmd.Flags().DurationVar(
+		&cc.CloseWait, "close_wait", defaultCloseWait, "time to "+
+			"wait for the closing transactions to appear on "+
+			"chain after all peers were contacted when using "+
+			"--all_public_channels",
+	)
 	cc.cmd.Flags().StringVar(
 		&cc.TorProxy, "torproxy", "", "SOCKS5 proxy to use for Tor "+
 			"connections (to .onion addresses)",
@@ -97,6 +158,46 @@ does not properly respond to a Data Loss Protection re-establish message).'`,
 	return cc.cmd
 }
 
+// forceCloseJob describes the channels we want a remote peer to force close.
+// All channels of a peer are handled over the same connection, since the peer
+// would drop one of two parallel connections with the same node identity.
+type forceCloseJob struct {
+	chanPoints []string
+	peer       string
+	addresses  []string
+}
+
+// forceCloseConfig holds the parameters for contacting a peer.
+type forceCloseConfig struct {
+	torProxy    string
+	peerTimeout time.Duration
+	retries     uint32
+}
+
+// forceCloseReport is the outcome of the attempt to trigger the force close of
+// a single channel.
+type forceCloseReport struct {
+	ChannelPoint     string   `json:"channel_point"`
+	Peer             string   `json:"peer"`
+	AddressesTried   []string `json:"addresses_tried"`
+	Attempts         uint32   `json:"attempts"`
+	Connected        bool     `json:"connected"`
+	Address          string   `json:"connected_address,omitempty"`
+	ReestablishAcked bool     `json:"reestablish_acknowledged"`
+	ErrorSent        bool     `json:"error_sent"`
+	PeerError        string   `json:"peer_error,omitempty"`
+	ClosingTx        string   `json:"closing_tx,omitempty"`
+	Error            string   `json:"error,omitempty"`
+
+	closingOutputs []string
+	reestablishes  []*dataformat.ChannelReestablish
+}
+
+// closingTxLookup returns the transaction that spent the given channel's
+// funding output together with its output addresses. An empty transaction ID
+// is returned if the channel output is still unspent.
+type closingTxLookup func(chanPoint string) (string, []string, error)
+
 func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 	var identityPriv *btcec.PrivateKey
 	switch {
@@ -136,13 +237,24 @@ func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 		}
 	}
 
+	identity := &keychain.PrivKeyECDH{
+		PrivKey: identityPriv,
+	}
+	cfg := &forceCloseConfig{
+		torProxy:    c.TorProxy,
+		peerTimeout: c.PeerTimeout,
+		retries:     c.Retries,
+	}
+
 	api := newExplorerAPI(c.APIURL)
+	lookup := func(chanPoint string) (string, []string, error) {
+		return closingTx(api, chanPoint)
+	}
 	switch {
 	case c.ChannelPoint != "" && c.Peer != "":
-		_, err := closeChannel(
-			identityPriv, api, c.ChannelPoint, c.Peer, c.TorProxy,
+		return closeChannel(
+			identity, lookup, c.ChannelPoint, c.Peer, cfg,
 		)
-		return err
 
 	case c.AllPublicChannels:
 		client := graphql.NewClient(
@@ -163,174 +275,312 @@ func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 		})
 
 		log.Infof("Found %d public open channels, attempting to force "+
-			"close each of them", len(channels))
+			"close each of them using %d workers", len(channels),
+			c.Workers)
 
-		var (
-			pubKeys []string
-			outputs []string
-		)
-		for idx, openChan := range channels {
-			addr := pickAddr(openChan.Node2Info.Node.Addresses)
-			peerAddr := fmt.Sprintf("%s@%s", openChan.Node2, addr)
+		jobs := groupForceCloseJobs(channels)
+		process := func(job *forceCloseJob) []*forceCloseReport {
+			return triggerForceClose(identity, job, cfg)
+		}
+		reports := runForceCloseWorkers(jobs, c.Workers, process)
 
-			if c.TorProxy == "" &&
-				strings.Contains(addr, ".onion") {
+		log.Infof("Contacted all peers, waiting up to %v for closing "+
+			"transactions to appear on chain", c.CloseWait)
+		waitForClosingTxs(reports, lookup, c.CloseWait)
 
-				log.Infof("Skipping channel %s with peer %s "+
-					"because it is a Tor address and no "+
-					"Tor proxy is configured",
-					openChan.ChanPoint, peerAddr)
-				continue
-			}
+		return writeForceCloseResults(reports)
 
-			log.Infof("Attempting to force close channel %s with "+
-				"peer %s (channel %d of %d)",
-				openChan.ChanPoint, peerAddr, idx+1,
-				len(channels))
+	default:
+		return errors.New("either --channel_point and --peer or " +
+			"--all_public_channels must be specified")
+	}
+}
 
-			outputAddrs, err := closeChannel(
-				identityPriv, api, openChan.ChanPoint,
-				peerAddr, c.TorProxy,
-			)
-			if err != nil {
-				log.Errorf("Error closing channel %s, "+
-					"skipping and trying next one. "+
-					"Reason: %v", openChan.ChanPoint, err)
-				continue
-			}
+// closeChannel triggers the force close of a single channel and waits for the
+// closing transaction to appear on chain.
+func closeChannel(identity keychain.SingleKeyECDH, lookup closingTxLookup,
+	channelPoint, peer string, cfg *forceCloseConfig) error {
 
-			pubKeys = append(pubKeys, openChan.Node2)
-			outputs = append(outputs, outputAddrs...)
-		}
+	pubKey, host, found := strings.Cut(peer, "@")
+	if !found {
+		return fmt.Errorf("invalid peer address %s, expected "+
+			"<pubkey>@<host>[:<port>]", peer)
+	}
+	job := &forceCloseJob{
+		chanPoints: []string{channelPoint},
+		peer:       pubKey,
+		addresses:  []string{host},
+	}
 
-		peersBytes := []byte(strings.Join(pubKeys, "\n"))
-		outputsBytes := []byte(strings.Join(outputs, "\n"))
+	report := triggerForceClose(identity, job, cfg)[0]
+	err := writeReestablishFile([]*forceCloseReport{report})
+	if err != nil {
+		return err
+	}
+	if !report.ErrorSent {
+		return fmt.Errorf("error requesting force close: %s",
+			report.Error)
+	}
+
+	log.Infof("Message sent, waiting for force close transaction to " +
+		"appear in mempool")
 
-		fileName := fmt.Sprintf("%s/forceclose-peers-%s.txt",
-			ResultsDir, time.Now().Format("2006-01-02"))
-		log.Infof("Writing peers to %s", fileName)
-		err = os.WriteFile(fileName, peersBytes, 0644)
+	var (
+		txid    string
+		outputs []string
+	)
+	for counter := 0; ; counter++ {
+		txid, outputs, err = lookup(channelPoint)
 		if err != nil {
-			return fmt.Errorf("error writing peers to file: %w",
-				err)
+			return err
+		}
+		if txid != "" {
+			break
 		}
 
-		fileName = fmt.Sprintf("%s/forceclose-addresses-%s.txt",
-			ResultsDir, time.Now().Format("2006-01-02"))
-		log.Infof("Writing addresses to %s", fileName)
-		return os.WriteFile(fileName, outputsBytes, 0644)
+		if counter == 6 {
+			log.Info("Waited 30 seconds, still no spends found, " +
+				"re-triggering force close request")
+			retry := triggerForceClose(identity, job, cfg)[0]
+			if !retry.ErrorSent {
+				return fmt.Errorf("error re-triggering force "+
+					"close: %s", retry.Error)
+			}
+		}
+		if counter >= 12 {
+			return errors.New("no spends found after 60 " +
+				"seconds, aborting re-try loop")
+		}
 
-	default:
-		return errors.New("either --channel_point and --peer or " +
-			"--all_public_channels must be specified")
+		log.Infof("No spends found yet, waiting 5 seconds...")
+		time.Sleep(5 * time.Second)
 	}
-}
 
-func pickAddr(addrs []*gqAddress) string {
-	// If there's only one address, we'll just return that one.
-	if len(addrs) == 1 {
-		return addrs[0].Address
-	}
+	log.Infof("Found force close transaction %v", txid)
+	log.Infof("Channel output addresses: %s", strings.Join(outputs, ", "))
+	log.Infof("You can now use the sweepremoteclosed command to sweep " +
+		"the funds from the channel")
 
-	// We'll pick the first address that is not a Tor address.
-	for _, addr := range addrs {
-		if !strings.Contains(addr.Address, ".onion") {
-			return addr.Address
+	return nil
+}
+
+// groupForceCloseJobs creates one job per peer that contains all channels we
+// have with that peer. The jobs are in the order the peers first appear in.
+func groupForceCloseJobs(channels []*gqChannel) []*forceCloseJob {
+	var (
+		jobs   []*forceCloseJob
+		byPeer = make(map[string]*forceCloseJob)
+	)
+	for _, openChan := range channels {
+		job, ok := byPeer[openChan.Node2]
+		if !ok {
+			job = &forceCloseJob{
+				peer: openChan.Node2,
+				addresses: fn.Map(
+					openChan.Node2Info.Node.Addresses,
+					func(a *gqAddress) string {
+						return a.Address
+					},
+				),
+			}
+			byPeer[openChan.Node2] = job
+			jobs = append(jobs, job)
 		}
-	}
 
-	// If all addresses are Tor addresses, we'll just return the first one.
-	if len(addrs) > 0 {
-		return addrs[0].Address
+		job.chanPoints = append(job.chanPoints, openChan.ChanPoint)
 	}
 
-	return ""
+	return jobs
 }
 
-func closeChannel(identityPriv *btcec.PrivateKey, api *btc.ExplorerAPI,
-	channelPoint, peer, torProxy string) ([]string, error) {
+// runForceCloseWorkers processes all jobs with the given number of parallel
+// workers. The returned reports are in the same order as the jobs and their
+// channels.
+func runForceCloseWorkers(jobs []*forceCloseJob, numWorkers uint32,
+	process func(*forceCloseJob) []*forceCloseReport) []*forceCloseReport {
 
-	identityECDH := &keychain.PrivKeyECDH{
-		PrivKey: identityPriv,
+	if numWorkers == 0 {
+		numWorkers = 1
 	}
 
-	outPoint, err := parseOutPoint(channelPoint)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing channel point: %w", err)
+	var (
+		jobReports = make([][]*forceCloseReport, len(jobs))
+		jobIndex   = make(chan int)
+		wg         sync.WaitGroup
+	)
+	for range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range jobIndex {
+				job := jobs[idx]
+				log.Infof("Attempting to force close %d "+
+					"channel(s) with peer %s (peer %d of "+
+					"%d)", len(job.chanPoints), job.peer,
+					idx+1, len(jobs))
+
+				jobReports[idx] = process(job)
+			}
+		}()
 	}
 
-	err = requestForceCloseLnd(peer, torProxy, *outPoint, identityECDH)
-	if err != nil {
-		return nil, fmt.Errorf("error requesting force close: %w", err)
+	for idx := range jobs {
+		jobIndex <- idx
 	}
-	err = requestForceCloseCln(peer, torProxy, *outPoint, identityECDH)
-	if err != nil {
-		return nil, fmt.Errorf("error requesting force close: %w", err)
+	close(jobIndex)
+	wg.Wait()
+
+	var reports []*forceCloseReport
+	for _, r := range jobReports {
+		reports = append(reports, r...)
 	}
 
-	log.Infof("Message sent, waiting for force close transaction to " +
-		"appear in mempool")
+	return reports
+}
 
-	channelAddress, err := api.Address(channelPoint)
-	if err != nil {
-		return nil, fmt.Errorf("error getting channel address: %w", err)
+// usableAddrs returns the addresses we can dial, clearnet addresses first.
+// Tor addresses are only returned if we have a Tor proxy.
+func usableAddrs(addrs []string, torEnabled bool) []string {
+	var clearnet, onion []string
+	for _, addr := range addrs {
+		if strings.Contains(addr, ".onion") {
+			onion = append(onion, addr)
+			continue
+		}
+
+		clearnet = append(clearnet, addr)
 	}
 
-	spends, err := api.Spends(channelAddress)
-	if err != nil {
-		return nil, fmt.Errorf("error getting spends: %w", err)
+	if !torEnabled {
+		return clearnet
 	}
 
-	counter := 0
-	for len(spends) == 0 {
-		log.Infof("No spends found yet, waiting 5 seconds...")
-		time.Sleep(5 * time.Second)
-		spends, err = api.Spends(channelAddress)
-		if err != nil {
-			return nil, fmt.Errorf("error getting spends: %w", err)
+	return append(clearnet, onion...)
+}
+
+// forceCloseTarget is a single channel of a job together with its report.
+type forceCloseTarget struct {
+	chanPoint wire.OutPoint
+	report    *forceCloseReport
+}
+
+// triggerForceClose tries to reach the peer of the job on any of its addresses
+// and asks it to force close all channels of the job over a single connection.
+// All addresses are tried again up to the configured number of retries until
+// the messages for all channels were delivered. One report per channel of the
+// job is returned, in the same order.
+func triggerForceClose(identity keychain.SingleKeyECDH, job *forceCloseJob,
+	cfg *forceCloseConfig) []*forceCloseReport {
+
+	var (
+		reports = make([]*forceCloseReport, len(job.chanPoints))
+		targets []*forceCloseTarget
+	)
+	for idx, chanPoint := range job.chanPoints {
+		reports[idx] = &forceCloseReport{
+			ChannelPoint: chanPoint,
+			Peer:         job.peer,
 		}
 
-		counter++
-		if counter >= 6 {
-			log.Info("Waited 30 seconds, still no spends found, " +
-				"re-triggering CLN request")
-			err = requestForceCloseCln(
-				peer, torProxy, *outPoint, identityECDH,
-			)
-			if err != nil {
-				return nil, fmt.Errorf("error requesting "+
-					"force close: %w", err)
-			}
+		outPoint, err := parseOutPoint(chanPoint)
+		if err != nil {
+			reports[idx].Error = fmt.Sprintf("error parsing "+
+				"channel point: %v", err)
+			continue
 		}
-		if counter >= 12 {
-			return nil, errors.New("no spends found after 60 " +
-				"seconds, aborting re-try loop")
+
+		targets = append(targets, &forceCloseTarget{
+			chanPoint: *outPoint,
+			report:    reports[idx],
+		})
+	}
+	if len(targets) == 0 {
+		return reports
+	}
+
+	addrs := usableAddrs(job.addresses, cfg.torProxy != "")
+	if len(addrs) == 0 {
+		for _, target := range targets {
+			target.report.Error = "peer has no usable address " +
+				"(Tor addresses require --torproxy)"
 		}
+		log.Infof("Skipping %d channel(s) with peer %s: %s",
+			len(targets), job.peer, targets[0].report.Error)
+		return reports
 	}
 
-	log.Infof("Found force close transaction %v", spends[0].TXID)
-	log.Infof("You can now use the sweepremoteclosed command to sweep " +
-		"the funds from the channel")
+	// pending returns all channels the messages weren't delivered for
+	// yet. If a connection breaks down halfway through, only those are
+	// retried on the next connection.
+	pending := func() []*forceCloseTarget {
+		return fn.Filter(targets, func(t *forceCloseTarget) bool {
+			return !t.report.ErrorSent
+		})
+	}
 
-	outputAddrs := fn.Map(spends[0].Vout, func(v *btc.Vout) string {
-		return v.ScriptPubkeyAddr
-	})
+	for attempt := uint32(0); attempt <= cfg.retries; attempt++ {
+		for _, addr := range addrs {
+			remaining := pending()
+			for _, target := range remaining {
+				target.report.Attempts++
+				if attempt == 0 {
+					target.report.AddressesTried = append(
+						target.report.AddressesTried,
+						addr,
+					)
+				}
+			}
 
-	return outputAddrs, nil
-}
+			peerHost := fmt.Sprintf("%s@%s", job.peer, addr)
+			err := requestForceClose(
+				identity, peerHost, remaining, cfg,
+			)
+			if err == nil {
+				return reports
+			}
 
-func noiseDial(idKey keychain.SingleKeyECDH, lnAddr *lnwire.NetAddress,
-	netCfg tor.Net, timeout time.Duration) (*brontide.Conn, error) {
+			log.Warnf("Attempt %d to force close %d channel(s) "+
+				"via %s failed: %v",
+				remaining[0].report.Attempts, len(remaining),
+				peerHost, err)
+			for _, target := range pending() {
+				target.report.Error = err.Error()
+			}
+		}
+	}
 
-	return brontide.Dial(idKey, lnAddr, timeout, netCfg.Dial)
+	return reports
 }
 
-func connectPeer(peerHost, torProxy string, identity keychain.SingleKeyECDH,
-	dialTimeout time.Duration) (*peer.Brontide, func() error, error) {
+// requestForceClose connects to the peer and sends it a channel re-establish
+// message followed by an error message for each of the given channels.
+func requestForceClose(identity keychain.SingleKeyECDH, peerHost string,
+	targets []*forceCloseTarget, cfg *forceCloseConfig) error {
 
-	cleanup := func() error {
-		return nil
+	conn, err := dialPeer(peerHost, cfg.torProxy, identity, cfg.peerTimeout)
+	if err != nil {
+		return err
+	}
+	session := lnd.NewSession(conn)
+	defer func() {
+		_ = session.Close()
+	}()
+
+	_, addr, _ := strings.Cut(peerHost, "@")
+	for _, target := range targets {
+		target.report.Connected = true
+		target.report.Address = addr
 	}
+	log.Infof("Connection established to peer %s", peerHost)
+
+	return sendForceCloseMessages(session, targets, cfg.peerTimeout)
+}
+
+// dialPeer resolves the given peer address and establishes a brontide
+// connection to it.
+func dialPeer(peerHost, torProxy string, identity keychain.SingleKeyECDH,
+	timeout time.Duration) (*brontide.Conn, error) {
 
 	var dialNet tor.Net = &tor.ClearNet{}
 	if torProxy != "" {
@@ -347,135 +597,364 @@ func connectPeer(peerHost, torProxy string, identity keychain.SingleKeyECDH,
 		peerHost, "9735", dialNet.ResolveTCPAddr,
 	)
 	if err != nil {
-		return nil, cleanup, fmt.Errorf("error parsing peer address: "+
-			"%w", err)
+		return nil, fmt.Errorf("error parsing peer address: %w", err)
 	}
 
-	peerPubKey := peerAddr.IdentityKey
-
-	log.Debugf("Attempting to dial resolved peer address %v",
-		peerAddr.String())
-	conn, err := noiseDial(identity, peerAddr, dialNet, dialTimeout)
+	log.Debugf("Attempting to dial resolved peer address %v, dial "+
+		"timeout is %v", peerAddr.String(), timeout)
+	conn, err := noiseDial(identity, peerAddr, dialNet, timeout)
 	if err != nil {
-		return nil, cleanup, fmt.Errorf("error dialing peer: %w", err)
+		return nil, fmt.Errorf("error dialing peer: %w", err)
 	}
 
-	cleanup = func() error {
-		return conn.Close()
+	return conn, nil
+}
+
+// sendForceCloseMessages runs the message exchange that asks the peer to force
+// close each of the channels over an established session. The peer's responses
+// are recorded in the reports of the channels. The timeout applies to each
+// channel separately.
+func sendForceCloseMessages(session *lnd.Session, targets []*forceCloseTarget,
+	timeout time.Duration) error {
+
+	deadline := time.Now().Add(timeout)
+	if err := session.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("error setting deadline: %w", err)
 	}
 
-	log.Infof("Attempting to establish p2p connection to peer %x, dial"+
-		"timeout is %v", peerPubKey.SerializeCompressed(), dialTimeout)
-	req := &connmgr.ConnReq{
-		Addr:      peerAddr,
-		Permanent: false,
+	// waitForReply reads messages until the given wait time is over or the
+	// handler signals that it is done.
+	waitForReply := func(wait time.Duration,
+		handle func(lnwire.Message) bool) error {
+
+		replyDeadline := time.Now().Add(wait)
+		if replyDeadline.After(deadline) {
+			replyDeadline = deadline
+		}
+
+		return receiveUntil(session, replyDeadline, handle)
 	}
-	p, channelDB, err := lnd.ConnectPeer(conn, req, chainParams, identity)
+
+	globalFeatures, localFeatures, err := lnd.DefaultInitFeatures()
 	if err != nil {
-		return nil, cleanup, fmt.Errorf("error connecting to peer: %w",
-			err)
+		return err
 	}
+	if err := session.Init(globalFeatures, localFeatures); err != nil {
+		return err
+	}
+
+	peer := hex.EncodeToString(session.RemotePub().SerializeCompressed())
+	byChanID := make(map[lnwire.ChannelID]*forceCloseTarget, len(targets))
+	for _, target := range targets {
+		byChanID[lnwire.NewChanIDFromOutPoint(target.chanPoint)] =
+			target
+	}
+
+	// handleReply records any channel re-establish message and error the
+	// peer sends us. It returns true if the peer responded to the given
+	// message type for the current channel.
+	handleReply := func(current *forceCloseTarget,
+		replyTo lnwire.MessageType, msg lnwire.Message) bool {
+
+		switch m := msg.(type) {
+		case *lnwire.ChannelReestablish:
+			// The peer might also send us re-establish messages
+			// for other channels we have with it, we record those
+			// as well. Messages for channels we don't know the
+			// channel point of are added to the current channel's
+			// report.
+			var chanPoint string
+			report := current.report
+			target, ok := byChanID[m.ChanID]
+			if ok {
+				chanPoint = target.chanPoint.String()
+				report = target.report
+				report.ReestablishAcked = true
+			}
+			report.reestablishes = append(
+				report.reestablishes,
+				dataformat.NewChannelReestablish(
+					peer, chanPoint, m,
+				),
+			)
+			log.Infof("Received channel re-establish from peer "+
+				"%s for channel %v", peer, m.ChanID)
+
+			return target == current &&
+				replyTo == lnwire.MsgChannelReestablish
 
-	cleanup = func() error {
-		p.Disconnect(errors.New("done with peer"))
-		if channelDB != nil {
-			if err := channelDB.Close(); err != nil {
-				log.Errorf("Error closing channel DB: %v", err)
+		case *lnwire.Error:
+			report := current.report
+			if target, ok := byChanID[m.ChanID]; ok {
+				report = target.report
 			}
+			report.PeerError = m.Error()
+			return true
+
+		case *lnwire.Warning:
+			current.report.PeerError = "warning: " + m.Warning()
+			return true
 		}
-		return conn.Close()
+
+		return false
 	}
 
-	log.Infof("Connection established to peer %x",
-		peerPubKey.SerializeCompressed())
+	for _, target := range targets {
+		deadline = time.Now().Add(timeout)
+		if err := session.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("error setting deadline: %w", err)
+		}
+
+		channelPoint := target.chanPoint
+		channelID := lnwire.NewChanIDFromOutPoint(channelPoint)
+
+		log.Infof("Sending channel re-establish to peer to trigger "+
+			"force close of channel %v", channelPoint)
+		err = session.Send(&lnwire.ChannelReestablish{
+			ChanID: channelID,
+		})
+		if err != nil {
+			return fmt.Errorf("error sending channel "+
+				"re-establish: %w", err)
+		}
 
-	// We'll wait until the peer is active.
-	select {
-	case <-p.ActiveSignal():
-	case <-p.QuitSignal():
-		return nil, cleanup, fmt.Errorf("peer %x disconnected",
-			peerPubKey.SerializeCompressed())
+		// The peer might have answered already while we were busy
+		// with a previous channel.
+		if !target.report.ReestablishAcked {
+			err = waitForReply(
+				reestablishReplyTimeout,
+				func(msg lnwire.Message) bool {
+					return handleReply(
+						target,
+						lnwire.MsgChannelReestablish,
+						msg,
+					)
+				},
+			)
+			if err != nil {
+				return fmt.Errorf("error waiting for "+
+					"re-establish reply: %w", err)
+			}
+		}
+
+		// Some versions of CLN don't force close on a Data Loss
+		// Protection re-establish message, so we also send an error
+		// for the channel.
+		log.Infof("Sending channel error message to peer to trigger "+
+			"force close of channel %v", channelPoint)
+		err = session.Send(&lnwire.Error{
+			ChanID: channelID,
+		})
+		if err != nil {
+			return fmt.Errorf("error sending error message: %w",
+				err)
+		}
+		target.report.ErrorSent = true
+		target.report.Error = ""
+
+		// Give the peer a few seconds to process the message. Any
+		// error it responds with is recorded, but the messages were
+		// delivered, so we don't fail anymore.
+		err = waitForReply(
+			errorReplyTimeout, func(msg lnwire.Message) bool {
+				return handleReply(target, lnwire.MsgError, msg)
+			},
+		)
+		if err != nil {
+			log.Debugf("Error waiting for reply from peer: %v",
+				err)
+		}
 	}
 
-	return p, cleanup, nil
+	return nil
 }
 
-func requestForceCloseLnd(peerHost, torProxy string, channelPoint wire.OutPoint,
-	identity keychain.SingleKeyECDH) error {
+// receiveUntil passes all messages received from the peer to the handler until
+// the handler signals that it is done or the deadline is reached.
+func receiveUntil(session *lnd.Session, deadline time.Time,
+	handle func(lnwire.Message) bool) error {
 
-	p, cleanup, err := connectPeer(
-		peerHost, torProxy, identity, dialTimeout,
-	)
-	defer func() {
-		_ = cleanup()
-	}()
-
-	if err != nil {
-		return fmt.Errorf("error connecting to peer: %w", err)
+	if err := session.SetReadDeadline(deadline); err != nil {
+		return fmt.Errorf("error setting deadline: %w", err)
 	}
 
-	channelID := lnwire.NewChanIDFromOutPoint(channelPoint)
+	for {
+		msg, err := session.Receive()
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return nil
+
+		case err != nil:
+			return err
+		}
+
+		if handle(msg) {
+			return nil
+		}
+	}
+}
 
-	// Channel ID (32 byte) + u16 for the data length (which will be 0).
-	data := make([]byte, 34)
-	copy(data[:32], channelID[:])
+// closingTx looks up the transaction that spent the channel's funding output.
+func closingTx(api *btc.ExplorerAPI, chanPoint string) (string, []string,
+	error) {
 
-	log.Infof("Sending channel re-establish to peer to trigger force "+
-		"close of channel %v", channelPoint)
+	channelAddress, err := api.Address(chanPoint)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting channel address: %w",
+			err)
+	}
 
-	err = p.SendMessageLazy(true, &lnwire.ChannelReestablish{
-		ChanID: channelID,
-	})
+	spends, err := api.Spends(channelAddress)
 	if err != nil {
-		return err
+		return "", nil, fmt.Errorf("error getting spends: %w", err)
+	}
+	if len(spends) == 0 {
+		return "", nil, nil
 	}
 
-	// Wait a few seconds to give the peer time to process the message.
-	time.Sleep(5 * time.Second)
+	outputAddrs := fn.Map(spends[0].Vout, func(v *btc.Vout) string {
+		return v.ScriptPubkeyAddr
+	})
 
-	return nil
+	return spends[0].TXID, outputAddrs, nil
 }
 
-func requestForceCloseCln(peerHost, torProxy string, channelPoint wire.OutPoint,
-	identity keychain.SingleKeyECDH) error {
+// waitForClosingTxs polls the chain until a closing transaction was found for
+// each channel whose peer we reached or until the wait time is over.
+func waitForClosingTxs(reports []*forceCloseReport, lookup closingTxLookup,
+	wait time.Duration) {
 
-	p, cleanup, err := connectPeer(
-		peerHost, torProxy, identity, dialTimeout,
+	deadline := time.Now().Add(wait)
+	for {
+		pending := 0
+		for _, report := range reports {
+			if !report.Connected || report.ClosingTx != "" {
+				continue
+			}
+
+			txid, outputs, err := lookup(report.ChannelPoint)
+			if err != nil {
+				log.Warnf("Error looking up closing "+
+					"transaction of channel %s: %v",
+					report.ChannelPoint, err)
+			}
+			if txid == "" {
+				pending++
+				continue
+			}
+
+			log.Infof("Found force close transaction %v for "+
+				"channel %s", txid, report.ChannelPoint)
+			report.ClosingTx = txid
+			report.closingOutputs = outputs
+		}
+
+		if pending == 0 || time.Now().After(deadline) {
+			return
+		}
+
+		log.Infof("Still waiting for %d closing transactions", pending)
+		time.Sleep(closingTxPollInterval)
+	}
+}
+
+// writeForceCloseResults writes the JSON report as well as the list of closed
+// peers and their channel output addresses to the results directory.
+func writeForceCloseResults(reports []*forceCloseReport) error {
+	var (
+		pubKeys []string
+		outputs []string
+		closed  int
 	)
-	defer func() {
-		_ = cleanup()
-	}()
+	for _, report := range reports {
+		if report.ClosingTx == "" {
+			continue
+		}
 
-	if err != nil {
-		return fmt.Errorf("error connecting to peer: %w", err)
+		closed++
+		pubKeys = append(pubKeys, report.Peer)
+		outputs = append(outputs, report.closingOutputs...)
 	}
 
-	channelID := lnwire.NewChanIDFromOutPoint(channelPoint)
+	log.Infof("Found closing transactions for %d of %d channels", closed,
+		len(reports))
 
-	// Channel ID (32 byte) + u16 for the data length (which will be 0).
-	data := make([]byte, 34)
-	copy(data[:32], channelID[:])
+	if err := writeReestablishFile(reports); err != nil {
+		return err
+	}
 
-	log.Infof("Sending channel error message to peer to trigger force "+
-		"close of channel %v", channelPoint)
+	date := time.Now().Format("2006-01-02")
+	reportBytes, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	fileName := fmt.Sprintf("%s/forceclose-report-%s.json", ResultsDir,
+		date)
+	log.Infof("Writing report to %s", fileName)
+	if err := os.WriteFile(fileName, reportBytes, 0644); err != nil {
+		return fmt.Errorf("error writing report to file: %w", err)
+	}
 
-	_ = lnwire.SetCustomOverrides([]uint16{
-		lnwire.MsgError, lnwire.MsgChannelReestablish,
-	})
-	msg, err := lnwire.NewCustom(lnwire.MsgError, data)
+	peersBytes := []byte(strings.Join(pubKeys, "\n"))
+	outputsBytes := []byte(strings.Join(outputs, "\n"))
+
+	fileName = fmt.Sprintf("%s/forceclose-peers-%s.txt", ResultsDir, date)
+	log.Infof("Writing peers to %s", fileName)
+	err = os.WriteFile(fileName, peersBytes, 0644)
 	if err != nil {
-		return err
+		return fmt.Errorf("error writing peers to file: %w", err)
+	}
+
+	fileName = fmt.Sprintf("%s/forceclose-addresses-%s.txt", ResultsDir,
+		date)
+	log.Infof("Writing addresses to %s", fileName)
+	return os.WriteFile(fileName, outputsBytes, 0644)
+}
+
+// writeReestablishFile writes all channel re-establish messages we received
+// from the peers to a file that can be used by the rescueclosed and
+// sweepremoteclosed commands.
+func writeReestablishFile(reports []*forceCloseReport) error {
+	// A peer might send the same message again after a reconnect, we
+	// only record it once.
+	var (
+		file = &dataformat.ReestablishFile{}
+		seen = make(map[dataformat.ChannelReestablish]struct{})
+	)
+	for _, report := range reports {
+		for _, msg := range report.reestablishes {
+			if _, ok := seen[*msg]; ok {
+				continue
+			}
+			seen[*msg] = struct{}{}
+
+			file.Messages = append(file.Messages, msg)
+		}
 	}
 
-	err = p.SendMessageLazy(true, msg)
+	if len(file.Messages) == 0 {
+		return nil
+	}
+
+	fileBytes, err := json.MarshalIndent(file, "", "  ")
 	if err != nil {
-		return fmt.Errorf("error sending message: %w", err)
+		return fmt.Errorf("error encoding re-establish messages: %w",
+			err)
 	}
 
-	// Wait a few seconds to give the peer time to process the message.
-	time.Sleep(5 * time.Second)
+	fileName := fmt.Sprintf("%s/reestablish-%s.json", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing %d received channel re-establish messages to %s",
+		len(file.Messages), fileName)
 
-	return nil
+	return os.WriteFile(fileName, fileBytes, 0644)
+}
+
+func noiseDial(idKey keychain.SingleKeyECDH, lnAddr *lnwire.NetAddress,
+	netCfg tor.Net, timeout time.Duration) (*brontide.Conn, error) {
+
+	return brontide.Dial(idKey, lnAddr, timeout, netCfg.Dial)
 }
 
 func parseOutPoint(s string) (*wire.OutPoint, error) {
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/dataformat"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/brontide"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendForceCloseMessages(t *testing.T) {
+	_ = newHarness(t)
+
+	ours, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	theirs, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	acceptAll := func(*btcec.PublicKey) (bool, error) {
+		return true, nil
+	}
+	listener, err := brontide.NewListener(
+		&keychain.PrivKeyECDH{PrivKey: theirs}, "127.0.0.1:0",
+		acceptAll,
+	)
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{1, 2, 3}, Index: 1}
+	channelID := lnwire.NewChanIDFromOutPoint(chanPoint)
+	chanPoint2 := wire.OutPoint{Hash: chainhash.Hash{4, 5, 6}}
+	channelID2 := lnwire.NewChanIDFromOutPoint(chanPoint2)
+
+	// The remote peer answers our re-establish message with its own and
+	// our error with an error, after making sure we answer pings. Both
+	// channels are handled over the same connection.
+	commitPriv, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	commitPoint := commitPriv.PubKey()
+
+	peerErr := make(chan error, 1)
+	go func() {
+		peerErr <- fakeForceClosePeer(
+			listener, channelID, channelID2, commitPoint,
+		)
+	}()
+
+	conn, err := brontide.Dial(
+		&keychain.PrivKeyECDH{PrivKey: ours}, &lnwire.NetAddress{
+			IdentityKey: theirs.PubKey(),
+			Address:     listener.Addr(),
+		}, 10*time.Second, (&tor.ClearNet{}).Dial,
+	)
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	report, report2 := &forceCloseReport{}, &forceCloseReport{}
+	err = sendForceCloseMessages(
+		lnd.NewSession(conn), []*forceCloseTarget{{
+			chanPoint: chanPoint,
+			report:    report,
+		}, {
+			chanPoint: chanPoint2,
+			report:    report2,
+		}}, 10*time.Second,
+	)
+	require.NoError(t, err)
+	require.NoError(t, <-peerErr)
+
+	require.True(t, report.ReestablishAcked)
+	require.True(t, report.ErrorSent)
+	require.Contains(t, report.PeerError, "err=unknown channel")
+	require.True(t, report2.ReestablishAcked)
+	require.True(t, report2.ErrorSent)
+	require.Len(t, report2.reestablishes, 1)
+	require.Equal(
+		t, chanPoint2.String(), report2.reestablishes[0].ChannelPoint,
+	)
+
+	// All received re-establish messages are recorded, but only ours has a
+	// known channel point.
+	peer := hex.EncodeToString(theirs.PubKey().SerializeCompressed())
+	require.Len(t, report.reestablishes, 2)
+	require.Empty(t, report.reestablishes[0].ChannelPoint)
+	require.Equal(t, &dataformat.ChannelReestablish{
+		Peer:                   peer,
+		ChannelID:              channelID.String(),
+		ChannelPoint:           chanPoint.String(),
+		NextLocalCommitHeight:  5,
+		RemoteCommitTailHeight: 4,
+		LastRemoteCommitSecret: "040404" + strings.Repeat("00", 29),
+		LocalUnrevokedCommitPoint: hex.EncodeToString(
+			commitPoint.SerializeCompressed(),
+		),
+	}, report.reestablishes[1])
+
+	file := &dataformat.ReestablishFile{Messages: report.reestablishes}
+	commitPoints, err := file.CommitPoints()
+	require.NoError(t, err)
+	require.Equal(t, []*btcec.PublicKey{commitPoint}, commitPoints)
+}
+
+func fakeForceClosePeer(listener *brontide.Listener, channelID,
+	channelID2 lnwire.ChannelID, commitPoint *btcec.PublicKey) error {
+
+	conn, err := acceptWithTimeout(listener, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	session := lnd.NewSession(conn)
+	defer func() {
+		_ = session.Close()
+	}()
+
+	expect := func(msgType lnwire.MessageType) (lnwire.Message, error) {
+		msg, err := session.Receive()
+		if err != nil {
+			return nil, err
+		}
+		if msg.MsgType() != msgType {
+			return nil, fmt.Errorf("expected %v, got %v", msgType,
+				msg.MsgType())
+		}
+
+		return msg, nil
+	}
+
+	err = session.Init(
+		lnwire.NewRawFeatureVector(), lnwire.NewRawFeatureVector(),
+	)
+	if err != nil {
+		return err
+	}
+
+	msg, err := expect(lnwire.MsgChannelReestablish)
+	if err != nil {
+		return err
+	}
+	if msg.(*lnwire.ChannelReestablish).ChanID != channelID {
+		return fmt.Errorf("unexpected channel ID")
+	}
+
+	if err := session.Send(lnwire.NewPing(4)); err != nil {
+		return err
+	}
+	if _, err := expect(lnwire.MsgPong); err != nil {
+		return err
+	}
+
+	// Also send a re-establish message for another channel, like lnd
+	// does for all channels it has with us.
+	err = session.Send(&lnwire.ChannelReestablish{
+		ChanID:                 lnwire.ChannelID{9, 9, 9},
+		NextLocalCommitHeight:  1,
+		RemoteCommitTailHeight: 1,
+	})
+	if err != nil {
+		return err
+	}
+	err = session.Send(&lnwire.ChannelReestablish{
+		ChanID:                    channelID,
+		NextLocalCommitHeight:     5,
+		RemoteCommitTailHeight:    4,
+		LastRemoteCommitSecret:    [32]byte{4, 4, 4},
+		LocalUnrevokedCommitPoint: commitPoint,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err = expect(lnwire.MsgError)
+	if err != nil {
+		return err
+	}
+	if msg.(*lnwire.Error).ChanID != channelID {
+		return fmt.Errorf("unexpected channel ID in error")
+	}
+
+	err = session.Send(&lnwire.Error{
+		ChanID: channelID,
+		Data:   []byte("unknown channel"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The second channel is requested over the same connection.
+	msg, err = expect(lnwire.MsgChannelReestablish)
+	if err != nil {
+		return err
+	}
+	if msg.(*lnwire.ChannelReestablish).ChanID != channelID2 {
+		return fmt.Errorf("unexpected channel ID")
+	}
+	err = session.Send(&lnwire.ChannelReestablish{
+		ChanID:                 channelID2,
+		NextLocalCommitHeight:  2,
+		RemoteCommitTailHeight: 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err = expect(lnwire.MsgError)
+	if err != nil {
+		return err
+	}
+	if msg.(*lnwire.Error).ChanID != channelID2 {
+		return fmt.Errorf("unexpected channel ID in error")
+	}
+
+	return session.Send(&lnwire.Error{
+		ChanID: channelID2,
+		Data:   []byte("closing"),
+	})
+}
+
+func TestRunForceCloseWorkers(t *testing.T) {
+	_ = newHarness(t)
+
+	// Two channels with every peer, so each peer must only be contacted
+	// by a single worker.
+	const numJobs = 20
+	channels := make([]*gqChannel, 0, 2*numJobs)
+	for i := range 2 * numJobs {
+		channels = append(channels, &gqChannel{
+			ChanPoint: fmt.Sprintf("%064x:%d", i, i),
+			Node2:     fmt.Sprintf("peer-%d", i%numJobs),
+		})
+	}
+	jobs := groupForceCloseJobs(channels)
+	require.Len(t, jobs, numJobs)
+
+	var (
+		mtx       sync.Mutex
+		active    int
+		maxActive int
+		processed = make(map[string]int)
+	)
+	reports := runForceCloseWorkers(
+		jobs, 3, func(job *forceCloseJob) []*forceCloseReport {
+			mtx.Lock()
+			active++
+			maxActive = max(maxActive, active)
+			processed[job.peer]++
+			mtx.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mtx.Lock()
+			active--
+			mtx.Unlock()
+
+			return fn.Map(job.chanPoints,
+				func(chanPoint string) *forceCloseReport {
+					return &forceCloseReport{
+						ChannelPoint: chanPoint,
+						Peer:         job.peer,
+						Connected:    true,
+					}
+				},
+			)
+		},
+	)
+
+	require.Len(t, processed, numJobs)
+	for _, count := range processed {
+		require.Equal(t, 1, count)
+	}
+	require.LessOrEqual(t, maxActive, 3)
+	require.Len(t, reports, 2*numJobs)
+	for i, report := range reports {
+		job := jobs[i/2]
+		require.Equal(t, job.chanPoints[i%2], report.ChannelPoint)
+		require.Equal(t, job.peer, report.Peer)
+	}
+
+	// Only the channels of peers we reached are looked up on chain.
+	reports[1].Connected = false
+	oldInterval := closingTxPollInterval
+	closingTxPollInterval = time.Millisecond
+	defer func() {
+		closingTxPollInterval = oldInterval
+	}()
+
+	var lookups atomic.Int32
+	waitForClosingTxs(
+		reports, func(chanPoint string) (string, []string, error) {
+			// The third channel is only closed on a later poll.
+			if lookups.Add(1) <= numJobs &&
+				chanPoint == reports[2].ChannelPoint {
+
+				return "", nil, nil
+			}
+
+			return "txid-" + chanPoint, []string{"bc1q"}, nil
+		}, time.Minute,
+	)
+	require.Empty(t, reports[1].ClosingTx)
+	require.Equal(t, "txid-"+reports[2].ChannelPoint, reports[2].ClosingTx)
+	require.Equal(t, []string{"bc1q"}, reports[0].closingOutputs)
+}
+
+func TestUsableAddrs(t *testing.T) {
+	addrs := []string{
+		"abcdef.onion:9735", "1.2.3.4:9735", "[::1]:9735",
+	}
+	require.Equal(t, []string{"1.2.3.4:9735", "[::1]:9735"},
+		usableAddrs(addrs, false))
+	require.Equal(t, []string{
+		"1.2.3.4:9735", "[::1]:9735", "abcdef.onion:9735",
+	}, usableAddrs(addrs, true))
+}
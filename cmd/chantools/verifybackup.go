@@ -0,0 +1,559 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightninglabs/chantools/scbforceclose"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+const (
+	fundingStatusUnspent  = "unspent"
+	fundingStatusSpent    = "spent"
+	fundingStatusNotFound = "not_found"
+	fundingStatusMismatch = "mismatch"
+	fundingStatusUnknown  = "unknown"
+
+	// fundingStatusLookupFailed means the chain API couldn't be asked
+	// about the funding output, so nothing is known about it.
+	fundingStatusLookupFailed = "lookup_failed"
+
+	recoveryScbForceClose = "scbforceclose: sign and publish the " +
+		"commitment transaction stored in the backup"
+	recoveryPeerForceClose = "lncli restorechanbackup or chantools " +
+		"triggerforceclose: ask the peer to force close the channel"
+	recoverySweepClosed = "chantools sweepremoteclosed or chantools " +
+		"rescueclosed: sweep our balance from the closed channel"
+)
+
+type verifyBackupCommand struct {
+	APIURL string
+
+	// How the channel backup is provided.
+	SingleBackup string
+	SingleFile   string
+	MultiBackup  string
+	MultiFile    string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newVerifyBackupCommand() *cobra.Command {
+	cc := &verifyBackupCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "verifybackup",
+		Short: "Verify that a channel backup can be used to recover " +
+			"the channels it contains",
+		Long: `Decrypts a channel backup and checks every channel it
+contains against the seed and the chain, so it is known whether the backup
+is usable before it is needed in an emergency.
+
+For each channel the following is checked:
+  - All local keys (multisig key, base points and the shachain root) can be
+    derived from the seed with the key locators stored in the backup.
+  - The funding output script built from our derived multisig key and the
+    peer's multisig key matches the funding output on chain and still is
+    unspent.
+  - If the backup contains the latest commitment transaction (lnd v0.19.0 and
+    later), it can be signed and the resulting transaction is valid.
+
+If the chain API fails to answer, the funding output of a channel is reported
+as lookup_failed and its recovery status as unknown, so the check should be
+run again later.
+
+A report with the available recovery methods for each channel is written to
+the results directory. No transaction is published and the peers are not
+contacted.`,
+		Example: `chantools verifybackup \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
+			"be esplora compatible)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SingleBackup, "single_backup", "", "a hex encoded single "+
+			"channel backup obtained from exportchanbackup",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.MultiBackup, "multi_backup", "", "a hex encoded "+
+			"multi-channel backup obtained from exportchanbackup",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SingleFile, "single_file", "", "the path to a "+
+			"single-channel backup file",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", "", "the path to a "+
+			"multi-channel backup file (channel.backup)",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup and "+
+		"deriving the keys")
+
+	return cc.cmd
+}
+
+// backupVerification is the result of verifying a single channel backup.
+type backupVerification struct {
+	ChannelPoint    string   `json:"channel_point"`
+	RemoteNodePub   string   `json:"remote_node_pub"`
+	Version         uint8    `json:"version"`
+	Capacity        int64    `json:"capacity"`
+	KeysDerivable   bool     `json:"keys_derivable"`
+	FundingOutput   string   `json:"funding_output"`
+	SpendingTxid    string   `json:"spending_txid,omitempty"`
+	HasCloseTx      bool     `json:"has_close_tx"`
+	CloseTxValid    bool     `json:"close_tx_valid"`
+	CloseTxid       string   `json:"close_txid,omitempty"`
+	Recoverable     bool     `json:"recoverable"`
+	RecoveryMethods []string `json:"recovery_methods,omitempty"`
+	Problems        []string `json:"problems,omitempty"`
+}
+
+// fundingOutputLookup returns the funding output of the given channel point
+// including its spend information or nil if the output doesn't exist. An error
+// is returned if the chain backend couldn't be asked.
+type fundingOutputLookup func(chanPoint wire.OutPoint) (*btc.Vout, error)
+
+func (c *verifyBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	signer.MusigSessionManager = input.NewMusigSessionManager(
+		signer.FetchPrivateKey,
+	)
+
+	backups, err := readChannelBackups(
+		c.SingleBackup, c.SingleFile, c.MultiBackup, c.MultiFile,
+		keyRing,
+	)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return errors.New("no channel backup provided")
+	}
+
+	api := newExplorerAPI(c.APIURL)
+	lookup := func(chanPoint wire.OutPoint) (*btc.Vout, error) {
+		tx, err := api.Transaction(chanPoint.Hash.String())
+		switch {
+		case errors.Is(err, btc.ErrTxNotFound):
+			return nil, nil
+
+		case err != nil:
+			return nil, fmt.Errorf("error fetching funding "+
+				"transaction %v: %w", chanPoint.Hash, err)
+		}
+		if int(chanPoint.Index) >= len(tx.Vout) {
+			return nil, nil
+		}
+
+		return tx.Vout[chanPoint.Index], nil
+	}
+
+	results := make([]*backupVerification, len(backups))
+	for idx, single := range backups {
+		results[idx] = verifySingleBackup(
+			single, keyRing, signer, lookup,
+		)
+		logBackupVerification(results[idx])
+	}
+
+	return writeBackupVerification(results)
+}
+
+// verifySingleBackup checks that the given channel backup can be used to
+// recover the channel's funds.
+func verifySingleBackup(single chanbackup.Single, keyRing *lnd.HDKeyRing,
+	signer *lnd.Signer, lookup fundingOutputLookup) *backupVerification {
+
+	result := &backupVerification{
+		ChannelPoint:  single.FundingOutpoint.String(),
+		RemoteNodePub: pubKeyString(single.RemoteNodePub),
+		Version:       uint8(single.Version),
+		Capacity:      int64(single.Capacity),
+		FundingOutput: fundingStatusUnknown,
+		HasCloseTx:    single.CloseTxInputs.IsSome(),
+	}
+	addProblem := func(format string, args ...any) {
+		result.Problems = append(
+			result.Problems, fmt.Sprintf(format, args...),
+		)
+	}
+
+	// We first make sure all our keys can be derived from the seed and
+	// use the key family they're supposed to.
+	result.KeysDerivable = true
+	localKeys := []struct {
+		name   string
+		desc   keychain.KeyDescriptor
+		family keychain.KeyFamily
+	}{
+		{"multisig key", single.LocalChanCfg.MultiSigKey,
+			keychain.KeyFamilyMultiSig},
+		{"revocation base point",
+			single.LocalChanCfg.RevocationBasePoint,
+			keychain.KeyFamilyRevocationBase},
+		{"payment base point", single.LocalChanCfg.PaymentBasePoint,
+			keychain.KeyFamilyPaymentBase},
+		{"delay base point", single.LocalChanCfg.DelayBasePoint,
+			keychain.KeyFamilyDelayBase},
+		{"htlc base point", single.LocalChanCfg.HtlcBasePoint,
+			keychain.KeyFamilyHtlcBase},
+	}
+	for _, localKey := range localKeys {
+		if localKey.desc.Family != localKey.family {
+			result.KeysDerivable = false
+			addProblem("local %s has key family %d, expected %d",
+				localKey.name, localKey.desc.Family,
+				localKey.family)
+
+			continue
+		}
+
+		_, err := keyRing.DeriveKey(localKey.desc.KeyLocator)
+		if err != nil {
+			result.KeysDerivable = false
+			addProblem("could not derive local %s: %v",
+				localKey.name, err)
+		}
+	}
+
+	if err := checkShaChainRoot(single, keyRing); err != nil {
+		result.KeysDerivable = false
+		addProblem("%v", err)
+	}
+
+	remoteKeys := []struct {
+		name string
+		desc keychain.KeyDescriptor
+	}{
+		{"multisig key", single.RemoteChanCfg.MultiSigKey},
+		{"revocation base point",
+			single.RemoteChanCfg.RevocationBasePoint},
+		{"payment base point", single.RemoteChanCfg.PaymentBasePoint},
+		{"delay base point", single.RemoteChanCfg.DelayBasePoint},
+		{"htlc base point", single.RemoteChanCfg.HtlcBasePoint},
+	}
+	for _, remoteKey := range remoteKeys {
+		if remoteKey.desc.PubKey == nil {
+			result.KeysDerivable = false
+			addProblem("remote %s is missing", remoteKey.name)
+		}
+	}
+
+	// With our multisig key we can now re-create the funding output script
+	// and compare it to the one on chain.
+	fundingScript, err := backupFundingScript(single, keyRing)
+	if err != nil {
+		addProblem("could not create funding script: %v", err)
+	}
+	if fundingScript != nil {
+		checkFundingOutput(single, fundingScript, lookup, result)
+	}
+
+	// If there is a commitment transaction in the backup, it must be
+	// signable and valid.
+	if result.HasCloseTx {
+		closeTx, err := signBackupCloseTx(
+			single, fundingScript, keyRing, signer,
+		)
+		if err != nil {
+			addProblem("commitment transaction is invalid: %v", err)
+		} else {
+			result.CloseTxValid = true
+			result.CloseTxid = closeTx.TxHash().String()
+		}
+	}
+
+	// Finally, we can decide how the channel can be recovered.
+	switch result.FundingOutput {
+	case fundingStatusSpent:
+		result.RecoveryMethods = []string{recoverySweepClosed}
+
+	case fundingStatusUnspent, fundingStatusUnknown:
+		if !result.KeysDerivable {
+			break
+		}
+		if result.CloseTxValid {
+			result.RecoveryMethods = append(
+				result.RecoveryMethods, recoveryScbForceClose,
+			)
+		}
+		if single.RemoteNodePub != nil && len(single.Addresses) > 0 {
+			result.RecoveryMethods = append(
+				result.RecoveryMethods, recoveryPeerForceClose,
+			)
+		} else {
+			addProblem("no peer address in backup, the peer " +
+				"can't be asked to force close")
+		}
+	}
+	result.Recoverable = len(result.RecoveryMethods) > 0
+
+	return result
+}
+
+// checkShaChainRoot makes sure the shachain root descriptor of the backup can
+// be derived from the seed.
+func checkShaChainRoot(single chanbackup.Single,
+	keyRing *lnd.HDKeyRing) error {
+
+	desc := single.ShaChainRootDesc
+
+	// Old backups contain the public key of the shachain root which can
+	// be checked directly.
+	if desc.PubKey != nil {
+		err := keyRing.CheckDescriptor(desc)
+		switch {
+		case errors.Is(err, keychain.ErrCannotDerivePrivKey):
+			return errors.New("shachain root can't be derived " +
+				"from the seed, the backup needs to be fixed " +
+				"with 'chantools fixoldbackup'")
+
+		case err != nil:
+			return fmt.Errorf("could not check shachain root: %w",
+				err)
+		}
+
+		return nil
+	}
+
+	if desc.Family != keychain.KeyFamilyRevocationRoot {
+		return fmt.Errorf("shachain root has key family %d, expected "+
+			"%d", desc.Family, keychain.KeyFamilyRevocationRoot)
+	}
+	if _, err := keyRing.DeriveKey(desc.KeyLocator); err != nil {
+		return fmt.Errorf("could not derive shachain root: %w", err)
+	}
+
+	return nil
+}
+
+// backupFundingScript re-creates the funding output script of a channel from
+// our derived multisig key and the peer's multisig key.
+func backupFundingScript(single chanbackup.Single,
+	keyRing *lnd.HDKeyRing) ([]byte, error) {
+
+	localKey, err := keyRing.DeriveKey(
+		single.LocalChanCfg.MultiSigKey.KeyLocator,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive multisig key: %w",
+			err)
+	}
+	remoteKey := single.RemoteChanCfg.MultiSigKey.PubKey
+	if remoteKey == nil {
+		return nil, errors.New("remote multisig key is missing")
+	}
+
+	if !single.Version.IsTaproot() {
+		witnessScript, err := input.GenMultiSigScript(
+			localKey.PubKey.SerializeCompressed(),
+			remoteKey.SerializeCompressed(),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return input.WitnessScriptHash(witnessScript)
+	}
+
+	// The tapscript root of a taproot channel is only stored together
+	// with the commitment transaction.
+	tapscriptRoot := fn.None[chainhash.Hash]()
+	if single.Version.HasTapscriptRoot() {
+		var inputs chanbackup.CloseTxInputs
+		inputs, err = single.CloseTxInputs.UnwrapOrErr(errors.New(
+			"tapscript root unknown without commitment " +
+				"transaction",
+		))
+		if err != nil {
+			return nil, err
+		}
+		tapscriptRoot = inputs.TapscriptRoot
+	}
+
+	pkScript, _, err := input.GenTaprootFundingScript(
+		localKey.PubKey, remoteKey, int64(single.Capacity),
+		tapscriptRoot,
+	)
+
+	return pkScript, err
+}
+
+// checkFundingOutput compares the funding output on chain to the expected
+// script and capacity.
+func checkFundingOutput(single chanbackup.Single, fundingScript []byte,
+	lookup fundingOutputLookup, result *backupVerification) {
+
+	fundingOut, err := lookup(single.FundingOutpoint)
+	switch {
+	case err != nil:
+		result.FundingOutput = fundingStatusLookupFailed
+		result.Problems = append(result.Problems, fmt.Sprintf("could "+
+			"not look up funding output, run again later: %v",
+			err))
+
+		return
+
+	case fundingOut == nil:
+		result.FundingOutput = fundingStatusNotFound
+		result.Problems = append(result.Problems, "funding output "+
+			"not found on chain")
+
+		return
+	}
+
+	expectedScript := hex.EncodeToString(fundingScript)
+	if fundingOut.ScriptPubkey != expectedScript {
+		result.FundingOutput = fundingStatusMismatch
+		result.Problems = append(result.Problems, fmt.Sprintf(
+			"funding output script on chain is %s, expected %s "+
+				"(wrong seed or corrupted backup)",
+			fundingOut.ScriptPubkey, expectedScript,
+		))
+
+		return
+	}
+	if int64(fundingOut.Value) != int64(single.Capacity) {
+		result.Problems = append(result.Problems, fmt.Sprintf(
+			"funding output value on chain is %d, backup says %d",
+			fundingOut.Value, single.Capacity,
+		))
+	}
+
+	result.FundingOutput = fundingStatusUnspent
+	if fundingOut.Outspend != nil && fundingOut.Outspend.Spent {
+		result.FundingOutput = fundingStatusSpent
+		result.SpendingTxid = fundingOut.Outspend.Txid
+	}
+}
+
+// signBackupCloseTx signs the commitment transaction stored in the backup and
+// verifies the resulting transaction against the funding output.
+func signBackupCloseTx(single chanbackup.Single, fundingScript []byte,
+	keyRing *lnd.HDKeyRing, signer *lnd.Signer) (*wire.MsgTx, error) {
+
+	if fundingScript == nil {
+		return nil, errors.New("funding script unknown")
+	}
+
+	closeTx, err := scbforceclose.SignCloseTx(
+		single, keyRing, signer, signer,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(closeTx.TxIn) != 1 ||
+		closeTx.TxIn[0].PreviousOutPoint != single.FundingOutpoint {
+
+		return nil, errors.New("commitment transaction doesn't " +
+			"spend the funding output")
+	}
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		fundingScript, int64(single.Capacity),
+	)
+	vm, err := txscript.NewEngine(
+		fundingScript, closeTx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(closeTx, prevOutFetcher),
+		int64(single.Capacity), prevOutFetcher,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := vm.Execute(); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w",
+			err)
+	}
+
+	return closeTx, nil
+}
+
+// logBackupVerification logs the result of a single channel's verification.
+func logBackupVerification(result *backupVerification) {
+	status := "NOT RECOVERABLE"
+	switch {
+	case result.Recoverable:
+		status = "recoverable"
+
+	// Without the funding output, we can't tell whether the channel can
+	// be recovered.
+	case result.FundingOutput == fundingStatusLookupFailed:
+		status = "UNKNOWN"
+	}
+	log.Infof("Channel %s (peer %s, %d sats): %s, funding output %s",
+		result.ChannelPoint, result.RemoteNodePub, result.Capacity,
+		status, result.FundingOutput)
+
+	for _, method := range result.RecoveryMethods {
+		log.Infof("    recovery method: %s", method)
+	}
+	for _, problem := range result.Problems {
+		log.Warnf("    problem: %s", problem)
+	}
+}
+
+// writeBackupVerification logs a summary of all verification results and
+// writes them to a report file in the results directory.
+func writeBackupVerification(results []*backupVerification) error {
+	var recoverable, withCloseTx, lookupFailed int
+	for _, result := range results {
+		if result.Recoverable {
+			recoverable++
+		}
+		if result.CloseTxValid {
+			withCloseTx++
+		}
+		if result.FundingOutput == fundingStatusLookupFailed {
+			lookupFailed++
+		}
+	}
+	log.Infof("%d of %d channels are recoverable, %d of them with the "+
+		"commitment transaction stored in the backup", recoverable,
+		len(results), withCloseTx)
+	if lookupFailed > 0 {
+		log.Warnf("The funding output of %d channels could not be "+
+			"looked up, their status is unknown", lookupFailed)
+	}
+
+	reportBytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	fileName := fmt.Sprintf("%s/verifybackup-%s.json", ResultsDir,
+		time.Now().Format("2006-01-02-15-04-05"))
+	log.Infof("Writing report to %s", fileName)
+
+	return os.WriteFile(fileName, reportBytes, 0644)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+// newVerifyBackupTestSingle creates a channel backup with our keys derived
+// from the test seed and a commitment transaction signed by the peer with the
+// given key.
+func newVerifyBackupTestSingle(t *testing.T, keyRing *lnd.HDKeyRing,
+	remotePriv, signingPriv *btcec.PrivateKey) (chanbackup.Single,
+	[]byte) {
+
+	t.Helper()
+
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{7, 7}, Index: 1}
+	single := newTestSingle(t, chanPoint)
+	local := &single.LocalChanCfg
+	localKeys := map[*keychain.KeyDescriptor]keychain.KeyFamily{
+		&local.MultiSigKey:         keychain.KeyFamilyMultiSig,
+		&local.RevocationBasePoint: keychain.KeyFamilyRevocationBase,
+		&local.PaymentBasePoint:    keychain.KeyFamilyPaymentBase,
+		&local.DelayBasePoint:      keychain.KeyFamilyDelayBase,
+		&local.HtlcBasePoint:       keychain.KeyFamilyHtlcBase,
+	}
+	for desc, family := range localKeys {
+		desc.KeyLocator = keychain.KeyLocator{Family: family, Index: 3}
+	}
+	single.ShaChainRootDesc = keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamilyRevocationRoot, Index: 3,
+		},
+	}
+	single.RemoteChanCfg.MultiSigKey.PubKey = remotePriv.PubKey()
+
+	localKey, err := keyRing.DeriveKey(
+		single.LocalChanCfg.MultiSigKey.KeyLocator,
+	)
+	require.NoError(t, err)
+	witnessScript, err := input.GenMultiSigScript(
+		localKey.PubKey.SerializeCompressed(),
+		remotePriv.PubKey().SerializeCompressed(),
+	)
+	require.NoError(t, err)
+	fundingScript, err := input.WitnessScriptHash(witnessScript)
+	require.NoError(t, err)
+
+	commitTx := wire.NewMsgTx(2)
+	commitTx.AddTxIn(wire.NewTxIn(&chanPoint, nil, nil))
+	commitTx.AddTxOut(wire.NewTxOut(
+		int64(single.Capacity)-1000, fundingScript,
+	))
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		fundingScript, int64(single.Capacity),
+	)
+	sig, err := txscript.RawTxInWitnessSignature(
+		commitTx, txscript.NewTxSigHashes(commitTx, prevOutFetcher), 0,
+		int64(single.Capacity), witnessScript, txscript.SigHashAll,
+		signingPriv,
+	)
+	require.NoError(t, err)
+
+	// The signature is stored without the sighash flag.
+	single.CloseTxInputs = fn.Some(chanbackup.CloseTxInputs{
+		CommitTx:  commitTx,
+		CommitSig: sig[:len(sig)-1],
+	})
+
+	return single, fundingScript
+}
+
+func TestVerifySingleBackup(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	signer.MusigSessionManager = input.NewMusigSessionManager(
+		signer.FetchPrivateKey,
+	)
+
+	remotePriv, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	otherPriv, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	single, fundingScript := newVerifyBackupTestSingle(
+		t, keyRing, remotePriv, remotePriv,
+	)
+	lookupOutput := func(vout *btc.Vout) fundingOutputLookup {
+		return func(chanPoint wire.OutPoint) (*btc.Vout, error) {
+			require.Equal(t, single.FundingOutpoint, chanPoint)
+
+			return vout, nil
+		}
+	}
+	unspent := &btc.Vout{
+		ScriptPubkey: hex.EncodeToString(fundingScript),
+		Value:        uint64(single.Capacity),
+		Outspend:     &btc.Outspend{},
+	}
+
+	// A correct backup of an open channel can be recovered with both
+	// methods.
+	result := verifySingleBackup(
+		single, keyRing, signer, lookupOutput(unspent),
+	)
+	require.Empty(t, result.Problems)
+	require.True(t, result.KeysDerivable)
+	require.Equal(t, fundingStatusUnspent, result.FundingOutput)
+	require.True(t, result.CloseTxValid)
+	require.True(t, result.Recoverable)
+	require.Equal(t, []string{
+		recoveryScbForceClose, recoveryPeerForceClose,
+	}, result.RecoveryMethods)
+
+	// If the channel was already closed, our funds need to be swept.
+	spent := *unspent
+	spent.Outspend = &btc.Outspend{Spent: true, Txid: "abcd"}
+	result = verifySingleBackup(
+		single, keyRing, signer, lookupOutput(&spent),
+	)
+	require.Equal(t, fundingStatusSpent, result.FundingOutput)
+	require.Equal(t, "abcd", result.SpendingTxid)
+	require.Equal(t, []string{recoverySweepClosed}, result.RecoveryMethods)
+
+	// A funding output that doesn't match our keys means the seed is
+	// wrong or the backup is corrupted.
+	mismatch := *unspent
+	mismatch.ScriptPubkey = "0020" + hex.EncodeToString(make([]byte, 32))
+	result = verifySingleBackup(
+		single, keyRing, signer, lookupOutput(&mismatch),
+	)
+	require.Equal(t, fundingStatusMismatch, result.FundingOutput)
+	require.False(t, result.Recoverable)
+
+	// A commitment transaction with an invalid signature can't be used,
+	// but the peer can still be asked to force close.
+	badSig, _ := newVerifyBackupTestSingle(
+		t, keyRing, remotePriv, otherPriv,
+	)
+	result = verifySingleBackup(
+		badSig, keyRing, signer, lookupOutput(unspent),
+	)
+	require.True(t, result.HasCloseTx)
+	require.False(t, result.CloseTxValid)
+	require.True(t, result.Recoverable)
+	require.Equal(t, []string{recoveryPeerForceClose},
+		result.RecoveryMethods)
+	require.Len(t, result.Problems, 1)
+	require.Contains(t, result.Problems[0], "commitment transaction")
+
+	// Keys with the wrong family point to a corrupted backup.
+	wrongFamily := single
+	wrongFamily.LocalChanCfg.DelayBasePoint.Family =
+		keychain.KeyFamilyHtlcBase
+	result = verifySingleBackup(
+		wrongFamily, keyRing, signer, lookupOutput(unspent),
+	)
+	require.False(t, result.KeysDerivable)
+	require.False(t, result.Recoverable)
+	require.Contains(t, result.Problems[0], "delay base point")
+
+	// A failed lookup isn't reported as a missing funding output.
+	result = verifySingleBackup(
+		single, keyRing, signer,
+		func(wire.OutPoint) (*btc.Vout, error) {
+			return nil, errors.New("API unavailable")
+		},
+	)
+	require.Equal(t, fundingStatusLookupFailed, result.FundingOutput)
+	require.False(t, result.Recoverable)
+	require.Contains(t, result.Problems[0], "API unavailable")
+
+	// Only a funding transaction that doesn't exist is not found.
+	result = verifySingleBackup(
+		single, keyRing, signer, lookupOutput(nil),
+	)
+	require.Equal(t, fundingStatusNotFound, result.FundingOutput)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcwallet/waddrmgr"
 	"github.com/btcsuite/btcwallet/wallet"
 	"github.com/btcsuite/btcwallet/walletdb"
@@ -37,6 +38,7 @@ type walletInfoCommand struct {
 	WalletDB    string
 	WithRootKey bool
 	DumpAddrs   bool
+	Salvage     bool
 
 	cmd *cobra.Command
 }
@@ -56,8 +58,16 @@ extracted** because it is hashed into the extended HD root key before storing it
 in the wallet.db.
 In case lnd was started with "--noseedbackup=true" your wallet has the default
 password. To unlock the wallet set the environment variable WALLET_PASSWORD="-"
-or simply press <enter> without entering a password when being prompted.`,
+or simply press <enter> without entering a password when being prompted.
+
+If the wallet.db file is partially corrupted and can't be opened anymore, the
+--salvage flag can be used to scan the raw database pages for the encrypted
+root key and decrypt it with the wallet password. Only the identity pubkey and
+the root key are shown in that mode.`,
 		Example: `chantools walletinfo --withrootkey \
+	--walletdb ~/.lnd/data/chain/bitcoin/mainnet/wallet.db
+
+chantools walletinfo --salvage \
 	--walletdb ~/.lnd/data/chain/bitcoin/mainnet/wallet.db`,
 		RunE: cc.Execute,
 	}
@@ -73,6 +83,11 @@ or simply press <enter> without entering a password when being prompted.`,
 		&cc.DumpAddrs, "dumpaddrs", false, "print all addresses, "+
 			"including private keys",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Salvage, "salvage", false, "don't open the wallet but "+
+			"scan the raw pages of a corrupted wallet.db file for "+
+			"the encrypted root key and print it to standard out",
+	)
 
 	return cc.cmd
 }
@@ -83,6 +98,10 @@ func (c *walletInfoCommand) Execute(_ *cobra.Command, _ []string) error {
 		return errors.New("wallet DB is required")
 	}
 
+	if c.Salvage {
+		return c.salvage()
+	}
+
 	w, privateWalletPw, cleanup, err := lnd.OpenWallet(
 		c.WalletDB, chainParams,
 	)
@@ -126,6 +145,50 @@ func (c *walletInfoCommand) Execute(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
+// salvage decrypts the root key of a wallet.db file that can't be opened
+// anymore and prints it together with the node's identity key.
+func (c *walletInfoCommand) salvage() error {
+	_, privateWalletPw, err := lnd.ReadWalletPassword()
+	if err != nil {
+		return err
+	}
+
+	masterHDPrivKey, err := lnd.SalvageWalletRootKey(
+		c.WalletDB, privateWalletPw,
+	)
+	if err != nil {
+		return fmt.Errorf("error salvaging root key from wallet file "+
+			"'%s': %w", c.WalletDB, err)
+	}
+
+	extendedKey, err := hdkeychain.NewKeyFromString(
+		string(masterHDPrivKey),
+	)
+	if err != nil {
+		return fmt.Errorf("error parsing master key: %w", err)
+	}
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	identityKey, err := keyRing.NodePubKey()
+	if err != nil {
+		return fmt.Errorf("error deriving identity key: %w", err)
+	}
+
+	result := fmt.Sprintf(
+		walletInfoFormat, identityKey.SerializeCompressed(),
+		extendedKey.String(), "n/a (salvaged)\n",
+	)
+
+	fmt.Println(result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
+}
+
 func walletInfo(w *wallet.Wallet, dumpAddrs bool) (*btcec.PublicKey, string,
 	error) {
 
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"os"
 	"testing"
 
 	"github.com/lightninglabs/chantools/lnd"
@@ -29,3 +32,64 @@ func TestWalletInfo(t *testing.T) {
 	h.assertLogContains(walletContent)
 	h.assertLogContains(rootKeyAezeed)
 }
+
+// corruptWalletDB writes a copy of the test wallet.db file in which all pages
+// for which the given function returns true are overwritten with garbage.
+func corruptWalletDB(h *harness, corrupt func(flags uint16,
+	content []byte) bool) string {
+
+	const pageSize = 4096
+	data := h.readTestdataFile("wallet.db")
+	for offset := 0; offset < len(data); offset += pageSize {
+		content := data[offset : offset+pageSize]
+		flags := binary.LittleEndian.Uint16(content[8:])
+		if corrupt(flags, content) {
+			garbage := bytes.Repeat([]byte{0xde, 0xad}, pageSize)
+			copy(content, garbage)
+		}
+	}
+
+	fileName := h.tempFile("wallet.db")
+	require.NoError(h.t, os.WriteFile(fileName, data, 0600))
+
+	return fileName
+}
+
+func TestWalletInfoSalvage(t *testing.T) {
+	h := newHarness(t)
+
+	// Destroy everything but the leaf pages, including the meta pages and
+	// the freelist. The wallet can't be opened anymore.
+	const leafPageFlag = 0x02
+	walletDB := corruptWalletDB(h, func(flags uint16, _ []byte) bool {
+		return flags != leafPageFlag
+	})
+
+	t.Setenv(lnd.PasswordEnvName, testPassPhrase)
+
+	info := &walletInfoCommand{
+		WalletDB: walletDB,
+	}
+	err := info.Execute(nil, nil)
+	require.Error(t, err)
+
+	info.Salvage = true
+	err = info.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(walletContent)
+	h.assertLogContains(rootKeyAezeed)
+
+	// The salvaged key is still protected by the password.
+	t.Setenv(lnd.PasswordEnvName, "wrong")
+	err = info.Execute(nil, nil)
+	require.ErrorContains(t, err, "invalid password")
+
+	// Without the main bucket of the address manager there is nothing to
+	// salvage.
+	info.WalletDB = corruptWalletDB(h, func(_ uint16, content []byte) bool {
+		return bytes.Contains(content, []byte("mhdpriv"))
+	})
+	err = info.Execute(nil, nil)
+	require.ErrorContains(t, err, "no 'mpriv' record found")
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/cln"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/spf13/cobra"
+)
+
+type zombieRecoveryCounterOfferCommand struct {
+	OfferFile string
+	FeeRate   uint32
+	FeeShare  uint32
+
+	HsmSecret string
+
+	Encrypt bool
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newZombieRecoveryCounterOfferCommand() *cobra.Command {
+	cc := &zombieRecoveryCounterOfferCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "counteroffer",
+		Short: "[2/3] Make a counter offer to an offer sent by the " +
+			"remote peer",
+		Long: `If the offer sent by the remote peer (created by the
+'makeoffer' or 'counteroffer' command) isn't acceptable, a counter offer can be
+made with this command.
+
+A counter offer spends exactly the same channels as the original offer, only the
+split of each channel and the fee contribution of each party can be changed.
+The previous proposal and a list of all changes are shown before the counter
+offer is signed. The counter offer is added to the history in the offer file
+that is then sent back to the remote peer. The remote peer can either accept it
+with the 'signoffer' command or make another counter offer.
+
+Because MuSig2 nonces must never be re-used, counter offers are not possible
+for Simple Taproot Channels. Instead, the party that made the original offer
+needs to create a new offer with the 'makeoffer' command.`,
+		Example: `chantools zombierecovery counteroffer \
+	--offer_file offer-xxxx-xx-xx-<pubkey>.json \
+	--feerate 15 \
+	--fee_share 50`,
+		RunE: cc.Execute,
+	}
+
+	cc.cmd.Flags().StringVar(
+		&cc.OfferFile, "offer_file", "", "the offer file (or the "+
+			"encrypted and signed envelope containing the offer "+
+			"file) that the other party sent",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.FeeRate, "feerate", 0, "fee rate to use for the sweep "+
+			"transaction in sat/vByte; if not set, the fee rate "+
+			"of the previous proposal is used",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.FeeShare, "fee_share", defaultFeeSharePercent, "the "+
+			"percentage of the sweep transaction fee we offer to "+
+			"pay, the other party pays the rest",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.HsmSecret, "hsm_secret", "", "the hex encoded HSM secret "+
+			"to use for deriving the multisig keys for a CLN "+
+			"node; obtain by running 'xxd -p -c32 "+
+			"~/.lightning/bitcoin/hsm_secret'",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Encrypt, "encrypt", false, "also write the counter offer "+
+			"to an envelope file that is encrypted to the remote "+
+			"peer's node key and signed with our node key",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "signing the counter offer")
+
+	return cc.cmd
+}
+
+func (c *zombieRecoveryCounterOfferCommand) Execute(_ *cobra.Command,
+	_ []string) error {
+
+	if c.OfferFile == "" {
+		return errors.New("offer file is required")
+	}
+
+	var signer lnd.ChannelSigner
+	switch {
+	case c.HsmSecret != "":
+		secretBytes, err := hex.DecodeString(c.HsmSecret)
+		if err != nil {
+			return fmt.Errorf("error decoding HSM secret: %w", err)
+		}
+
+		var hsmSecret [32]byte
+		copy(hsmSecret[:], secretBytes)
+
+		signer = &cln.Signer{
+			HsmSecret: hsmSecret,
+		}
+
+	default:
+		extendedKey, err := c.rootKey.read()
+		if err != nil {
+			return fmt.Errorf("error reading root key: %w", err)
+		}
+
+		signer = &lnd.Signer{
+			ExtendedKey: extendedKey,
+			ChainParams: chainParams,
+		}
+	}
+
+	ourNodePriv, err := signer.FetchPrivateKey(&keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamilyNodeKey,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deriving identity private key: %w",
+			err)
+	}
+	ourPubKeyStr := hex.EncodeToString(
+		ourNodePriv.PubKey().SerializeCompressed(),
+	)
+
+	offerBytes, sender, err := readMaybeEnvelope(
+		c.OfferFile, envelopeTypeOffer, ourNodePriv,
+	)
+	if err != nil {
+		return err
+	}
+	offer, packet, err := parseOffer(offerBytes)
+	if err != nil {
+		return err
+	}
+	if offer == nil {
+		return errors.New("a counter offer can only be made for an " +
+			"offer file, not a plain PSBT")
+	}
+
+	latest := offer.latest()
+	if latest.ProposedBy == ourPubKeyStr {
+		return errors.New("the latest proposal was made by us, " +
+			"waiting for the remote peer to respond")
+	}
+	if offer.Node1.PubKey != ourPubKeyStr &&
+		offer.Node2.PubKey != ourPubKeyStr {
+
+		return fmt.Errorf("our node %s is not part of the offer",
+			ourPubKeyStr)
+	}
+
+	switch {
+	case sender != nil && hex.EncodeToString(
+		sender.SerializeCompressed(),
+	) != latest.ProposedBy:
+		return fmt.Errorf("offer was signed by %x but proposed by %s",
+			sender.SerializeCompressed(), latest.ProposedBy)
+
+	case sender == nil:
+		log.Warnf("Offer file is not a signed envelope, cannot " +
+			"verify the offer was created by the peer")
+	}
+
+	peerPubKey, err := pubKeyFromHex(latest.ProposedBy)
+	if err != nil {
+		return fmt.Errorf("error parsing peer pubkey: %w", err)
+	}
+
+	feeRate := c.FeeRate
+	if feeRate == 0 {
+		feeRate = latest.FeeRate
+	}
+
+	offer.printLatestProposal(ourPubKeyStr)
+
+	proposal, counterPacket, err := makeCounterOffer(
+		offer, packet, signer, ourPubKeyStr, peerPubKey, feeRate,
+		c.FeeShare,
+	)
+	if err != nil {
+		return err
+	}
+	offer.addProposal(proposal)
+
+	fmt.Printf("Your counter offer:\n")
+	offer.printLatestProposal(ourPubKeyStr)
+
+	fmt.Printf("Press <enter> to continue and sign the counter offer or " +
+		"<ctrl+c> to abort: ")
+	_, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+
+	err = signCounterOffer(counterPacket, signer, peerPubKey)
+	if err != nil {
+		return err
+	}
+	proposal.Psbt, err = counterPacket.B64Encode()
+	if err != nil {
+		return fmt.Errorf("error encoding PSBT: %w", err)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	offerFileName := fmt.Sprintf("%s/offer-%s-%s-round%d.json", ResultsDir,
+		today, ourPubKeyStr, proposal.Round)
+	offerBytes, err = writeOfferFile(offerFileName, offer)
+	if err != nil {
+		return fmt.Errorf("error writing offer file: %w", err)
+	}
+
+	fmt.Printf("Done creating counter offer, please send the offer file "+
+		"%s\nto the other party to review and sign (if they accept).\n",
+		offerFileName)
+
+	if !c.Encrypt {
+		return nil
+	}
+
+	e, err := sealEnvelope(
+		envelopeTypeOffer, offerBytes, ourNodePriv, peerPubKey,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating envelope: %w", err)
+	}
+
+	fileName := fmt.Sprintf("%s/offer-%s-%s-round%d.envelope.json",
+		ResultsDir, today, ourPubKeyStr, proposal.Round)
+	return writeEnvelope(fileName, e)
+}
+
+// makeCounterOffer asks the user for a new split of each channel in the offer
+// and creates a new unsigned PSBT that spends the same inputs as the current
+// offer.
+func makeCounterOffer(offer *offerFile, packet *psbt.Packet,
+	signer lnd.ChannelSigner, ourPubKeyStr string,
+	peerPubKey *btcec.PublicKey, feeRate,
+	feeShare uint32) (*offerProposal, *psbt.Packet, error) {
+
+	ourPayoutAddr, theirPayoutAddr := offer.Node2.PayoutAddr,
+		offer.Node1.PayoutAddr
+	weAreNode1 := offer.isNode1(ourPubKeyStr)
+	if weAreNode1 {
+		ourPayoutAddr, theirPayoutAddr = offer.Node1.PayoutAddr,
+			offer.Node2.PayoutAddr
+	}
+
+	counterPacket, err := psbt.NewFromUnsignedTx(wire.NewMsgTx(2))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating PSBT from TX: %w",
+			err)
+	}
+
+	var (
+		ourSum    int64
+		theirSum  int64
+		estimator input.TxWeightEstimator
+		proposal  = &offerProposal{
+			ProposedBy: ourPubKeyStr,
+			CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+			FeeRate:    feeRate,
+		}
+		channels = offer.latest().Channels
+	)
+	for idx, offerChan := range channels {
+		pIn := packet.Inputs[idx]
+		if len(pIn.MuSig2PubNonces) > 0 ||
+			txscript.IsPayToTaproot(pIn.WitnessUtxo.PkScript) {
+
+			return nil, nil, fmt.Errorf("channel %s is a taproot "+
+				"channel, counter offers are not supported, "+
+				"ask the peer for a new offer instead",
+				offerChan.ChanPoint)
+		}
+
+		ourKey, theirKey, err := counterOfferKeys(
+			&pIn, signer, peerPubKey,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error finding keys for "+
+				"channel %s: %w", offerChan.ChanPoint, err)
+		}
+		log.Debugf("Found keys for channel %s: our key %x, their "+
+			"key %x", offerChan.ChanPoint,
+			ourKey.SerializeCompressed(),
+			theirKey.SerializeCompressed())
+
+		ch := &channel{
+			ChannelID: offerChan.ChannelID,
+			ChanPoint: offerChan.ChanPoint,
+			Address:   offerChan.Address,
+			Capacity:  offerChan.Capacity,
+		}
+		ourPart, theirPart, err := askAboutChannel(
+			ch, idx+1, len(channels), ourPayoutAddr,
+			theirPayoutAddr,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		ourSum += ourPart
+		theirSum += theirPart
+
+		if weAreNode1 {
+			proposal.Channels = append(
+				proposal.Channels,
+				newOfferChannel(ch, ourPart, theirPart),
+			)
+		} else {
+			proposal.Channels = append(
+				proposal.Channels,
+				newOfferChannel(ch, theirPart, ourPart),
+			)
+		}
+
+		// We keep the input as it is, but without any signatures. The
+		// remote peer now needs to sign with their key.
+		counterPacket.UnsignedTx.TxIn = append(
+			counterPacket.UnsignedTx.TxIn, &wire.TxIn{
+				PreviousOutPoint: packet.UnsignedTx.TxIn[idx].
+					PreviousOutPoint,
+			},
+		)
+		counterPacket.Inputs = append(counterPacket.Inputs, psbt.PInput{
+			WitnessScript: pIn.WitnessScript,
+			WitnessUtxo:   pIn.WitnessUtxo,
+			SighashType:   txscript.SigHashAll,
+			Unknowns: []*psbt.Unknown{{
+				Key:   PsbtKeyTypeOutputMissingSigPubkey,
+				Value: theirKey.SerializeCompressed(),
+			}},
+		})
+		estimator.AddWitnessInput(input.MultiSigWitnessSize)
+	}
+
+	// Don't create dust.
+	dustLimit := int64(lnwallet.DustLimitForSize(input.P2WSHSize))
+	if ourSum < dustLimit {
+		ourSum = 0
+	}
+	if theirSum < dustLimit {
+		theirSum = 0
+	}
+
+	var ourOutput, theirOutput *wire.TxOut
+	if ourSum > 0 {
+		ourOutput, err = addPayoutOutput(
+			counterPacket, &estimator, ourPayoutAddr, ourSum,
+			"our payout",
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if theirSum > 0 {
+		theirOutput, err = addPayoutOutput(
+			counterPacket, &estimator, theirPayoutAddr, theirSum,
+			"their payout",
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := int64(feeRateKWeight.FeeForWeight(estimator.Weight()))
+	ourFee, theirFee, err := distributeFee(
+		ourSum, theirSum, totalFee, feeShare,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ourFee > 0 {
+		ourSum -= ourFee
+		ourOutput.Value -= ourFee
+	}
+	if theirFee > 0 {
+		theirSum -= theirFee
+		theirOutput.Value -= theirFee
+	}
+
+	proposal.Node1Fee, proposal.Node2Fee = theirFee, ourFee
+	proposal.Node1Total, proposal.Node2Total = theirSum, ourSum
+	if weAreNode1 {
+		proposal.Node1Fee, proposal.Node2Fee = ourFee, theirFee
+		proposal.Node1Total, proposal.Node2Total = ourSum, theirSum
+	}
+
+	return proposal, counterPacket, nil
+}
+
+// counterOfferKeys returns our and the remote peer's multisig key of the given
+// input. The key we need to sign with is the one the remote peer put into the
+// PSBT unknowns, the other key is taken from the 2-of-2 multisig witness
+// script.
+func counterOfferKeys(pIn *psbt.PInput, signer lnd.ChannelSigner,
+	peerPubKey *btcec.PublicKey) (*btcec.PublicKey, *btcec.PublicKey,
+	error) {
+
+	if len(pIn.Unknowns) != 1 || !bytes.Equal(
+		pIn.Unknowns[0].Key, PsbtKeyTypeOutputMissingSigPubkey,
+	) {
+
+		return nil, nil, errors.New("invalid PSBT, missing our key")
+	}
+	ourKey, err := btcec.ParsePubKey(pIn.Unknowns[0].Value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid PSBT, unknown has "+
+			"invalid pubkey: %w", err)
+	}
+
+	// Make sure we can actually sign for the key.
+	_, err = signer.FindMultisigKey(ourKey, peerPubKey, MaxChannelLookup)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not find local multisig "+
+			"key: %w", err)
+	}
+
+	// A 2-of-2 multisig script is OP_2 <key1> <key2> OP_2 OP_CHECKMULTISIG.
+	script := pIn.WitnessScript
+	if len(script) != 71 || script[1] != 33 || script[35] != 33 {
+		return nil, nil, errors.New("invalid PSBT, witness script is " +
+			"not a 2-of-2 multisig")
+	}
+	key1, err := btcec.ParsePubKey(script[2:35])
+	if err != nil {
+		return nil, nil, err
+	}
+	key2, err := btcec.ParsePubKey(script[36:69])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case key1.IsEqual(ourKey):
+		return ourKey, key2, nil
+
+	case key2.IsEqual(ourKey):
+		return ourKey, key1, nil
+
+	default:
+		return nil, nil, errors.New("invalid PSBT, our key is not " +
+			"part of the witness script")
+	}
+}
+
+// signCounterOffer adds our partial signature to each input of the counter
+// offer.
+func signCounterOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
+	peerPubKey *btcec.PublicKey) error {
+
+	for idx := range packet.Inputs {
+		pIn := packet.Inputs[idx]
+		theirKey, err := btcec.ParsePubKey(pIn.Unknowns[0].Value)
+		if err != nil {
+			return err
+		}
+
+		script := pIn.WitnessScript
+		ourKey, err := btcec.ParsePubKey(script[2:35])
+		if err != nil {
+			return err
+		}
+		if ourKey.IsEqual(theirKey) {
+			ourKey, err = btcec.ParsePubKey(script[36:69])
+			if err != nil {
+				return err
+			}
+		}
+
+		localKeyDesc, err := signer.FindMultisigKey(
+			ourKey, peerPubKey, MaxChannelLookup,
+		)
+		if err != nil {
+			return fmt.Errorf("could not find local multisig "+
+				"key: %w", err)
+		}
+
+		err = signer.AddPartialSignature(
+			packet, *localKeyDesc, pIn.WitnessUtxo,
+			pIn.WitnessScript, idx,
+		)
+		if err != nil {
+			return fmt.Errorf("error adding partial signature: %w",
+				err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/tv42/zbase32"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// envelopeVersion is the current version of the envelope format.
+	envelopeVersion = 1
+
+	// envelopeTypePreparedKeys is the envelope type of a file created by
+	// the 'zombierecovery preparekeys' command.
+	envelopeTypePreparedKeys = "preparedkeys"
+
+	// envelopeTypeOffer is the envelope type of an offer created by the
+	// 'zombierecovery makeoffer' command.
+	envelopeTypeOffer = "offer"
+
+	// envelopeTypeSignedOffer is the envelope type of the final
+	// transaction created by the 'zombierecovery signoffer' command.
+	envelopeTypeSignedOffer = "signedoffer"
+)
+
+var (
+	// envelopeTypes is the set of all known envelope types. The type is
+	// chosen by the sender and used in file names, so any other value is
+	// refused.
+	envelopeTypes = map[string]struct{}{
+		envelopeTypePreparedKeys: {},
+		envelopeTypeOffer:        {},
+		envelopeTypeSignedOffer:  {},
+	}
+
+	// envelopeKeyInfo is the HKDF info used to derive the symmetric
+	// encryption key from the ECDH shared secret of the two node keys.
+	envelopeKeyInfo = []byte("chantools zombierecovery envelope")
+)
+
+// envelope is an encrypted and signed container for the files that are
+// exchanged between two nodes during a zombie channel recovery. The payload is
+// encrypted to the recipient with a key derived from an ECDH between both node
+// identity keys and then signed with the sender's node identity key (in the
+// same way the 'signmessage' command does). That way the recipient can be sure
+// the keys, payout address or offer contained in the payload were created by
+// the owner of the sender node.
+type envelope struct {
+	Version    uint8  `json:"envelope_version"`
+	Type       string `json:"type"`
+	Sender     string `json:"sender"`
+	Recipient  string `json:"recipient"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+	Signature  string `json:"signature"`
+}
+
+// sealEnvelope encrypts the given payload to the recipient node and signs the
+// result with the sender's node identity key.
+func sealEnvelope(payloadType string, payload []byte,
+	senderKey *btcec.PrivateKey,
+	recipient *btcec.PublicKey) (*envelope, error) {
+
+	e := &envelope{
+		Version: envelopeVersion,
+		Type:    payloadType,
+		Sender: hex.EncodeToString(
+			senderKey.PubKey().SerializeCompressed(),
+		),
+		Recipient: hex.EncodeToString(recipient.SerializeCompressed()),
+	}
+
+	aead, err := envelopeCipher(senderKey, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	ciphertext := aead.Seal(nil, nonce, payload, e.associatedData())
+	e.Nonce = hex.EncodeToString(nonce)
+	e.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+
+	msgSigner := keychain.NewPrivKeyMessageSigner(
+		senderKey, keychain.KeyLocator{
+			Family: keychain.KeyFamilyNodeKey,
+		},
+	)
+	sig, err := msgSigner.SignMessageCompact(e.signedMessage(), true)
+	if err != nil {
+		return nil, fmt.Errorf("error signing envelope: %w", err)
+	}
+	e.Signature = zbase32.EncodeToString(sig)
+
+	return e, nil
+}
+
+// open verifies the signature of the envelope and decrypts the payload with
+// the given recipient node identity key. The payload type must match the
+// expected type. The public key of the sender is returned together with the
+// decrypted payload.
+func (e *envelope) open(expectedType string,
+	recipientKey *btcec.PrivateKey) (*btcec.PublicKey, []byte, error) {
+
+	if e.Version != envelopeVersion {
+		return nil, nil, fmt.Errorf("unsupported envelope version %d",
+			e.Version)
+	}
+
+	if e.Type != expectedType {
+		return nil, nil, fmt.Errorf("invalid envelope type '%s', "+
+			"expected '%s'", e.Type, expectedType)
+	}
+
+	ourKey := hex.EncodeToString(
+		recipientKey.PubKey().SerializeCompressed(),
+	)
+	if e.Recipient != ourKey {
+		return nil, nil, fmt.Errorf("envelope is addressed to %s but "+
+			"our node key is %s", e.Recipient, ourKey)
+	}
+
+	sender, err := pubKeyFromHex(e.Sender)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing sender key: %w",
+			err)
+	}
+
+	// Make sure the sender actually controls the node key they claim to
+	// be, before we even look at the content.
+	sig, err := zbase32.DecodeString(e.Signature)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding signature: %w",
+			err)
+	}
+	signerKey, _, err := ecdsa.RecoverCompact(
+		sig, chainhash.DoubleHashB(e.signedMessage()),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error verifying signature: %w",
+			err)
+	}
+	if !signerKey.IsEqual(sender) {
+		return nil, nil, fmt.Errorf("invalid envelope signature, "+
+			"signed by %x instead of sender %s",
+			signerKey.SerializeCompressed(), e.Sender)
+	}
+
+	nonce, err := hex.DecodeString(e.Nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(e.Ciphertext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding ciphertext: %w",
+			err)
+	}
+
+	aead, err := envelopeCipher(recipientKey, sender)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, nil, fmt.Errorf("invalid nonce length %d",
+			len(nonce))
+	}
+
+	payload, err := aead.Open(nil, nonce, ciphertext, e.associatedData())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decrypting envelope: %w",
+			err)
+	}
+
+	return sender, payload, nil
+}
+
+// associatedData returns the envelope header that is authenticated together
+// with the encrypted payload.
+func (e *envelope) associatedData() []byte {
+	var b bytes.Buffer
+	b.WriteByte(e.Version)
+	b.WriteString(e.Type)
+	b.WriteString(e.Sender)
+	b.WriteString(e.Recipient)
+
+	return b.Bytes()
+}
+
+// signedMessage returns the message that is signed by the sender. We use the
+// same prefix as lnd's signmessage RPC so the signature cannot be confused
+// with a signature for any other purpose.
+func (e *envelope) signedMessage() []byte {
+	msg := append([]byte{}, signedMsgPrefix...)
+	msg = append(msg, e.associatedData()...)
+	msg = append(msg, []byte(e.Nonce)...)
+	msg = append(msg, []byte(e.Ciphertext)...)
+
+	return msg
+}
+
+// envelopeCipher derives the symmetric key shared between the two nodes and
+// returns the AEAD cipher for it.
+func envelopeCipher(privKey *btcec.PrivateKey,
+	pubKey *btcec.PublicKey) (cipher.AEAD, error) {
+
+	sharedSecret, err := lnd.ECDH(privKey, pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving shared secret: %w", err)
+	}
+
+	var key [chacha20poly1305.KeySize]byte
+	kdf := hkdf.New(sha256.New, sharedSecret[:], nil, envelopeKeyInfo)
+	if _, err := io.ReadFull(kdf, key[:]); err != nil {
+		return nil, fmt.Errorf("error deriving encryption key: %w",
+			err)
+	}
+
+	return chacha20poly1305.NewX(key[:])
+}
+
+// writeEnvelope writes the given envelope as a JSON file.
+func writeEnvelope(fileName string, e *envelope) error {
+	envelopeBytes, err := json.MarshalIndent(e, "", " ")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Writing encrypted and signed envelope for node %s to %s",
+		e.Recipient, fileName)
+	return os.WriteFile(fileName, envelopeBytes, 0644)
+}
+
+// readMaybeEnvelope reads the given file and, if it contains an envelope,
+// verifies and decrypts it with our node identity key. If the file doesn't
+// contain an envelope, the raw file content is returned and the sender is nil.
+func readMaybeEnvelope(fileName, expectedType string,
+	ourKey *btcec.PrivateKey) ([]byte, *btcec.PublicKey, error) {
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading file %s: %w",
+			fileName, err)
+	}
+
+	e, err := parseEnvelope(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding file %s: %w",
+			fileName, err)
+	}
+
+	// Not an envelope, return the plain content.
+	if e == nil {
+		return content, nil, nil
+	}
+
+	sender, payload, err := e.open(expectedType, ourKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening envelope %s: %w",
+			fileName, err)
+	}
+
+	log.Infof("Verified signature of envelope %s from node %s", fileName,
+		e.Sender)
+
+	return payload, sender, nil
+}
+
+// parseEnvelope attempts to parse the given content as an envelope. If the
+// content is valid JSON but not an envelope, nil is returned.
+func parseEnvelope(content []byte) (*envelope, error) {
+	// Anything that isn't a JSON object can't be an envelope (for example
+	// a plain base64 encoded PSBT).
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, nil
+	}
+
+	e := &envelope{}
+	if err := json.Unmarshal(trimmed, e); err != nil {
+		return nil, err
+	}
+
+	if e.Version == 0 {
+		return nil, nil
+	}
+
+	if e.Sender == "" || e.Recipient == "" || e.Ciphertext == "" {
+		return nil, errors.New("invalid envelope, missing fields")
+	}
+
+	if _, ok := envelopeTypes[e.Type]; !ok {
+		return nil, fmt.Errorf("invalid envelope, unknown type %q",
+			e.Type)
+	}
+
+	return e, nil
+}
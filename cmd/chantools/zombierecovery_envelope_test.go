@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	sender, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	recipient, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	mallory, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	payload := []byte(`{"node1":{"identity_pubkey":"02abcd"}}`)
+	e, err := sealEnvelope(
+		envelopeTypePreparedKeys, payload, sender, recipient.PubKey(),
+	)
+	require.NoError(t, err)
+
+	// The recipient can open the envelope and learns who sent it.
+	from, content, err := e.open(envelopeTypePreparedKeys, recipient)
+	require.NoError(t, err)
+	require.Equal(t, payload, content)
+	require.True(t, from.IsEqual(sender.PubKey()))
+
+	// The envelope can't be opened as a different type.
+	_, _, err = e.open(envelopeTypeOffer, recipient)
+	require.ErrorContains(t, err, "invalid envelope type")
+
+	// Nobody else can open the envelope.
+	_, _, err = e.open(envelopeTypePreparedKeys, mallory)
+	require.ErrorContains(t, err, "envelope is addressed to")
+
+	// Claiming to be someone else invalidates the signature.
+	spoofed := *e
+	spoofed.Sender = e.Recipient
+	_, _, err = spoofed.open(envelopeTypePreparedKeys, recipient)
+	require.ErrorContains(t, err, "invalid envelope signature")
+
+	// Any change to the ciphertext invalidates the signature.
+	ciphertext, err := base64.StdEncoding.DecodeString(e.Ciphertext)
+	require.NoError(t, err)
+	ciphertext[0] ^= 0x01
+	tampered := *e
+	tampered.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	_, _, err = tampered.open(envelopeTypePreparedKeys, recipient)
+	require.ErrorContains(t, err, "invalid envelope signature")
+}
+
+func TestReadMaybeEnvelope(t *testing.T) {
+	h := newHarness(t)
+
+	sender, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	recipient, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	payload := []byte("cHNidP8BAH0CAAAAA...")
+
+	// A plain file is returned as is, without a sender.
+	plainFile := h.tempFile("plain.txt")
+	require.NoError(t, os.WriteFile(plainFile, payload, 0644))
+	content, from, err := readMaybeEnvelope(
+		plainFile, envelopeTypeOffer, recipient,
+	)
+	require.NoError(t, err)
+	require.Nil(t, from)
+	require.Equal(t, payload, content)
+
+	// A JSON file that isn't an envelope is also returned as is.
+	plainJSON := h.tempFile("plain.json")
+	jsonPayload, err := json.Marshal(&match{})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(plainJSON, jsonPayload, 0644))
+	content, from, err = readMaybeEnvelope(
+		plainJSON, envelopeTypePreparedKeys, recipient,
+	)
+	require.NoError(t, err)
+	require.Nil(t, from)
+	require.Equal(t, jsonPayload, content)
+
+	// An envelope is decrypted and verified.
+	e, err := sealEnvelope(
+		envelopeTypeOffer, payload, sender, recipient.PubKey(),
+	)
+	require.NoError(t, err)
+	envelopeFile := h.tempFile("offer.envelope.json")
+	require.NoError(t, writeEnvelope(envelopeFile, e))
+
+	content, from, err = readMaybeEnvelope(
+		envelopeFile, envelopeTypeOffer, recipient,
+	)
+	require.NoError(t, err)
+	require.True(t, from.IsEqual(sender.PubKey()))
+	require.Equal(t, payload, content)
+	h.assertLogContains("Verified signature of envelope")
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/lightninglabs/chantools/cln"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/brontide"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// zombieMsgTypeEnvelopeChunk is the custom message type that carries
+	// a chunk of an envelope. We use an odd type, so peers that don't
+	// understand the message can safely ignore it.
+	zombieMsgTypeEnvelopeChunk = lnwire.CustomTypeStart + 11001
+
+	// zombieMsgTypeDone is the custom message type that signals the peer
+	// that we've sent all envelopes we wanted to send.
+	zombieMsgTypeDone = lnwire.CustomTypeStart + 11003
+
+	// envelopeChunkHeaderSize is the size of the header of each envelope
+	// chunk: an 8 byte transfer ID, a 2 byte chunk index and a 2 byte total
+	// number of chunks.
+	envelopeChunkHeaderSize = 8 + 2 + 2
+
+	// maxEnvelopeChunkSize is the maximum number of envelope bytes that
+	// are sent in a single custom message.
+	maxEnvelopeChunkSize = lnwire.MaxMsgBody - envelopeChunkHeaderSize
+
+	// maxEnvelopeSize is the maximum size of a serialized envelope. This
+	// is far more than the keys or PSBTs exchanged for any realistic
+	// number of channels, but limits how much a peer can make us buffer.
+	maxEnvelopeSize = 8 << 20
+
+	// maxEnvelopeChunks is the number of chunks the largest valid
+	// envelope is split into.
+	maxEnvelopeChunks = (maxEnvelopeSize + maxEnvelopeChunkSize - 1) /
+		maxEnvelopeChunkSize
+
+	// maxOpenEnvelopeTransfers is the maximum number of envelopes that
+	// can be received at the same time. Envelopes are sent one after the
+	// other, so an honest peer only ever has a single open transfer.
+	maxOpenEnvelopeTransfers = 4
+
+	// envelopeTransferTimeout is the time after which an incomplete
+	// envelope is dropped to make room for new transfers.
+	envelopeTransferTimeout = 5 * time.Minute
+
+	defaultExchangeTimeout = 10 * time.Minute
+)
+
+type zombieRecoveryExchangeCommand struct {
+	Peer    string
+	Listen  string
+	Send    []string
+	Timeout time.Duration
+
+	TorProxy string
+
+	HsmSecret string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newZombieRecoveryExchangeCommand() *cobra.Command {
+	cc := &zombieRecoveryExchangeCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "exchange",
+		Short: "Exchange envelope files with the remote peer " +
+			"over the Lightning p2p network",
+		Long: `Instead of copy/pasting files between the two parties of
+a zombie channel recovery, the encrypted and signed envelope files created by
+'preparekeys --encrypt', 'makeoffer --encrypt' and 'signoffer --encrypt' can be
+exchanged directly over an encrypted brontide (Lightning p2p) connection.
+
+One of the parties needs to listen for an incoming connection with the --listen
+flag, the other one connects to it with the --peer flag. Both parties send the
+envelope files specified with --send (if any) and store all envelopes received
+from the remote peer in the results directory. Each received envelope is
+verified to be addressed to our node and to be signed by the connected peer.
+The files can then be used with the next step of the recovery.
+
+The envelopes are transported as custom Lightning messages (odd types in the
+custom range >= 32768), so this command only works if both parties run
+chantools, not with a regular Lightning node.`,
+		Example: `chantools zombierecovery exchange \
+	--listen 0.0.0.0:9736 \
+	--send preparedkeys-xxxx-xx-xx-<pubkey1>.envelope.json
+
+chantools zombierecovery exchange \
+	--peer <pubkey1>@xx.yy.zz.aa:9736 \
+	--send preparedkeys-xxxx-xx-xx-<pubkey2>.envelope.json`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Peer, "peer", "", "remote peer address to connect to "+
+			"(<pubkey>@<host>[:<port>])",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Listen, "listen", "", "local address to listen on for "+
+			"an incoming connection from the remote peer "+
+			"(<host>:<port>)",
+	)
+	cc.cmd.Flags().StringSliceVar(
+		&cc.Send, "send", nil, "envelope file(s) to send to the "+
+			"remote peer; can be specified multiple times",
+	)
+	cc.cmd.Flags().DurationVar(
+		&cc.Timeout, "timeout", defaultExchangeTimeout, "the maximum "+
+			"time to wait for the remote peer to connect and send "+
+			"all its envelopes",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.TorProxy, "torproxy", "", "SOCKS5 proxy to use for Tor "+
+			"connections (to .onion addresses)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.HsmSecret, "hsm_secret", "", "the hex encoded HSM secret "+
+			"to use for deriving the node key for a CLN "+
+			"node; obtain by running 'xxd -p -c32 "+
+			"~/.lightning/bitcoin/hsm_secret'",
+	)
+	cc.rootKey = newRootKey(cc.cmd, "deriving the identity key")
+
+	return cc.cmd
+}
+
+func (c *zombieRecoveryExchangeCommand) Execute(_ *cobra.Command,
+	_ []string) error {
+
+	if (c.Peer == "") == (c.Listen == "") {
+		return errors.New("exactly one of --peer or --listen must be " +
+			"specified")
+	}
+
+	var identityPriv *btcec.PrivateKey
+	switch {
+	case c.HsmSecret != "":
+		secretBytes, err := hex.DecodeString(c.HsmSecret)
+		if err != nil {
+			return fmt.Errorf("error decoding HSM secret: %w", err)
+		}
+
+		var hsmSecret [32]byte
+		copy(hsmSecret[:], secretBytes)
+
+		_, identityPriv, err = cln.NodeKey(hsmSecret)
+		if err != nil {
+			return fmt.Errorf("error deriving identity key: %w",
+				err)
+		}
+
+	default:
+		extendedKey, err := c.rootKey.read()
+		if err != nil {
+			return fmt.Errorf("error reading root key: %w", err)
+		}
+
+		identityPath := lnd.IdentityPath(chainParams)
+		child, _, _, err := lnd.DeriveKey(
+			extendedKey, identityPath, chainParams,
+		)
+		if err != nil {
+			return fmt.Errorf("could not derive identity key: %w",
+				err)
+		}
+		identityPriv, err = child.ECPrivKey()
+		if err != nil {
+			return fmt.Errorf("could not get identity private "+
+				"key: %w", err)
+		}
+	}
+	identityECDH := &keychain.PrivKeyECDH{
+		PrivKey: identityPriv,
+	}
+
+	toSend := make([]*envelope, 0, len(c.Send))
+	for _, fileName := range c.Send {
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return fmt.Errorf("error reading file %s: %w",
+				fileName, err)
+		}
+
+		e, err := parseEnvelope(content)
+		if err != nil {
+			return fmt.Errorf("error decoding file %s: %w",
+				fileName, err)
+		}
+		if e == nil {
+			return fmt.Errorf("file %s is not an envelope, use "+
+				"the --encrypt flag to create one", fileName)
+		}
+
+		toSend = append(toSend, e)
+	}
+
+	conn, err := c.connect(identityECDH)
+	if err != nil {
+		return err
+	}
+	session := lnd.NewSession(conn)
+	defer func() {
+		_ = session.Close()
+	}()
+
+	received, err := exchangeEnvelopes(
+		session, identityECDH, toSend, c.Timeout,
+	)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	for idx, e := range received {
+		fileName := fmt.Sprintf("%s/received-%s-%s-%s-%d.envelope.json",
+			ResultsDir, e.Type, today, e.Sender, idx)
+		if err := writeEnvelope(fileName, e); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Sent %d and received %d envelope(s)", len(toSend),
+		len(received))
+
+	return nil
+}
+
+// connect either dials the remote peer or waits for the remote peer to connect
+// to us, depending on the command flags.
+func (c *zombieRecoveryExchangeCommand) connect(
+	identity keychain.SingleKeyECDH) (*brontide.Conn, error) {
+
+	if c.Listen != "" {
+		// We don't know the remote peer's key in advance, but each
+		// received envelope is verified against the connected peer.
+		acceptAll := func(*btcec.PublicKey) (bool, error) {
+			return true, nil
+		}
+		listener, err := brontide.NewListener(
+			identity, c.Listen, acceptAll,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error listening on %s: %w",
+				c.Listen, err)
+		}
+		defer func() {
+			_ = listener.Close()
+		}()
+
+		log.Infof("Waiting for remote peer to connect to %s (our "+
+			"node key is %x)", listener.Addr(),
+			identity.PubKey().SerializeCompressed())
+
+		conn, err := acceptWithTimeout(listener, c.Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	log.Infof("Connecting to remote peer %v", c.Peer)
+	return dialPeer(c.Peer, c.TorProxy, identity, dialTimeout)
+}
+
+// acceptWithTimeout waits for the next incoming brontide connection on the
+// given listener.
+func acceptWithTimeout(listener *brontide.Listener,
+	timeout time.Duration) (*brontide.Conn, error) {
+
+	type acceptResult struct {
+		conn net.Conn
+		err  error
+	}
+	resultChan := make(chan acceptResult, 1)
+	go func() {
+		conn, err := listener.Accept()
+		resultChan <- acceptResult{conn: conn, err: err}
+	}()
+
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			return nil, fmt.Errorf("error accepting connection: "+
+				"%w", result.err)
+		}
+
+		conn, ok := result.conn.(*brontide.Conn)
+		if !ok {
+			_ = result.conn.Close()
+			return nil, fmt.Errorf("unexpected connection type %T",
+				result.conn)
+		}
+
+		return conn, nil
+
+	case <-time.After(timeout):
+		return nil, errors.New("timed out waiting for remote peer " +
+			"to connect")
+	}
+}
+
+// exchangeEnvelopes sends all given envelopes to the remote peer over the
+// given session and waits until the remote peer has sent all its envelopes to
+// us. Only envelopes that are addressed to us and signed by the connected peer
+// are returned.
+func exchangeEnvelopes(session *lnd.Session, identity *keychain.PrivKeyECDH,
+	toSend []*envelope, timeout time.Duration) ([]*envelope, error) {
+
+	remotePub := session.RemotePub()
+	remotePubStr := hex.EncodeToString(remotePub.SerializeCompressed())
+	for _, e := range toSend {
+		if e.Recipient != remotePubStr {
+			return nil, fmt.Errorf("envelope is addressed to %s "+
+				"but connected peer is %s", e.Recipient,
+				remotePubStr)
+		}
+	}
+
+	if err := session.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("error setting deadline: %w", err)
+	}
+
+	// Because both peers are chantools, we don't signal any features.
+	err := session.Init(
+		lnwire.NewRawFeatureVector(), lnwire.NewRawFeatureVector(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	receiver := newEnvelopeReceiver(identity.PrivKey, remotePub)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readEnvelopeMessages(session, receiver)
+	}()
+
+	for _, e := range toSend {
+		msgs, err := envelopeToMessages(e)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Sending %s envelope to peer in %d message(s)",
+			e.Type, len(msgs))
+		for _, msg := range msgs {
+			if err := session.Send(msg); err != nil {
+				return nil, fmt.Errorf("error sending "+
+					"envelope: %w", err)
+			}
+		}
+	}
+
+	done, err := lnwire.NewCustom(zombieMsgTypeDone, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.Send(done); err != nil {
+		return nil, fmt.Errorf("error sending message: %w", err)
+	}
+
+	if err := <-readErr; err != nil {
+		return nil, fmt.Errorf("error reading from peer %s: %w",
+			remotePubStr, err)
+	}
+
+	return receiver.result()
+}
+
+// readEnvelopeMessages reads messages from the remote peer and hands all
+// custom messages to the receiver until the peer signals it is done.
+func readEnvelopeMessages(session *lnd.Session,
+	receiver *envelopeReceiver) error {
+
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.(type) {
+		case *lnwire.Error:
+			return fmt.Errorf("peer sent error: %v", m.Error())
+
+		case *lnwire.Custom:
+			receiver.handleMessage(m)
+
+		default:
+			log.Debugf("Ignoring message of type %v", msg.MsgType())
+		}
+
+		select {
+		case <-receiver.done:
+			return nil
+		default:
+		}
+	}
+}
+
+// envelopeToMessages serializes the given envelope and splits it into as many
+// custom messages as are required to transport it.
+func envelopeToMessages(e *envelope) ([]lnwire.Message, error) {
+	envelopeBytes, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var transferID [8]byte
+	if _, err := rand.Read(transferID[:]); err != nil {
+		return nil, err
+	}
+
+	if len(envelopeBytes) > maxEnvelopeSize {
+		return nil, fmt.Errorf("envelope too large: %d bytes, maximum "+
+			"is %d bytes", len(envelopeBytes), maxEnvelopeSize)
+	}
+	numChunks := (len(envelopeBytes) + maxEnvelopeChunkSize - 1) /
+		maxEnvelopeChunkSize
+
+	msgs := make([]lnwire.Message, 0, numChunks)
+	for idx := range numChunks {
+		start := idx * maxEnvelopeChunkSize
+		end := min(start+maxEnvelopeChunkSize, len(envelopeBytes))
+
+		data := make([]byte, envelopeChunkHeaderSize, end-start+
+			envelopeChunkHeaderSize)
+		copy(data[:8], transferID[:])
+		binary.BigEndian.PutUint16(data[8:10], uint16(idx))
+		binary.BigEndian.PutUint16(data[10:12], uint16(numChunks))
+		data = append(data, envelopeBytes[start:end]...)
+
+		msg, err := lnwire.NewCustom(zombieMsgTypeEnvelopeChunk, data)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// envelopeReceiver re-assembles envelopes from custom messages received from
+// the remote peer.
+type envelopeReceiver struct {
+	ourKey    *btcec.PrivateKey
+	remotePub *btcec.PublicKey
+
+	mu        sync.Mutex
+	transfers map[[8]byte]*envelopeTransfer
+	envelopes []*envelope
+	err       error
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// newEnvelopeReceiver creates a new envelope receiver for messages from the
+// given remote peer.
+func newEnvelopeReceiver(ourKey *btcec.PrivateKey,
+	remotePub *btcec.PublicKey) *envelopeReceiver {
+
+	return &envelopeReceiver{
+		ourKey:    ourKey,
+		remotePub: remotePub,
+		transfers: make(map[[8]byte]*envelopeTransfer),
+		done:      make(chan struct{}),
+	}
+}
+
+// envelopeTransfer is an envelope of which not all chunks were received yet.
+type envelopeTransfer struct {
+	chunks  [][]byte
+	started time.Time
+}
+
+// handleMessage is called for every custom message received from the peer.
+func (r *envelopeReceiver) handleMessage(msg *lnwire.Custom) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch msg.Type {
+	case zombieMsgTypeEnvelopeChunk:
+		err := r.addChunk(msg.Data)
+		if err != nil && r.err == nil {
+			log.Errorf("Error processing envelope chunk: %v", err)
+			r.err = err
+		}
+
+	case zombieMsgTypeDone:
+		if len(r.transfers) > 0 && r.err == nil {
+			r.err = fmt.Errorf("peer is done but %d envelope(s) "+
+				"are incomplete", len(r.transfers))
+		}
+		r.doneOnce.Do(func() {
+			close(r.done)
+		})
+
+	default:
+		log.Debugf("Ignoring custom message of type %d", msg.Type)
+	}
+}
+
+// addChunk adds a single envelope chunk. Once all chunks of an envelope are
+// received, the envelope is decoded and verified.
+//
+// NOTE: The mutex must be held when calling this method.
+func (r *envelopeReceiver) addChunk(data []byte) error {
+	if len(data) < envelopeChunkHeaderSize {
+		return fmt.Errorf("invalid chunk length %d", len(data))
+	}
+
+	var transferID [8]byte
+	copy(transferID[:], data[:8])
+	idx := int(binary.BigEndian.Uint16(data[8:10]))
+	numChunks := int(binary.BigEndian.Uint16(data[10:12]))
+	if numChunks == 0 || idx >= numChunks {
+		return fmt.Errorf("invalid chunk %d of %d", idx, numChunks)
+	}
+	if numChunks > maxEnvelopeChunks {
+		return fmt.Errorf("envelope with %d chunks exceeds maximum "+
+			"of %d chunks", numChunks, maxEnvelopeChunks)
+	}
+
+	transfer, ok := r.transfers[transferID]
+	if !ok {
+		r.dropStaleTransfers()
+		if len(r.transfers) >= maxOpenEnvelopeTransfers {
+			return fmt.Errorf("too many incomplete envelopes, "+
+				"maximum is %d", maxOpenEnvelopeTransfers)
+		}
+
+		transfer = &envelopeTransfer{
+			chunks:  make([][]byte, numChunks),
+			started: time.Now(),
+		}
+		r.transfers[transferID] = transfer
+	}
+	chunks := transfer.chunks
+	if len(chunks) != numChunks {
+		return fmt.Errorf("invalid number of chunks %d, expected %d",
+			numChunks, len(chunks))
+	}
+	chunks[idx] = append([]byte{}, data[envelopeChunkHeaderSize:]...)
+
+	for _, chunk := range chunks {
+		if chunk == nil {
+			return nil
+		}
+	}
+	delete(r.transfers, transferID)
+
+	e, err := parseEnvelope(bytes.Join(chunks, nil))
+	if err != nil {
+		return fmt.Errorf("error decoding envelope: %w", err)
+	}
+	if e == nil {
+		return errors.New("received data is not an envelope")
+	}
+
+	// We make sure we can actually open the envelope and that it was
+	// created by the peer we're connected to.
+	sender, _, err := e.open(e.Type, r.ourKey)
+	if err != nil {
+		return fmt.Errorf("error opening envelope: %w", err)
+	}
+	if !sender.IsEqual(r.remotePub) {
+		return fmt.Errorf("envelope was signed by %s but connected "+
+			"peer is %x", e.Sender,
+			r.remotePub.SerializeCompressed())
+	}
+
+	log.Infof("Received and verified %s envelope from peer %s", e.Type,
+		e.Sender)
+	r.envelopes = append(r.envelopes, e)
+
+	return nil
+}
+
+// dropStaleTransfers removes all incomplete envelopes that didn't receive all
+// their chunks within the transfer timeout.
+//
+// NOTE: The mutex must be held when calling this method.
+func (r *envelopeReceiver) dropStaleTransfers() {
+	for transferID, transfer := range r.transfers {
+		if time.Since(transfer.started) < envelopeTransferTimeout {
+			continue
+		}
+
+		log.Warnf("Dropping incomplete envelope %x that didn't "+
+			"receive all of its %d chunks in time", transferID,
+			len(transfer.chunks))
+		delete(r.transfers, transferID)
+	}
+}
+
+// result returns all received envelopes or the first error that occurred.
+func (r *envelopeReceiver) result() ([]*envelope, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.envelopes, r.err
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/brontide"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeToMessages(t *testing.T) {
+	sender, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	recipient, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	// A payload that is larger than a single message forces the envelope
+	// to be split into multiple chunks.
+	payload := bytes.Repeat([]byte{'a'}, 3*maxEnvelopeChunkSize)
+	e, err := sealEnvelope(
+		envelopeTypePreparedKeys, payload, sender, recipient.PubKey(),
+	)
+	require.NoError(t, err)
+
+	msgs, err := envelopeToMessages(e)
+	require.NoError(t, err)
+	require.Len(t, msgs, 5)
+
+	// Deliver the chunks in reverse order to make sure the receiver can
+	// re-assemble them.
+	receiver := newEnvelopeReceiver(recipient, sender.PubKey())
+	for idx := len(msgs) - 1; idx >= 0; idx-- {
+		msg, ok := msgs[idx].(*lnwire.Custom)
+		require.True(t, ok)
+		require.LessOrEqual(t, len(msg.Data), lnwire.MaxMsgBody)
+		receiver.handleMessage(msg)
+	}
+
+	received, err := receiver.result()
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	require.Equal(t, e, received[0])
+
+	// The type is used in the file name of received envelopes, so the
+	// peer can't choose an arbitrary one.
+	e.Type = "../../etc/evil"
+	msgs, err = envelopeToMessages(e)
+	require.NoError(t, err)
+	receiver = newEnvelopeReceiver(recipient, sender.PubKey())
+	for _, msg := range msgs {
+		customMsg, ok := msg.(*lnwire.Custom)
+		require.True(t, ok)
+		receiver.handleMessage(customMsg)
+	}
+	_, err = receiver.result()
+	require.ErrorContains(t, err, "unknown type")
+}
+
+// envelopeChunk creates the data of an envelope chunk message.
+func envelopeChunk(transferID byte, idx, numChunks uint16) []byte {
+	data := make([]byte, envelopeChunkHeaderSize+1)
+	data[0] = transferID
+	binary.BigEndian.PutUint16(data[8:10], idx)
+	binary.BigEndian.PutUint16(data[10:12], numChunks)
+
+	return data
+}
+
+func TestEnvelopeReceiverLimits(t *testing.T) {
+	_ = newHarness(t)
+
+	// We never send or accept envelopes that are larger than the limit.
+	_, err := envelopeToMessages(&envelope{
+		Ciphertext: strings.Repeat("a", maxEnvelopeSize),
+	})
+	require.ErrorContains(t, err, "envelope too large")
+
+	receiver := newEnvelopeReceiver(nil, nil)
+	err = receiver.addChunk(envelopeChunk(1, 0, maxEnvelopeChunks+1))
+	require.ErrorContains(t, err, "exceeds maximum")
+	require.Empty(t, receiver.transfers)
+
+	// Only a limited number of incomplete envelopes is kept.
+	for id := range byte(maxOpenEnvelopeTransfers) {
+		require.NoError(t, receiver.addChunk(envelopeChunk(id, 0, 2)))
+	}
+	err = receiver.addChunk(envelopeChunk(0xff, 0, 2))
+	require.ErrorContains(t, err, "too many incomplete envelopes")
+
+	// Incomplete envelopes are dropped after the timeout to make room
+	// for new ones.
+	for _, transfer := range receiver.transfers {
+		transfer.started = time.Now().Add(-envelopeTransferTimeout)
+	}
+	require.NoError(t, receiver.addChunk(envelopeChunk(0xff, 0, 2)))
+	require.Len(t, receiver.transfers, 1)
+}
+
+func TestExchangeEnvelopes(t *testing.T) {
+	_ = newHarness(t)
+
+	alice, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	bob, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	aliceECDH := &keychain.PrivKeyECDH{PrivKey: alice}
+	bobECDH := &keychain.PrivKeyECDH{PrivKey: bob}
+
+	aliceEnvelope, err := sealEnvelope(
+		envelopeTypePreparedKeys,
+		bytes.Repeat([]byte{'k'}, 2*maxEnvelopeChunkSize), alice,
+		bob.PubKey(),
+	)
+	require.NoError(t, err)
+	bobEnvelope, err := sealEnvelope(
+		envelopeTypeOffer, []byte("cHNidP8B"), bob, alice.PubKey(),
+	)
+	require.NoError(t, err)
+
+	acceptAll := func(*btcec.PublicKey) (bool, error) {
+		return true, nil
+	}
+	listener, err := brontide.NewListener(
+		aliceECDH, "127.0.0.1:0", acceptAll,
+	)
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	type result struct {
+		envelopes []*envelope
+		err       error
+	}
+	aliceResult := make(chan result, 1)
+	go func() {
+		conn, err := acceptWithTimeout(listener, 10*time.Second)
+		if err != nil {
+			aliceResult <- result{err: err}
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		envelopes, err := exchangeEnvelopes(
+			lnd.NewSession(conn), aliceECDH,
+			[]*envelope{aliceEnvelope}, 10*time.Second,
+		)
+		aliceResult <- result{envelopes: envelopes, err: err}
+	}()
+
+	aliceAddr := &lnwire.NetAddress{
+		IdentityKey: alice.PubKey(),
+		Address:     listener.Addr(),
+	}
+	conn, err := brontide.Dial(
+		bobECDH, aliceAddr, 10*time.Second, (&tor.ClearNet{}).Dial,
+	)
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	bobSession := lnd.NewSession(conn)
+	bobReceived, err := exchangeEnvelopes(
+		bobSession, bobECDH, []*envelope{bobEnvelope}, 10*time.Second,
+	)
+	require.NoError(t, err)
+	require.Len(t, bobReceived, 1)
+	require.Equal(t, aliceEnvelope, bobReceived[0])
+
+	aliceReceived := <-aliceResult
+	require.NoError(t, aliceReceived.err)
+	require.Len(t, aliceReceived.envelopes, 1)
+	require.Equal(t, bobEnvelope, aliceReceived.envelopes[0])
+
+	// Envelopes addressed to someone else are refused before anything is
+	// sent.
+	_, err = exchangeEnvelopes(
+		bobSession, bobECDH, []*envelope{aliceEnvelope}, time.Second,
+	)
+	require.ErrorContains(t, err, "but connected peer is")
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
@@ -14,6 +16,9 @@ import (
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/hasura/go-graphql-client"
+	"github.com/lightninglabs/chantools/btc"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/spf13/cobra"
 	"golang.org/x/oauth2"
 )
@@ -131,6 +136,9 @@ type zombieRecoveryFindMatchesCommand struct {
 	AmbossKey     string
 	AmbossDelay   time.Duration
 
+	FromChannelGraph string
+	ChannelDB        string
+
 	cmd *cobra.Command
 }
 
@@ -145,10 +153,24 @@ registered their ID on https://www.node-recovery.com and checks whether there
 are any matches of channels between them by looking at the whole channel graph.
 
 This command will be run by guggero and the result will be sent to the
-registered nodes.`,
+registered nodes.
+
+Instead of querying the Amboss API, the channel graph can also be read from a
+local file created by 'lncli describegraph' (--from_channel_graph) or from the
+graph stored in an lnd channel.db file (--channeldb). That allows anyone to run
+their own recovery coordination. In that case each channel is checked to still
+be unspent using the configured chain API (--apiurl).
+
+The registrations file can either be the raw data.txt of node-recovery.com or a
+JSON file with a list of registered nodes:
+[{"identity_pubkey": "03xxxx", "contact": "email@example.com"}, ...]`,
 		Example: `chantools zombierecovery findmatches \
 	--registrations data.txt \
-	--ambosskey <API key>`,
+	--ambosskey <API key>
+
+chantools zombierecovery findmatches \
+	--registrations registrations.json \
+	--from_channel_graph lncli_describegraph.json`,
 		RunE: cc.Execute,
 	}
 
@@ -168,6 +190,17 @@ registered nodes.`,
 		&cc.AmbossDelay, "ambossdelay", defaultAmbossQueryDelay,
 		"the delay between each query to the Amboss GraphQL API",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.FromChannelGraph, "from_channel_graph", "", "the full "+
+			"LN channel graph in the JSON format that the "+
+			"'lncli describegraph' returns, to be used instead of "+
+			"the Amboss API",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to read "+
+			"the channel graph from, to be used instead of the "+
+			"Amboss API",
+	)
 
 	return cc.cmd
 }
@@ -175,19 +208,92 @@ registered nodes.`,
 func (c *zombieRecoveryFindMatchesCommand) Execute(_ *cobra.Command,
 	_ []string) error {
 
-	logFileBytes, err := os.ReadFile(c.Registrations)
+	if c.FromChannelGraph != "" && c.ChannelDB != "" {
+		return errors.New("only one of --from_channel_graph or " +
+			"--channeldb can be specified")
+	}
+
+	registrationBytes, err := os.ReadFile(c.Registrations)
 	if err != nil {
 		return fmt.Errorf("error reading registrations file %s: %w",
 			c.Registrations, err)
 	}
 
+	registrations, err := parseRegistrations(registrationBytes)
+	if err != nil {
+		return err
+	}
+
+	api := newExplorerAPI(c.APIURL)
+
+	// Find ancient channels first.
+	err = ancientChannelsForNodes(registrations)
+	if err != nil {
+		log.Errorf("Error finding ancient channels, ignoring: %v", err)
+	}
+
+	var matches map[string]map[string]*match
+	switch {
+	case c.FromChannelGraph != "" || c.ChannelDB != "":
+		graph, err := c.readLocalGraph()
+		if err != nil {
+			return err
+		}
+
+		lookup := func(chanPoint string) (string, bool, error) {
+			return channelOutput(api, chanPoint)
+		}
+		matches, err = findLocalMatches(graph, registrations, lookup)
+		if err != nil {
+			return err
+		}
+
+	default:
+		matches, err = c.findAmbossMatches(registrations, api)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeMatches(matches, registrations)
+}
+
+// parseRegistrations parses the list of registered nodes, either in the raw
+// format of node-recovery.com or as a JSON list of node infos.
+func parseRegistrations(content []byte) (map[string]string, error) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var nodes []*nodeInfo
+		if err := json.Unmarshal(trimmed, &nodes); err != nil {
+			return nil, fmt.Errorf("error decoding registrations: "+
+				"%w", err)
+		}
+
+		registrations := make(map[string]string, len(nodes))
+		for _, node := range nodes {
+			if _, err := pubKeyFromHex(node.PubKey); err != nil {
+				return nil, fmt.Errorf("error parsing node "+
+					"ID: %w", err)
+			}
+
+			if registrations[node.PubKey] != "" {
+				registrations[node.PubKey] += ", "
+			}
+			registrations[node.PubKey] += node.Contact
+
+			log.Infof("%s: %s", node.PubKey, node.Contact)
+		}
+
+		return registrations, nil
+	}
+
 	allMatches := patternRegistration.FindAllStringSubmatch(
-		string(logFileBytes), -1,
+		string(content), -1,
 	)
 	registrations := make(map[string]string, len(allMatches))
 	for _, groups := range allMatches {
 		if _, err := pubKeyFromHex(groups[1]); err != nil {
-			return fmt.Errorf("error parsing node ID: %w", err)
+			return nil, fmt.Errorf("error parsing node ID: %w", err)
 		}
 
 		if registrations[groups[1]] != "" {
@@ -199,19 +305,154 @@ func (c *zombieRecoveryFindMatchesCommand) Execute(_ *cobra.Command,
 		log.Infof("%s: %s", groups[1], groups[2])
 	}
 
-	api := newExplorerAPI(c.APIURL)
+	return registrations, nil
+}
+
+// readLocalGraph reads the channel graph either from a describegraph JSON file
+// or from a channel.db file.
+func (c *zombieRecoveryFindMatchesCommand) readLocalGraph() (
+	*lnrpc.ChannelGraph, error) {
+
+	if c.ChannelDB != "" {
+		db, graphDB, err := lnd.OpenDB(c.ChannelDB, true)
+		if err != nil {
+			return nil, fmt.Errorf("error opening rescue DB: %w",
+				err)
+		}
+		defer func() { _ = db.Close() }()
+
+		return lnd.ChannelGraphFromDB(graphDB)
+	}
+
+	graphBytes, err := os.ReadFile(c.FromChannelGraph)
+	if err != nil {
+		return nil, fmt.Errorf("error reading graph JSON file %s: "+
+			"%v", c.FromChannelGraph, err)
+	}
+	graph := &lnrpc.ChannelGraph{}
+	err = lnrpc.ProtoJSONUnmarshalOpts.Unmarshal(graphBytes, graph)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing graph JSON: %w", err)
+	}
+
+	return graph, nil
+}
+
+// chanOutputLookup is a function that returns the address of a channel funding
+// output and whether it was already spent.
+type chanOutputLookup func(chanPoint string) (string, bool, error)
+
+// channelOutput looks up the address of the given channel funding output and
+// whether it was already spent.
+func channelOutput(api *btc.ExplorerAPI, chanPoint string) (string, bool,
+	error) {
+
+	op, err := lnd.ParseOutpoint(chanPoint)
+	if err != nil {
+		return "", false, err
+	}
+
+	tx, err := api.Transaction(op.Hash.String())
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(tx.Vout) <= int(op.Index) {
+		return "", false, fmt.Errorf("invalid output index: %d",
+			op.Index)
+	}
+	vout := tx.Vout[op.Index]
+
+	return vout.ScriptPubkeyAddr, vout.Outspend != nil &&
+		vout.Outspend.Spent, nil
+}
+
+// findLocalMatches finds all channels in the local channel graph that are
+// shared between two registered nodes and still unspent.
+func findLocalMatches(graph *lnrpc.ChannelGraph,
+	registrations map[string]string,
+	lookup chanOutputLookup) (map[string]map[string]*match, error) {
+
+	// To achieve a stable order, we go through the nodes sorted
+	// lexicographically by their node key.
+	nodes := make([]string, 0, len(registrations))
+	for node := range registrations {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	matches := make(map[string]map[string]*match)
+	for i, node1 := range nodes {
+		matches[node1] = make(map[string]*match)
+
+		for _, node2 := range nodes[i+1:] {
+			contact1 := registrations[node1]
+			contact2 := registrations[node2]
+			edges := lnd.FindCommonEdges(graph, node1, node2)
+			for _, edge := range edges {
+				addr, spent, err := lookup(edge.ChanPoint)
+				if err != nil {
+					return nil, fmt.Errorf("error "+
+						"fetching address for "+
+						"channel %s: %w",
+						edge.ChanPoint, err)
+				}
+
+				if spent {
+					log.Debugf("Channel %s between %s and "+
+						"%s is already closed",
+						edge.ChanPoint, node1, node2)
+
+					continue
+				}
+
+				log.Debugf("Node 1 (%s, %s) has channel with "+
+					"match (%s): %v", node1, contact1,
+					node2, edge.ChannelId)
+
+				if matches[node1][node2] == nil {
+					matches[node1][node2] = &match{
+						Node1: &nodeInfo{
+							PubKey:  node1,
+							Contact: contact1,
+						},
+						Node2: &nodeInfo{
+							PubKey:  node2,
+							Contact: contact2,
+						},
+					}
+				}
+
+				matches[node1][node2].Channels = append(
+					matches[node1][node2].Channels,
+					&channel{
+						ChannelID: strconv.FormatUint(
+							edge.ChannelId, 10,
+						),
+						ChanPoint: edge.ChanPoint,
+						Address:   addr,
+						Capacity:  edge.Capacity,
+					},
+				)
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// findAmbossMatches finds all channels between two registered nodes by
+// querying the channels of each node from the Amboss API.
+func (c *zombieRecoveryFindMatchesCommand) findAmbossMatches(
+	registrations map[string]string,
+	api *btc.ExplorerAPI) (map[string]map[string]*match, error) {
+
 	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.AmbossKey})
 	httpClient := oauth2.NewClient(context.Background(), src)
 	client := graphql.NewClient(
 		"https://api.amboss.space/graphql", httpClient,
 	)
 
-	// Find ancient channels first.
-	err = ancientChannelsForNodes(registrations)
-	if err != nil {
-		log.Errorf("Error finding ancient channels, ignoring: %v", err)
-	}
-
 	// Loop through all nodes now.
 	matches := make(map[string]map[string]*match)
 	idx := 0
@@ -225,8 +466,8 @@ func (c *zombieRecoveryFindMatchesCommand) Execute(_ *cobra.Command,
 
 		channels, err := fetchChannels(client, node1)
 		if err != nil {
-			return fmt.Errorf("error fetching channels for %s: %w",
-				node1, err)
+			return nil, fmt.Errorf("error fetching channels for "+
+				"%s: %w", node1, err)
 		}
 		for _, node1Chan := range channels {
 			peer := identifyPeer(node1Chan, node1)
@@ -261,16 +502,18 @@ func (c *zombieRecoveryFindMatchesCommand) Execute(_ *cobra.Command,
 				// Find the address of the channel.
 				addr, err := api.Address(node1Chan.ChanPoint)
 				if err != nil {
-					return fmt.Errorf("error fetching "+
-						"address for channel %s: %w",
+					return nil, fmt.Errorf("error "+
+						"fetching address for "+
+						"channel %s: %w",
 						node1Chan.ChannelID, err)
 				}
 				capacity, err := strconv.ParseUint(
 					node1Chan.Capacity, 10, 64,
 				)
 				if err != nil {
-					return fmt.Errorf("error parsing "+
-						"capacity for channel %s: %w",
+					return nil, fmt.Errorf("error "+
+						"parsing capacity for "+
+						"channel %s: %w",
 						node1Chan.ChannelID, err)
 				}
 
@@ -289,6 +532,14 @@ func (c *zombieRecoveryFindMatchesCommand) Execute(_ *cobra.Command,
 		}
 	}
 
+	return matches, nil
+}
+
+// writeMatches writes a match file for each pair of matched nodes and a
+// message template for each node.
+func writeMatches(matches map[string]map[string]*match,
+	registrations map[string]string) error {
+
 	// To achieve a stable order, we sort the matches lexicographically by
 	// their node key.
 	node1IDs := make([]string, 0, len(matches))
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testNodeA = "02aad76b7ec22006f88588f3004a7e74be22023dd51fc2c1de7d5e15" +
+		"cd8c5311b8"
+	testNodeB = "0293a16db57c962952e8107ab21ad57e85bf7f91ef320254b6b1e7ab" +
+		"dcaf5f6e92"
+	testNodeC = "03f2802e51e27c3c0549b564805ab81945512edc932844cab03c432b" +
+		"e97e891391"
+)
+
+func TestParseRegistrations(t *testing.T) {
+	_ = newHarness(t)
+
+	raw := "ID: " + testNodeA + "\nContact: a@example.com\nTime: 1\n" +
+		"ID: " + testNodeB + "\nContact: @b on telegram\nTime: 2\n" +
+		"ID: " + testNodeA + "\nContact: a2@example.com\nTime: 3\n"
+	registrations, err := parseRegistrations([]byte(raw))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		testNodeA: "a@example.com, a2@example.com",
+		testNodeB: "@b on telegram",
+	}, registrations)
+
+	jsonList := `[
+		{"identity_pubkey": "` + testNodeA + `", "contact": "a"},
+		{"identity_pubkey": "` + testNodeC + `", "contact": "c"}
+	]`
+	registrations, err = parseRegistrations([]byte(jsonList))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		testNodeA: "a",
+		testNodeC: "c",
+	}, registrations)
+
+	_, err = parseRegistrations([]byte(`[{"identity_pubkey": "02ab"}]`))
+	require.ErrorContains(t, err, "error parsing node ID")
+}
+
+func TestFindLocalMatches(t *testing.T) {
+	_ = newHarness(t)
+
+	registrations := map[string]string{
+		testNodeA: "a",
+		testNodeB: "b",
+		testNodeC: "c",
+	}
+	graph := &lnrpc.ChannelGraph{
+		Edges: []*lnrpc.ChannelEdge{{
+			ChannelId: 1,
+			ChanPoint: "aa:0",
+			Node1Pub:  testNodeB,
+			Node2Pub:  testNodeA,
+			Capacity:  100_000,
+		}, {
+			ChannelId: 2,
+			ChanPoint: "bb:1",
+			Node1Pub:  testNodeA,
+			Node2Pub:  testNodeB,
+			Capacity:  200_000,
+		}, {
+			// Already closed.
+			ChannelId: 3,
+			ChanPoint: "cc:0",
+			Node1Pub:  testNodeA,
+			Node2Pub:  testNodeC,
+			Capacity:  300_000,
+		}, {
+			// Peer not registered.
+			ChannelId: 4,
+			ChanPoint: "dd:0",
+			Node1Pub:  testNodeC,
+			Node2Pub:  "03ffff",
+			Capacity:  400_000,
+		}},
+	}
+	lookup := func(chanPoint string) (string, bool, error) {
+		return "bc1q" + chanPoint, chanPoint == "cc:0", nil
+	}
+
+	matches, err := findLocalMatches(graph, registrations, lookup)
+	require.NoError(t, err)
+
+	// Each pair of nodes is only matched once, the node with the
+	// lexicographically smaller key is node 1.
+	require.Len(t, matches[testNodeB], 1)
+	require.Empty(t, matches[testNodeA])
+	require.Empty(t, matches[testNodeC])
+
+	m := matches[testNodeB][testNodeA]
+	require.Equal(t, testNodeB, m.Node1.PubKey)
+	require.Equal(t, "b", m.Node1.Contact)
+	require.Equal(t, testNodeA, m.Node2.PubKey)
+	require.Equal(t, "a", m.Node2.Contact)
+	require.Equal(t, []*channel{{
+		ChannelID: "1",
+		ChanPoint: "aa:0",
+		Address:   "bc1qaa:0",
+		Capacity:  100_000,
+	}, {
+		ChannelID: "2",
+		ChanPoint: "bb:1",
+		Address:   "bc1qbb:1",
+		Capacity:  200_000,
+	}}, m.Channels)
+
+	// Lookup errors are returned.
+	_, err = findLocalMatches(
+		graph, registrations, func(string) (string, bool, error) {
+			return "", false, errors.New("api down")
+		},
+	)
+	require.ErrorContains(t, err, "api down")
+}
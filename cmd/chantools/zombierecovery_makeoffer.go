@@ -9,9 +9,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
@@ -35,14 +37,18 @@ import (
 )
 
 type zombieRecoveryMakeOfferCommand struct {
-	Node1   string
-	Node2   string
-	FeeRate uint32
+	Node1    string
+	Node2    string
+	FeeRate  uint32
+	FeeShare uint32
 
 	MatchOnly bool
 
 	HsmSecret string
 
+	Encrypt         bool
+	RequireEnvelope bool
+
 	rootKey *rootKey
 	cmd     *cobra.Command
 }
@@ -59,11 +65,26 @@ one party has to create an offer on how to split the funds that are in the
 channels to be rescued.
 If the other party agrees with the offer, they can sign and publish the offer
 with the 'signoffer' command. If the other party does not agree, they can create
-a counter offer.`,
+a counter offer with the 'counteroffer' command.
+
+The offer is written to an offer file in the results directory that contains
+the PSBT together with the proposed split of each channel and the fee
+contribution of each party. That file is also used to keep track of the history
+of all counter offers. The split of a channel can be entered either in sats or
+as a percentage of the channel capacity (for example '50%').
+
+Both key files can either be the plain JSON files or the encrypted and signed
+envelope files created by 'preparekeys --encrypt'. If the remote peer's file is
+an envelope, its signature is verified against the peer's node key before any of
+the keys or the payout address in it are used. A plain key file of the remote
+peer is only accepted with --require_envelope=false. With --encrypt the resulting
+offer is also written to an envelope file that can only be read by the remote
+peer.`,
 		Example: `chantools zombierecovery makeoffer \
 	--node1_keys preparedkeys-xxxx-xx-xx-<pubkey1>.json \
 	--node2_keys preparedkeys-xxxx-xx-xx-<pubkey2>.json \
-	--feerate 15`,
+	--feerate 15 \
+	--fee_share 50`,
 		RunE: cc.Execute,
 	}
 
@@ -79,6 +100,11 @@ a counter offer.`,
 		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
 			"use for the sweep transaction in sat/vByte",
 	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.FeeShare, "fee_share", defaultFeeSharePercent, "the "+
+			"percentage of the sweep transaction fee we offer to "+
+			"pay, the other party pays the rest",
+	)
 	cc.cmd.Flags().BoolVar(
 		&cc.MatchOnly, "matchonly", false, "only match the keys, "+
 			"don't create an offer",
@@ -89,6 +115,16 @@ a counter offer.`,
 			"node; obtain by running 'xxd -p -c32 "+
 			"~/.lightning/bitcoin/hsm_secret'",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Encrypt, "encrypt", false, "also write the offer to an "+
+			"envelope file that is encrypted to the remote peer's "+
+			"node key and signed with our node key",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.RequireEnvelope, "require_envelope", true, "refuse to "+
+			"use the remote peer's key file if it is not an "+
+			"envelope signed with the peer's node key",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "signing the offer")
 
@@ -102,12 +138,69 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		c.FeeRate = defaultFeeSatPerVByte
 	}
 
-	node1Bytes, err := os.ReadFile(c.Node1)
+	var (
+		signer      lnd.ChannelSigner
+		ourNode     *btcec.PublicKey
+		ourNodePriv *btcec.PrivateKey
+	)
+	switch {
+	case c.HsmSecret != "":
+		secretBytes, err := hex.DecodeString(c.HsmSecret)
+		if err != nil {
+			return fmt.Errorf("error decoding HSM secret: %w", err)
+		}
+
+		var hsmSecret [32]byte
+		copy(hsmSecret[:], secretBytes)
+
+		ourNode, ourNodePriv, err = cln.NodeKey(hsmSecret)
+		if err != nil {
+			return fmt.Errorf("error deriving CLN node pubkey: %w",
+				err)
+		}
+
+		signer = &cln.Signer{
+			HsmSecret: hsmSecret,
+		}
+
+	default:
+		extendedKey, err := c.rootKey.read()
+		if err != nil {
+			return fmt.Errorf("error reading root key: %w", err)
+		}
+
+		var identityKey *hdkeychain.ExtendedKey
+		identityKey, ourNode, _, err = lnd.DeriveKey(
+			extendedKey, lnd.IdentityPath(chainParams), chainParams,
+		)
+		if err != nil {
+			return fmt.Errorf("error deriving identity pubkey: %w",
+				err)
+		}
+		ourNodePriv, err = identityKey.ECPrivKey()
+		if err != nil {
+			return fmt.Errorf("error deriving identity private "+
+				"key: %w", err)
+		}
+
+		signer = &lnd.Signer{
+			ExtendedKey: extendedKey,
+			ChainParams: chainParams,
+		}
+	}
+
+	// The key files can either be plain JSON files or envelopes that were
+	// encrypted to our node key and signed by the sender.
+	node1Bytes, node1Sender, err := readMaybeEnvelope(
+		c.Node1, envelopeTypePreparedKeys, ourNodePriv,
+	)
 	if err != nil {
 		return fmt.Errorf("error reading node1 key file %s: %w",
 			c.Node1, err)
 	}
-	node2Bytes, err := os.ReadFile(c.Node2)
+	node2Bytes, node2Sender, err := readMaybeEnvelope(
+		c.Node2, envelopeTypePreparedKeys, ourNodePriv,
+	)
 	if err != nil {
 		return fmt.Errorf("error reading node2 key file %s: %w",
 			c.Node2, err)
@@ -187,50 +280,6 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		}
 	}
 
-	var (
-		signer  lnd.ChannelSigner
-		ourNode *btcec.PublicKey
-	)
-	switch {
-	case c.HsmSecret != "":
-		secretBytes, err := hex.DecodeString(c.HsmSecret)
-		if err != nil {
-			return fmt.Errorf("error decoding HSM secret: %w", err)
-		}
-
-		var hsmSecret [32]byte
-		copy(hsmSecret[:], secretBytes)
-
-		ourNode, _, err = cln.NodeKey(hsmSecret)
-		if err != nil {
-			return fmt.Errorf("error deriving CLN node pubkey: %w",
-				err)
-		}
-
-		signer = &cln.Signer{
-			HsmSecret: hsmSecret,
-		}
-
-	default:
-		extendedKey, err := c.rootKey.read()
-		if err != nil {
-			return fmt.Errorf("error reading root key: %w", err)
-		}
-
-		_, ourNode, _, err = lnd.DeriveKey(
-			extendedKey, lnd.IdentityPath(chainParams), chainParams,
-		)
-		if err != nil {
-			return fmt.Errorf("error deriving identity pubkey: %w",
-				err)
-		}
-
-		signer = &lnd.Signer{
-			ExtendedKey: extendedKey,
-			ChainParams: chainParams,
-		}
-	}
-
 	// Make sure one of the nodes is ours.
 	pubKeyStr := hex.EncodeToString(ourNode.SerializeCompressed())
 	if keys1.Node1.PubKey != pubKeyStr && keys1.Node2.PubKey != pubKeyStr {
@@ -262,6 +311,7 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		theirKeys       []string
 		theirPayoutAddr string
 		theirChannels   []*channel
+		theirSender     *btcec.PublicKey
 	)
 	if keys1.Node1.PubKey == pubKeyStr && len(keys1.Node1.MultisigKeys) > 0 {
 		ourKeys = keys1.Node1.MultisigKeys
@@ -270,6 +320,7 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		theirKeys = keys2.Node2.MultisigKeys
 		theirPayoutAddr = keys2.Node2.PayoutAddr
 		theirChannels = keys2.Channels
+		theirSender = node2Sender
 	}
 	if keys1.Node2.PubKey == pubKeyStr && len(keys1.Node2.MultisigKeys) > 0 {
 		ourKeys = keys1.Node2.MultisigKeys
@@ -278,6 +329,7 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		theirKeys = keys2.Node1.MultisigKeys
 		theirPayoutAddr = keys2.Node1.PayoutAddr
 		theirChannels = keys2.Channels
+		theirSender = node2Sender
 	}
 	if keys2.Node1.PubKey == pubKeyStr && len(keys2.Node1.MultisigKeys) > 0 {
 		ourKeys = keys2.Node1.MultisigKeys
@@ -286,6 +338,7 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		theirKeys = keys1.Node2.MultisigKeys
 		theirPayoutAddr = keys1.Node2.PayoutAddr
 		theirChannels = keys1.Channels
+		theirSender = node1Sender
 	}
 	if keys2.Node2.PubKey == pubKeyStr && len(keys2.Node2.MultisigKeys) > 0 {
 		ourKeys = keys2.Node2.MultisigKeys
@@ -294,6 +347,7 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		theirKeys = keys1.Node1.MultisigKeys
 		theirPayoutAddr = keys1.Node1.PayoutAddr
 		theirChannels = keys1.Channels
+		theirSender = node1Sender
 	}
 	if len(ourKeys) == 0 || len(theirKeys) == 0 {
 		return errors.New("couldn't find necessary keys")
@@ -302,6 +356,24 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		return errors.New("payout address missing")
 	}
 
+	// If the peer's keys were sent to us in an envelope, we can make sure
+	// they were really created by the peer and not by someone else.
+	switch {
+	case theirSender != nil && !theirSender.IsEqual(peerPubKey):
+		return fmt.Errorf("peer key file was signed by %x but peer "+
+			"is %s", theirSender.SerializeCompressed(),
+			peerPubKeyStr)
+
+	case theirSender == nil && c.RequireEnvelope:
+		return errors.New("peer key file is not a signed envelope " +
+			"but --require_envelope was set")
+
+	case theirSender == nil:
+		log.Warnf("Peer key file is not a signed envelope, cannot " +
+			"verify the peer's keys and payout address were " +
+			"created by the peer")
+	}
+
 	ourPubKeys, err := parseKeys(ourKeys)
 	if err != nil {
 		return fmt.Errorf("error parsing their keys: %w", err)
@@ -337,7 +409,28 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		signDescs = make(
 			[]*input.SignDescriptor, 0, len(keys1.Channels),
 		)
+		weAreNode1 = keys1.Node1.PubKey == pubKeyStr
+		offer      = &offerFile{
+			Version: offerFileVersion,
+			Node1: &nodeInfo{
+				PubKey:     keys1.Node1.PubKey,
+				PayoutAddr: theirPayoutAddr,
+			},
+			Node2: &nodeInfo{
+				PubKey:     keys1.Node2.PubKey,
+				PayoutAddr: ourPayoutAddr,
+			},
+		}
+		proposal = &offerProposal{
+			ProposedBy: pubKeyStr,
+			CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+			FeeRate:    c.FeeRate,
+		}
 	)
+	if weAreNode1 {
+		offer.Node1.PayoutAddr = ourPayoutAddr
+		offer.Node2.PayoutAddr = theirPayoutAddr
+	}
 	for idx, channel := range ourChannels {
 		op, err := lnd.ParseOutpoint(channel.ChanPoint)
 		if err != nil {
@@ -357,6 +450,17 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 
 		ourSum += ourPart
 		theirSum += theirPart
+		if weAreNode1 {
+			proposal.Channels = append(
+				proposal.Channels,
+				newOfferChannel(channel, ourPart, theirPart),
+			)
+		} else {
+			proposal.Channels = append(
+				proposal.Channels,
+				newOfferChannel(channel, theirPart, ourPart),
+			)
+		}
 		txIn := &wire.TxIn{
 			PreviousOutPoint: *op,
 		}
@@ -458,61 +562,22 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 	// Only add output for us if we should receive something.
 	var ourOutput, theirOutput *wire.TxOut
 	if ourSum > 0 {
-		err = lnd.CheckAddress(
-			ourPayoutAddr, chainParams, false, "our payout",
-			lnd.AddrTypeP2WKH, lnd.AddrTypeP2TR,
-		)
-		if err != nil {
-			return fmt.Errorf("error verifying our payout "+
-				"address: %w", err)
-		}
-
-		pkScript, err := lnd.PrepareWalletAddress(
-			ourPayoutAddr, chainParams, &estimator, nil,
-			"our payout",
+		ourOutput, err = addPayoutOutput(
+			packet, &estimator, ourPayoutAddr, ourSum, "our payout",
 		)
 		if err != nil {
-			return fmt.Errorf("error preparing our payout "+
-				"address: %w", err)
-		}
-
-		ourOutput = &wire.TxOut{
-			PkScript: pkScript,
-			Value:    ourSum,
+			return err
 		}
-		packet.UnsignedTx.TxOut = append(
-			packet.UnsignedTx.TxOut, ourOutput,
-		)
-		packet.Outputs = append(packet.Outputs, psbt.POutput{})
 	}
 
 	if theirSum > 0 {
-		err = lnd.CheckAddress(
-			theirPayoutAddr, chainParams, false, "their payout",
-			lnd.AddrTypeP2WKH, lnd.AddrTypeP2TR,
-		)
-		if err != nil {
-			return fmt.Errorf("error verifying their payout "+
-				"address: %w", err)
-		}
-
-		pkScript, err := lnd.PrepareWalletAddress(
-			theirPayoutAddr, chainParams, &estimator, nil,
+		theirOutput, err = addPayoutOutput(
+			packet, &estimator, theirPayoutAddr, theirSum,
 			"their payout",
 		)
 		if err != nil {
-			return fmt.Errorf("error preparing their payout "+
-				"address: %w", err)
-		}
-
-		theirOutput = &wire.TxOut{
-			PkScript: pkScript,
-			Value:    theirSum,
+			return err
 		}
-		packet.UnsignedTx.TxOut = append(
-			packet.UnsignedTx.TxOut, theirOutput,
-		)
-		packet.Outputs = append(packet.Outputs, psbt.POutput{})
 	}
 
 	feeRateKWeight := chainfee.SatPerKVByte(
@@ -528,24 +593,19 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		totalFee)
 
 	// Distribute the fees.
-	halfFee := totalFee / 2
-	switch {
-	case ourSum-halfFee > 0 && theirSum-halfFee > 0:
-		ourSum -= halfFee
-		ourOutput.Value -= halfFee
-		theirSum -= halfFee
-		theirOutput.Value -= halfFee
-
-	case ourSum-totalFee > 0:
-		ourSum -= totalFee
-		ourOutput.Value -= totalFee
-
-	case theirSum-totalFee > 0:
-		theirSum -= totalFee
-		theirOutput.Value -= totalFee
-
-	default:
-		return errors.New("error distributing fees, unhandled case")
+	ourFee, theirFee, err := distributeFee(
+		ourSum, theirSum, totalFee, c.FeeShare,
+	)
+	if err != nil {
+		return err
+	}
+	if ourFee > 0 {
+		ourSum -= ourFee
+		ourOutput.Value -= ourFee
+	}
+	if theirFee > 0 {
+		theirSum -= theirFee
+		theirOutput.Value -= theirFee
 	}
 
 	fmt.Printf("Current tally (after fees):\n\t"+
@@ -591,9 +651,49 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 		return fmt.Errorf("error encoding PSBT: %w", err)
 	}
 
+	proposal.Psbt = base64
+	proposal.Node1Fee, proposal.Node2Fee = theirFee, ourFee
+	proposal.Node1Total, proposal.Node2Total = theirSum, ourSum
+	if weAreNode1 {
+		proposal.Node1Fee, proposal.Node2Fee = ourFee, theirFee
+		proposal.Node1Total, proposal.Node2Total = ourSum, theirSum
+	}
+	offer.addProposal(proposal)
+
+	today := time.Now().Format("2006-01-02")
+	offerFileName := fmt.Sprintf("%s/offer-%s-%s.json", ResultsDir, today,
+		pubKeyStr)
+	offerBytes, err := writeOfferFile(offerFileName, offer)
+	if err != nil {
+		return fmt.Errorf("error writing offer file: %w", err)
+	}
+
 	fmt.Printf("Done creating offer, please send this PSBT string to \n"+
 		"the other party to review and sign (if they accept): \n%s\n",
 		base64)
+	fmt.Printf("\nTo allow the other party to make a counter offer, send "+
+		"them the offer file\n%s instead.\n", offerFileName)
+
+	if !c.Encrypt {
+		return nil
+	}
+
+	e, err := sealEnvelope(
+		envelopeTypeOffer, offerBytes, ourNodePriv, peerPubKey,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating envelope: %w", err)
+	}
+
+	fileName := fmt.Sprintf("%s/offer-%s-%s.envelope.json", ResultsDir,
+		today, pubKeyStr)
+	err = writeEnvelope(fileName, e)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nAlternatively send the encrypted and signed offer file "+
+		"%s\nto the other party.\n", fileName)
 
 	return nil
 }
@@ -712,7 +812,8 @@ func askAboutChannel(channel *channel, current, total int, ourAddr,
 		"Funding TXID: https://blockstream.info/tx/%v\n\t"+
 		"Channel info: https://1ml.com/channel/%s\n\t"+
 		"Channel funding address: %s\n\n"+
-		"How many sats should go to you (%s) before fees?: ",
+		"How many sats (or percent, e.g. 50%%) should go to you (%s) "+
+		"before fees?: ",
 		channel.ChanPoint, current, total, channel.Capacity,
 		fundingTxid, channel.ChannelID, channel.Address, ourAddr)
 	reader := bufio.NewReader(os.Stdin)
@@ -721,7 +822,7 @@ func askAboutChannel(channel *channel, current, total int, ourAddr,
 		return 0, 0, err
 	}
 
-	ourPart, err := strconv.ParseUint(strings.TrimSpace(ourPartStr), 10, 64)
+	ourPart, err := parseChannelSplit(ourPartStr, channel.Capacity)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -743,6 +844,28 @@ func askAboutChannel(channel *channel, current, total int, ourAddr,
 	return int64(ourPart), theirPart, nil
 }
 
+// parseChannelSplit parses the user's answer to how much of a channel should go
+// to them. The answer can either be a number of sats or a percentage of the
+// channel capacity.
+func parseChannelSplit(answer string, capacity int64) (uint64, error) {
+	answer = strings.TrimSpace(answer)
+	if !strings.HasSuffix(answer, "%") {
+		return strconv.ParseUint(answer, 10, 64)
+	}
+
+	percent, err := strconv.ParseFloat(
+		strings.TrimSpace(strings.TrimSuffix(answer, "%")), 64,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid percentage %v", percent)
+	}
+
+	return uint64(math.Round(float64(capacity) * percent / 100)), nil
+}
+
 func addMuSig2Data(extendedKey *hdkeychain.ExtendedKey, pIn *psbt.PInput,
 	ourChannel, theirChannel *channel, channelPoint *wire.OutPoint,
 	xOnlyPubKey []byte) error {
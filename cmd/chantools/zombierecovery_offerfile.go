@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet"
+)
+
+const (
+	// offerFileVersion is the current version of the offer file format.
+	offerFileVersion = 1
+
+	// defaultFeeSharePercent is the default percentage of the sweep
+	// transaction fee we offer to pay.
+	defaultFeeSharePercent = 50
+)
+
+// offerFile is the file that is exchanged between the two parties of a zombie
+// channel recovery while negotiating how to split the funds. It contains the
+// full history of all proposals, so each side can see what changed from one
+// round to the next. The last proposal is the one that is currently on the
+// table.
+type offerFile struct {
+	Version   uint8            `json:"offer_version"`
+	Node1     *nodeInfo        `json:"node1"`
+	Node2     *nodeInfo        `json:"node2"`
+	Proposals []*offerProposal `json:"proposals"`
+}
+
+// offerProposal is a single proposal (offer or counter offer) of how to split
+// the funds in the channels. The PSBT is signed by the proposing node.
+type offerProposal struct {
+	Round      int             `json:"round"`
+	ProposedBy string          `json:"proposed_by"`
+	CreatedAt  string          `json:"created_at"`
+	FeeRate    uint32          `json:"sat_per_vbyte"`
+	Channels   []*offerChannel `json:"channels"`
+	Node1Fee   int64           `json:"node1_fee"`
+	Node2Fee   int64           `json:"node2_fee"`
+	Node1Total int64           `json:"node1_total"`
+	Node2Total int64           `json:"node2_total"`
+	Psbt       string          `json:"psbt"`
+}
+
+// offerChannel is the proposed split of a single channel, before fees.
+type offerChannel struct {
+	ChannelID    string  `json:"short_channel_id"`
+	ChanPoint    string  `json:"chan_point"`
+	Address      string  `json:"address"`
+	Capacity     int64   `json:"capacity"`
+	ToNode1      int64   `json:"to_node1"`
+	ToNode2      int64   `json:"to_node2"`
+	Node1Percent float64 `json:"node1_percent"`
+}
+
+// newOfferChannel creates the proposed split of a single channel.
+func newOfferChannel(c *channel, toNode1, toNode2 int64) *offerChannel {
+	percent := 0.0
+	if c.Capacity > 0 {
+		percent = float64(toNode1) * 100 / float64(c.Capacity)
+	}
+
+	return &offerChannel{
+		ChannelID:    c.ChannelID,
+		ChanPoint:    c.ChanPoint,
+		Address:      c.Address,
+		Capacity:     c.Capacity,
+		ToNode1:      toNode1,
+		ToNode2:      toNode2,
+		Node1Percent: math.Round(percent*100) / 100,
+	}
+}
+
+// isNode1 returns true if the given pubkey is node 1 of the offer.
+func (o *offerFile) isNode1(pubKey string) bool {
+	return o.Node1.PubKey == pubKey
+}
+
+// latest returns the proposal that is currently on the table.
+func (o *offerFile) latest() *offerProposal {
+	return o.Proposals[len(o.Proposals)-1]
+}
+
+// previous returns the proposal before the latest one or nil if there only is
+// a single proposal.
+func (o *offerFile) previous() *offerProposal {
+	if len(o.Proposals) < 2 {
+		return nil
+	}
+
+	return o.Proposals[len(o.Proposals)-2]
+}
+
+// addProposal adds a new proposal to the history.
+func (o *offerFile) addProposal(p *offerProposal) {
+	p.Round = len(o.Proposals) + 1
+	o.Proposals = append(o.Proposals, p)
+}
+
+// validate makes sure the offer file is well-formed and that the PSBT of each
+// proposal actually does what the proposal claims. The decoded PSBT of the
+// latest proposal is returned.
+func (o *offerFile) validate() (*psbt.Packet, error) {
+	if o.Version != offerFileVersion {
+		return nil, fmt.Errorf("unsupported offer file version %d",
+			o.Version)
+	}
+	if o.Node1 == nil || o.Node2 == nil || o.Node1.PubKey == "" ||
+		o.Node2.PubKey == "" {
+
+		return nil, errors.New("invalid offer file, node info missing")
+	}
+	if len(o.Proposals) == 0 {
+		return nil, errors.New("invalid offer file, no proposals")
+	}
+
+	var (
+		packet     *psbt.Packet
+		prevPacket *psbt.Packet
+	)
+	for idx, p := range o.Proposals {
+		if p.Round != idx+1 {
+			return nil, fmt.Errorf("invalid offer file, proposal "+
+				"%d has round %d", idx+1, p.Round)
+		}
+		if p.ProposedBy != o.Node1.PubKey &&
+			p.ProposedBy != o.Node2.PubKey {
+
+			return nil, fmt.Errorf("invalid offer file, proposal "+
+				"%d proposed by unknown node %s", p.Round,
+				p.ProposedBy)
+		}
+
+		var err error
+		packet, err = o.checkProposal(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proposal %d: %w",
+				p.Round, err)
+		}
+
+		// A counter offer can only change how the funds are split,
+		// the channels that are being closed must stay the same.
+		if prevPacket != nil {
+			err := checkSameInputs(prevPacket, packet)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proposal %d: "+
+					"%w", p.Round, err)
+			}
+		}
+		prevPacket = packet
+	}
+
+	return packet, nil
+}
+
+// checkProposal makes sure the PSBT of the proposal spends the channels of
+// the proposal and pays the stated amounts to the payout addresses of the two
+// nodes. The capacities, split percentages and fees stated in the proposal are
+// recomputed from the PSBT, so the summary shown to the user always matches
+// what is actually signed.
+func (o *offerFile) checkProposal(p *offerProposal) (*psbt.Packet, error) {
+	packet, err := psbt.NewFromRawBytes(
+		strings.NewReader(p.Psbt), true,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding PSBT: %w", err)
+	}
+
+	if len(p.Channels) != len(packet.UnsignedTx.TxIn) {
+		return nil, fmt.Errorf("proposal has %d channels but PSBT "+
+			"has %d inputs", len(p.Channels),
+			len(packet.UnsignedTx.TxIn))
+	}
+	var node1Split, node2Split int64
+	for idx, c := range p.Channels {
+		op, err := lnd.ParseOutpoint(c.ChanPoint)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing channel out "+
+				"point: %w", err)
+		}
+		if packet.UnsignedTx.TxIn[idx].PreviousOutPoint != *op {
+			return nil, fmt.Errorf("input %d doesn't spend "+
+				"channel %s", idx, c.ChanPoint)
+		}
+
+		utxo := packet.Inputs[idx].WitnessUtxo
+		if utxo == nil {
+			return nil, fmt.Errorf("input %d is missing the "+
+				"witness UTXO", idx)
+		}
+		if utxo.Value != c.Capacity {
+			return nil, fmt.Errorf("channel %s has capacity %d "+
+				"sats but input %d spends %d sats",
+				c.ChanPoint, c.Capacity, idx, utxo.Value)
+		}
+		chanScript, err := payoutScript(c.Address)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(chanScript, utxo.PkScript) {
+			return nil, fmt.Errorf("input %d doesn't spend "+
+				"channel address %s", idx, c.Address)
+		}
+
+		if c.ToNode1 < 0 || c.ToNode2 < 0 ||
+			c.ToNode1+c.ToNode2 != c.Capacity {
+
+			return nil, fmt.Errorf("invalid split for channel %s",
+				c.ChanPoint)
+		}
+		expected := newOfferChannel(&channel{
+			Capacity: c.Capacity,
+		}, c.ToNode1, c.ToNode2)
+		if c.Node1Percent != expected.Node1Percent {
+			return nil, fmt.Errorf("channel %s states %.2f%% to "+
+				"node 1 but split is %.2f%%", c.ChanPoint,
+				c.Node1Percent, expected.Node1Percent)
+		}
+
+		node1Split += c.ToNode1
+		node2Split += c.ToNode2
+	}
+
+	node1Script, err := payoutScript(o.Node1.PayoutAddr)
+	if err != nil {
+		return nil, err
+	}
+	node2Script, err := payoutScript(o.Node2.PayoutAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var node1Total, node2Total int64
+	for _, txOut := range packet.UnsignedTx.TxOut {
+		switch {
+		case bytes.Equal(txOut.PkScript, node1Script):
+			node1Total += txOut.Value
+
+		case bytes.Equal(txOut.PkScript, node2Script):
+			node2Total += txOut.Value
+
+		default:
+			return nil, fmt.Errorf("PSBT pays to unknown script "+
+				"%x", txOut.PkScript)
+		}
+	}
+	if node1Total != p.Node1Total || node2Total != p.Node2Total {
+		return nil, fmt.Errorf("PSBT pays %d/%d sats but proposal "+
+			"states %d/%d sats", node1Total, node2Total,
+			p.Node1Total, p.Node2Total)
+	}
+
+	node1Fee, err := payoutFee(node1Split, node1Total)
+	if err != nil {
+		return nil, fmt.Errorf("node 1: %w", err)
+	}
+	node2Fee, err := payoutFee(node2Split, node2Total)
+	if err != nil {
+		return nil, fmt.Errorf("node 2: %w", err)
+	}
+	if node1Fee != p.Node1Fee || node2Fee != p.Node2Fee {
+		return nil, fmt.Errorf("PSBT makes nodes pay %d/%d sats fees "+
+			"but proposal states %d/%d sats", node1Fee, node2Fee,
+			p.Node1Fee, p.Node2Fee)
+	}
+
+	return packet, nil
+}
+
+// payoutFee returns the fee a node pays, given the sum of its split of all
+// channels and the amount it actually receives. A node that receives nothing
+// because its split is dust doesn't pay any fees.
+func payoutFee(split, total int64) (int64, error) {
+	dustLimit := int64(lnwallet.DustLimitForSize(input.P2WSHSize))
+	switch {
+	case total == 0 && split >= dustLimit:
+		return 0, fmt.Errorf("split of %d sats is not paid out", split)
+
+	case total == 0:
+		return 0, nil
+
+	case total > split:
+		return 0, fmt.Errorf("PSBT pays %d sats but split is only %d "+
+			"sats", total, split)
+
+	default:
+		return split - total, nil
+	}
+}
+
+// payoutScript returns the pk script of the given payout or channel address.
+func payoutScript(addr string) ([]byte, error) {
+	parsedAddr, err := lnd.ParseAddress(addr, chainParams)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing address %s: %w", addr,
+			err)
+	}
+
+	return txscript.PayToAddrScript(parsedAddr)
+}
+
+// checkSameInputs makes sure both packets spend exactly the same inputs in the
+// same order.
+func checkSameInputs(prev, current *psbt.Packet) error {
+	prevIns, curIns := prev.UnsignedTx.TxIn, current.UnsignedTx.TxIn
+	if len(prevIns) != len(curIns) {
+		return fmt.Errorf("input set changed, %d inputs instead of %d",
+			len(curIns), len(prevIns))
+	}
+
+	for idx := range prevIns {
+		if prevIns[idx].PreviousOutPoint !=
+			curIns[idx].PreviousOutPoint {
+
+			return fmt.Errorf("input set changed, input %d spends "+
+				"%v instead of %v", idx,
+				curIns[idx].PreviousOutPoint,
+				prevIns[idx].PreviousOutPoint)
+		}
+	}
+
+	return nil
+}
+
+// parseOffer parses the given content either as an offer file or, for
+// compatibility with older offers, as a plain base64 encoded PSBT. In the
+// latter case the returned offer file is nil.
+func parseOffer(content []byte) (*offerFile, *psbt.Packet, error) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		packet, err := psbt.NewFromRawBytes(
+			bytes.NewReader(trimmed), true,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error decoding PSBT: %w",
+				err)
+		}
+
+		return nil, packet, nil
+	}
+
+	o := &offerFile{}
+	if err := json.Unmarshal(trimmed, o); err != nil {
+		return nil, nil, fmt.Errorf("error decoding offer file: %w",
+			err)
+	}
+
+	packet, err := o.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return o, packet, nil
+}
+
+// writeOfferFile writes the given offer file as JSON and returns the encoded
+// content.
+func writeOfferFile(fileName string, o *offerFile) ([]byte, error) {
+	offerBytes, err := json.MarshalIndent(o, "", " ")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Writing offer file to %s", fileName)
+	return offerBytes, os.WriteFile(fileName, offerBytes, 0644)
+}
+
+// distributeFee splits the total fee between the two parties. We pay the given
+// percentage of the fee, the other party pays the rest. If one of the parties
+// doesn't receive enough to pay their share, the other party pays the full
+// fee.
+func distributeFee(ourSum, theirSum, totalFee int64,
+	ourSharePercent uint32) (int64, int64, error) {
+
+	if ourSharePercent > 100 {
+		return 0, 0, fmt.Errorf("invalid fee share %d%%, must be "+
+			"between 0 and 100", ourSharePercent)
+	}
+
+	ourFee := totalFee * int64(ourSharePercent) / 100
+	theirFee := totalFee - ourFee
+
+	switch {
+	case (ourFee == 0 || ourSum-ourFee > 0) &&
+		(theirFee == 0 || theirSum-theirFee > 0):
+
+		return ourFee, theirFee, nil
+
+	case ourSum-totalFee > 0:
+		return totalFee, 0, nil
+
+	case theirSum-totalFee > 0:
+		return 0, totalFee, nil
+
+	default:
+		return 0, 0, errors.New("error distributing fees, unhandled " +
+			"case")
+	}
+}
+
+// addPayoutOutput adds an output paying the given amount to the payout address
+// to the packet.
+func addPayoutOutput(packet *psbt.Packet, estimator *input.TxWeightEstimator,
+	addr string, amount int64, hint string) (*wire.TxOut, error) {
+
+	err := lnd.CheckAddress(
+		addr, chainParams, false, hint, lnd.AddrTypeP2WKH,
+		lnd.AddrTypeP2TR,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying %s address: %w", hint,
+			err)
+	}
+
+	pkScript, err := lnd.PrepareWalletAddress(
+		addr, chainParams, estimator, nil, hint,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing %s address: %w", hint,
+			err)
+	}
+
+	txOut := &wire.TxOut{
+		PkScript: pkScript,
+		Value:    amount,
+	}
+	packet.UnsignedTx.TxOut = append(packet.UnsignedTx.TxOut, txOut)
+	packet.Outputs = append(packet.Outputs, psbt.POutput{})
+
+	return txOut, nil
+}
+
+// describeProposal returns a human-readable summary of the given proposal from
+// our point of view.
+func (o *offerFile) describeProposal(p *offerProposal,
+	ourPubKey string) string {
+
+	ourName := func(pubKey string) string {
+		if pubKey == ourPubKey {
+			return "us"
+		}
+
+		return "them"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Proposal %d by %s (%s), fee rate %d sat/vByte:\n",
+		p.Round, ourName(p.ProposedBy), p.ProposedBy, p.FeeRate)
+	for _, c := range p.Channels {
+		fmt.Fprintf(&b, "\tChannel %s (%d sats): %d sats (%.2f%%) to "+
+			"node 1, %d sats to node 2\n", c.ChanPoint, c.Capacity,
+			c.ToNode1, c.Node1Percent, c.ToNode2)
+	}
+	fmt.Fprintf(&b, "\tNode 1 (%s, %s) pays %d sats fees, receives %d "+
+		"sats at %s\n", ourName(o.Node1.PubKey), o.Node1.PubKey,
+		p.Node1Fee, p.Node1Total, o.Node1.PayoutAddr)
+	fmt.Fprintf(&b, "\tNode 2 (%s, %s) pays %d sats fees, receives %d "+
+		"sats at %s\n", ourName(o.Node2.PubKey), o.Node2.PubKey,
+		p.Node2Fee, p.Node2Total, o.Node2.PayoutAddr)
+
+	return b.String()
+}
+
+// diffProposals returns a human-readable list of everything that changed
+// between the two proposals.
+func diffProposals(prev, current *offerProposal) []string {
+	var changes []string
+	for idx, c := range current.Channels {
+		p := prev.Channels[idx]
+		if p.ToNode1 == c.ToNode1 && p.ToNode2 == c.ToNode2 {
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("Channel %s: node 1 "+
+			"%d -> %d sats (%.2f%% -> %.2f%%), node 2 %d -> %d "+
+			"sats", c.ChanPoint, p.ToNode1, c.ToNode1,
+			p.Node1Percent, c.Node1Percent, p.ToNode2, c.ToNode2))
+	}
+
+	if prev.FeeRate != current.FeeRate {
+		changes = append(changes, fmt.Sprintf("Fee rate: %d -> %d "+
+			"sat/vByte", prev.FeeRate, current.FeeRate))
+	}
+	if prev.Node1Fee != current.Node1Fee {
+		changes = append(changes, fmt.Sprintf("Fee paid by node 1: "+
+			"%d -> %d sats", prev.Node1Fee, current.Node1Fee))
+	}
+	if prev.Node2Fee != current.Node2Fee {
+		changes = append(changes, fmt.Sprintf("Fee paid by node 2: "+
+			"%d -> %d sats", prev.Node2Fee, current.Node2Fee))
+	}
+	if prev.Node1Total != current.Node1Total {
+		changes = append(changes, fmt.Sprintf("Total to node 1: %d "+
+			"-> %d sats (%+d)", prev.Node1Total,
+			current.Node1Total, current.Node1Total-prev.Node1Total))
+	}
+	if prev.Node2Total != current.Node2Total {
+		changes = append(changes, fmt.Sprintf("Total to node 2: %d "+
+			"-> %d sats (%+d)", prev.Node2Total,
+			current.Node2Total, current.Node2Total-prev.Node2Total))
+	}
+
+	return changes
+}
+
+// printLatestProposal prints the proposal that is currently on the table and,
+// if it is a counter offer, what changed compared to the previous proposal.
+func (o *offerFile) printLatestProposal(ourPubKey string) {
+	fmt.Printf("\n%s\n", o.describeProposal(o.latest(), ourPubKey))
+
+	prev := o.previous()
+	if prev == nil {
+		return
+	}
+
+	fmt.Printf("Changes compared to proposal %d:\n", prev.Round)
+	changes := diffProposals(prev, o.latest())
+	if len(changes) == 0 {
+		fmt.Printf("\tNone\n")
+	}
+	for _, change := range changes {
+		fmt.Printf("\t%s\n", change)
+	}
+	fmt.Println()
+}
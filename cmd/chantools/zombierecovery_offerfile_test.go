@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChannelSplit(t *testing.T) {
+	testCases := []struct {
+		answer   string
+		capacity int64
+		expected uint64
+		err      bool
+	}{{
+		answer:   "12345\n",
+		capacity: 100_000,
+		expected: 12345,
+	}, {
+		answer:   "50%\n",
+		capacity: 100_001,
+		expected: 50_001,
+	}, {
+		answer:   " 33.3 %",
+		capacity: 1_000_000,
+		expected: 333_000,
+	}, {
+		answer:   "101%",
+		capacity: 1_000_000,
+		err:      true,
+	}, {
+		answer:   "abc",
+		capacity: 1_000_000,
+		err:      true,
+	}}
+
+	for _, tc := range testCases {
+		result, err := parseChannelSplit(tc.answer, tc.capacity)
+		if tc.err {
+			require.Error(t, err)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, result)
+	}
+}
+
+func TestDistributeFee(t *testing.T) {
+	testCases := []struct {
+		name             string
+		ourSum, theirSum int64
+		fee              int64
+		share            uint32
+		ourFee, theirFee int64
+		err              bool
+	}{{
+		name:     "half",
+		ourSum:   10_000,
+		theirSum: 10_000,
+		fee:      1_001,
+		share:    50,
+		ourFee:   500,
+		theirFee: 501,
+	}, {
+		name:     "we pay all",
+		ourSum:   10_000,
+		theirSum: 10_000,
+		fee:      1_000,
+		share:    100,
+		ourFee:   1_000,
+	}, {
+		name:     "they can't pay",
+		ourSum:   10_000,
+		theirSum: 0,
+		fee:      1_000,
+		share:    50,
+		ourFee:   1_000,
+	}, {
+		name:     "we can't pay",
+		ourSum:   100,
+		theirSum: 10_000,
+		fee:      1_000,
+		share:    50,
+		theirFee: 1_000,
+	}, {
+		name:  "invalid share",
+		fee:   1_000,
+		share: 101,
+		err:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ourFee, theirFee, err := distributeFee(
+				tc.ourSum, tc.theirSum, tc.fee, tc.share,
+			)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.ourFee, ourFee)
+			require.Equal(t, tc.theirFee, theirFee)
+		})
+	}
+}
+
+func TestOfferFileValidate(t *testing.T) {
+	node1, addr1 := testOfferNode(t)
+	node2, addr2 := testOfferNode(t)
+
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{1, 2, 3}, Index: 1}
+	offer := &offerFile{
+		Version: offerFileVersion,
+		Node1:   node1,
+		Node2:   node2,
+	}
+	offer.addProposal(testProposal(
+		t, node1.PubKey, chanPoint, addr1, 60_000, addr2, 39_000,
+	))
+	offer.addProposal(testProposal(
+		t, node2.PubKey, chanPoint, addr1, 40_000, addr2, 59_000,
+	))
+
+	offerBytes, err := json.Marshal(offer)
+	require.NoError(t, err)
+	parsed, packet, err := parseOffer(offerBytes)
+	require.NoError(t, err)
+	require.Len(t, parsed.Proposals, 2)
+	require.Equal(t, chanPoint, packet.UnsignedTx.TxIn[0].PreviousOutPoint)
+
+	changes := diffProposals(parsed.previous(), parsed.latest())
+	require.Equal(t, []string{
+		"Channel " + chanPoint.String() + ": node 1 60500 -> 40500 " +
+			"sats (60.50% -> 40.50%), node 2 39500 -> 59500 sats",
+		"Total to node 1: 60000 -> 40000 sats (-20000)",
+		"Total to node 2: 39000 -> 59000 sats (+20000)",
+	}, changes)
+
+	// The stated totals must match what the PSBT actually pays.
+	offer.latest().Node1Total = 50_000
+	offerBytes, err = json.Marshal(offer)
+	require.NoError(t, err)
+	_, _, err = parseOffer(offerBytes)
+	require.ErrorContains(t, err, "PSBT pays 40000/59000 sats")
+	offer.latest().Node1Total = 40_000
+
+	// The split, percentages and fees shown to the user are recomputed
+	// from the PSBT as well.
+	offer.latest().Channels[0].Node1Percent = 90
+	offerBytes, err = json.Marshal(offer)
+	require.NoError(t, err)
+	_, _, err = parseOffer(offerBytes)
+	require.ErrorContains(t, err, "states 90.00% to node 1")
+	offer.latest().Channels[0].Node1Percent = 40.5
+
+	offer.latest().Node1Fee, offer.latest().Node2Fee = 0, 1_000
+	offerBytes, err = json.Marshal(offer)
+	require.NoError(t, err)
+	_, _, err = parseOffer(offerBytes)
+	require.ErrorContains(t, err, "PSBT makes nodes pay 500/500 sats fees")
+	offer.latest().Node1Fee, offer.latest().Node2Fee = 500, 500
+
+	// Every input needs the witness UTXO, a counter offer reads the
+	// channel script from it.
+	noUtxo := testProposal(
+		t, node1.PubKey, chanPoint, addr1, 40_000, addr2, 59_000,
+	)
+	packet, err = psbt.NewFromRawBytes(
+		strings.NewReader(noUtxo.Psbt), true,
+	)
+	require.NoError(t, err)
+	packet.Inputs[0].WitnessUtxo = nil
+	noUtxo.Psbt, err = packet.B64Encode()
+	require.NoError(t, err)
+	_, err = offer.checkProposal(noUtxo)
+	require.ErrorContains(t, err, "missing the witness UTXO")
+
+	// A counter offer can't change the channels that are being closed.
+	otherChanPoint := wire.OutPoint{Hash: chainhash.Hash{3, 2, 1}}
+	offer.addProposal(testProposal(
+		t, node1.PubKey, otherChanPoint, addr1, 50_000, addr2, 49_000,
+	))
+	offerBytes, err = json.Marshal(offer)
+	require.NoError(t, err)
+	_, _, err = parseOffer(offerBytes)
+	require.ErrorContains(t, err, "input set changed")
+
+	// A plain PSBT is still accepted, just without any history.
+	parsed, packet, err = parseOffer([]byte(offer.Proposals[0].Psbt))
+	require.NoError(t, err)
+	require.Nil(t, parsed)
+	require.Len(t, packet.UnsignedTx.TxOut, 2)
+}
+
+func testOfferNode(t *testing.T) (*nodeInfo, btcutil.Address) {
+	t.Helper()
+
+	priv, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	pubKey := priv.PubKey().SerializeCompressed()
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(
+		btcutil.Hash160(pubKey), chainParams,
+	)
+	require.NoError(t, err)
+
+	return &nodeInfo{
+		PubKey:     hex.EncodeToString(pubKey),
+		PayoutAddr: addr.String(),
+	}, addr
+}
+
+func testProposal(t *testing.T, proposedBy string, chanPoint wire.OutPoint,
+	addr1 btcutil.Address, amount1 int64, addr2 btcutil.Address,
+	amount2 int64) *offerProposal {
+
+	t.Helper()
+
+	script1, err := txscript.PayToAddrScript(addr1)
+	require.NoError(t, err)
+	script2, err := txscript.PayToAddrScript(addr2)
+	require.NoError(t, err)
+
+	chanAddr, err := btcutil.NewAddressWitnessScriptHash(
+		chanPoint.Hash[:], chainParams,
+	)
+	require.NoError(t, err)
+	chanScript, err := txscript.PayToAddrScript(chanAddr)
+	require.NoError(t, err)
+
+	const capacity = 100_000
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: chanPoint})
+	tx.AddTxOut(&wire.TxOut{PkScript: script1, Value: amount1})
+	tx.AddTxOut(&wire.TxOut{PkScript: script2, Value: amount2})
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	require.NoError(t, err)
+	packet.Inputs[0].WitnessUtxo = &wire.TxOut{
+		PkScript: chanScript,
+		Value:    capacity,
+	}
+	b64, err := packet.B64Encode()
+	require.NoError(t, err)
+
+	toNode1 := amount1 + 500
+	return &offerProposal{
+		ProposedBy: proposedBy,
+		FeeRate:    1,
+		Channels: []*offerChannel{newOfferChannel(&channel{
+			ChanPoint: chanPoint.String(),
+			Address:   chanAddr.String(),
+			Capacity:  capacity,
+		}, toNode1, capacity-toNode1)},
+		Node1Fee:   500,
+		Node2Fee:   500,
+		Node1Total: amount1,
+		Node2Total: amount2,
+		Psbt:       b64,
+	}
+}
@@ -30,6 +30,8 @@ type zombieRecoveryPrepareKeysCommand struct {
 
 	HsmSecret string
 
+	Encrypt bool
+
 	rootKey *rootKey
 	cmd     *cobra.Command
 }
@@ -43,10 +45,20 @@ func newZombieRecoveryPrepareKeysCommand() *cobra.Command {
 then adds the first 2500 multisig pubkeys to it.
 This must be run by both parties of a channel for a successful recovery. The
 next step (makeoffer) takes two such key enriched files and tries to find the
-correct ones for the matched channels.`,
+correct ones for the matched channels.
+
+If the --encrypt flag is set, an additional envelope file is written that is
+encrypted to the remote peer's node key and signed with this node's identity
+key. Sending that file instead of the plain JSON file allows the remote peer to
+verify that the keys and payout address really were created by this node.`,
 		Example: `chantools zombierecovery preparekeys \
 	--match_file match-xxxx-xx-xx-<pubkey1>-<pubkey2>.json \
-	--payout_addr bc1q...`,
+	--payout_addr bc1q...
+
+chantools zombierecovery preparekeys \
+	--match_file match-xxxx-xx-xx-<pubkey1>-<pubkey2>.json \
+	--payout_addr bc1q... \
+	--encrypt`,
 		RunE: cc.Execute,
 	}
 
@@ -69,6 +81,12 @@ correct ones for the matched channels.`,
 			"node; obtain by running 'xxd -p -c32 "+
 			"~/.lightning/bitcoin/hsm_secret'",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Encrypt, "encrypt", false, "also write an envelope file "+
+			"that is encrypted to the remote peer's node key and "+
+			"signed with our node key, to be sent to the remote "+
+			"peer instead of the plain file",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "deriving the multisig keys")
 
@@ -250,5 +268,23 @@ func (c *zombieRecoveryPrepareKeysCommand) Execute(_ *cobra.Command,
 	fileName := fmt.Sprintf("%s/preparedkeys-%s-%s.json", ResultsDir,
 		time.Now().Format("2006-01-02"), pubKeyStr)
 	log.Infof("Writing result to %s", fileName)
-	return os.WriteFile(fileName, matchBytes, 0644)
+	err = os.WriteFile(fileName, matchBytes, 0644)
+	if err != nil {
+		return err
+	}
+
+	if !c.Encrypt {
+		return nil
+	}
+
+	e, err := sealEnvelope(
+		envelopeTypePreparedKeys, matchBytes, nodePrivKey, theirNodeKey,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating envelope: %w", err)
+	}
+
+	fileName = fmt.Sprintf("%s/preparedkeys-%s-%s.envelope.json",
+		ResultsDir, time.Now().Format("2006-01-02"), pubKeyStr)
+	return writeEnvelope(fileName, e)
 }
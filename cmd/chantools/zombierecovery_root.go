@@ -39,7 +39,9 @@ interactive GUI that guides you through the different steps.
 		newZombieRecoveryFindMatchesCommand(),
 		newZombieRecoveryPrepareKeysCommand(),
 		newZombieRecoveryMakeOfferCommand(),
+		newZombieRecoveryCounterOfferCommand(),
 		newZombieRecoverySignOfferCommand(),
+		newZombieRecoveryExchangeCommand(),
 	)
 
 	return cc.cmd
@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil/psbt"
@@ -14,17 +16,23 @@ import (
 	"github.com/lightninglabs/chantools/btc"
 	"github.com/lightninglabs/chantools/cln"
 	"github.com/lightninglabs/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/spf13/cobra"
 )
 
 type zombieRecoverySignOfferCommand struct {
-	Psbt string
+	Psbt      string
+	OfferFile string
+	MatchFile string
+
+	RequireEnvelope bool
 
 	HsmSecret  string
 	RemotePeer string
 
 	APIURL  string
 	Publish bool
+	Encrypt bool
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -37,8 +45,26 @@ func newZombieRecoverySignOfferCommand() *cobra.Command {
 		Short: "[3/3] Sign an offer sent by the remote peer to " +
 			"recover funds",
 		Long: `Inspect and sign an offer that was sent by the remote
-peer to recover funds from one or more channels.`,
+peer to recover funds from one or more channels.
+
+If the offer was sent as an offer file (created by 'makeoffer' or
+'counteroffer') or as an encrypted and signed envelope file (created with
+--encrypt), use the --offer_file flag instead of --psbt. For an envelope, the
+signature is verified to make sure the offer was created by the remote peer. If
+the offer is a counter offer, all changes compared to the previous proposal are
+shown before signing. With --encrypt the final transaction is also written to an
+envelope file that can be sent back to the remote peer.
+
+By default the offer must be an envelope and the match file (or our own
+prepared keys file) must be specified with --match_file. The sender of the
+envelope must then be the counterparty named in the match file and the offer
+may only spend the channels listed in it. Use --require_envelope=false to sign
+a plain PSBT or offer file without these checks.`,
 		Example: `chantools zombierecovery signoffer \
+	--offer_file offer-xxxx-xx-xx-<pubkey>.envelope.json \
+	--match_file match-xxxx-xx-xx-<pubkey1>-<pubkey2>.json
+
+chantools zombierecovery signoffer --require_envelope=false \
 	--psbt <offered_psbt_base64>`,
 		RunE: cc.Execute,
 	}
@@ -47,6 +73,23 @@ peer to recover funds from one or more channels.`,
 		&cc.Psbt, "psbt", "", "the base64 encoded PSBT that the other "+
 			"party sent as an offer to rescue funds",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.OfferFile, "offer_file", "", "the offer file or the "+
+			"encrypted and signed offer envelope file that the "+
+			"other party sent as an offer to rescue funds, can be "+
+			"used instead of --psbt",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.MatchFile, "match_file", "", "the match JSON file or our "+
+			"own prepared keys file of the channels the offer is "+
+			"for; the sender of the offer and the channels it "+
+			"spends are checked against it",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.RequireEnvelope, "require_envelope", true, "refuse to "+
+			"sign an offer that is not an envelope signed by the "+
+			"counterparty named in --match_file",
+	)
 	cc.cmd.Flags().StringVar(
 		&cc.HsmSecret, "hsm_secret", "", "the hex encoded HSM secret "+
 			"to use for deriving the multisig keys for a CLN "+
@@ -68,6 +111,12 @@ peer to recover funds from one or more channels.`,
 			"will be published to the network after signing, "+
 			"otherwise it will just be printed to stdout",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Encrypt, "encrypt", false, "also write the final signed "+
+			"transaction to an envelope file that is encrypted to "+
+			"the remote peer's node key and signed with our node "+
+			"key, so it can be sent back to the remote peer",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "signing the offer")
 
@@ -77,12 +126,15 @@ peer to recover funds from one or more channels.`,
 func (c *zombieRecoverySignOfferCommand) Execute(_ *cobra.Command,
 	_ []string) error {
 
-	// Decode the PSBT.
-	packet, err := psbt.NewFromRawBytes(
-		bytes.NewReader([]byte(c.Psbt)), true,
-	)
-	if err != nil {
-		return fmt.Errorf("error decoding PSBT: %w", err)
+	if c.Psbt == "" && c.OfferFile == "" {
+		return errors.New("either --psbt or --offer_file must be " +
+			"specified")
+	}
+	if c.RequireEnvelope && (c.OfferFile == "" || c.MatchFile == "") {
+		return errors.New("--offer_file and --match_file are " +
+			"required to verify the offer was sent by the " +
+			"counterparty, use --require_envelope=false to sign " +
+			"without verification")
 	}
 
 	var (
@@ -99,18 +151,19 @@ func (c *zombieRecoverySignOfferCommand) Execute(_ *cobra.Command,
 		var hsmSecret [32]byte
 		copy(hsmSecret[:], secretBytes)
 
-		if c.RemotePeer == "" {
+		// The remote peer can also be read from the offer
+		// envelope.
+		if c.RemotePeer == "" && c.OfferFile == "" {
 			return errors.New("remote peer pubkey is required " +
 				"when using the HSM secret")
 		}
 
-		remoteNodeBytes, err := hex.DecodeString(c.RemotePeer)
-		if err != nil {
-			return fmt.Errorf("error decoding peer pubkey: %w", err)
-		}
-		remoteNode, err = btcec.ParsePubKey(remoteNodeBytes)
-		if err != nil {
-			return fmt.Errorf("error parsing peer pubkey: %w", err)
+		if c.RemotePeer != "" {
+			remoteNode, err = pubKeyFromHex(c.RemotePeer)
+			if err != nil {
+				return fmt.Errorf("error parsing peer "+
+					"pubkey: %w", err)
+			}
 		}
 
 		signer = &cln.Signer{
@@ -129,26 +182,250 @@ func (c *zombieRecoverySignOfferCommand) Execute(_ *cobra.Command,
 		}
 	}
 
-	return signOffer(
+	ourNodePriv, err := signer.FetchPrivateKey(&keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamilyNodeKey,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deriving identity private key: %w",
+			err)
+	}
+
+	offerBytes := []byte(c.Psbt)
+	if c.OfferFile != "" {
+		var sender *btcec.PublicKey
+		offerBytes, sender, err = readMaybeEnvelope(
+			c.OfferFile, envelopeTypeOffer, ourNodePriv,
+		)
+		if err != nil {
+			return err
+		}
+
+		// We now know who sent us the offer, so we don't need the
+		// remote peer flag for CLN anymore. But if it was set, it
+		// must match.
+		switch {
+		case sender == nil && c.RequireEnvelope:
+			return errors.New("offer file is not a signed " +
+				"envelope but --require_envelope was set")
+
+		case sender == nil:
+			log.Warnf("Offer file is not a signed envelope, " +
+				"cannot verify the offer was created by the " +
+				"peer")
+
+		case remoteNode != nil && !remoteNode.IsEqual(sender):
+			return fmt.Errorf("offer was signed by %x but remote "+
+				"peer is %x", sender.SerializeCompressed(),
+				remoteNode.SerializeCompressed())
+
+		default:
+			remoteNode = sender
+		}
+	}
+
+	// Decode the offer, which is either an offer file or a plain PSBT.
+	offer, packet, err := parseOffer(offerBytes)
+	if err != nil {
+		return err
+	}
+
+	// The envelope only proves who sent the offer. Whether that is really
+	// the counterparty of the channels we're about to sign for is checked
+	// against the match file.
+	if c.MatchFile != "" {
+		m, err := readMatchFile(c.MatchFile)
+		if err != nil {
+			return err
+		}
+
+		remoteNode, err = checkOfferCounterparty(
+			m, ourNodePriv.PubKey(), remoteNode, offer, packet,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if offer != nil {
+		err := checkOfferForSigning(offer, ourNodePriv, remoteNode)
+		if err != nil {
+			return err
+		}
+
+		// For CLN we need the remote peer. If it wasn't known
+		// before, we take the one that made the proposal.
+		if remoteNode == nil {
+			remoteNode, err = pubKeyFromHex(
+				offer.latest().ProposedBy,
+			)
+			if err != nil {
+				return fmt.Errorf("error parsing peer "+
+					"pubkey: %w", err)
+			}
+		}
+	}
+
+	if c.Encrypt && remoteNode == nil {
+		return errors.New("--encrypt requires the remote peer to be " +
+			"known, use --offer_file or --remote_peer")
+	}
+
+	finalTx, err := signOffer(
 		packet, signer, remoteNode, newExplorerAPI(c.APIURL), c.Publish,
 	)
+	if err != nil {
+		return err
+	}
+
+	if !c.Encrypt {
+		return nil
+	}
+
+	e, err := sealEnvelope(
+		envelopeTypeSignedOffer, []byte(hex.EncodeToString(finalTx)),
+		ourNodePriv, remoteNode,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating envelope: %w", err)
+	}
+
+	fileName := fmt.Sprintf("%s/signedoffer-%s-%x.envelope.json",
+		ResultsDir, time.Now().Format("2006-01-02"),
+		ourNodePriv.PubKey().SerializeCompressed())
+	return writeEnvelope(fileName, e)
+}
+
+// readMatchFile reads a match file or a prepared keys file.
+func readMatchFile(fileName string) (*match, error) {
+	matchBytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading match file %s: %w",
+			fileName, err)
+	}
+
+	m := &match{}
+	if err := json.Unmarshal(matchBytes, m); err != nil {
+		return nil, fmt.Errorf("error decoding match file %s: %w",
+			fileName, err)
+	}
+
+	if m.Node1 == nil || m.Node2 == nil {
+		return nil, errors.New("invalid match file, node info missing")
+	}
+
+	return m, nil
+}
+
+// checkOfferCounterparty makes sure the offer was made by the counterparty of
+// the matched channels and only spends those channels. The remote node (if
+// already known from the envelope or the flags) must be the other node of the
+// match. The remote node of the match is returned.
+func checkOfferCounterparty(m *match, ourNode, remoteNode *btcec.PublicKey,
+	offer *offerFile, packet *psbt.Packet) (*btcec.PublicKey, error) {
+
+	ourPubKeyStr := hex.EncodeToString(ourNode.SerializeCompressed())
+	var theirPubKeyStr string
+	switch ourPubKeyStr {
+	case m.Node1.PubKey:
+		theirPubKeyStr = m.Node2.PubKey
+
+	case m.Node2.PubKey:
+		theirPubKeyStr = m.Node1.PubKey
+
+	default:
+		return nil, fmt.Errorf("our node %s is not part of the match "+
+			"file", ourPubKeyStr)
+	}
+
+	theirNode, err := pubKeyFromHex(theirPubKeyStr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing peer pubkey: %w", err)
+	}
+	if remoteNode != nil && !remoteNode.IsEqual(theirNode) {
+		return nil, fmt.Errorf("offer is from %x but the counterparty "+
+			"of the matched channels is %s",
+			remoteNode.SerializeCompressed(), theirPubKeyStr)
+	}
+
+	if offer != nil && (offer.Node1.PubKey != m.Node1.PubKey ||
+		offer.Node2.PubKey != m.Node2.PubKey) {
+
+		return nil, errors.New("nodes of the offer don't match the " +
+			"nodes of the match file")
+	}
+
+	matchedChannels := make(map[string]struct{}, len(m.Channels))
+	for _, c := range m.Channels {
+		matchedChannels[c.ChanPoint] = struct{}{}
+	}
+	for idx, txIn := range packet.UnsignedTx.TxIn {
+		chanPoint := txIn.PreviousOutPoint.String()
+		if _, ok := matchedChannels[chanPoint]; !ok {
+			return nil, fmt.Errorf("input %d spends %s which is "+
+				"not a channel of the match file", idx,
+				chanPoint)
+		}
+	}
+
+	return theirNode, nil
+}
+
+// checkOfferForSigning makes sure the latest proposal of the offer file was
+// made by the remote peer and shows it to the user, together with everything
+// that changed compared to the previous proposal.
+func checkOfferForSigning(offer *offerFile, ourNodePriv *btcec.PrivateKey,
+	remoteNode *btcec.PublicKey) error {
+
+	ourPubKeyStr := hex.EncodeToString(
+		ourNodePriv.PubKey().SerializeCompressed(),
+	)
+	latest := offer.latest()
+	if latest.ProposedBy == ourPubKeyStr {
+		return errors.New("the latest proposal was made by us, it " +
+			"needs to be signed by the remote peer")
+	}
+	if offer.Node1.PubKey != ourPubKeyStr &&
+		offer.Node2.PubKey != ourPubKeyStr {
+
+		return fmt.Errorf("our node %s is not part of the offer",
+			ourPubKeyStr)
+	}
+	if remoteNode != nil && hex.EncodeToString(
+		remoteNode.SerializeCompressed(),
+	) != latest.ProposedBy {
+
+		return fmt.Errorf("latest proposal was made by %s but remote "+
+			"peer is %x", latest.ProposedBy,
+			remoteNode.SerializeCompressed())
+	}
+
+	offer.printLatestProposal(ourPubKeyStr)
+
+	return nil
 }
 
 func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
-	peerPubKey *btcec.PublicKey, api *btc.ExplorerAPI, publish bool) error {
+	peerPubKey *btcec.PublicKey, api *btc.ExplorerAPI,
+	publish bool) ([]byte, error) {
 
 	// Now let's check that the packet has the expected proprietary key with
 	// our pubkey that we need to sign with.
 	if len(packet.Inputs) == 0 {
-		return fmt.Errorf("invalid PSBT, expected at least 1 input, "+
-			"got %d", len(packet.Inputs))
+		return nil, fmt.Errorf("invalid PSBT, expected at least 1 "+
+			"input, got %d", len(packet.Inputs))
 	}
 	for idx := range packet.Inputs {
 		if len(packet.Inputs[idx].Unknowns) != 1 {
-			return fmt.Errorf("invalid PSBT, expected 1 unknown "+
-				"in input %d, got %d", idx,
+			return nil, fmt.Errorf("invalid PSBT, expected 1 "+
+				"unknown in input %d, got %d", idx,
 				len(packet.Inputs[idx].Unknowns))
 		}
+		if packet.Inputs[idx].WitnessUtxo == nil {
+			return nil, fmt.Errorf("invalid PSBT, witness UTXO "+
+				"missing in input %d", idx)
+		}
 	}
 
 	fmt.Printf("The PSBT contains the following proposal:\n\n\t"+
@@ -167,11 +444,12 @@ func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
 		totalOutput += txOut.Value
 		pkScript, err := txscript.ParsePkScript(txOut.PkScript)
 		if err != nil {
-			return fmt.Errorf("error parsing pk script: %w", err)
+			return nil, fmt.Errorf("error parsing pk script: %w",
+				err)
 		}
 		addr, err := pkScript.Address(chainParams)
 		if err != nil {
-			return fmt.Errorf("error parsing address: %w", err)
+			return nil, fmt.Errorf("error parsing address: %w", err)
 		}
 		fmt.Printf("\tSend %d sats to address %s\n", txOut.Value, addr)
 	}
@@ -184,14 +462,14 @@ func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
 	for idx := range packet.Inputs {
 		unknown := packet.Inputs[idx].Unknowns[0]
 		if !bytes.Equal(unknown.Key, PsbtKeyTypeOutputMissingSigPubkey) {
-			return fmt.Errorf("invalid PSBT, unknown has invalid "+
-				"key %x, expected %x", unknown.Key,
+			return nil, fmt.Errorf("invalid PSBT, unknown has "+
+				"invalid key %x, expected %x", unknown.Key,
 				PsbtKeyTypeOutputMissingSigPubkey)
 		}
 		targetKey, err := btcec.ParsePubKey(unknown.Value)
 		if err != nil {
-			return fmt.Errorf("invalid PSBT, proprietary key has "+
-				"invalid pubkey: %w", err)
+			return nil, fmt.Errorf("invalid PSBT, proprietary key "+
+				"has invalid pubkey: %w", err)
 		}
 
 		// Now we can look up the local key and check the PSBT further,
@@ -200,8 +478,8 @@ func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
 			targetKey, peerPubKey, MaxChannelLookup,
 		)
 		if err != nil {
-			return fmt.Errorf("could not find local multisig key: "+
-				"%w", err)
+			return nil, fmt.Errorf("could not find local multisig "+
+				"key: %w", err)
 		}
 
 		// If this is a Simple Taproot channel, we need to generate a
@@ -209,15 +487,15 @@ func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
 		if len(packet.Inputs[idx].MuSig2PartialSigs) > 0 {
 			lndSigner, ok := signer.(*lnd.Signer)
 			if !ok {
-				return errors.New("taproot channels not yet " +
-					"supported for CLN")
+				return nil, errors.New("taproot channels not " +
+					"yet supported for CLN")
 			}
 
 			err = muSig2PartialSign(
 				lndSigner, localKeyDesc, packet, idx,
 			)
 			if err != nil {
-				return fmt.Errorf("error adding partial "+
+				return nil, fmt.Errorf("error adding partial "+
 					"signature: %w", err)
 			}
 
@@ -225,21 +503,18 @@ func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
 		}
 
 		if len(packet.Inputs[idx].WitnessScript) == 0 {
-			return errors.New("invalid PSBT, missing witness " +
-				"script")
+			return nil, errors.New("invalid PSBT, missing " +
+				"witness script")
 		}
 		witnessScript := packet.Inputs[idx].WitnessScript
-		if packet.Inputs[idx].WitnessUtxo == nil {
-			return errors.New("invalid PSBT, witness UTXO missing")
-		}
 		utxo := packet.Inputs[idx].WitnessUtxo
 
 		err = signer.AddPartialSignature(
 			packet, *localKeyDesc, utxo, witnessScript, idx,
 		)
 		if err != nil {
-			return fmt.Errorf("error adding partial signature: %w",
-				err)
+			return nil, fmt.Errorf("error adding partial "+
+				"signature: %w", err)
 		}
 	}
 
@@ -247,23 +522,23 @@ func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
 	// extract the final TX.
 	err := psbt.MaybeFinalizeAll(packet)
 	if err != nil {
-		return fmt.Errorf("error finalizing PSBT: %w", err)
+		return nil, fmt.Errorf("error finalizing PSBT: %w", err)
 	}
 	finalTx, err := psbt.Extract(packet)
 	if err != nil {
-		return fmt.Errorf("unable to extract final TX: %w", err)
+		return nil, fmt.Errorf("unable to extract final TX: %w", err)
 	}
 	var buf bytes.Buffer
 	err = finalTx.Serialize(&buf)
 	if err != nil {
-		return fmt.Errorf("unable to serialize final TX: %w", err)
+		return nil, fmt.Errorf("unable to serialize final TX: %w", err)
 	}
 
 	// Publish TX.
 	if publish {
 		response, err := api.PublishTx(hex.EncodeToString(buf.Bytes()))
 		if err != nil {
-			return err
+			return nil, err
 		}
 		log.Infof("Published TX %s, response: %s",
 			finalTx.TxHash().String(), response)
@@ -273,5 +548,5 @@ func signOffer(packet *psbt.Packet, signer lnd.ChannelSigner,
 			"any bitcoin node:\n\n%x\n\n", buf.Bytes())
 	}
 
-	return nil
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOfferCounterparty(t *testing.T) {
+	node1, addr1 := testOfferNode(t)
+	node2, addr2 := testOfferNode(t)
+	node1Key, err := pubKeyFromHex(node1.PubKey)
+	require.NoError(t, err)
+	node2Key, err := pubKeyFromHex(node2.PubKey)
+	require.NoError(t, err)
+
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{1, 2, 3}, Index: 1}
+	m := &match{
+		Node1: node1,
+		Node2: node2,
+		Channels: []*channel{{
+			ChanPoint: chanPoint.String(),
+		}},
+	}
+	offer := &offerFile{
+		Version: offerFileVersion,
+		Node1:   node1,
+		Node2:   node2,
+	}
+	offer.addProposal(testProposal(
+		t, node1.PubKey, chanPoint, addr1, 60_000, addr2, 39_000,
+	))
+	packet, err := offer.validate()
+	require.NoError(t, err)
+
+	// The offer was sent by the counterparty of the matched channels.
+	remote, err := checkOfferCounterparty(
+		m, node2Key, node1Key, offer, packet,
+	)
+	require.NoError(t, err)
+	require.True(t, remote.IsEqual(node1Key))
+
+	// Without an envelope the remote node is taken from the match file.
+	remote, err = checkOfferCounterparty(m, node2Key, nil, offer, packet)
+	require.NoError(t, err)
+	require.True(t, remote.IsEqual(node1Key))
+
+	// Anyone else can seal a valid envelope, but isn't the counterparty.
+	stranger, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	_, err = checkOfferCounterparty(
+		m, node2Key, stranger.PubKey(), offer, packet,
+	)
+	require.ErrorContains(t, err, "counterparty of the matched channels")
+
+	// We must be part of the match.
+	_, err = checkOfferCounterparty(
+		m, stranger.PubKey(), node1Key, offer, packet,
+	)
+	require.ErrorContains(t, err, "not part of the match file")
+
+	// The offer can't spend any channel that wasn't matched.
+	m.Channels[0].ChanPoint = wire.OutPoint{
+		Hash: chainhash.Hash{3, 2, 1},
+	}.String()
+	_, err = checkOfferCounterparty(m, node2Key, node1Key, offer, packet)
+	require.ErrorContains(t, err, "not a channel of the match file")
+}
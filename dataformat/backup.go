@@ -0,0 +1,524 @@
+package dataformat
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lncfg"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// defaultPeerPort is the port that is assumed for backup addresses
+	// that don't specify one.
+	defaultPeerPort = "9735"
+)
+
+// BackupFile is the JSON representation of an lnd channel.backup (multi)
+// file. It contains exactly the information that is stored in the encrypted
+// binary file, so it can be converted back without losing anything.
+type BackupFile struct {
+	// Version is the version of the multi backup, currently always 0.
+	Version uint8 `json:"version"`
+
+	// Channels is the list of channel backups contained in the file.
+	Channels []*BackupChannel `json:"channels"`
+}
+
+// BackupChannel is the JSON representation of a single channel backup (see
+// chanbackup.Single).
+type BackupChannel struct {
+	// Version is the channel backup version that determines the commitment
+	// type of the channel:
+	//   0: legacy (no tweakless to_remote key)
+	//   1: tweakless
+	//   2: anchors
+	//   3: anchors with zero fee HTLC transactions
+	//   4: script enforced lease
+	//   5: simple taproot
+	//   6: simple taproot with tapscript root
+	Version uint8 `json:"version"`
+
+	IsInitiator bool `json:"is_initiator"`
+
+	// ChainHash is the genesis block hash of the channel's chain.
+	ChainHash string `json:"chain_hash"`
+
+	// FundingOutpoint is the channel point in the format <txid>:<index>.
+	FundingOutpoint string `json:"funding_outpoint"`
+
+	// ShortChannelID is the short channel ID in the format
+	// <block>:<tx_index>:<output_index>. The format
+	// <block>x<tx_index>x<output_index> is also accepted.
+	ShortChannelID string `json:"short_channel_id"`
+
+	// RemoteNodePub is the hex encoded identity public key of the peer.
+	RemoteNodePub string `json:"remote_node_pub"`
+
+	// Addresses is the list of network addresses of the peer in the
+	// format <host>:<port>. Onion addresses are supported.
+	Addresses []string `json:"addresses"`
+
+	// Capacity is the channel capacity in satoshis.
+	Capacity int64 `json:"capacity"`
+
+	LocalChanCfg *BackupLocalConfig `json:"local_chan_cfg"`
+
+	RemoteChanCfg *BackupRemoteConfig `json:"remote_chan_cfg"`
+
+	// ShaChainRoot is the key the revocation producer is derived from.
+	// Its public key is optional.
+	ShaChainRoot *BackupKey `json:"sha_chain_root"`
+
+	// LeaseExpiry is the absolute block height the channel lease expires
+	// at, only used by version 4 (script enforced lease) channels.
+	LeaseExpiry uint32 `json:"lease_expiry,omitempty"`
+
+	// CloseTxInputs contains the latest commitment transaction and the
+	// peer's signature for it, if the backup was created with an lnd
+	// version that stores them.
+	CloseTxInputs *BackupCloseTxInputs `json:"close_tx_inputs,omitempty"`
+}
+
+// BackupLocalConfig is the local part of a channel's configuration that is
+// stored in a channel backup. Only the key locators are stored, the keys are
+// derived from the seed.
+type BackupLocalConfig struct {
+	CsvDelay            uint16     `json:"csv_delay"`
+	MultiSigKey         *BackupKey `json:"multisig_key"`
+	RevocationBasePoint *BackupKey `json:"revocation_base_point"`
+	PaymentBasePoint    *BackupKey `json:"payment_base_point"`
+	DelayBasePoint      *BackupKey `json:"delay_base_point"`
+	HtlcBasePoint       *BackupKey `json:"htlc_base_point"`
+}
+
+// BackupRemoteConfig is the remote part of a channel's configuration that is
+// stored in a channel backup. The keys are hex encoded compressed public keys.
+type BackupRemoteConfig struct {
+	CsvDelay            uint16 `json:"csv_delay"`
+	MultiSigKey         string `json:"multisig_key"`
+	RevocationBasePoint string `json:"revocation_base_point"`
+	PaymentBasePoint    string `json:"payment_base_point"`
+	DelayBasePoint      string `json:"delay_base_point"`
+	HtlcBasePoint       string `json:"htlc_base_point"`
+}
+
+// BackupKey is the key locator of one of our keys and optionally its hex
+// encoded compressed public key.
+type BackupKey struct {
+	Family uint32 `json:"family"`
+	Index  uint32 `json:"index"`
+	PubKey string `json:"pubkey,omitempty"`
+}
+
+// BackupCloseTxInputs is the data needed to produce a force close transaction
+// from a channel backup.
+type BackupCloseTxInputs struct {
+	// CommitTx is the hex encoded unsigned commitment transaction.
+	CommitTx string `json:"commit_tx"`
+
+	// CommitSig is the hex encoded signature of the peer for the
+	// commitment transaction.
+	CommitSig string `json:"commit_sig"`
+
+	// CommitHeight is the commitment height, only used by taproot
+	// channels.
+	CommitHeight uint64 `json:"commit_height,omitempty"`
+
+	// TapscriptRoot is the hex encoded tapscript root of the funding
+	// output, only used by version 6 channels.
+	TapscriptRoot string `json:"tapscript_root,omitempty"`
+}
+
+// NewBackupFile converts the given multi backup into its JSON representation.
+func NewBackupFile(multi *chanbackup.Multi) (*BackupFile, error) {
+	file := &BackupFile{
+		Version:  uint8(multi.Version),
+		Channels: make([]*BackupChannel, len(multi.StaticBackups)),
+	}
+	for idx := range multi.StaticBackups {
+		channel, err := NewBackupChannel(&multi.StaticBackups[idx])
+		if err != nil {
+			return nil, err
+		}
+		file.Channels[idx] = channel
+	}
+
+	return file, nil
+}
+
+// Multi converts the JSON representation back into a multi backup.
+func (f *BackupFile) Multi() (*chanbackup.Multi, error) {
+	multi := &chanbackup.Multi{
+		Version:       chanbackup.MultiBackupVersion(f.Version),
+		StaticBackups: make([]chanbackup.Single, len(f.Channels)),
+	}
+	for idx, channel := range f.Channels {
+		single, err := channel.Single()
+		if err != nil {
+			return nil, fmt.Errorf("error converting channel %d "+
+				"(%s): %w", idx, channel.FundingOutpoint, err)
+		}
+		multi.StaticBackups[idx] = *single
+	}
+
+	return multi, nil
+}
+
+// ReadBackupFile reads and parses the given JSON backup file. Unknown fields
+// are rejected to catch typos in manually edited files.
+func ReadBackupFile(fileName string) (*BackupFile, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %w", fileName,
+			err)
+	}
+
+	file := &BackupFile{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(file); err != nil {
+		return nil, fmt.Errorf("error parsing file %s: %w", fileName,
+			err)
+	}
+
+	return file, nil
+}
+
+// NewBackupChannel converts the given single channel backup into its JSON
+// representation.
+func NewBackupChannel(single *chanbackup.Single) (*BackupChannel, error) {
+	addresses := make([]string, len(single.Addresses))
+	for idx, addr := range single.Addresses {
+		addresses[idx] = addr.String()
+	}
+
+	local, remote := single.LocalChanCfg, single.RemoteChanCfg
+	channel := &BackupChannel{
+		Version:         uint8(single.Version),
+		IsInitiator:     single.IsInitiator,
+		ChainHash:       single.ChainHash.String(),
+		FundingOutpoint: single.FundingOutpoint.String(),
+		ShortChannelID:  single.ShortChannelID.String(),
+		RemoteNodePub:   pubKeyHex(single.RemoteNodePub),
+		Addresses:       addresses,
+		Capacity:        int64(single.Capacity),
+		LocalChanCfg: &BackupLocalConfig{
+			CsvDelay:    local.CsvDelay,
+			MultiSigKey: newBackupKey(local.MultiSigKey, false),
+			RevocationBasePoint: newBackupKey(
+				local.RevocationBasePoint, false,
+			),
+			PaymentBasePoint: newBackupKey(
+				local.PaymentBasePoint, false,
+			),
+			DelayBasePoint: newBackupKey(
+				local.DelayBasePoint, false,
+			),
+			HtlcBasePoint: newBackupKey(
+				local.HtlcBasePoint, false,
+			),
+		},
+		RemoteChanCfg: &BackupRemoteConfig{
+			CsvDelay:    remote.CsvDelay,
+			MultiSigKey: pubKeyHex(remote.MultiSigKey.PubKey),
+			RevocationBasePoint: pubKeyHex(
+				remote.RevocationBasePoint.PubKey,
+			),
+			PaymentBasePoint: pubKeyHex(
+				remote.PaymentBasePoint.PubKey,
+			),
+			DelayBasePoint: pubKeyHex(
+				remote.DelayBasePoint.PubKey,
+			),
+			HtlcBasePoint: pubKeyHex(remote.HtlcBasePoint.PubKey),
+		},
+		ShaChainRoot: newBackupKey(single.ShaChainRootDesc, true),
+		LeaseExpiry:  single.LeaseExpiry,
+	}
+
+	var err error
+	single.CloseTxInputs.WhenSome(func(inputs chanbackup.CloseTxInputs) {
+		var buf bytes.Buffer
+		if err = inputs.CommitTx.Serialize(&buf); err != nil {
+			return
+		}
+
+		channel.CloseTxInputs = &BackupCloseTxInputs{
+			CommitTx:     hex.EncodeToString(buf.Bytes()),
+			CommitSig:    hex.EncodeToString(inputs.CommitSig),
+			CommitHeight: inputs.CommitHeight,
+		}
+		inputs.TapscriptRoot.WhenSome(func(root chainhash.Hash) {
+			rootHex := hex.EncodeToString(root[:])
+			channel.CloseTxInputs.TapscriptRoot = rootHex
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error serializing commit tx: %w", err)
+	}
+
+	return channel, nil
+}
+
+// Single converts the JSON representation back into a single channel backup.
+func (c *BackupChannel) Single() (*chanbackup.Single, error) {
+	if c.LocalChanCfg == nil || c.RemoteChanCfg == nil ||
+		c.ShaChainRoot == nil {
+
+		return nil, errors.New("local_chan_cfg, remote_chan_cfg and " +
+			"sha_chain_root are required")
+	}
+
+	chainHash, err := chainhash.NewHashFromStr(c.ChainHash)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing chain hash: %w", err)
+	}
+	fundingOutpoint, err := wire.NewOutPointFromString(c.FundingOutpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing funding outpoint: %w",
+			err)
+	}
+	shortChanID, err := parseShortChannelID(c.ShortChannelID)
+	if err != nil {
+		return nil, err
+	}
+	remoteNodePub, err := parsePubKey("remote_node_pub", c.RemoteNodePub)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]net.Addr, len(c.Addresses))
+	for idx, addr := range c.Addresses {
+		addresses[idx], err = lncfg.ParseAddressString(
+			addr, defaultPeerPort, net.ResolveTCPAddr,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing address %s: %w",
+				addr, err)
+		}
+	}
+
+	single := &chanbackup.Single{
+		Version:         chanbackup.SingleBackupVersion(c.Version),
+		IsInitiator:     c.IsInitiator,
+		ChainHash:       *chainHash,
+		FundingOutpoint: *fundingOutpoint,
+		ShortChannelID:  shortChanID,
+		RemoteNodePub:   remoteNodePub,
+		Addresses:       addresses,
+		Capacity:        btcutil.Amount(c.Capacity),
+		LeaseExpiry:     c.LeaseExpiry,
+	}
+
+	local := &single.LocalChanCfg
+	local.CsvDelay = c.LocalChanCfg.CsvDelay
+	localKeys := []struct {
+		name   string
+		key    *BackupKey
+		target *keychain.KeyDescriptor
+	}{
+		{"multisig_key", c.LocalChanCfg.MultiSigKey,
+			&local.MultiSigKey},
+		{"revocation_base_point", c.LocalChanCfg.RevocationBasePoint,
+			&local.RevocationBasePoint},
+		{"payment_base_point", c.LocalChanCfg.PaymentBasePoint,
+			&local.PaymentBasePoint},
+		{"delay_base_point", c.LocalChanCfg.DelayBasePoint,
+			&local.DelayBasePoint},
+		{"htlc_base_point", c.LocalChanCfg.HtlcBasePoint,
+			&local.HtlcBasePoint},
+	}
+	for _, localKey := range localKeys {
+		if localKey.key == nil {
+			return nil, fmt.Errorf("local %s is required",
+				localKey.name)
+		}
+
+		*localKey.target, err = localKey.key.desc()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing local %s: %w",
+				localKey.name, err)
+		}
+	}
+
+	remote := &single.RemoteChanCfg
+	remote.CsvDelay = c.RemoteChanCfg.CsvDelay
+	remoteKeys := []struct {
+		name   string
+		key    string
+		target *keychain.KeyDescriptor
+	}{
+		{"multisig_key", c.RemoteChanCfg.MultiSigKey,
+			&remote.MultiSigKey},
+		{"revocation_base_point", c.RemoteChanCfg.RevocationBasePoint,
+			&remote.RevocationBasePoint},
+		{"payment_base_point", c.RemoteChanCfg.PaymentBasePoint,
+			&remote.PaymentBasePoint},
+		{"delay_base_point", c.RemoteChanCfg.DelayBasePoint,
+			&remote.DelayBasePoint},
+		{"htlc_base_point", c.RemoteChanCfg.HtlcBasePoint,
+			&remote.HtlcBasePoint},
+	}
+	for _, remoteKey := range remoteKeys {
+		remoteKey.target.PubKey, err = parsePubKey(
+			"remote "+remoteKey.name, remoteKey.key,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	single.ShaChainRootDesc, err = c.ShaChainRoot.desc()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing sha_chain_root: %w", err)
+	}
+
+	if c.CloseTxInputs != nil {
+		inputs, err := c.CloseTxInputs.closeTxInputs()
+		if err != nil {
+			return nil, err
+		}
+		single.CloseTxInputs = fn.Some(*inputs)
+	}
+
+	return single, nil
+}
+
+// closeTxInputs parses the close transaction inputs.
+func (i *BackupCloseTxInputs) closeTxInputs() (*chanbackup.CloseTxInputs,
+	error) {
+
+	txBytes, err := hex.DecodeString(i.CommitTx)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding commit_tx: %w", err)
+	}
+	commitTx := &wire.MsgTx{}
+	if err := commitTx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return nil, fmt.Errorf("error parsing commit_tx: %w", err)
+	}
+
+	commitSig, err := hex.DecodeString(i.CommitSig)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding commit_sig: %w", err)
+	}
+
+	inputs := &chanbackup.CloseTxInputs{
+		CommitTx:     commitTx,
+		CommitSig:    commitSig,
+		CommitHeight: i.CommitHeight,
+	}
+	if i.TapscriptRoot != "" {
+		rootBytes, err := hex.DecodeString(i.TapscriptRoot)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding "+
+				"tapscript_root: %w", err)
+		}
+		root, err := chainhash.NewHash(rootBytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing tapscript_root: "+
+				"%w", err)
+		}
+		inputs.TapscriptRoot = fn.Some(*root)
+	}
+
+	return inputs, nil
+}
+
+// newBackupKey converts the given key descriptor, optionally including its
+// public key.
+func newBackupKey(desc keychain.KeyDescriptor, withPubKey bool) *BackupKey {
+	key := &BackupKey{
+		Family: uint32(desc.Family),
+		Index:  desc.Index,
+	}
+	if withPubKey && desc.PubKey != nil {
+		key.PubKey = pubKeyHex(desc.PubKey)
+	}
+
+	return key
+}
+
+// desc converts the key back into a key descriptor.
+func (k *BackupKey) desc() (keychain.KeyDescriptor, error) {
+	desc := keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamily(k.Family),
+			Index:  k.Index,
+		},
+	}
+	if k.PubKey == "" {
+		return desc, nil
+	}
+
+	var err error
+	desc.PubKey, err = parsePubKey("pubkey", k.PubKey)
+
+	return desc, err
+}
+
+// parseShortChannelID parses a short channel ID in the format
+// <block>:<tx_index>:<output_index> or <block>x<tx_index>x<output_index>.
+func parseShortChannelID(scid string) (lnwire.ShortChannelID, error) {
+	parts := strings.Split(strings.ReplaceAll(scid, "x", ":"), ":")
+	if len(parts) != 3 {
+		return lnwire.ShortChannelID{}, fmt.Errorf("invalid short "+
+			"channel ID %s, expected format <block>:<tx_index>:"+
+			"<output_index>", scid)
+	}
+
+	var values [3]uint64
+	bitSizes := [3]int{24, 24, 16}
+	for idx, part := range parts {
+		value, err := strconv.ParseUint(part, 10, bitSizes[idx])
+		if err != nil {
+			return lnwire.ShortChannelID{}, fmt.Errorf("invalid "+
+				"short channel ID %s: %w", scid, err)
+		}
+		values[idx] = value
+	}
+
+	return lnwire.ShortChannelID{
+		BlockHeight: uint32(values[0]),
+		TxIndex:     uint32(values[1]),
+		TxPosition:  uint16(values[2]),
+	}, nil
+}
+
+// parsePubKey parses a hex encoded compressed public key.
+func parsePubKey(name, pubKeyHex string) (*btcec.PublicKey, error) {
+	pubKeyBytes, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", name, err)
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", name, err)
+	}
+
+	return pubKey, nil
+}
+
+// pubKeyHex returns the hex encoded compressed public key or an empty string
+// if the key is nil.
+func pubKeyHex(pubKey *btcec.PublicKey) string {
+	if pubKey == nil {
+		return ""
+	}
+
+	return hex.EncodeToString(pubKey.SerializeCompressed())
+}
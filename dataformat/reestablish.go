@@ -0,0 +1,116 @@
+package dataformat
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// ChannelReestablish is a channel_reestablish message that was received from a
+// remote peer. The commit point it contains is the per-commitment point of the
+// peer's current commitment transaction, which is required to derive our key
+// of the to_remote output of legacy (non-tweakless) channels.
+type ChannelReestablish struct {
+	Peer                      string `json:"peer"`
+	ChannelID                 string `json:"channel_id"`
+	ChannelPoint              string `json:"channel_point,omitempty"`
+	NextLocalCommitHeight     uint64 `json:"next_local_commit_height"`
+	RemoteCommitTailHeight    uint64 `json:"remote_commit_tail_height"`
+	LastRemoteCommitSecret    string `json:"last_remote_commit_secret"`
+	LocalUnrevokedCommitPoint string `json:"local_unrevoked_commit_point"`
+}
+
+// NewChannelReestablish converts the given wire message received from the
+// given peer. The channel point is optional, as it can't be derived from the
+// channel ID alone.
+func NewChannelReestablish(peer, channelPoint string,
+	msg *lnwire.ChannelReestablish) *ChannelReestablish {
+
+	var commitPoint string
+	if msg.LocalUnrevokedCommitPoint != nil {
+		commitPoint = hex.EncodeToString(
+			msg.LocalUnrevokedCommitPoint.SerializeCompressed(),
+		)
+	}
+
+	return &ChannelReestablish{
+		Peer:                   peer,
+		ChannelID:              msg.ChanID.String(),
+		ChannelPoint:           channelPoint,
+		NextLocalCommitHeight:  msg.NextLocalCommitHeight,
+		RemoteCommitTailHeight: msg.RemoteCommitTailHeight,
+		LastRemoteCommitSecret: hex.EncodeToString(
+			msg.LastRemoteCommitSecret[:],
+		),
+		LocalUnrevokedCommitPoint: commitPoint,
+	}
+}
+
+// CommitPoint returns the parsed commit point of the message or nil if the
+// peer didn't send one.
+func (r *ChannelReestablish) CommitPoint() (*btcec.PublicKey, error) {
+	if r.LocalUnrevokedCommitPoint == "" {
+		return nil, nil
+	}
+
+	commitPointBytes, err := hex.DecodeString(r.LocalUnrevokedCommitPoint)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding commit point: %w", err)
+	}
+
+	return btcec.ParsePubKey(commitPointBytes)
+}
+
+// ReestablishFile is a file containing all channel_reestablish messages that
+// were received from remote peers.
+type ReestablishFile struct {
+	Messages []*ChannelReestablish `json:"channel_reestablish_messages"`
+}
+
+// CommitPoints returns all distinct commit points contained in the file.
+func (f *ReestablishFile) CommitPoints() ([]*btcec.PublicKey, error) {
+	var (
+		result []*btcec.PublicKey
+		seen   = make(map[string]struct{}, len(f.Messages))
+	)
+	for _, msg := range f.Messages {
+		commitPoint, err := msg.CommitPoint()
+		if err != nil {
+			return nil, fmt.Errorf("invalid message for channel "+
+				"%s: %w", msg.ChannelID, err)
+		}
+
+		if commitPoint == nil {
+			continue
+		}
+		if _, ok := seen[msg.LocalUnrevokedCommitPoint]; ok {
+			continue
+		}
+
+		seen[msg.LocalUnrevokedCommitPoint] = struct{}{}
+		result = append(result, commitPoint)
+	}
+
+	return result, nil
+}
+
+// ReadReestablishFile reads and parses the given channel_reestablish file.
+func ReadReestablishFile(fileName string) (*ReestablishFile, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %w", fileName,
+			err)
+	}
+
+	file := &ReestablishFile{}
+	if err := json.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("error parsing file %s: %w", fileName,
+			err)
+	}
+
+	return file, nil
+}
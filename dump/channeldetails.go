@@ -0,0 +1,402 @@
+package dump
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// ChannelDetails is the full state of a single channel in lnd's channel DB,
+// including the HTLCs of all commitments, the revocation log and the
+// forwarding packages. It contains everything needed to plan the recovery of
+// HTLCs or the punishment of a breach.
+type ChannelDetails struct {
+	ChanPoint string `json:"chan_point"`
+
+	ShortChannelID string `json:"short_channel_id"`
+
+	RemotePub string `json:"remote_pub"`
+
+	Status string `json:"status"`
+
+	// Historical is true for a closed channel that was read from the
+	// historical channel bucket.
+	Historical bool `json:"historical"`
+
+	Capacity btcutil.Amount `json:"capacity"`
+
+	LocalCommitHeight uint64 `json:"local_commit_height"`
+
+	RemoteCommitHeight uint64 `json:"remote_commit_height"`
+
+	LocalCommitment Commitment `json:"local_commitment"`
+
+	RemoteCommitment Commitment `json:"remote_commitment"`
+
+	// PendingRemoteCommitment is the new remote commitment we signed but
+	// the remote party didn't revoke their current one for yet.
+	PendingRemoteCommitment *Commitment `json:"pending_remote_commitment,omitempty"`
+
+	UnsignedAckedUpdates []LogUpdate `json:"unsigned_acked_updates"`
+
+	RemoteUnsignedLocalUpdates []LogUpdate `json:"remote_unsigned_local_updates"`
+
+	// RevocationLog contains the revoked remote commitments. It's empty
+	// for historical channels as lnd deletes it when a channel is closed.
+	RevocationLog []RevocationLogEntry `json:"revocation_log"`
+
+	ForwardingPackages []ForwardingPackage `json:"forwarding_packages"`
+}
+
+// Commitment is the information we want to dump from a commitment. See
+// `channeldb.ChannelCommitment` for information about the fields.
+type Commitment struct {
+	CommitHeight      uint64         `json:"commit_height"`
+	LocalLogIndex     uint64         `json:"local_log_index"`
+	LocalHtlcIndex    uint64         `json:"local_htlc_index"`
+	RemoteLogIndex    uint64         `json:"remote_log_index"`
+	RemoteHtlcIndex   uint64         `json:"remote_htlc_index"`
+	LocalBalanceMsat  uint64         `json:"local_balance_msat"`
+	RemoteBalanceMsat uint64         `json:"remote_balance_msat"`
+	CommitFee         btcutil.Amount `json:"commit_fee"`
+	FeePerKw          btcutil.Amount `json:"fee_per_kw"`
+	CommitTxid        string         `json:"commit_txid"`
+	HTLCs             []HTLC         `json:"htlcs"`
+}
+
+// HTLC is the information we want to dump from an HTLC of a commitment. See
+// `channeldb.HTLC` for information about the fields. An output index of -1
+// means the HTLC is dust and has no output on the commitment transaction.
+type HTLC struct {
+	Incoming      bool   `json:"incoming"`
+	PaymentHash   string `json:"payment_hash"`
+	AmountMsat    uint64 `json:"amount_msat"`
+	RefundTimeout uint32 `json:"refund_timeout"`
+	OutputIndex   int32  `json:"output_index"`
+	HtlcIndex     uint64 `json:"htlc_index"`
+	LogIndex      uint64 `json:"log_index"`
+}
+
+// RevocationLogEntry is the information we want to dump from a revoked remote
+// commitment. See `channeldb.RevocationLog` for information about the fields.
+// Output indexes of -1 mean there is no such output. Entries written by old
+// versions of lnd contain the full commitment and are marked as legacy.
+type RevocationLogEntry struct {
+	CommitHeight     uint64        `json:"commit_height"`
+	Legacy           bool          `json:"legacy"`
+	CommitTxid       string        `json:"commit_txid"`
+	OurOutputIndex   int32         `json:"our_output_index"`
+	TheirOutputIndex int32         `json:"their_output_index"`
+	OurBalanceMsat   *uint64       `json:"our_balance_msat,omitempty"`
+	TheirBalanceMsat *uint64       `json:"their_balance_msat,omitempty"`
+	HTLCs            []RevokedHTLC `json:"htlcs"`
+}
+
+// RevokedHTLC is the information we want to dump from an HTLC of a revoked
+// commitment. See `channeldb.HTLCEntry` for information about the fields.
+type RevokedHTLC struct {
+	Incoming      bool           `json:"incoming"`
+	PaymentHash   string         `json:"payment_hash"`
+	Amount        btcutil.Amount `json:"amount"`
+	RefundTimeout uint32         `json:"refund_timeout"`
+	OutputIndex   int32          `json:"output_index"`
+	HtlcIndex     *uint64        `json:"htlc_index,omitempty"`
+}
+
+// ForwardingPackage is the information we want to dump from a forwarding
+// package. See `channeldb.FwdPkg` for information about the fields.
+type ForwardingPackage struct {
+	Source      string      `json:"source"`
+	Height      uint64      `json:"height"`
+	State       string      `json:"state"`
+	Adds        []LogUpdate `json:"adds"`
+	SettleFails []LogUpdate `json:"settle_fails"`
+}
+
+// LogUpdate is the information we want to dump from an HTLC related update of
+// a channel's update log. See `channeldb.LogUpdate` for information about the
+// fields.
+type LogUpdate struct {
+	LogIndex    uint64 `json:"log_index"`
+	Type        string `json:"type"`
+	HtlcID      uint64 `json:"htlc_id"`
+	PaymentHash string `json:"payment_hash,omitempty"`
+	AmountMsat  uint64 `json:"amount_msat,omitempty"`
+	Expiry      uint32 `json:"expiry,omitempty"`
+	Preimage    string `json:"preimage,omitempty"`
+	FeePerKw    uint32 `json:"fee_per_kw,omitempty"`
+}
+
+// RevocationLogReader reads the revocation log of a channel and calls fn for
+// every revoked remote commitment in ascending order of the commit heights.
+// Entries in the legacy format are passed as the full commitment with a nil
+// revocation log.
+type RevocationLogReader func(fn func(height uint64,
+	revLog *channeldb.RevocationLog,
+	commit *channeldb.ChannelCommitment) error) error
+
+// ChannelDetailsDump reads the full state of the given channel from the DB
+// and converts it into a dumpable format. The revocation log entries are read
+// with the given reader, which is nil for historical (closed) channels. Those
+// also no longer have pending commitments or updates.
+func ChannelDetailsDump(channel *channeldb.OpenChannel, historical bool,
+	readRevocationLog RevocationLogReader) (*ChannelDetails, error) {
+
+	details := &ChannelDetails{
+		ChanPoint:          channel.FundingOutpoint.String(),
+		ShortChannelID:     channel.ShortChannelID.String(),
+		RemotePub:          PubKeyToString(channel.IdentityPub),
+		Status:             channel.ChanStatus().String(),
+		Historical:         historical,
+		Capacity:           channel.Capacity,
+		LocalCommitHeight:  channel.LocalCommitment.CommitHeight,
+		RemoteCommitHeight: channel.RemoteCommitment.CommitHeight,
+		LocalCommitment: commitmentDump(
+			&channel.LocalCommitment,
+		),
+		RemoteCommitment: commitmentDump(
+			&channel.RemoteCommitment,
+		),
+		UnsignedAckedUpdates:       []LogUpdate{},
+		RemoteUnsignedLocalUpdates: []LogUpdate{},
+		RevocationLog:              []RevocationLogEntry{},
+	}
+
+	if !historical {
+		tip, err := channel.RemoteCommitChainTip()
+		switch {
+		case errors.Is(err, channeldb.ErrNoPendingCommit):
+
+		case err != nil:
+			return nil, fmt.Errorf("error fetching pending remote "+
+				"commitment: %w", err)
+
+		default:
+			pending := commitmentDump(&tip.Commitment)
+			details.PendingRemoteCommitment = &pending
+		}
+
+		updates, err := channel.UnsignedAckedUpdates()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching unsigned acked "+
+				"updates: %w", err)
+		}
+		details.UnsignedAckedUpdates = logUpdatesDump(updates)
+
+		updates, err = channel.RemoteUnsignedLocalUpdates()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching remote "+
+				"unsigned local updates: %w", err)
+		}
+		details.RemoteUnsignedLocalUpdates = logUpdatesDump(updates)
+	}
+
+	if readRevocationLog != nil {
+		err := readRevocationLog(func(height uint64,
+			revLog *channeldb.RevocationLog,
+			commit *channeldb.ChannelCommitment) error {
+
+			details.RevocationLog = append(
+				details.RevocationLog,
+				revocationLogDump(height, revLog, commit),
+			)
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading revocation log: "+
+				"%w", err)
+		}
+	}
+
+	fwdPkgs, err := channel.LoadFwdPkgs()
+	if err != nil {
+		return nil, fmt.Errorf("error loading forwarding packages: %w",
+			err)
+	}
+	details.ForwardingPackages = make([]ForwardingPackage, len(fwdPkgs))
+	for idx, fwdPkg := range fwdPkgs {
+		details.ForwardingPackages[idx] = ForwardingPackage{
+			Source:      fwdPkg.Source.String(),
+			Height:      fwdPkg.Height,
+			State:       fwdStateString(fwdPkg.State),
+			Adds:        logUpdatesDump(fwdPkg.Adds),
+			SettleFails: logUpdatesDump(fwdPkg.SettleFails),
+		}
+	}
+
+	return details, nil
+}
+
+func commitmentDump(commit *channeldb.ChannelCommitment) Commitment {
+	dumpCommit := Commitment{
+		CommitHeight:      commit.CommitHeight,
+		LocalLogIndex:     commit.LocalLogIndex,
+		LocalHtlcIndex:    commit.LocalHtlcIndex,
+		RemoteLogIndex:    commit.RemoteLogIndex,
+		RemoteHtlcIndex:   commit.RemoteHtlcIndex,
+		LocalBalanceMsat:  uint64(commit.LocalBalance),
+		RemoteBalanceMsat: uint64(commit.RemoteBalance),
+		CommitFee:         commit.CommitFee,
+		FeePerKw:          commit.FeePerKw,
+		HTLCs:             make([]HTLC, len(commit.Htlcs)),
+	}
+	if commit.CommitTx != nil {
+		dumpCommit.CommitTxid = commit.CommitTx.TxHash().String()
+	}
+
+	for idx, htlc := range commit.Htlcs {
+		dumpCommit.HTLCs[idx] = HTLC{
+			Incoming:      htlc.Incoming,
+			PaymentHash:   fmt.Sprintf("%x", htlc.RHash[:]),
+			AmountMsat:    uint64(htlc.Amt),
+			RefundTimeout: htlc.RefundTimeout,
+			OutputIndex:   htlc.OutputIndex,
+			HtlcIndex:     htlc.HtlcIndex,
+			LogIndex:      htlc.LogIndex,
+		}
+	}
+
+	return dumpCommit
+}
+
+func revocationLogDump(height uint64, revLog *channeldb.RevocationLog,
+	commit *channeldb.ChannelCommitment) RevocationLogEntry {
+
+	// Old versions of lnd stored the full commitment in the log.
+	if revLog == nil {
+		legacyCommit := commitmentDump(commit)
+		entry := RevocationLogEntry{
+			CommitHeight:     height,
+			Legacy:           true,
+			CommitTxid:       legacyCommit.CommitTxid,
+			OurOutputIndex:   -1,
+			TheirOutputIndex: -1,
+			OurBalanceMsat:   &legacyCommit.LocalBalanceMsat,
+			TheirBalanceMsat: &legacyCommit.RemoteBalanceMsat,
+			HTLCs: make(
+				[]RevokedHTLC, 0, len(commit.Htlcs),
+			),
+		}
+		for _, htlc := range commit.Htlcs {
+			htlcIndex := htlc.HtlcIndex
+			entry.HTLCs = append(entry.HTLCs, RevokedHTLC{
+				Incoming:      htlc.Incoming,
+				PaymentHash:   fmt.Sprintf("%x", htlc.RHash[:]),
+				Amount:        htlc.Amt.ToSatoshis(),
+				RefundTimeout: htlc.RefundTimeout,
+				OutputIndex:   htlc.OutputIndex,
+				HtlcIndex:     &htlcIndex,
+			})
+		}
+
+		return entry
+	}
+
+	commitTxid := chainhash.Hash(revLog.CommitTxHash.Val)
+	entry := RevocationLogEntry{
+		CommitHeight: height,
+		CommitTxid:   commitTxid.String(),
+		OurOutputIndex: revLogOutputIndex(
+			revLog.OurOutputIndex.Val,
+		),
+		TheirOutputIndex: revLogOutputIndex(
+			revLog.TheirOutputIndex.Val,
+		),
+		HTLCs: make([]RevokedHTLC, len(revLog.HTLCEntries)),
+	}
+	revLog.OurBalance.WhenSomeV(func(b channeldb.BigSizeMilliSatoshi) {
+		balance := uint64(b.Int())
+		entry.OurBalanceMsat = &balance
+	})
+	revLog.TheirBalance.WhenSomeV(func(b channeldb.BigSizeMilliSatoshi) {
+		balance := uint64(b.Int())
+		entry.TheirBalanceMsat = &balance
+	})
+
+	for idx, htlc := range revLog.HTLCEntries {
+		revokedHTLC := RevokedHTLC{
+			Incoming:      htlc.Incoming.Val,
+			PaymentHash:   fmt.Sprintf("%x", htlc.RHash.Val[:]),
+			Amount:        htlc.Amt.Val.Int(),
+			RefundTimeout: htlc.RefundTimeout.Val,
+			OutputIndex:   int32(htlc.OutputIndex.Val),
+		}
+		htlc.HtlcIndex.WhenSomeV(func(htlcIndex uint16) {
+			index := uint64(htlcIndex)
+			revokedHTLC.HtlcIndex = &index
+		})
+		entry.HTLCs[idx] = revokedHTLC
+	}
+
+	return entry
+}
+
+// revLogOutputIndex converts an output index of the revocation log, using -1
+// for a missing output.
+func revLogOutputIndex(index uint16) int32 {
+	if index == channeldb.OutputIndexEmpty {
+		return -1
+	}
+
+	return int32(index)
+}
+
+func logUpdatesDump(updates []channeldb.LogUpdate) []LogUpdate {
+	dumpUpdates := make([]LogUpdate, len(updates))
+	for idx, update := range updates {
+		dumpUpdate := LogUpdate{
+			LogIndex: update.LogIndex,
+			Type:     update.UpdateMsg.MsgType().String(),
+		}
+
+		switch msg := update.UpdateMsg.(type) {
+		case *lnwire.UpdateAddHTLC:
+			dumpUpdate.HtlcID = msg.ID
+			dumpUpdate.PaymentHash = fmt.Sprintf(
+				"%x", msg.PaymentHash[:],
+			)
+			dumpUpdate.AmountMsat = uint64(msg.Amount)
+			dumpUpdate.Expiry = msg.Expiry
+
+		case *lnwire.UpdateFulfillHTLC:
+			dumpUpdate.HtlcID = msg.ID
+			dumpUpdate.Preimage = fmt.Sprintf(
+				"%x", msg.PaymentPreimage[:],
+			)
+
+		case *lnwire.UpdateFailHTLC:
+			dumpUpdate.HtlcID = msg.ID
+
+		case *lnwire.UpdateFailMalformedHTLC:
+			dumpUpdate.HtlcID = msg.ID
+
+		case *lnwire.UpdateFee:
+			dumpUpdate.FeePerKw = msg.FeePerKw
+		}
+
+		dumpUpdates[idx] = dumpUpdate
+	}
+
+	return dumpUpdates
+}
+
+func fwdStateString(state channeldb.FwdState) string {
+	switch state {
+	case channeldb.FwdStateLockedIn:
+		return "locked_in"
+
+	case channeldb.FwdStateProcessed:
+		return "processed"
+
+	case channeldb.FwdStateCompleted:
+		return "completed"
+
+	default:
+		return fmt.Sprintf("unknown (%d)", state)
+	}
+}
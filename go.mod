@@ -39,6 +39,7 @@ require (
 require (
 	github.com/btcsuite/btclog/v2 v2.0.1-0.20250110154127-3ae4bf1cb318
 	github.com/lightningnetwork/lnd/fn/v2 v2.0.8
+	github.com/lightningnetwork/lnd/tlv v1.3.1
 	github.com/tv42/zbase32 v0.0.0-20220222190657-f76a9fc892fa
 	google.golang.org/protobuf v1.36.11
 )
@@ -229,7 +230,6 @@ require (
 	github.com/lightningnetwork/lnd/clock v1.1.1 // indirect
 	github.com/lightningnetwork/lnd/healthcheck v1.2.6 // indirect
 	github.com/lightningnetwork/lnd/sqldb v1.0.9 // indirect
-	github.com/lightningnetwork/lnd/tlv v1.3.1 // indirect
 	github.com/ltcsuite/ltcd v0.0.0-20191228044241-92166e412499 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/macabu/inamedparam v0.2.0 // indirect
@@ -435,6 +435,7 @@ func getZombieMakeOffer(t *testing.T, node, tempDir, keys1File,
 	cmdOutput := invokeCmdZombieRecoveryMakeOffer(
 		t, &emptyPassword, tempDir, localBalance,
 		"--node1_keys", keys1File, "--node2_keys", keys2File,
+		"--require_envelope=false",
 		"--walletdb", walletDbPath,
 	)
 	psbt := extractRowContent(cmdOutput, rowOffer)
@@ -451,6 +452,7 @@ func getZombieMakeOfferCln(t *testing.T, node, tempDir, keys1File,
 	cmdOutput := invokeCmdZombieRecoveryMakeOffer(
 		t, nil, tempDir, localBalance,
 		"--node1_keys", keys1File, "--node2_keys", keys2File,
+		"--require_envelope=false",
 		"--hsm_secret", readHsmSecret(t, node),
 	)
 	psbt := extractRowContent(cmdOutput, rowOffer)
@@ -466,6 +468,7 @@ func getZombieSignOffer(t *testing.T, node, tempDir, psbt string) string {
 	cmdOutput := invokeCmdZombieRecoverySignOffer(
 		t, &emptyPassword, tempDir,
 		"--psbt", psbt, "--walletdb", walletDbPath,
+		"--require_envelope=false",
 	)
 	txHex := extractRowContent(cmdOutput, rowPublish)
 	require.Contains(t, txHex, transactionHexIdent)
@@ -480,6 +483,7 @@ func getZombieSignOfferCln(t *testing.T, node, tempDir, peerIdentity,
 
 	cmdOutput := invokeCmdZombieRecoverySignOffer(
 		t, nil, tempDir, "--remote_peer", peerIdentity, "--psbt", psbt,
+		"--require_envelope=false",
 		"--hsm_secret", readHsmSecret(t, node),
 	)
 	txHex := extractRowContent(cmdOutput, rowPublish)
@@ -17,6 +17,7 @@ import (
 	"github.com/btcsuite/btcwallet/waddrmgr"
 	"github.com/btcsuite/btcwallet/wallet"
 	"github.com/btcsuite/btcwallet/walletdb"
+	"github.com/lightninglabs/chantools/rawbolt"
 	"github.com/lightningnetwork/lnd/aezeed"
 	"github.com/lightningnetwork/lnd/lncfg"
 	"github.com/lightningnetwork/lnd/lnwallet"
@@ -25,9 +26,10 @@ import (
 )
 
 const (
-	MnemonicEnvName   = "AEZEED_MNEMONIC"
-	PassphraseEnvName = "AEZEED_PASSPHRASE"
-	PasswordEnvName   = "WALLET_PASSWORD"
+	MnemonicEnvName      = "AEZEED_MNEMONIC"
+	PassphraseEnvName    = "AEZEED_PASSPHRASE"
+	NewPassphraseEnvName = "AEZEED_NEW_PASSPHRASE"
+	PasswordEnvName      = "WALLET_PASSWORD"
 )
 
 var (
@@ -57,6 +59,45 @@ func noConsole() ([]byte, error) {
 func ReadAezeed(params *chaincfg.Params) (*hdkeychain.ExtendedKey, time.Time,
 	error) {
 
+	cipherSeedMnemonic, err := ReadMnemonicWords()
+	if err != nil {
+		return nil, time.Unix(0, 0), err
+	}
+
+	fmt.Println()
+
+	if len(cipherSeedMnemonic) != 24 {
+		return nil, time.Unix(0, 0), fmt.Errorf("wrong cipher seed "+
+			"mnemonic length: got %v words, expecting %v words",
+			len(cipherSeedMnemonic), 24)
+	}
+
+	passphraseBytes, err := ReadPassphrase("doesn't have")
+	if err != nil {
+		return nil, time.Unix(0, 0), err
+	}
+
+	var mnemonic aezeed.Mnemonic
+	copy(mnemonic[:], cipherSeedMnemonic)
+
+	// If we're unable to map it back into the ciphertext, then either the
+	// mnemonic is wrong, or the passphrase is wrong.
+	cipherSeed, err := mnemonic.ToCipherSeed(passphraseBytes)
+	if err != nil {
+		return nil, time.Unix(0, 0), fmt.Errorf("failed to decrypt "+
+			"seed with passphrase: %w", err)
+	}
+	rootKey, err := hdkeychain.NewMaster(cipherSeed.Entropy[:], params)
+	if err != nil {
+		return nil, time.Unix(0, 0), errors.New("failed to derive " +
+			"master extended key")
+	}
+	return rootKey, cipherSeed.BirthdayTime(), nil
+}
+
+// ReadMnemonicWords reads the words of a mnemonic from the console or the
+// environment variable and cleans them up, without checking them in any way.
+func ReadMnemonicWords() ([]string, error) {
 	// To automate things with chantools, we also offer reading the seed
 	// from environment variables.
 	mnemonicStr := strings.TrimSpace(os.Getenv(MnemonicEnvName))
@@ -70,7 +111,7 @@ func ReadAezeed(params *chaincfg.Params) (*hdkeychain.ExtendedKey, time.Time,
 		reader := bufio.NewReader(os.Stdin)
 		mnemonicStr, err = reader.ReadString('\n')
 		if err != nil {
-			return nil, time.Unix(0, 0), err
+			return nil, err
 		}
 	}
 
@@ -86,37 +127,71 @@ func ReadAezeed(params *chaincfg.Params) (*hdkeychain.ExtendedKey, time.Time,
 	mnemonicStr = multipleSpaces.ReplaceAllString(mnemonicStr, " ")
 	mnemonicStr = strings.TrimSpace(mnemonicStr)
 
-	cipherSeedMnemonic := strings.Split(mnemonicStr, " ")
-
-	fmt.Println()
+	return strings.Split(mnemonicStr, " "), nil
+}
 
-	if len(cipherSeedMnemonic) != 24 {
-		return nil, time.Unix(0, 0), fmt.Errorf("wrong cipher seed "+
-			"mnemonic length: got %v words, expecting %v words",
-			len(cipherSeedMnemonic), 24)
+// MnemonicToCipherText converts an aezeed mnemonic into the enciphered seed it
+// encodes.
+func MnemonicToCipherText(mnemonic *aezeed.Mnemonic) (
+	[aezeed.EncipheredCipherSeedSize]byte, error) {
+
+	var wordIndexes [aezeed.NumMnemonicWords]uint16
+	for idx, word := range mnemonic {
+		wordIndex, ok := aezeed.ReverseWordMap[word]
+		if !ok {
+			return [aezeed.EncipheredCipherSeedSize]byte{},
+				aezeed.ErrUnknownMnemonicWord{
+					Word:  word,
+					Index: uint8(idx),
+				}
+		}
+		wordIndexes[idx] = uint16(wordIndex)
 	}
 
-	passphraseBytes, err := ReadPassphrase("doesn't have")
-	if err != nil {
-		return nil, time.Unix(0, 0), err
-	}
+	return WordIndexesToCipherText(wordIndexes), nil
+}
 
-	var mnemonic aezeed.Mnemonic
-	copy(mnemonic[:], cipherSeedMnemonic)
+// WordIndexesToCipherText packs the word list indexes of an aezeed mnemonic
+// (11 bits each) into the enciphered seed they encode.
+func WordIndexesToCipherText(wordIndexes [aezeed.NumMnemonicWords]uint16) (
+	cipherText [aezeed.EncipheredCipherSeedSize]byte) {
 
-	// If we're unable to map it back into the ciphertext, then either the
-	// mnemonic is wrong, or the passphrase is wrong.
-	cipherSeed, err := mnemonic.ToCipherSeed(passphraseBytes)
-	if err != nil {
-		return nil, time.Unix(0, 0), fmt.Errorf("failed to decrypt "+
-			"seed with passphrase: %w", err)
+	var (
+		acc     uint32
+		accBits uint
+		pos     int
+	)
+	for _, wordIndex := range wordIndexes {
+		acc = acc<<aezeed.BitsPerWord | uint32(wordIndex)
+		accBits += aezeed.BitsPerWord
+		for accBits >= 8 {
+			accBits -= 8
+			cipherText[pos] = byte(acc >> accBits)
+			pos++
+		}
 	}
-	rootKey, err := hdkeychain.NewMaster(cipherSeed.Entropy[:], params)
-	if err != nil {
-		return nil, time.Unix(0, 0), errors.New("failed to derive " +
-			"master extended key")
+
+	return cipherText
+}
+
+// CipherTextToMnemonic converts an enciphered seed into its aezeed mnemonic.
+func CipherTextToMnemonic(
+	cipherText [aezeed.EncipheredCipherSeedSize]byte) aezeed.Mnemonic {
+
+	var mnemonic aezeed.Mnemonic
+	for idx := range mnemonic {
+		var wordIndex int
+		for bit := range aezeed.BitsPerWord {
+			pos := idx*aezeed.BitsPerWord + bit
+			wordIndex <<= 1
+			if cipherText[pos/8]&(0x80>>(pos%8)) != 0 {
+				wordIndex |= 1
+			}
+		}
+		mnemonic[idx] = aezeed.DefaultWordList[wordIndex]
 	}
-	return rootKey, cipherSeed.BirthdayTime(), nil
+
+	return mnemonic
 }
 
 // ReadPassphrase reads a cipher seed passphrase from the console or the
@@ -184,12 +259,10 @@ func PasswordFromConsole(userQuery string) ([]byte, error) {
 	return bytes.TrimSpace(pw), nil
 }
 
-// OpenWallet opens a lnd compatible wallet and returns it, along with the
-// private wallet password.
-func OpenWallet(walletDbPath string,
-	chainParams *chaincfg.Params) (*wallet.Wallet, []byte, func() error,
-	error) {
-
+// ReadWalletPassword reads the wallet password from the environment or the
+// terminal and returns the public and private passphrase to unlock the wallet
+// with.
+func ReadWalletPassword() ([]byte, []byte, error) {
 	var (
 		publicWalletPw  = lnwallet.DefaultPublicPassphrase
 		privateWalletPw = lnwallet.DefaultPrivatePassphrase
@@ -214,7 +287,7 @@ func OpenWallet(walletDbPath string,
 	case len(pw) == 0:
 		pw, err = PasswordFromConsole("Input wallet password: ")
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, nil, err
 		}
 		if len(pw) > 0 {
 			publicWalletPw = pw
@@ -227,19 +300,24 @@ func OpenWallet(walletDbPath string,
 		privateWalletPw = pw
 	}
 
-	// Try to load and open the wallet.
-	db, err := walletdb.Open(
-		"bdb", lncfg.CleanAndExpandPath(walletDbPath), false,
-		DefaultOpenTimeout, false,
-	)
-	if errors.Is(err, bbolt.ErrTimeout) {
-		return nil, nil, nil, errors.New("error opening wallet " +
-			"database, make sure lnd is not running and holding " +
-			"the exclusive lock on the wallet")
+	return publicWalletPw, privateWalletPw, nil
+}
+
+// OpenWallet opens a lnd compatible wallet and returns it, along with the
+// private wallet password.
+func OpenWallet(walletDbPath string,
+	chainParams *chaincfg.Params) (*wallet.Wallet, []byte, func() error,
+	error) {
+
+	publicWalletPw, privateWalletPw, err := ReadWalletPassword()
+	if err != nil {
+		return nil, nil, nil, err
 	}
+
+	// Try to load and open the wallet.
+	db, err := OpenWalletDB(walletDbPath, false)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("error opening wallet "+
-			"database: %w", err)
+		return nil, nil, nil, err
 	}
 
 	w, err := wallet.Open(db, publicWalletPw, openCallbacks, chainParams, 0)
@@ -269,15 +347,41 @@ func OpenWallet(walletDbPath string,
 	return w, privateWalletPw, cleanup, nil
 }
 
-// DecryptWalletRootKey decrypts a lnd compatible wallet's root key.
-func DecryptWalletRootKey(db walletdb.DB,
-	privatePassphrase []byte) ([]byte, error) {
+// OpenWalletDB opens the database of a lnd compatible wallet without
+// unlocking the wallet.
+func OpenWalletDB(walletDbPath string, readOnly bool) (walletdb.DB, error) {
+	db, err := walletdb.Open(
+		"bdb", lncfg.CleanAndExpandPath(walletDbPath), false,
+		DefaultOpenTimeout, readOnly,
+	)
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, errors.New("error opening wallet database, make " +
+			"sure lnd is not running and holding the exclusive " +
+			"lock on the wallet")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening wallet database: %w", err)
+	}
+
+	return db, nil
+}
+
+// EncryptedWalletRootKey is the encrypted root key of a lnd compatible wallet
+// together with the parameters for deriving the decryption key from the
+// private passphrase.
+type EncryptedWalletRootKey struct {
+	masterKeyPrivParams []byte
+	cryptoKeyPrivEnc    []byte
+	masterHDPrivEnc     []byte
+}
+
+// ReadEncryptedWalletRootKey reads the encrypted root key of a lnd compatible
+// wallet.
+func ReadEncryptedWalletRootKey(db walletdb.DB) (*EncryptedWalletRootKey,
+	error) {
 
-	// Step 1: Load the encryption parameters and encrypted keys from the
-	// database.
-	var masterKeyPrivParams []byte
-	var cryptoKeyPrivEnc []byte
-	var masterHDPrivEnc []byte
+	// Load the encryption parameters and encrypted keys from the database.
+	key := &EncryptedWalletRootKey{}
 	err := walletdb.View(db, func(tx walletdb.ReadTx) error {
 		ns := tx.ReadBucket(WaddrmgrNamespaceKey)
 		if ns == nil {
@@ -293,18 +397,18 @@ func DecryptWalletRootKey(db walletdb.DB,
 
 		val := mainBucket.Get(masterPrivKeyName)
 		if val != nil {
-			masterKeyPrivParams = make([]byte, len(val))
-			copy(masterKeyPrivParams, val)
+			key.masterKeyPrivParams = make([]byte, len(val))
+			copy(key.masterKeyPrivParams, val)
 		}
 		val = mainBucket.Get(cryptoPrivKeyName)
 		if val != nil {
-			cryptoKeyPrivEnc = make([]byte, len(val))
-			copy(cryptoKeyPrivEnc, val)
+			key.cryptoKeyPrivEnc = make([]byte, len(val))
+			copy(key.cryptoKeyPrivEnc, val)
 		}
 		val = mainBucket.Get(masterHDPrivName)
 		if val != nil {
-			masterHDPrivEnc = make([]byte, len(val))
-			copy(masterHDPrivEnc, val)
+			key.masterHDPrivEnc = make([]byte, len(val))
+			copy(key.masterHDPrivEnc, val)
 		}
 
 		return nil
@@ -313,22 +417,149 @@ func DecryptWalletRootKey(db walletdb.DB,
 		return nil, err
 	}
 
-	// Step 2: Unmarshal the master private key parameters and derive
-	// key from passphrase.
+	return key, nil
+}
+
+// MasterKeyParams returns the serialized parameters of the key derived from
+// the private passphrase.
+func (k *EncryptedWalletRootKey) MasterKeyParams() []byte {
+	return k.masterKeyPrivParams
+}
+
+// Decrypt decrypts the wallet's root key with the private passphrase. If the
+// passphrase is wrong, snacl.ErrInvalidPassword is returned.
+func (k *EncryptedWalletRootKey) Decrypt(privatePassphrase []byte) ([]byte,
+	error) {
+
+	// Unmarshal the master private key parameters and derive key from
+	// passphrase.
 	var masterKeyPriv snacl.SecretKey
-	if err := masterKeyPriv.Unmarshal(masterKeyPrivParams); err != nil {
+	if err := masterKeyPriv.Unmarshal(k.masterKeyPrivParams); err != nil {
 		return nil, err
 	}
 	if err := masterKeyPriv.DeriveKey(&privatePassphrase); err != nil {
 		return nil, err
 	}
 
-	// Step 3: Decrypt the keys in the correct order.
+	// Decrypt the keys in the correct order.
 	cryptoKeyPriv := &snacl.CryptoKey{}
-	cryptoKeyPrivBytes, err := masterKeyPriv.Decrypt(cryptoKeyPrivEnc)
+	cryptoKeyPrivBytes, err := masterKeyPriv.Decrypt(k.cryptoKeyPrivEnc)
 	if err != nil {
 		return nil, err
 	}
 	copy(cryptoKeyPriv[:], cryptoKeyPrivBytes)
-	return cryptoKeyPriv.Decrypt(masterHDPrivEnc)
+	return cryptoKeyPriv.Decrypt(k.masterHDPrivEnc)
+}
+
+// DecryptWalletRootKey decrypts a lnd compatible wallet's root key.
+func DecryptWalletRootKey(db walletdb.DB,
+	privatePassphrase []byte) ([]byte, error) {
+
+	key, err := ReadEncryptedWalletRootKey(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Decrypt(privatePassphrase)
+}
+
+// SalvageEncryptedWalletRootKeys scans the raw pages of a possibly corrupted
+// wallet.db file for the records needed to decrypt the wallet's root key,
+// without opening the database. Because old copies of the records can remain
+// in freed pages, all combinations of the records found are returned.
+func SalvageEncryptedWalletRootKeys(walletDbPath string) (
+	[]*EncryptedWalletRootKey, error) {
+
+	file, err := rawbolt.Open(lncfg.CleanAndExpandPath(walletDbPath))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var masterKeyPrivParams, cryptoKeysPrivEnc, masterHDPrivEnc [][]byte
+	addUnique := func(values [][]byte, value []byte) [][]byte {
+		for _, existing := range values {
+			if bytes.Equal(existing, value) {
+				return values
+			}
+		}
+
+		return append(values, bytes.Clone(value))
+	}
+	file.ScanLeaves(func(elem rawbolt.Element) {
+		switch {
+		case elem.Bucket:
+
+		case bytes.Equal(elem.Key, masterPrivKeyName):
+			masterKeyPrivParams = addUnique(
+				masterKeyPrivParams, elem.Value,
+			)
+
+		case bytes.Equal(elem.Key, cryptoPrivKeyName):
+			cryptoKeysPrivEnc = addUnique(
+				cryptoKeysPrivEnc, elem.Value,
+			)
+
+		case bytes.Equal(elem.Key, masterHDPrivName):
+			masterHDPrivEnc = addUnique(masterHDPrivEnc, elem.Value)
+		}
+	})
+
+	switch {
+	case len(masterKeyPrivParams) == 0:
+		return nil, fmt.Errorf("no '%s' record found",
+			masterPrivKeyName)
+
+	case len(cryptoKeysPrivEnc) == 0:
+		return nil, fmt.Errorf("no '%s' record found",
+			cryptoPrivKeyName)
+
+	case len(masterHDPrivEnc) == 0:
+		return nil, fmt.Errorf("no '%s' record found",
+			masterHDPrivName)
+	}
+
+	var keys []*EncryptedWalletRootKey
+	for _, params := range masterKeyPrivParams {
+		for _, cryptoKey := range cryptoKeysPrivEnc {
+			for _, hdKey := range masterHDPrivEnc {
+				keys = append(keys, &EncryptedWalletRootKey{
+					masterKeyPrivParams: params,
+					cryptoKeyPrivEnc:    cryptoKey,
+					masterHDPrivEnc:     hdKey,
+				})
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// SalvageWalletRootKey decrypts the root key of a possibly corrupted wallet.db
+// file that can't be opened anymore. See SalvageEncryptedWalletRootKeys.
+func SalvageWalletRootKey(walletDbPath string,
+	privatePassphrase []byte) ([]byte, error) {
+
+	keys, err := SalvageEncryptedWalletRootKeys(walletDbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Any combination that decrypts is correct, the encryption is
+	// authenticated. We prefer reporting a wrong password over other
+	// errors since that's what the user can fix.
+	var lastErr error
+	for _, key := range keys {
+		rootKey, err := key.Decrypt(privatePassphrase)
+		if err == nil {
+			return rootKey, nil
+		}
+
+		if lastErr == nil || errors.Is(err, snacl.ErrInvalidPassword) {
+			lastErr = err
+		}
+	}
+
+	return nil, fmt.Errorf("unable to decrypt any of %d salvaged root "+
+		"key candidates: %w", len(keys), lastErr)
 }
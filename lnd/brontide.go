@@ -1,359 +1,231 @@
 package lnd
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"math/rand"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
-	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcd/connmgr"
-	"github.com/btcsuite/btcd/wire"
-	"github.com/lightningnetwork/lnd/aliasmgr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/lightningnetwork/lnd/brontide"
-	"github.com/lightningnetwork/lnd/chainntnfs"
-	"github.com/lightningnetwork/lnd/channeldb"
-	"github.com/lightningnetwork/lnd/channelnotifier"
-	"github.com/lightningnetwork/lnd/discovery"
 	"github.com/lightningnetwork/lnd/feature"
-	"github.com/lightningnetwork/lnd/fn/v2"
-	graphdb "github.com/lightningnetwork/lnd/graph/db"
-	"github.com/lightningnetwork/lnd/graph/db/models"
-	"github.com/lightningnetwork/lnd/htlcswitch"
-	"github.com/lightningnetwork/lnd/htlcswitch/hodl"
-	"github.com/lightningnetwork/lnd/keychain"
-	"github.com/lightningnetwork/lnd/kvdb"
-	"github.com/lightningnetwork/lnd/lncfg"
-	"github.com/lightningnetwork/lnd/lnpeer"
-	"github.com/lightningnetwork/lnd/lntest/mock"
-	"github.com/lightningnetwork/lnd/lnwallet"
-	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
-	"github.com/lightningnetwork/lnd/lnwallet/chancloser"
 	"github.com/lightningnetwork/lnd/lnwire"
-	"github.com/lightningnetwork/lnd/msgmux"
-	"github.com/lightningnetwork/lnd/netann"
-	"github.com/lightningnetwork/lnd/peer"
-	"github.com/lightningnetwork/lnd/pool"
-	"github.com/lightningnetwork/lnd/queue"
-	"github.com/lightningnetwork/lnd/routing/route"
-	"github.com/lightningnetwork/lnd/ticker"
+	"github.com/lightningnetwork/lnd/tlv"
 )
 
-const (
-	defaultChannelCommitBatchSize = 10
-	defaultCoopCloseTargetConfs   = 6
-)
-
-var (
-	chanEnableTimeout            = 19 * time.Minute
-	defaultChannelCommitInterval = 50 * time.Millisecond
-	defaultPendingCommitInterval = 1 * time.Minute
-)
+// initNetworksType is the TLV type of the networks field of the init message
+// as defined in BOLT#1.
+const initNetworksType tlv.Type = 1
 
-func ConnectPeer(conn *brontide.Conn, connReq *connmgr.ConnReq,
-	netParams *chaincfg.Params,
-	identityECDH keychain.SingleKeyECDH) (*peer.Brontide, *channeldb.DB,
+// DefaultInitFeatures returns the legacy global and the local feature vectors
+// lnd announces in its init message with a default configuration.
+func DefaultInitFeatures() (*lnwire.RawFeatureVector, *lnwire.RawFeatureVector,
 	error) {
 
 	featureMgr, err := feature.NewManager(feature.Config{})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("error creating feature manager: "+
+			"%w", err)
 	}
 
-	initFeatures := featureMgr.Get(feature.SetInit)
-	legacyFeatures := featureMgr.Get(feature.SetLegacyGlobal)
+	return featureMgr.GetRaw(feature.SetLegacyGlobal),
+		featureMgr.GetRaw(feature.SetInit), nil
+}
+
+// Session is a minimal Lightning p2p session on top of an established brontide
+// connection. It takes care of the init handshake and of answering pings, so
+// the caller can exchange arbitrary wire messages with the remote peer without
+// running a full lnd peer.
+type Session struct {
+	conn *brontide.Conn
+
+	writeMtx sync.Mutex
 
-	addr := conn.RemoteAddr()
-	pubKey := conn.RemotePub()
-	peerAddr := &lnwire.NetAddress{
-		IdentityKey: pubKey,
-		Address:     addr,
-		ChainNet:    netParams.Net,
+	remoteInit *lnwire.Init
+}
+
+// NewSession creates a new session on top of the given connection. Init must
+// be called before any other message is sent.
+func NewSession(conn *brontide.Conn) *Session {
+	return &Session{
+		conn: conn,
 	}
-	errBuffer, err := queue.NewCircularBuffer(500)
+}
+
+// Init sends our init message with the given feature vectors and waits for the
+// init message of the remote peer.
+func (s *Session) Init(globalFeatures,
+	localFeatures *lnwire.RawFeatureVector) error {
+
+	err := s.Send(lnwire.NewInitMessage(globalFeatures, localFeatures))
 	if err != nil {
-		return nil, nil, err
+		return fmt.Errorf("error sending init message: %w", err)
 	}
 
-	pongBuf := make([]byte, lnwire.MaxPongBytes)
-
-	writeBufferPool := pool.NewWriteBuffer(
-		pool.DefaultWriteBufferGCInterval,
-		pool.DefaultWriteBufferExpiryInterval,
-	)
-	writePool := pool.NewWrite(
-		writeBufferPool, lncfg.DefaultWriteWorkers,
-		pool.DefaultWorkerTimeout,
-	)
-
-	readBufferPool := pool.NewReadBuffer(
-		pool.DefaultReadBufferGCInterval,
-		pool.DefaultReadBufferExpiryInterval,
-	)
-	readPool := pool.NewRead(
-		readBufferPool, lncfg.DefaultWriteWorkers,
-		pool.DefaultWorkerTimeout,
-	)
-	commitFee := chainfee.SatPerKVByte(
-		lnwallet.DefaultAnchorsCommitMaxFeeRateSatPerVByte * 1000,
-	)
-
-	if err := writePool.Start(); err != nil {
-		return nil, nil, fmt.Errorf("unable to start write pool: %w",
-			err)
+	msg, err := s.Receive()
+	if err != nil {
+		return fmt.Errorf("error reading init message: %w", err)
 	}
-	if err := readPool.Start(); err != nil {
-		return nil, nil, fmt.Errorf("unable to start read pool: %w",
-			err)
+
+	switch m := msg.(type) {
+	case *lnwire.Init:
+		s.remoteInit = m
+
+		return nil
+
+	// Peers that don't want to talk to us usually tell us why.
+	case *lnwire.Error:
+		return fmt.Errorf("peer sent error instead of init: %v",
+			m.Error())
+
+	case *lnwire.Warning:
+		return fmt.Errorf("peer sent warning instead of init: %v",
+			m.Warning())
+
+	default:
+		return fmt.Errorf("expected init message, got %v",
+			msg.MsgType())
 	}
+}
 
-	randNum := rand.Int31()
-	backend, err := kvdb.GetBoltBackend(&kvdb.BoltBackendConfig{
-		DBPath:            os.TempDir(),
-		DBFileName:        fmt.Sprintf("channel-%d.db", randNum),
-		NoFreelistSync:    true,
-		AutoCompact:       false,
-		AutoCompactMinAge: kvdb.DefaultBoltAutoCompactMinAge,
-		DBTimeout:         kvdb.DefaultDBTimeout,
-	})
-	if err != nil {
-		return nil, nil, err
+// RemoteInit returns the init message of the remote peer or nil if the
+// handshake hasn't completed yet.
+func (s *Session) RemoteInit() *lnwire.Init {
+	return s.remoteInit
+}
+
+// RemoteFeatures returns the combined global and local features the remote
+// peer announced in its init message.
+func (s *Session) RemoteFeatures() (*lnwire.FeatureVector, error) {
+	if s.remoteInit == nil {
+		return nil, errors.New("init handshake not completed")
 	}
 
-	channelDB, err := channeldb.CreateWithBackend(backend)
-	if err != nil {
-		_ = backend.Close()
-		return nil, nil, err
+	features := s.remoteInit.Features.Clone()
+	if err := features.Merge(s.remoteInit.GlobalFeatures); err != nil {
+		return nil, fmt.Errorf("error merging remote features: %w",
+			err)
 	}
 
-	graphDB, err := graphdb.NewChannelGraph(&graphdb.Config{
-		KVDB: backend,
-	})
-	if err != nil {
-		_ = backend.Close()
-		_ = channelDB.Close()
+	return lnwire.NewFeatureVector(features, lnwire.Features), nil
+}
 
-		return nil, nil, fmt.Errorf("unable to open graph db: %w",
-			err)
+// RemoteChains returns the genesis hashes of the chains the remote peer is
+// interested in, as announced in the networks field of its init message. An
+// empty list means the peer didn't announce any networks.
+func (s *Session) RemoteChains() ([]chainhash.Hash, error) {
+	if s.remoteInit == nil {
+		return nil, errors.New("init handshake not completed")
 	}
 
-	gossiper := discovery.New(discovery.Config{
-		ChainHash: *netParams.GenesisHash,
-		Broadcast: func(_ map[route.Vertex]struct{},
-			_ ...lnwire.Message) error {
-
-			return nil
-		},
-		NotifyWhenOnline: func([33]byte, chan<- lnpeer.Peer) {
-		},
-		NotifyWhenOffline: func(_ [33]byte) <-chan struct{} {
-			return make(chan struct{})
-		},
-		FetchSelfAnnouncement: func() lnwire.NodeAnnouncement {
-			return lnwire.NodeAnnouncement{}
-		},
-		ProofMatureDelta:    0,
-		TrickleDelay:        time.Millisecond * 50,
-		RetransmitTicker:    ticker.New(time.Minute * 30),
-		RebroadcastInterval: time.Hour * 24,
-		RotateTicker: ticker.New(
-			discovery.DefaultSyncerRotationInterval,
-		),
-		HistoricalSyncTicker: ticker.New(
-			discovery.DefaultHistoricalSyncInterval,
-		),
-		NumActiveSyncers:        0,
-		MinimumBatchSize:        10,
-		SubBatchDelay:           discovery.DefaultSubBatchDelay,
-		IgnoreHistoricalFilters: true,
-		PinnedSyncers:           make(map[route.Vertex]struct{}),
-		MaxChannelUpdateBurst:   discovery.DefaultMaxChannelUpdateBurst,
-		ChannelUpdateInterval:   discovery.DefaultChannelUpdateInterval,
-		IsAlias:                 aliasmgr.IsAlias,
-		SignAliasUpdate: func(
-			*lnwire.ChannelUpdate1) (*ecdsa.Signature, error) {
-
-			return nil, errors.New("unimplemented")
-		},
-		FindBaseByAlias: func(
-			lnwire.ShortChannelID) (lnwire.ShortChannelID, error) {
-
-			return lnwire.ShortChannelID{},
-				errors.New("unimplemented")
-		},
-		GetAlias: func(_ lnwire.ChannelID) (lnwire.ShortChannelID,
-			error) {
-
-			return lnwire.ShortChannelID{},
-				errors.New("unimplemented")
-		},
-		FindChannel: func(*btcec.PublicKey,
-			lnwire.ChannelID) (*channeldb.OpenChannel, error) {
-
-			return nil, errors.New("unimplemented")
-		},
-	}, &keychain.KeyDescriptor{
-		KeyLocator: keychain.KeyLocator{},
-		PubKey:     identityECDH.PubKey(),
-	})
-
-	chanStatusMgr, err := netann.NewChanStatusManager(
-		&netann.ChanStatusConfig{
-			ChanStatusSampleInterval: 30 * time.Second,
-			// Enable + Sample Interval must be <= DisableTimeout.
-			ChanEnableTimeout:  30 * time.Second,
-			ChanDisableTimeout: 2 * time.Minute,
-			DB:                 channelDB.ChannelStateDB(),
-			Graph:              graphDB,
-			OurPubKey:          identityECDH.PubKey(),
-			IsChannelActive: func(lnwire.ChannelID) bool {
-				return true
-			},
-			ApplyChannelUpdate: func(*lnwire.ChannelUpdate1,
-				*wire.OutPoint, bool) error {
-
-				return nil
-			},
-		},
-	)
+	typeMap, err := s.remoteInit.ExtraData.ExtractRecords()
 	if err != nil {
-		_ = channelDB.Close()
-		return nil, nil, fmt.Errorf("unable to create channel status "+
-			"manager: %w", err)
+		return nil, fmt.Errorf("error parsing init TLV records: %w",
+			err)
 	}
 
-	channelNotifier := channelnotifier.New(channelDB.ChannelStateDB())
-	interceptableSwitchNotifier := &mock.ChainNotifier{
-		EpochChan: make(chan *chainntnfs.BlockEpoch, 1),
+	networks := typeMap[initNetworksType]
+	if len(networks)%chainhash.HashSize != 0 {
+		return nil, fmt.Errorf("invalid networks field length %d",
+			len(networks))
 	}
-	interceptableSwitchNotifier.EpochChan <- &chainntnfs.BlockEpoch{
-		Height: 1,
-	}
-	interceptableSwitch, err := htlcswitch.NewInterceptableSwitch(
-		&htlcswitch.InterceptableSwitchConfig{
-			CltvRejectDelta:    13,
-			CltvInterceptDelta: 16,
-			Notifier:           interceptableSwitchNotifier,
-		},
-	)
-	if err != nil {
-		_ = channelDB.Close()
-		return nil, nil, fmt.Errorf("unable to create interceptable "+
-			"switch: %w", err)
+
+	chains := make([]chainhash.Hash, 0, len(networks)/chainhash.HashSize)
+	for len(networks) > 0 {
+		var chain chainhash.Hash
+		copy(chain[:], networks[:chainhash.HashSize])
+		chains = append(chains, chain)
+
+		networks = networks[chainhash.HashSize:]
 	}
 
-	pCfg := peer.Config{
-		Conn:    conn,
-		ConnReq: connReq,
-		PubKeyBytes: [33]byte(
-			identityECDH.PubKey().SerializeCompressed(),
-		),
-		Addr:                    peerAddr,
-		Features:                initFeatures,
-		LegacyFeatures:          legacyFeatures,
-		OutgoingCltvRejectDelta: lncfg.DefaultOutgoingCltvRejectDelta,
-		ChanActiveTimeout:       chanEnableTimeout,
-		ErrorBuffer:             errBuffer,
-		WritePool:               writePool,
-		ReadPool:                readPool,
-		Switch:                  &mockMessageSwitch{},
-		InterceptSwitch:         interceptableSwitch,
-		ChannelDB:               channelDB.ChannelStateDB(),
-		ChainArb:                nil,
-		AuthGossiper:            gossiper,
-		ChanStatusMgr:           chanStatusMgr,
-		ChainIO:                 &mock.ChainIO{},
-		FeeEstimator:            nil,
-		Signer:                  nil,
-		SigPool:                 nil,
-		Wallet: &lnwallet.LightningWallet{
-			WalletController: &mock.WalletController{},
-		},
-		ChainNotifier: &mock.ChainNotifier{},
-		BestBlockView: chainntnfs.NewBestBlockTracker(
-			&mock.ChainNotifier{},
-		),
-		RoutingPolicy:   models.ForwardingPolicy{},
-		Sphinx:          nil,
-		WitnessBeacon:   nil,
-		Invoices:        nil,
-		ChannelNotifier: channelNotifier,
-		HtlcNotifier:    nil,
-		TowerClient:     nil,
-		DisconnectPeer: func(key *btcec.PublicKey) error {
-			fmt.Printf("Peer %x disconnected\n",
-				key.SerializeCompressed())
-			return nil
-		},
-		GenNodeAnnouncement: func(_ ...netann.NodeAnnModifier) (
-			lnwire.NodeAnnouncement, error) {
-
-			return lnwire.NodeAnnouncement{},
-				errors.New("unimplemented")
-		},
-		PrunePersistentPeerConnection: func(_ [33]byte) {},
-		FetchLastChanUpdate: func(_ lnwire.ShortChannelID) (
-			*lnwire.ChannelUpdate1, error) {
-
-			return nil, errors.New("unimplemented")
-		},
-		FundingManager:          nil,
-		Hodl:                    &hodl.Config{},
-		UnsafeReplay:            false,
-		MaxOutgoingCltvExpiry:   htlcswitch.DefaultMaxOutgoingCltvExpiry,
-		MaxChannelFeeAllocation: htlcswitch.DefaultMaxLinkFeeAllocation,
-		MaxAnchorsCommitFeeRate: commitFee.FeePerKWeight(),
-		CoopCloseTargetConfs:    defaultCoopCloseTargetConfs,
-		ServerPubKey:            [33]byte{},
-		ChannelCommitInterval:   defaultChannelCommitInterval,
-		PendingCommitInterval:   defaultPendingCommitInterval,
-		ChannelCommitBatchSize:  defaultChannelCommitBatchSize,
-		HandleCustomMessage: func(peer [33]byte,
-			msg *lnwire.Custom) error {
-
-			fmt.Printf("Received custom message from %x: %v\n",
-				peer[:], msg)
-			return nil
-		},
-		GetAliases: func(
-			_ lnwire.ShortChannelID) []lnwire.ShortChannelID {
-
-			return nil
-		},
-		RequestAlias: func() (lnwire.ShortChannelID, error) {
-			return lnwire.ShortChannelID{}, nil
-		},
-		AddLocalAlias: func(_, _ lnwire.ShortChannelID, _,
-			_ bool) error {
-
-			return nil
-		},
-		AuxLeafStore:              fn.None[lnwallet.AuxLeafStore](),
-		AuxSigner:                 fn.None[lnwallet.AuxSigner](),
-		AuxResolver:               fn.None[lnwallet.AuxContractResolver](),
-		AuxTrafficShaper:          fn.None[htlcswitch.AuxTrafficShaper](),
-		PongBuf:                   pongBuf,
-		DisallowRouteBlinding:     false,
-		DisallowQuiescence:        false,
-		MaxFeeExposure:            0,
-		MsgRouter:                 fn.None[msgmux.Router](),
-		AuxChanCloser:             fn.None[chancloser.AuxChanCloser](),
-		ShouldFwdExpEndorsement:   nil,
-		NoDisconnectOnPongFailure: false,
-		Quit:                      make(chan struct{}),
+	return chains, nil
+}
+
+// RemotePub returns the identity public key of the remote peer.
+func (s *Session) RemotePub() *btcec.PublicKey {
+	return s.conn.RemotePub()
+}
+
+// Send serializes the given message and writes it to the remote peer. It is
+// safe to call Send concurrently with Receive.
+func (s *Session) Send(msg lnwire.Message) error {
+	s.writeMtx.Lock()
+	defer s.writeMtx.Unlock()
+
+	var b bytes.Buffer
+	if _, err := lnwire.WriteMessage(&b, msg, 0); err != nil {
+		return fmt.Errorf("error encoding message: %w", err)
+	}
+	if err := s.conn.WriteMessage(b.Bytes()); err != nil {
+		return err
 	}
+	_, err := s.conn.Flush()
 
-	copy(pCfg.PubKeyBytes[:], peerAddr.IdentityKey.SerializeCompressed())
-	copy(pCfg.ServerPubKey[:], identityECDH.PubKey().SerializeCompressed())
+	return err
+}
 
-	p := peer.NewBrontide(pCfg)
-	if err := p.Start(); err != nil {
-		_ = channelDB.Close()
-		return nil, nil, err
+// Receive returns the next message from the remote peer. Pings are answered
+// automatically and unknown odd messages are skipped, as per BOLT#1. Only a
+// single goroutine must call Receive at a time.
+func (s *Session) Receive() (lnwire.Message, error) {
+	for {
+		rawMsg, err := s.conn.ReadNextMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := lnwire.ReadMessage(bytes.NewReader(rawMsg), 0)
+		var unknownType *lnwire.UnknownMessage
+		switch {
+		case errors.As(err, &unknownType):
+			msgType := binary.BigEndian.Uint16(rawMsg[:2])
+			if msgType%2 == 0 {
+				return nil, fmt.Errorf("received unknown "+
+					"even message type %d", msgType)
+			}
+
+			continue
+
+		// A ping that asks for a pong that can't be encoded must be
+		// ignored according to BOLT#1.
+		case errors.Is(err, lnwire.ErrMaxPongBytesExceeded):
+			continue
+
+		case err != nil:
+			return nil, fmt.Errorf("error decoding message: %w",
+				err)
+		}
+
+		ping, ok := msg.(*lnwire.Ping)
+		if !ok {
+			return msg, nil
+		}
+		if ping.NumPongBytes >= lnwire.MaxPongBytes {
+			continue
+		}
+
+		pong := lnwire.NewPong(make([]byte, ping.NumPongBytes))
+		if err := s.Send(pong); err != nil {
+			return nil, fmt.Errorf("error sending pong: %w", err)
+		}
 	}
+}
+
+// SetDeadline sets the read and write deadline of the underlying connection.
+func (s *Session) SetDeadline(t time.Time) error {
+	return s.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+func (s *Session) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
 
-	return p, channelDB, nil
+// Close closes the underlying connection.
+func (s *Session) Close() error {
+	return s.conn.Close()
 }
@@ -0,0 +1,206 @@
+package lnd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/brontide"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/lightningnetwork/lnd/tor"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSessions creates two sessions that are connected to each other.
+func newTestSessions(t *testing.T) (*Session, *Session) {
+	t.Helper()
+
+	alice, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	bob, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	acceptAll := func(*btcec.PublicKey) (bool, error) {
+		return true, nil
+	}
+	listener, err := brontide.NewListener(
+		&keychain.PrivKeyECDH{PrivKey: alice}, "127.0.0.1:0", acceptAll,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- conn
+	}()
+
+	bobConn, err := brontide.Dial(
+		&keychain.PrivKeyECDH{PrivKey: bob}, &lnwire.NetAddress{
+			IdentityKey: alice.PubKey(),
+			Address:     listener.Addr(),
+		}, 5*time.Second, (&tor.ClearNet{}).Dial,
+	)
+	require.NoError(t, err)
+
+	aliceConn := <-accepted
+	require.NotNil(t, aliceConn)
+
+	aliceSession := NewSession(aliceConn.(*brontide.Conn))
+	bobSession := NewSession(bobConn)
+	t.Cleanup(func() {
+		_ = aliceSession.Close()
+		_ = bobSession.Close()
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	require.NoError(t, aliceSession.SetDeadline(deadline))
+	require.NoError(t, bobSession.SetDeadline(deadline))
+
+	require.Equal(
+		t, alice.PubKey().SerializeCompressed(),
+		bobSession.RemotePub().SerializeCompressed(),
+	)
+
+	return aliceSession, bobSession
+}
+
+func TestSessionInit(t *testing.T) {
+	alice, bob := newTestSessions(t)
+
+	_, err := bob.RemoteFeatures()
+	require.ErrorContains(t, err, "not completed")
+	_, err = bob.RemoteChains()
+	require.ErrorContains(t, err, "not completed")
+
+	global, local, err := DefaultInitFeatures()
+	require.NoError(t, err)
+	require.True(t, local.IsSet(lnwire.DataLossProtectRequired))
+
+	aliceErr := make(chan error, 1)
+	go func() {
+		aliceErr <- alice.Init(global, local)
+	}()
+
+	// Bob announces his features in the legacy global vector and signals
+	// the networks he's interested in.
+	legacyGlobal := lnwire.NewRawFeatureVector(
+		lnwire.DataLossProtectRequired,
+	)
+	bobInit := lnwire.NewInitMessage(
+		legacyGlobal, lnwire.NewRawFeatureVector(),
+	)
+	networks := append(
+		chaincfg.MainNetParams.GenesisHash.CloneBytes(),
+		chaincfg.SigNetParams.GenesisHash.CloneBytes()...,
+	)
+	networksRecord := tlv.MakePrimitiveRecord(initNetworksType, &networks)
+	require.NoError(t, bobInit.ExtraData.PackRecords(&networksRecord))
+	require.NoError(t, bob.Send(bobInit))
+
+	msg, err := bob.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &lnwire.Init{}, msg)
+	require.NoError(t, <-aliceErr)
+
+	features, err := alice.RemoteFeatures()
+	require.NoError(t, err)
+	require.True(t, features.HasFeature(lnwire.DataLossProtectRequired))
+
+	// The remote init message itself must not be modified by merging the
+	// feature vectors.
+	require.Zero(t, alice.RemoteInit().Features.SerializeSize())
+
+	chains, err := alice.RemoteChains()
+	require.NoError(t, err)
+	require.Equal(t, []chainhash.Hash{
+		*chaincfg.MainNetParams.GenesisHash,
+		*chaincfg.SigNetParams.GenesisHash,
+	}, chains)
+}
+
+func TestSessionInitError(t *testing.T) {
+	alice, bob := newTestSessions(t)
+
+	aliceErr := make(chan error, 1)
+	go func() {
+		aliceErr <- alice.Init(
+			lnwire.NewRawFeatureVector(),
+			lnwire.NewRawFeatureVector(),
+		)
+	}()
+
+	require.NoError(t, bob.Send(&lnwire.Error{
+		Data: []byte("no thanks"),
+	}))
+	require.ErrorContains(
+		t, <-aliceErr, "peer sent error instead of init",
+	)
+}
+
+func TestSessionSendReceive(t *testing.T) {
+	alice, bob := newTestSessions(t)
+
+	// Pings are answered automatically while the receiving side waits for
+	// the next message.
+	aliceMsg := make(chan lnwire.Message, 1)
+	aliceErr := make(chan error, 1)
+	go func() {
+		msg, err := alice.Receive()
+		aliceErr <- err
+		aliceMsg <- msg
+	}()
+	require.NoError(t, bob.Send(lnwire.NewPing(16)))
+
+	msg, err := bob.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &lnwire.Pong{}, msg)
+	require.Len(t, msg.(*lnwire.Pong).PongBytes, 16)
+
+	// Pings that ask for too many pong bytes are ignored without ending
+	// the session.
+	require.NoError(t, bob.Send(lnwire.NewPing(lnwire.MaxPongBytes)))
+	require.NoError(t, bob.Send(lnwire.NewPing(lnwire.MaxPongBytes+1)))
+
+	// Unknown odd messages are skipped.
+	require.NoError(t, bob.conn.WriteMessage([]byte{0, 101}))
+	_, err = bob.conn.Flush()
+	require.NoError(t, err)
+
+	chanID := lnwire.ChannelID{1, 2, 3}
+	require.NoError(t, bob.Send(&lnwire.ChannelReestablish{
+		ChanID: chanID,
+	}))
+	require.NoError(t, <-aliceErr)
+	require.Equal(
+		t, chanID, (<-aliceMsg).(*lnwire.ChannelReestablish).ChanID,
+	)
+
+	// Alice didn't answer the ignored pings, so the next message Bob
+	// receives is the one Alice sends now.
+	require.NoError(t, alice.Send(&lnwire.ChannelReestablish{
+		ChanID: chanID,
+	}))
+	msg, err = bob.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &lnwire.ChannelReestablish{}, msg)
+
+	// Unknown even messages are an error.
+	require.NoError(t, bob.conn.WriteMessage([]byte{0, 100}))
+	_, err = bob.conn.Flush()
+	require.NoError(t, err)
+
+	_, err = alice.Receive()
+	require.ErrorContains(t, err, "unknown even message type 100")
+}
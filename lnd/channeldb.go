@@ -59,6 +59,22 @@ func OpenDB(dbPath string,
 	return channelDB, graphDB, nil
 }
 
+// OpenBackend opens any bbolt based lnd database file (for example channel.db,
+// wallet.db or sphinxreplay.db) without interpreting its content.
+func OpenBackend(dbPath string, readonly bool) (kvdb.Backend, error) {
+	backend, err := openDB(dbPath, false, readonly, DefaultOpenTimeout)
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("error opening %s: make sure lnd "+
+			"is not running, database is locked by another process",
+			dbPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
 // convertErr converts some bolt errors to the equivalent walletdb error.
 func convertErr(err error) error {
 	switch {
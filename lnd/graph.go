@@ -1,8 +1,11 @@
 package lnd
 
 import (
+	"encoding/hex"
 	"fmt"
 
+	graphdb "github.com/lightningnetwork/lnd/graph/db"
+	"github.com/lightningnetwork/lnd/graph/db/models"
 	"github.com/lightningnetwork/lnd/lnrpc"
 )
 
@@ -51,3 +54,30 @@ func FindNode(graph *lnrpc.ChannelGraph,
 
 	return nil, fmt.Errorf("node %s not found in graph", nodePubKey)
 }
+
+// ChannelGraphFromDB converts all channel edges of the given graph database
+// into the same format the 'lncli describegraph' command returns. Only the
+// edges are converted, node announcements and policies are not included.
+func ChannelGraphFromDB(graphDB *graphdb.ChannelGraph) (*lnrpc.ChannelGraph,
+	error) {
+
+	graph := &lnrpc.ChannelGraph{}
+	err := graphDB.ForEachChannel(func(info *models.ChannelEdgeInfo,
+		_, _ *models.ChannelEdgePolicy) error {
+
+		graph.Edges = append(graph.Edges, &lnrpc.ChannelEdge{
+			ChannelId: info.ChannelID,
+			ChanPoint: info.ChannelPoint.String(),
+			Node1Pub:  hex.EncodeToString(info.NodeKey1Bytes[:]),
+			Node2Pub:  hex.EncodeToString(info.NodeKey2Bytes[:]),
+			Capacity:  int64(info.Capacity),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading channel graph: %w", err)
+	}
+
+	return graph, nil
+}
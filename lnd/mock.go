@@ -1,40 +0,0 @@
-package lnd
-
-import (
-	"github.com/lightningnetwork/lnd/htlcswitch"
-	"github.com/lightningnetwork/lnd/lnwallet"
-	"github.com/lightningnetwork/lnd/lnwire"
-)
-
-// mockMessageSwitch is a mock implementation of the messageSwitch interface
-// used for testing without relying on a *htlcswitch.Switch in unit tests.
-type mockMessageSwitch struct {
-	links []htlcswitch.ChannelUpdateHandler
-}
-
-// BestHeight currently returns a dummy value.
-func (m *mockMessageSwitch) BestHeight() uint32 {
-	return 0
-}
-
-// CircuitModifier currently returns a dummy value.
-func (m *mockMessageSwitch) CircuitModifier() htlcswitch.CircuitModifier {
-	return nil
-}
-
-// RemoveLink currently does nothing.
-func (m *mockMessageSwitch) RemoveLink(lnwire.ChannelID) {}
-
-// CreateAndAddLink currently returns a dummy value.
-func (m *mockMessageSwitch) CreateAndAddLink(htlcswitch.ChannelLinkConfig,
-	*lnwallet.LightningChannel) error {
-
-	return nil
-}
-
-// GetLinksByInterface returns the active links.
-func (m *mockMessageSwitch) GetLinksByInterface([33]byte) (
-	[]htlcswitch.ChannelUpdateHandler, error) {
-
-	return m.links, nil
-}
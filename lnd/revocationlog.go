@@ -0,0 +1,275 @@
+package lnd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/tlv"
+)
+
+var (
+	// The names of the buckets that hold the state of an open channel. See
+	// lnd's channeldb package.
+	openChannelBucket             = []byte("open-chan-bucket")
+	revocationLogBucket           = []byte("revocation-log")
+	revocationLogBucketDeprecated = []byte("revocation-log-key")
+)
+
+// RevocationLogEntryFunc is called for every revoked remote commitment in the
+// revocation log of a channel. Entries stored in the deprecated format by old
+// versions of lnd are passed as the full commitment with a nil revocation log.
+type RevocationLogEntryFunc func(height uint64,
+	revLog *channeldb.RevocationLog,
+	commit *channeldb.ChannelCommitment) error
+
+// ForEachRevocationLogEntry calls fn for the revoked remote commitments in the
+// revocation log of an open channel, in ascending order of their commit
+// heights. All entries are read in a single cursor pass within one read
+// transaction. If maxEntries isn't zero, only that many of the most recent
+// entries are read.
+func ForEachRevocationLogEntry(channel *channeldb.OpenChannel, maxEntries int,
+	fn RevocationLogEntryFunc) error {
+
+	var chanPointBuf bytes.Buffer
+	_, err := chanPointBuf.Write(channel.FundingOutpoint.Hash[:])
+	if err != nil {
+		return err
+	}
+	err = binary.Write(
+		&chanPointBuf, binary.BigEndian, channel.FundingOutpoint.Index,
+	)
+	if err != nil {
+		return err
+	}
+
+	path := [][]byte{
+		openChannelBucket, channel.IdentityPub.SerializeCompressed(),
+		channel.ChainHash[:], chanPointBuf.Bytes(),
+	}
+
+	db := channel.Db.GetParentDB()
+	return kvdb.View(db, func(tx kvdb.RTx) error {
+		chanBucket := tx.ReadBucket(path[0])
+		for _, name := range path[1:] {
+			if chanBucket == nil {
+				break
+			}
+			chanBucket = chanBucket.NestedReadBucket(name)
+		}
+		if chanBucket == nil {
+			return channeldb.ErrChannelNotFound
+		}
+
+		// Entries in the deprecated bucket have lower heights than the
+		// ones in the new bucket.
+		logBuckets := []kvdb.RBucket{
+			chanBucket.NestedReadBucket(
+				revocationLogBucketDeprecated,
+			),
+			chanBucket.NestedReadBucket(revocationLogBucket),
+		}
+
+		startBucket, startKey := 0, []byte(nil)
+		if maxEntries > 0 {
+			startBucket, startKey = revocationLogStart(
+				logBuckets, maxEntries,
+			)
+		}
+
+		for idx := startBucket; idx < len(logBuckets); idx++ {
+			if logBuckets[idx] == nil {
+				continue
+			}
+
+			cursor := logBuckets[idx].ReadCursor()
+			k, v := cursor.First()
+			if idx == startBucket && startKey != nil {
+				k, v = cursor.Seek(startKey)
+			}
+			for ; k != nil; k, v = cursor.Next() {
+				err := decodeRevocationLogEntry(
+					idx == 0, k, v, fn,
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}, func() {})
+}
+
+// revocationLogStart returns the index of the bucket and the key of the entry
+// at which to start reading to get the given number of most recent entries. A
+// nil key means all entries are read.
+func revocationLogStart(logBuckets []kvdb.RBucket,
+	maxEntries int) (int, []byte) {
+
+	remaining := maxEntries
+	for idx := len(logBuckets) - 1; idx >= 0; idx-- {
+		if logBuckets[idx] == nil {
+			continue
+		}
+
+		cursor := logBuckets[idx].ReadCursor()
+		for k, _ := cursor.Last(); k != nil; k, _ = cursor.Prev() {
+			remaining--
+			if remaining == 0 {
+				return idx, bytes.Clone(k)
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// decodeRevocationLogEntry decodes a single entry of the new or the deprecated
+// revocation log bucket and passes it to fn.
+func decodeRevocationLogEntry(deprecated bool, k, v []byte,
+	fn RevocationLogEntryFunc) error {
+
+	if len(k) != 8 || v == nil {
+		return fmt.Errorf("invalid revocation log key %x", k)
+	}
+	height := binary.BigEndian.Uint64(k)
+
+	if deprecated {
+		commit, err := decodeLegacyRevocationLog(bytes.NewReader(v))
+		if err != nil {
+			return fmt.Errorf("error decoding revocation log "+
+				"entry %d: %w", height, err)
+		}
+
+		return fn(height, nil, commit)
+	}
+
+	revLog, err := decodeRevocationLog(bytes.NewReader(v))
+	if err != nil {
+		return fmt.Errorf("error decoding revocation log entry %d: %w",
+			height, err)
+	}
+
+	return fn(height, revLog, nil)
+}
+
+// decodeRevocationLog decodes a revocation log entry in the TLV format. See
+// deserializeRevocationLog in lnd's channeldb package.
+func decodeRevocationLog(r io.Reader) (*channeldb.RevocationLog, error) {
+	var revLog channeldb.RevocationLog
+
+	ourBalance := revLog.OurBalance.Zero()
+	theirBalance := revLog.TheirBalance.Zero()
+	customBlob := revLog.CustomBlob.Zero()
+
+	stream, err := tlv.NewStream(
+		revLog.OurOutputIndex.Record(),
+		revLog.TheirOutputIndex.Record(),
+		revLog.CommitTxHash.Record(),
+		ourBalance.Record(),
+		theirBalance.Record(),
+		customBlob.Record(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedTypes, err := readTLVStream(r, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := parsedTypes[ourBalance.TlvType()]; ok && t == nil {
+		revLog.OurBalance = tlv.SomeRecordT(ourBalance)
+	}
+	if t, ok := parsedTypes[theirBalance.TlvType()]; ok && t == nil {
+		revLog.TheirBalance = tlv.SomeRecordT(theirBalance)
+	}
+	if t, ok := parsedTypes[customBlob.TlvType()]; ok && t == nil {
+		revLog.CustomBlob = tlv.SomeRecordT(customBlob)
+	}
+
+	// The HTLC entries follow until the end of the value.
+	for {
+		var htlc channeldb.HTLCEntry
+
+		customBlob := htlc.CustomBlob.Zero()
+		htlcIndex := htlc.HtlcIndex.Zero()
+
+		stream, err := tlv.NewStream(
+			htlc.RHash.Record(),
+			htlc.RefundTimeout.Record(),
+			htlc.OutputIndex.Record(),
+			htlc.Incoming.Record(),
+			htlc.Amt.Record(),
+			customBlob.Record(),
+			htlcIndex.Record(),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedTypes, err := readTLVStream(r, stream)
+		switch {
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return &revLog, nil
+
+		case err != nil:
+			return nil, err
+		}
+
+		if t, ok := parsedTypes[customBlob.TlvType()]; ok && t == nil {
+			htlc.CustomBlob = tlv.SomeRecordT(customBlob)
+		}
+		if t, ok := parsedTypes[htlcIndex.TlvType()]; ok && t == nil {
+			htlc.HtlcIndex = tlv.SomeRecordT(htlcIndex)
+		}
+
+		revLog.HTLCEntries = append(revLog.HTLCEntries, &htlc)
+	}
+}
+
+// readTLVStream decodes a TLV stream that is prefixed with its length. An
+// empty reader results in io.ErrUnexpectedEOF.
+func readTLVStream(r io.Reader, stream *tlv.Stream) (tlv.TypeMap, error) {
+	length, err := tlv.ReadVarInt(r, &[8]byte{})
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil, io.ErrUnexpectedEOF
+
+	case err != nil:
+		return nil, err
+	}
+
+	return stream.DecodeWithParsedTypes(io.LimitReader(r, int64(length)))
+}
+
+// decodeLegacyRevocationLog decodes a full commitment stored in the deprecated
+// revocation log bucket. See deserializeChanCommit in lnd's channeldb package.
+func decodeLegacyRevocationLog(r io.Reader) (*channeldb.ChannelCommitment,
+	error) {
+
+	var commit channeldb.ChannelCommitment
+	err := channeldb.ReadElements(
+		r, &commit.CommitHeight, &commit.LocalLogIndex,
+		&commit.LocalHtlcIndex, &commit.RemoteLogIndex,
+		&commit.RemoteHtlcIndex, &commit.LocalBalance,
+		&commit.RemoteBalance, &commit.CommitFee, &commit.FeePerKw,
+		&commit.CommitTx, &commit.CommitSig,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	commit.Htlcs, err = channeldb.DeserializeHtlcs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &commit, nil
+}
@@ -0,0 +1,77 @@
+package lnd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeLegacyRevocationLog(t *testing.T) {
+	commitTx := wire.NewMsgTx(2)
+	commitTx.AddTxIn(wire.NewTxIn(staticChanPoint, nil, nil))
+	commitTx.AddTxOut(wire.NewTxOut(1000, []byte{0x00, 0x14}))
+
+	commit := channeldb.ChannelCommitment{
+		CommitHeight:  42,
+		LocalBalance:  lnwire.MilliSatoshi(123_000),
+		RemoteBalance: lnwire.MilliSatoshi(456_000),
+		CommitFee:     253,
+		FeePerKw:      1000,
+		CommitTx:      commitTx,
+		CommitSig:     []byte{0x01, 0x02},
+		Htlcs: []channeldb.HTLC{{
+			RHash:         [32]byte{0xaa},
+			Amt:           lnwire.NewMSatFromSatoshis(12345),
+			RefundTimeout: 800_123,
+			OutputIndex:   2,
+			Incoming:      true,
+			HtlcIndex:     7,
+			OnionBlob:     [lnwire.OnionPacketSize]byte{0x01},
+		}},
+	}
+
+	// The deprecated revocation log stores the full commitment the same
+	// way as the current commitments are stored.
+	var b bytes.Buffer
+	require.NoError(t, channeldb.WriteElements(
+		&b, commit.CommitHeight, commit.LocalLogIndex,
+		commit.LocalHtlcIndex, commit.RemoteLogIndex,
+		commit.RemoteHtlcIndex, commit.LocalBalance,
+		commit.RemoteBalance, commit.CommitFee, commit.FeePerKw,
+		commit.CommitTx, commit.CommitSig,
+	))
+	require.NoError(t, channeldb.SerializeHtlcs(&b, commit.Htlcs...))
+
+	var calls int
+	err := decodeRevocationLogEntry(
+		true, []byte{0, 0, 0, 0, 0, 0, 0, 42}, b.Bytes(),
+		func(height uint64, revLog *channeldb.RevocationLog,
+			decoded *channeldb.ChannelCommitment) error {
+
+			calls++
+			require.EqualValues(t, 42, height)
+			require.Nil(t, revLog)
+			require.Equal(t, commit.CommitTx.TxHash(),
+				decoded.CommitTx.TxHash())
+			require.Equal(t, commit.RemoteBalance,
+				decoded.RemoteBalance)
+			require.Len(t, decoded.Htlcs, 1)
+			require.Equal(t, commit.Htlcs[0].RHash,
+				decoded.Htlcs[0].RHash)
+			require.Equal(t, commit.Htlcs[0].HtlcIndex,
+				decoded.Htlcs[0].HtlcIndex)
+
+			return nil
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	// Keys that aren't commit heights are rejected.
+	err = decodeRevocationLogEntry(true, []byte{0x01}, b.Bytes(), nil)
+	require.ErrorContains(t, err, "invalid revocation log key")
+}
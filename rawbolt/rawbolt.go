@@ -0,0 +1,646 @@
+// Package rawbolt reads bbolt database files page by page without relying on
+// the meta pages, the freelist or the integrity of the B+ tree. This allows
+// salvaging data from files that bbolt itself refuses to open.
+package rawbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"slices"
+)
+
+const (
+	// pageHeaderSize is the size of the header of every page: the page ID
+	// (8 bytes), flags (2 bytes), element count (2 bytes) and number of
+	// overflow pages (4 bytes).
+	pageHeaderSize = 16
+
+	// elementSize is the size of a leaf or branch page element.
+	elementSize = 16
+
+	// bucketHeaderSize is the size of the bucket header in the value of a
+	// bucket element: the root page ID and the sequence.
+	bucketHeaderSize = 16
+
+	// metaChecksumOffset is the offset of the checksum within the meta
+	// data, which is the FNV-1a hash of all fields before it.
+	metaChecksumOffset = 56
+
+	magic   uint32 = 0xED0CDAED
+	version uint32 = 2
+
+	branchPageFlag   uint16 = 0x01
+	leafPageFlag     uint16 = 0x02
+	metaPageFlag     uint16 = 0x04
+	freelistPageFlag uint16 = 0x10
+
+	bucketLeafFlag uint32 = 0x01
+
+	// scanChunkSize is the number of bytes that are read at once when
+	// guessing the page size. It is a multiple of all candidate page
+	// sizes.
+	scanChunkSize = 1 << 20
+)
+
+var (
+	// byteOrder is the byte order bbolt uses on all common platforms.
+	byteOrder = binary.LittleEndian
+
+	// candidatePageSizes are the page sizes that are tried if both meta
+	// pages are unreadable.
+	candidatePageSizes = []int{4096, 8192, 16384, 65536, 1024, 2048}
+
+	// ErrPageSize is returned if the page size of a file can't be
+	// determined.
+	ErrPageSize = errors.New("unable to determine page size")
+)
+
+// Meta is the content of a meta page.
+type Meta struct {
+	// PageSize is the page size of the file.
+	PageSize uint32
+
+	// Root is the page ID of the root bucket.
+	Root uint64
+
+	// Freelist is the page ID of the freelist.
+	Freelist uint64
+
+	// HighWaterMark is the ID of the first page after the end of the
+	// used part of the file.
+	HighWaterMark uint64
+
+	// TxID is the ID of the transaction that wrote the meta page.
+	TxID uint64
+}
+
+// Element is a key/value pair of a leaf page. If Bucket is true, the value is
+// a bucket header, optionally followed by an inline page.
+type Element struct {
+	Key    []byte
+	Value  []byte
+	Bucket bool
+}
+
+// File is a bbolt database file whose pages are read on demand.
+type File struct {
+	r        io.ReaderAt
+	size     int64
+	closer   io.Closer
+	pageSize int
+}
+
+// Open opens the bbolt database file at the given path for reading. The file
+// must be closed with Close when it's no longer needed.
+func Open(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %w", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("error reading file %s: %w", path, err)
+	}
+
+	f, err := newFile(file, info.Size())
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	f.closer = file
+
+	return f, nil
+}
+
+// New creates a file from the raw content of a bbolt database file.
+func New(data []byte) (*File, error) {
+	return newFile(bytes.NewReader(data), int64(len(data)))
+}
+
+// newFile creates a file that reads its pages from the given reader and
+// determines the page size.
+func newFile(r io.ReaderAt, size int64) (*File, error) {
+	f := &File{r: r, size: size}
+
+	// Use the page size of the first readable meta page. If both are
+	// damaged, we guess the page size by looking at the page IDs.
+	if meta, err := f.Meta(); err == nil {
+		f.pageSize = int(meta.PageSize)
+		return f, nil
+	}
+
+	matches := make([]int, len(candidatePageSizes))
+	chunk := make([]byte, scanChunkSize)
+	for offset := int64(0); offset < size; offset += scanChunkSize {
+		n, err := r.ReadAt(chunk, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+
+		for idx, pageSize := range candidatePageSizes {
+			for pos := 0; pos+8 <= n; pos += pageSize {
+				id := (offset + int64(pos)) / int64(pageSize)
+				if id < 2 || (id+1)*int64(pageSize) > size {
+					continue
+				}
+
+				if byteOrder.Uint64(chunk[pos:]) == uint64(id) {
+					matches[idx]++
+				}
+			}
+		}
+	}
+
+	bestMatches := 1
+	for idx, pageSize := range candidatePageSizes {
+		if matches[idx] > bestMatches {
+			f.pageSize, bestMatches = pageSize, matches[idx]
+		}
+	}
+	if f.pageSize == 0 {
+		return nil, ErrPageSize
+	}
+
+	return f, nil
+}
+
+// Close closes the underlying file if the file was opened with Open.
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+
+	return f.closer.Close()
+}
+
+// readAt reads n bytes at the given offset of the file.
+func (f *File) readAt(offset int64, n int) ([]byte, error) {
+	if offset < 0 || offset+int64(n) > f.size {
+		return nil, fmt.Errorf("offset %d is beyond the end of the "+
+			"file", offset+int64(n))
+	}
+
+	data := make([]byte, n)
+	if _, err := f.r.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("error reading at offset %d: %w",
+			offset, err)
+	}
+
+	return data, nil
+}
+
+// PageSize returns the page size of the file.
+func (f *File) PageSize() int {
+	return f.pageSize
+}
+
+// NumPages returns the number of pages in the file.
+func (f *File) NumPages() int {
+	return int(f.size / int64(f.pageSize))
+}
+
+// Meta returns the valid meta page with the highest transaction ID.
+func (f *File) Meta() (*Meta, error) {
+	var (
+		best    *Meta
+		lastErr error
+	)
+	for id := range 2 {
+		meta, err := f.readMeta(id)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if best == nil || meta.TxID > best.TxID {
+			best = meta
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no valid meta page: %w", lastErr)
+	}
+
+	return best, nil
+}
+
+// readMeta reads and validates the meta page with the given ID (0 or 1).
+func (f *File) readMeta(id int) (*Meta, error) {
+	// The offset of the second meta page depends on the page size, which
+	// we only know from the first one if that isn't damaged.
+	sizes := []int{f.pageSize}
+	if f.pageSize == 0 {
+		sizes = candidatePageSizes
+	}
+
+	for _, size := range sizes {
+		header, err := f.readAt(
+			int64(id*size), pageHeaderSize+metaChecksumOffset+8,
+		)
+		if err != nil {
+			continue
+		}
+
+		if byteOrder.Uint64(header) != uint64(id) ||
+			byteOrder.Uint16(header[8:])&metaPageFlag == 0 {
+
+			continue
+		}
+
+		m := header[pageHeaderSize:]
+		if byteOrder.Uint32(m) != magic ||
+			byteOrder.Uint32(m[4:]) != version {
+
+			continue
+		}
+
+		// The second meta page is only found at the right offset.
+		if id == 1 && byteOrder.Uint32(m[8:]) != uint32(size) {
+			continue
+		}
+
+		h := fnv.New64a()
+		_, _ = h.Write(m[:metaChecksumOffset])
+		if h.Sum64() != byteOrder.Uint64(m[metaChecksumOffset:]) {
+			return nil, fmt.Errorf("meta page %d checksum "+
+				"mismatch", id)
+		}
+
+		return &Meta{
+			PageSize:      byteOrder.Uint32(m[8:]),
+			Root:          byteOrder.Uint64(m[16:]),
+			Freelist:      byteOrder.Uint64(m[32:]),
+			HighWaterMark: byteOrder.Uint64(m[40:]),
+			TxID:          byteOrder.Uint64(m[48:]),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("meta page %d not found", id)
+}
+
+// page is a parsed page header together with the page's data, including its
+// overflow pages.
+type page struct {
+	id       uint64
+	flags    uint16
+	count    uint16
+	overflow uint32
+	data     []byte
+}
+
+// page returns the page with the given ID. The page header must carry the
+// same ID, otherwise the page is considered damaged.
+func (f *File) page(id uint64) (*page, error) {
+	if id >= uint64(f.NumPages()) {
+		return nil, fmt.Errorf("page %d is beyond the end of the file",
+			id)
+	}
+
+	offset := int64(id) * int64(f.pageSize)
+	data, err := f.readAt(offset, f.pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: %w", id, err)
+	}
+	p, err := parsePage(data)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: %w", id, err)
+	}
+	if p.id != id {
+		return nil, fmt.Errorf("page %d has invalid ID %d", id, p.id)
+	}
+
+	if p.overflow == 0 {
+		return p, nil
+	}
+
+	numPages := uint64(p.overflow) + 1
+	if p.overflow >= uint32(f.NumPages()) ||
+		id+numPages > uint64(f.NumPages()) {
+
+		return nil, fmt.Errorf("page %d has invalid overflow %d", id,
+			p.overflow)
+	}
+	p.data, err = f.readAt(offset, int(numPages)*f.pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: %w", id, err)
+	}
+
+	return p, nil
+}
+
+// parsePage parses the page header at the beginning of data. The returned
+// page's data reaches to the end of the given data.
+func parsePage(data []byte) (*page, error) {
+	if len(data) < pageHeaderSize {
+		return nil, errors.New("page header truncated")
+	}
+
+	return &page{
+		id:       byteOrder.Uint64(data),
+		flags:    byteOrder.Uint16(data[8:]),
+		count:    byteOrder.Uint16(data[10:]),
+		overflow: byteOrder.Uint32(data[12:]),
+		data:     data,
+	}, nil
+}
+
+// leafElements returns the key/value pairs of a leaf page.
+func (p *page) leafElements() ([]Element, error) {
+	if p.flags&leafPageFlag == 0 {
+		return nil, fmt.Errorf("page %d is not a leaf page", p.id)
+	}
+
+	elements := make([]Element, 0, p.count)
+	for idx := range int(p.count) {
+		elemOffset := pageHeaderSize + idx*elementSize
+		if elemOffset+elementSize > len(p.data) {
+			return elements, fmt.Errorf("element %d of page %d "+
+				"is out of bounds", idx, p.id)
+		}
+
+		elem := p.data[elemOffset:]
+		flags := byteOrder.Uint32(elem)
+		pos := uint64(byteOrder.Uint32(elem[4:]))
+		keySize := uint64(byteOrder.Uint32(elem[8:]))
+		valueSize := uint64(byteOrder.Uint32(elem[12:]))
+
+		start := uint64(elemOffset) + pos
+		end := start + keySize + valueSize
+		if end > uint64(len(p.data)) {
+			return elements, fmt.Errorf("data of element %d of "+
+				"page %d is out of bounds", idx, p.id)
+		}
+
+		elements = append(elements, Element{
+			Key:    p.data[start : start+keySize],
+			Value:  p.data[start+keySize : end],
+			Bucket: flags&bucketLeafFlag != 0,
+		})
+	}
+
+	return elements, nil
+}
+
+// branchElements returns the first keys and page IDs of the children of a
+// branch page.
+func (p *page) branchElements() ([][]byte, []uint64, error) {
+	if p.flags&branchPageFlag == 0 {
+		return nil, nil, fmt.Errorf("page %d is not a branch page",
+			p.id)
+	}
+
+	keys := make([][]byte, 0, p.count)
+	children := make([]uint64, 0, p.count)
+	for idx := range int(p.count) {
+		elemOffset := pageHeaderSize + idx*elementSize
+		if elemOffset+elementSize > len(p.data) {
+			return keys, children, fmt.Errorf("element %d of "+
+				"page %d is out of bounds", idx, p.id)
+		}
+
+		elem := p.data[elemOffset:]
+		pos := uint64(byteOrder.Uint32(elem))
+		keySize := uint64(byteOrder.Uint32(elem[4:]))
+
+		start := uint64(elemOffset) + pos
+		if start+keySize > uint64(len(p.data)) {
+			return keys, children, fmt.Errorf("key of element %d "+
+				"of page %d is out of bounds", idx, p.id)
+		}
+
+		keys = append(keys, p.data[start:start+keySize])
+		children = append(children, byteOrder.Uint64(elem[8:]))
+	}
+
+	return keys, children, nil
+}
+
+// inlinePage returns the inline page of a bucket value or nil if the bucket
+// isn't inline.
+func inlinePage(value []byte) (*page, uint64, error) {
+	if len(value) < bucketHeaderSize {
+		return nil, 0, errors.New("bucket header truncated")
+	}
+
+	root := byteOrder.Uint64(value)
+	if root != 0 {
+		return nil, root, nil
+	}
+
+	p, err := parsePage(value[bucketHeaderSize:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("inline bucket: %w", err)
+	}
+
+	return p, 0, nil
+}
+
+// ScanLeaves calls fn for every key/value pair that is found in any leaf page
+// of the file, including the leaves of inline buckets. Pages are scanned
+// regardless of whether they are still reachable from the root, so the same
+// key can be reported multiple times with old values from freed pages.
+// Damaged pages are skipped.
+func (f *File) ScanLeaves(fn func(elem Element)) {
+	var scanPage func(p *page)
+	scanPage = func(p *page) {
+		// Even if an element is damaged, we still report the ones
+		// before it.
+		elements, _ := p.leafElements()
+		for _, elem := range elements {
+			fn(elem)
+
+			if !elem.Bucket {
+				continue
+			}
+			inline, _, err := inlinePage(elem.Value)
+			if err == nil && inline != nil &&
+				inline.flags&leafPageFlag != 0 {
+
+				scanPage(inline)
+			}
+		}
+	}
+
+	for id := range f.NumPages() {
+		p, err := f.page(uint64(id))
+		if err != nil || p.flags&leafPageFlag == 0 {
+			continue
+		}
+
+		scanPage(p)
+	}
+}
+
+// WalkFunc is called for every bucket and key/value pair found by Walk. keys is
+// the list of bucket names leading to the bucket that contains k. For buckets,
+// v is nil and seq is the sequence of the bucket.
+type WalkFunc func(keys [][]byte, k, v []byte, seq uint64) error
+
+// Damage describes a part of the B+ tree that couldn't be read.
+type Damage struct {
+	// Path is the list of bucket names leading to the bucket the damaged
+	// part belongs to.
+	Path [][]byte
+
+	// Page is the ID of the damaged page. It is zero for the inline page
+	// of a small bucket.
+	Page uint64
+
+	// FirstKey is the first key the damaged page should contain, if it is
+	// known from the parent branch page.
+	FirstKey []byte
+
+	// Err describes the damage.
+	Err error
+}
+
+// Walk walks the B+ tree of the file starting at the root bucket of the most
+// recent valid meta page and calls fn for every bucket and key/value pair. The
+// content of damaged pages is skipped and reported, everything else is still
+// visited.
+func (f *File) Walk(fn WalkFunc) ([]*Damage, error) {
+	meta, err := f.Meta()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &walker{
+		file:    f,
+		fn:      fn,
+		visited: make(map[uint64]struct{}),
+	}
+	if err := w.walkPage(nil, meta.Root, nil); err != nil {
+		return w.damages, err
+	}
+
+	return w.damages, nil
+}
+
+// walker keeps the state of a Walk.
+type walker struct {
+	file    *File
+	fn      WalkFunc
+	visited map[uint64]struct{}
+	damages []*Damage
+}
+
+// damage records a damaged part of the tree.
+func (w *walker) damage(path [][]byte, id uint64, firstKey []byte,
+	err error) {
+
+	w.damages = append(w.damages, &Damage{
+		Path:     path,
+		Page:     id,
+		FirstKey: firstKey,
+		Err:      err,
+	})
+}
+
+// walkPage walks the page with the given ID and all its children.
+func (w *walker) walkPage(path [][]byte, id uint64, firstKey []byte) error {
+	// A damaged branch page could point to a page that we've already
+	// visited, which would lead to an endless loop.
+	if _, ok := w.visited[id]; ok {
+		w.damage(path, id, firstKey, fmt.Errorf("page %d is "+
+			"referenced more than once", id))
+		return nil
+	}
+	w.visited[id] = struct{}{}
+
+	p, err := w.file.page(id)
+	if err != nil {
+		w.damage(path, id, firstKey, err)
+		return nil
+	}
+
+	switch {
+	case p.flags&branchPageFlag != 0:
+		keys, children, err := p.branchElements()
+		if err != nil {
+			w.damage(path, id, firstKey, err)
+		}
+		for idx, child := range children {
+			err := w.walkPage(path, child, keys[idx])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case p.flags&leafPageFlag != 0:
+		return w.walkLeaf(path, p, firstKey)
+
+	default:
+		w.damage(path, id, firstKey, fmt.Errorf("page %d has "+
+			"unexpected flags %#x", id, p.flags))
+		return nil
+	}
+}
+
+// walkLeaf calls the walk function for all elements of a leaf page and walks
+// into the buckets it contains.
+func (w *walker) walkLeaf(path [][]byte, p *page, firstKey []byte) error {
+	elements, err := p.leafElements()
+	if err != nil {
+		w.damage(path, p.id, firstKey, err)
+	}
+
+	for _, elem := range elements {
+		// The root bucket can only contain buckets.
+		if !elem.Bucket {
+			if len(path) == 0 {
+				w.damage(path, p.id, elem.Key, fmt.Errorf(
+					"unexpected value %x in root bucket",
+					elem.Key,
+				))
+				continue
+			}
+
+			err := w.fn(path, elem.Key, elem.Value, 0)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		inline, root, err := inlinePage(elem.Value)
+		if err != nil {
+			w.damage(path, p.id, elem.Key, err)
+			continue
+		}
+
+		seq := byteOrder.Uint64(elem.Value[8:])
+		if err := w.fn(path, elem.Key, nil, seq); err != nil {
+			return err
+		}
+
+		bucketPath := append(slices.Clone(path), elem.Key)
+		if inline == nil {
+			err := w.walkPage(bucketPath, root, nil)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if inline.flags&leafPageFlag == 0 {
+			w.damage(bucketPath, 0, nil, fmt.Errorf("inline "+
+				"bucket has unexpected flags %#x",
+				inline.flags))
+			continue
+		}
+		if err := w.walkLeaf(bucketPath, inline, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package rawbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	topBucket    = []byte("top")
+	nestedBucket = []byte("nested")
+	largeKey     = []byte("large")
+)
+
+// createTestDB creates a bbolt file with a top level bucket that holds many
+// keys, an inline nested bucket and a value that spans overflow pages.
+func createTestDB(t *testing.T) []byte {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		top, err := tx.CreateBucket(topBucket)
+		if err != nil {
+			return err
+		}
+
+		for i := range 1000 {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			err := top.Put(key, bytes.Repeat(key, 4))
+			if err != nil {
+				return err
+			}
+		}
+
+		nested, err := top.CreateBucket(nestedBucket)
+		if err != nil {
+			return err
+		}
+		err = nested.Put([]byte("mpriv"), []byte("secret"))
+		if err != nil {
+			return err
+		}
+
+		return top.Put(largeKey, bytes.Repeat([]byte{0xaa}, 20000))
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return data
+}
+
+// scanKeys returns all non-bucket keys found in the file and their values.
+func scanKeys(f *File) map[string][]byte {
+	keys := make(map[string][]byte)
+	f.ScanLeaves(func(elem Element) {
+		if !elem.Bucket {
+			keys[string(elem.Key)] = elem.Value
+		}
+	})
+
+	return keys
+}
+
+func TestScanLeaves(t *testing.T) {
+	data := createTestDB(t)
+
+	f, err := New(data)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpagesize(), f.PageSize())
+
+	meta, err := f.Meta()
+	require.NoError(t, err)
+	require.Less(t, meta.HighWaterMark, uint64(f.NumPages()+1))
+
+	keys := scanKeys(f)
+	require.Contains(t, keys, "key-0000")
+	require.Contains(t, keys, "key-0999")
+	require.Equal(t, []byte("secret"), keys["mpriv"])
+	require.Equal(t, bytes.Repeat([]byte{0xaa}, 20000), keys["large"])
+
+	// Reading the pages from the file on disk gives the same result.
+	fromDisk, err := Open(writeFile(t, data))
+	require.NoError(t, err)
+	defer func() { _ = fromDisk.Close() }()
+	require.Equal(t, f.NumPages(), fromDisk.NumPages())
+	require.Equal(t, keys, scanKeys(fromDisk))
+
+	// Without both meta pages the page size is guessed and everything is
+	// still found.
+	damaged := bytes.Clone(data)
+	clear(damaged[:2*f.PageSize()])
+
+	_, err = bbolt.Open(writeFile(t, damaged), 0600, nil)
+	require.Error(t, err)
+
+	f, err = New(damaged)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpagesize(), f.PageSize())
+	_, err = f.Meta()
+	require.ErrorContains(t, err, "no valid meta page")
+	require.Len(t, scanKeys(f), len(keys))
+}
+
+func TestScanLeavesCorrupted(t *testing.T) {
+	data := createTestDB(t)
+
+	f, err := New(data)
+	require.NoError(t, err)
+	pageSize := f.PageSize()
+	numKeys := len(scanKeys(f))
+
+	// Overwriting a leaf page with garbage only loses the keys in it, so
+	// every key is lost exactly once.
+	rnd := rand.New(rand.NewSource(1))
+	var lost int
+	for id := 2; id < f.NumPages(); id++ {
+		offset := id * pageSize
+		if binary.LittleEndian.Uint16(data[offset+8:]) != leafPageFlag {
+			continue
+		}
+
+		damaged := bytes.Clone(data)
+		_, _ = rnd.Read(damaged[offset+8 : offset+pageSize])
+
+		damagedFile, err := New(damaged)
+		require.NoError(t, err)
+		lost += numKeys - len(scanKeys(damagedFile))
+	}
+	require.Equal(t, numKeys, lost)
+
+	// Random garbage anywhere must never cause a panic.
+	for range 200 {
+		damaged := bytes.Clone(data)
+		for range 20 {
+			pos := rnd.Intn(len(damaged) - 8)
+			binary.LittleEndian.PutUint64(
+				damaged[pos:], rnd.Uint64(),
+			)
+		}
+
+		damagedFile, err := New(damaged)
+		if err != nil {
+			continue
+		}
+		scanKeys(damagedFile)
+	}
+
+	// A file without any recognizable pages can't be read.
+	_, err = New(make([]byte, len(data)))
+	require.ErrorIs(t, err, ErrPageSize)
+}
+
+func TestWalk(t *testing.T) {
+	data := createTestDB(t)
+
+	f, err := New(data)
+	require.NoError(t, err)
+
+	type entry struct {
+		path  string
+		value []byte
+	}
+	walk := func(f *File) ([]entry, []*Damage) {
+		var entries []entry
+		damages, err := f.Walk(func(keys [][]byte, k, v []byte,
+			_ uint64) error {
+
+			path := string(bytes.Join(append(keys, k), []byte("/")))
+			entries = append(entries, entry{path, v})
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		return entries, damages
+	}
+
+	// The intact file contains two buckets and all keys.
+	entries, damages := walk(f)
+	require.Empty(t, damages)
+	require.Len(t, entries, 1000+1+2+1)
+	require.Contains(t, entries, entry{"top", nil})
+	require.Contains(t, entries, entry{"top/nested", nil})
+	require.Contains(t, entries, entry{
+		"top/nested/mpriv", []byte("secret"),
+	})
+	require.Contains(t, entries, entry{
+		"top/key-0500", bytes.Repeat([]byte("key-0500"), 4),
+	})
+
+	// Overwrite a leaf page of the top bucket. Only its keys are lost and
+	// the damage is reported with the first key of the page.
+	pageSize := f.PageSize()
+	var damagedPage int
+	for id := 2; id < f.NumPages(); id++ {
+		offset := id * pageSize
+		content := data[offset : offset+pageSize]
+		if binary.LittleEndian.Uint16(content[8:]) == leafPageFlag &&
+			bytes.Contains(content, []byte("key-0500")) {
+
+			damagedPage = id
+			clear(data[offset+8 : offset+pageSize])
+		}
+	}
+	require.NotZero(t, damagedPage)
+
+	f, err = New(data)
+	require.NoError(t, err)
+
+	damagedEntries, damages := walk(f)
+	require.Len(t, damages, 1)
+	require.Equal(t, uint64(damagedPage), damages[0].Page)
+	require.Equal(t, [][]byte{topBucket}, damages[0].Path)
+	require.NotNil(t, damages[0].FirstKey)
+	require.ErrorContains(t, damages[0].Err, "unexpected flags")
+	require.Less(t, len(damagedEntries), len(entries))
+	require.NotContains(t, damagedEntries, entry{
+		"top/key-0500", bytes.Repeat([]byte("key-0500"), 4),
+	})
+	require.Contains(t, damagedEntries, entry{"top/nested", nil})
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "damaged.db")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	return path
+}
@@ -0,0 +1,137 @@
+// Package shamir implements Shamir's secret sharing over GF(256) and an M-of-N
+// share format for splitting lnd seeds and root keys between several people.
+package shamir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// expTable and logTable are the exponent and logarithm tables of
+	// GF(256) with the Rijndael polynomial x^8 + x^4 + x^3 + x + 1 and the
+	// generator 3.
+	expTable [255]byte
+	logTable [256]byte
+)
+
+func init() { //nolint:gochecknoinits
+	x := byte(1)
+	for i := range len(expTable) {
+		expTable[i] = x
+		logTable[x] = byte(i)
+
+		// Multiply by the generator 3 = x + 1.
+		high := x & 0x80
+		x2 := x << 1
+		if high != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+}
+
+// mul multiplies two elements of GF(256).
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+// div divides two elements of GF(256). The divisor must not be zero.
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return expTable[(int(logTable[a])+255-int(logTable[b]))%255]
+}
+
+// SplitSecret splits the secret into numShares shares of which any threshold
+// shares are needed to recover it. The share with index i is returned at
+// position i-1, the index 0 is the secret itself.
+func SplitSecret(secret []byte, threshold, numShares uint8,
+	randSource io.Reader) ([][]byte, error) {
+
+	if threshold == 0 || threshold > numShares {
+		return nil, fmt.Errorf("invalid threshold %d for %d shares",
+			threshold, numShares)
+	}
+
+	// Every byte of the secret is the constant term of a random polynomial
+	// of degree threshold-1.
+	coefficients := make([][]byte, threshold-1)
+	for idx := range coefficients {
+		coefficients[idx] = make([]byte, len(secret))
+		_, err := io.ReadFull(randSource, coefficients[idx])
+		if err != nil {
+			return nil, fmt.Errorf("error reading randomness: %w",
+				err)
+		}
+	}
+
+	shares := make([][]byte, numShares)
+	for idx := range shares {
+		x := byte(idx + 1)
+		shares[idx] = make([]byte, len(secret))
+		for pos := range secret {
+			// Horner's method, starting with the highest
+			// coefficient.
+			var y byte
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = mul(y, x) ^ coefficients[c][pos]
+			}
+			shares[idx][pos] = mul(y, x) ^ secret[pos]
+		}
+	}
+
+	return shares, nil
+}
+
+// interpolate returns the value at x of the polynomial that goes through the
+// given points.
+func interpolate(xs []byte, ys [][]byte, x byte) ([]byte, error) {
+	result := make([]byte, len(ys[0]))
+	for i := range xs {
+		// The Lagrange basis polynomial of point i, evaluated at x.
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			if xs[i] == xs[j] {
+				return nil, errors.New("duplicate share index")
+			}
+
+			// Subtraction is the same as addition (XOR) in GF(256).
+			basis = mul(basis, div(x^xs[j], xs[i]^xs[j]))
+		}
+
+		for pos := range result {
+			result[pos] ^= mul(basis, ys[i][pos])
+		}
+	}
+
+	return result, nil
+}
+
+// CombineShares recovers a secret from threshold shares, given by their
+// indexes and values.
+func CombineShares(indexes []byte, values [][]byte) ([]byte, error) {
+	if len(indexes) == 0 || len(indexes) != len(values) {
+		return nil, errors.New("invalid number of shares")
+	}
+	for idx := range values {
+		if indexes[idx] == 0 {
+			return nil, errors.New("invalid share index 0")
+		}
+		if len(values[idx]) != len(values[0]) {
+			return nil, errors.New("shares have different lengths")
+		}
+	}
+
+	return interpolate(indexes, values, 0)
+}
@@ -0,0 +1,129 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldArithmetic(t *testing.T) {
+	// 0x53 and 0xca are inverses in the Rijndael field.
+	require.Equal(t, byte(0x01), mul(0x53, 0xca))
+	require.Equal(t, byte(0xca), div(0x01, 0x53))
+	require.Equal(t, byte(0xc1), mul(0x57, 0x83))
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			require.Equal(t, byte(a), div(mul(byte(a), byte(b)),
+				byte(b)))
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 64)
+	_, err := rand.Read(secret)
+	require.NoError(t, err)
+
+	shares, err := Split(SecretRootKey, secret, 3, 5)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	// Any three shares in any order recover the secret.
+	for i := range shares {
+		for j := range shares {
+			for k := range shares {
+				if i == j || j == k || i == k {
+					continue
+				}
+
+				secretType, combined, err := Combine([]*Share{
+					shares[i], shares[j], shares[k],
+				})
+				require.NoError(t, err)
+				require.Equal(t, SecretRootKey, secretType)
+				require.Equal(t, secret, combined)
+			}
+		}
+	}
+
+	// Two shares aren't enough.
+	_, _, err = Combine(shares[:2])
+	require.ErrorContains(t, err, "need 3 shares")
+
+	// A duplicate share doesn't count.
+	_, _, err = Combine([]*Share{shares[0], shares[1], shares[1]})
+	require.ErrorContains(t, err, "duplicate share index")
+
+	// Additional shares must match the others.
+	_, combined, err := Combine(shares)
+	require.NoError(t, err)
+	require.Equal(t, secret, combined)
+
+	modified := *shares[4]
+	modified.Value = bytes.Clone(modified.Value)
+	modified.Value[0] ^= 0x01
+	_, _, err = Combine([]*Share{
+		shares[0], shares[1], shares[2], &modified,
+	})
+	require.ErrorContains(t, err, "share 5 doesn't match")
+
+	// Shares of different splits can't be combined.
+	otherShares, err := Split(SecretRootKey, secret, 3, 5)
+	require.NoError(t, err)
+	otherShares[0].SetID = shares[0].SetID + 1
+	_, _, err = Combine([]*Share{otherShares[0], shares[1], shares[2]})
+	require.ErrorContains(t, err, "doesn't belong to the same set")
+
+	_, err = Split(SecretAezeed, secret, 3, 5)
+	require.ErrorContains(t, err, "aezeed secret must be 33 bytes")
+	_, err = Split(SecretRootKey, secret, 6, 5)
+	require.ErrorContains(t, err, "invalid threshold")
+}
+
+func TestShareMnemonic(t *testing.T) {
+	secret := make([]byte, 33)
+	_, err := rand.Read(secret)
+	require.NoError(t, err)
+
+	shares, err := Split(SecretAezeed, secret, 2, 3)
+	require.NoError(t, err)
+
+	parsed := make([]*Share, len(shares))
+	for idx, share := range shares {
+		words := share.Mnemonic()
+		require.Len(t, words, 32)
+
+		parsed[idx], err = ParseShare(strings.Join(words, " "))
+		require.NoError(t, err)
+		require.Equal(t, share, parsed[idx])
+	}
+
+	secretType, combined, err := Combine(parsed[1:])
+	require.NoError(t, err)
+	require.Equal(t, SecretAezeed, secretType)
+	require.Equal(t, secret, combined)
+
+	// A wrong word is detected by the checksum.
+	words := shares[0].Mnemonic()
+	if words[5] == "abandon" {
+		words[5] = "ability"
+	} else {
+		words[5] = "abandon"
+	}
+	_, err = ParseShare(strings.Join(words, " "))
+	require.ErrorContains(t, err, "checksum doesn't match")
+
+	// A missing word is detected by the length.
+	words = shares[0].Mnemonic()
+	_, err = ParseShare(strings.Join(words[:31], " "))
+	require.ErrorContains(t, err, "must have 32 words")
+
+	// Root key shares are longer.
+	rootKeyShares, err := Split(SecretRootKey, make([]byte, 64), 2, 2)
+	require.NoError(t, err)
+	require.Len(t, rootKeyShares[0].Mnemonic(), 54)
+}
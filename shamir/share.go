@@ -0,0 +1,281 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/aezeed"
+)
+
+const (
+	// ShareVersion is the version of the share format.
+	ShareVersion uint8 = 0
+
+	// headerSize is the size of the version, type, set ID, threshold and
+	// index at the beginning of an encoded share.
+	headerSize = 6
+
+	// checksumSize is the size of the checksum at the end of an encoded
+	// share.
+	checksumSize = 4
+
+	// bitsPerWord is the number of bits encoded in a single word.
+	bitsPerWord = 11
+)
+
+// SecretType is the type of the secret that was split.
+type SecretType uint8
+
+const (
+	// SecretAezeed is the 33 byte enciphered lnd aezeed. The passphrase of
+	// the seed is still required after combining the shares.
+	SecretAezeed SecretType = 0
+
+	// SecretRootKey is a BIP32 HD root key, encoded as the 32 byte chain
+	// code followed by the 32 byte private key.
+	SecretRootKey SecretType = 1
+)
+
+// String returns a human readable name of the secret type.
+func (t SecretType) String() string {
+	switch t {
+	case SecretAezeed:
+		return "aezeed"
+
+	case SecretRootKey:
+		return "root key"
+
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// secretSize returns the size of a secret of the given type.
+func (t SecretType) secretSize() (int, error) {
+	switch t {
+	case SecretAezeed:
+		return aezeed.EncipheredCipherSeedSize, nil
+
+	case SecretRootKey:
+		return 64, nil
+
+	default:
+		return 0, fmt.Errorf("unknown secret type %d", uint8(t))
+	}
+}
+
+// Share is a single share of a split secret.
+type Share struct {
+	// Type is the type of the secret that was split.
+	Type SecretType
+
+	// SetID is a random identifier that all shares of a split have in
+	// common.
+	SetID uint16
+
+	// Threshold is the number of shares needed to recover the secret.
+	Threshold uint8
+
+	// Index is the index of the share, starting at 1.
+	Index uint8
+
+	// Value is the share of the secret.
+	Value []byte
+}
+
+// Split splits a secret of the given type into numShares shares of which any
+// threshold shares are needed to recover it.
+func Split(secretType SecretType, secret []byte, threshold,
+	numShares uint8) ([]*Share, error) {
+
+	return split(secretType, secret, threshold, numShares, rand.Reader)
+}
+
+func split(secretType SecretType, secret []byte, threshold, numShares uint8,
+	randSource io.Reader) ([]*Share, error) {
+
+	size, err := secretType.secretSize()
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != size {
+		return nil, fmt.Errorf("%v secret must be %d bytes",
+			secretType, size)
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+
+	var setID [2]byte
+	if _, err := io.ReadFull(randSource, setID[:]); err != nil {
+		return nil, fmt.Errorf("error reading randomness: %w", err)
+	}
+
+	values, err := SplitSecret(secret, threshold, numShares, randSource)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]*Share, len(values))
+	for idx, value := range values {
+		shares[idx] = &Share{
+			Type:      secretType,
+			SetID:     binary.BigEndian.Uint16(setID[:]),
+			Threshold: threshold,
+			Index:     uint8(idx + 1),
+			Value:     value,
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine recovers the secret from at least threshold shares of the same set.
+// If more shares than needed are given, they are checked to belong to the
+// same secret.
+func Combine(shares []*Share) (SecretType, []byte, error) {
+	if len(shares) == 0 {
+		return 0, nil, errors.New("no shares given")
+	}
+
+	first := shares[0]
+	for _, share := range shares[1:] {
+		if share.Type != first.Type || share.SetID != first.SetID ||
+			share.Threshold != first.Threshold {
+
+			return 0, nil, fmt.Errorf("share %d doesn't belong to "+
+				"the same set as share %d", share.Index,
+				first.Index)
+		}
+	}
+	if len(shares) < int(first.Threshold) {
+		return 0, nil, fmt.Errorf("need %d shares to recover the "+
+			"secret, got %d", first.Threshold, len(shares))
+	}
+
+	indexes := make([]byte, len(shares))
+	values := make([][]byte, len(shares))
+	for idx, share := range shares {
+		indexes[idx] = share.Index
+		values[idx] = share.Value
+	}
+
+	threshold := int(first.Threshold)
+	secret, err := CombineShares(indexes[:threshold], values[:threshold])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Any additional share must lie on the same polynomial.
+	for idx := threshold; idx < len(shares); idx++ {
+		expected, err := interpolate(
+			indexes[:threshold], values[:threshold], indexes[idx],
+		)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !bytes.Equal(expected, values[idx]) {
+			return 0, nil, fmt.Errorf("share %d doesn't match the "+
+				"other shares", indexes[idx])
+		}
+	}
+
+	return first.Type, secret, nil
+}
+
+// serialize returns the binary encoding of the share, including the checksum.
+func (s *Share) serialize() []byte {
+	data := make([]byte, 0, headerSize+len(s.Value)+checksumSize)
+	data = append(data, ShareVersion, uint8(s.Type))
+	data = binary.BigEndian.AppendUint16(data, s.SetID)
+	data = append(data, s.Threshold, s.Index)
+	data = append(data, s.Value...)
+
+	checksum := sha256.Sum256(data)
+
+	return append(data, checksum[:checksumSize]...)
+}
+
+// Mnemonic returns the share encoded as words of the BIP39 English word list.
+func (s *Share) Mnemonic() []string {
+	data := s.serialize()
+
+	numWords := (len(data)*8 + bitsPerWord - 1) / bitsPerWord
+	words := make([]string, numWords)
+	for idx := range words {
+		var wordIndex int
+		for bit := range bitsPerWord {
+			pos := idx*bitsPerWord + bit
+			wordIndex <<= 1
+			if pos < len(data)*8 &&
+				data[pos/8]&(0x80>>(pos%8)) != 0 {
+
+				wordIndex |= 1
+			}
+		}
+		words[idx] = aezeed.DefaultWordList[wordIndex]
+	}
+
+	return words
+}
+
+// ParseShare decodes a share from its mnemonic.
+func ParseShare(mnemonic string) (*Share, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	data := make([]byte, len(words)*bitsPerWord/8)
+	for idx, word := range words {
+		wordIndex, ok := aezeed.ReverseWordMap[word]
+		if !ok {
+			return nil, fmt.Errorf("word %d (%s) is not on the "+
+				"word list", idx+1, word)
+		}
+		for bit := range bitsPerWord {
+			pos := idx*bitsPerWord + bit
+			if pos >= len(data)*8 {
+				break
+			}
+			if wordIndex&(1<<(bitsPerWord-1-bit)) != 0 {
+				data[pos/8] |= 0x80 >> (pos % 8)
+			}
+		}
+	}
+
+	if len(data) < headerSize+checksumSize {
+		return nil, errors.New("share is too short")
+	}
+	if data[0] != ShareVersion {
+		return nil, fmt.Errorf("unknown share version %d", data[0])
+	}
+	share := &Share{
+		Type:      SecretType(data[1]),
+		SetID:     binary.BigEndian.Uint16(data[2:4]),
+		Threshold: data[4],
+		Index:     data[5],
+	}
+	size, err := share.Type.secretSize()
+	if err != nil {
+		return nil, err
+	}
+	encodedSize := headerSize + size + checksumSize
+	numWords := (encodedSize*8 + bitsPerWord - 1) / bitsPerWord
+	if len(words) != numWords {
+		return nil, fmt.Errorf("%v share must have %d words",
+			share.Type, numWords)
+	}
+	share.Value = data[headerSize : headerSize+size]
+
+	checksum := sha256.Sum256(data[:headerSize+size])
+	shareChecksum := data[headerSize+size : encodedSize]
+	if !bytes.Equal(checksum[:checksumSize], shareChecksum) {
+		return nil, errors.New("share checksum doesn't match, check " +
+			"the words")
+	}
+
+	return share, nil
+}